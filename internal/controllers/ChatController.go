@@ -1,8 +1,11 @@
 package controllers
 
 import (
+	"errors"
 	"log"
+	"time"
 
+	"github.com/ady243/teamup/internal/models"
 	"github.com/ady243/teamup/internal/services"
 	"github.com/gofiber/fiber/v2"
 )
@@ -28,71 +31,75 @@ func NewChatController(chatService *services.ChatService, notificationService *s
 }
 
 // @Summary SendMessage
-// @Description Send a message to a chat
+// @Description Send a message to a match chat. The message is stored, broadcast to the match chat WebSocket and notified to the other players.
+// @Description Retrying with the same client_msg_id returns the original message without sending it twice.
 // @Tags Chat
 // @Accept json
 // @Produce json
 // @Param match_id body string true "Match ID"
-// @Param user_id body string true "User ID"
 // @Param message body string true "Message"
-// @Success 200 {object} SuccessResponse
+// @Param client_msg_id body string false "Idempotency key generated by the client"
+// @Param reply_to_id body string false "ID of the message this one replies to"
+// @Param attachment_id body string false "ID of an attachment uploaded for this match; the message text is then optional"
+// @Success 200 {object} map[string]interface{}
 // @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
 // @Router /api/chat/send [post]
 func (ctrl *ChatController) SendMessage(c *fiber.Ctx) error {
 	var req struct {
-		MatchID  string `json:"match_id" binding:"required"`
-		UserID   string `json:"user_id" binding:"required"`
-		Message  string `json:"message" binding:"required"`
-		FCMToken string `json:"fcm_token" binding:"required"`
+		services.ChatMessageInput
+		MatchID string `json:"match_id"`
+		UserID  string `json:"user_id"`
 	}
 
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{Error: "Invalid request"})
 	}
 
-	if err := ctrl.ChatService.AddMessage(req.MatchID, req.UserID, req.Message); err != nil {
-		log.Printf("Error saving message: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{Error: "Could not save message"})
+	// L'auteur est toujours l'utilisateur authentifié ; user_id n'est conservé que pour les anciens clients
+	userID := c.Locals("user_id").(string)
+	if req.UserID != "" && req.UserID != userID {
+		return c.Status(fiber.StatusForbidden).JSON(ErrorResponse{Error: "Cannot send a message on behalf of another user"})
 	}
 
-	// Récupérer les tokens FCM des participants
-	participants, err := ctrl.ChatService.GetParticipants(req.MatchID)
+	message, created, err := ctrl.ChatService.AddMessage(req.MatchID, userID, req.ChatMessageInput)
 	if err != nil {
-		log.Printf("Error fetching participants: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{Error: "Could not fetch participants"})
+		return chatMessageError(c, err)
 	}
 
-	// Envoyer une notification push aux participants
-	for _, participant := range participants {
-		if participant.ID != req.UserID {
-			err := ctrl.NotificationService.SendPushNotification(
-				participant.FCMToken,
-				"TeamUp",
-				"Vous avez reçu un nouveau message dans votre match ",
-			)
-			if err != nil {
-				log.Printf("Failed to send push notification: %v", err)
-			}
-		}
+	status := fiber.StatusCreated
+	if !created {
+		status = fiber.StatusOK
 	}
-
-	return c.Status(fiber.StatusOK).JSON(SuccessResponse{Status: "Message sent"})
+	return c.Status(status).JSON(fiber.Map{"status": "Message sent", "message": message})
 }
 
 // @Summary GetMessages
-// @Description Get messages from a chat
+// @Description Get messages from a chat, oldest first. Without cursor, the latest messages are returned.
 // @Tags Chat
 // @Accept json
 // @Produce json
 // @Param matchID path string true "Match ID"
-// @Success 200 {object} []map[string]interface{}
+// @Param before query string false "Return messages older than this message ID"
+// @Param after query string false "Return messages newer than this message ID"
+// @Param limit query int false "Maximum number of messages (default 50, max 100)"
+// @Success 200 {object} []models.ChatMessage
 // @Failure 500 {object} ErrorResponse
 // @Router /api/chat/{matchID} [get]
 func (ctrl *ChatController) GetMessages(c *fiber.Ctx) error {
 	matchID := c.Params("matchID")
-	limit := 10
-	messages, err := ctrl.ChatService.GetMessages(matchID, limit)
+	// Les joueurs exclus du chat n'ont plus accès à son historique
+	if !ctrl.ChatService.IsChatMember(matchID, c.Locals("user_id").(string)) {
+		return chatMessageError(c, services.ErrMessageForbidden)
+	}
+	before := c.Query("before")
+	after := c.Query("after")
+	if before != "" && after != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{Error: "Use either before or after, not both"})
+	}
+
+	messages, err := ctrl.ChatService.GetMessages(matchID, before, after, c.QueryInt("limit", 50))
 	if err != nil {
 		log.Printf("Error retrieving messages: %v", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{Error: "Could not retrieve messages"})
@@ -100,3 +107,368 @@ func (ctrl *ChatController) GetMessages(c *fiber.Ctx) error {
 
 	return c.JSON(messages)
 }
+
+// @Summary SetChatRetention
+// @Description Set how many days the chat messages of a match are kept. Only the organizer can change it; null restores the default.
+// @Tags Chat
+// @Accept json
+// @Produce json
+// @Param matchID path string true "Match ID"
+// @Param days body int false "Retention in days"
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /api/chat/{matchID}/retention [put]
+func (ctrl *ChatController) SetRetention(c *fiber.Ctx) error {
+	matchID := c.Params("matchID")
+	var match models.Matches
+	if err := ctrl.ChatService.DB.Where("id = ? AND deleted_at IS NULL", matchID).First(&match).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{Error: "Match not found"})
+	}
+	if match.OrganizerID != c.Locals("user_id").(string) {
+		return c.Status(fiber.StatusForbidden).JSON(ErrorResponse{Error: "Only the organizer can change the chat retention"})
+	}
+
+	var req struct {
+		Days *int `json:"days"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{Error: "Invalid request"})
+	}
+	if err := ctrl.ChatService.SetRetention(matchID, req.Days); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{Error: err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(SuccessResponse{Status: "Retention updated"})
+}
+
+// chatMessageError convertit les erreurs du chat en réponse HTTP
+func chatMessageError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, services.ErrMessageNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(ErrorResponse{Error: err.Error()})
+	case errors.Is(err, services.ErrMessageForbidden), errors.Is(err, services.ErrChatMuted):
+		return c.Status(fiber.StatusForbidden).JSON(ErrorResponse{Error: err.Error()})
+	case errors.Is(err, services.ErrMessageDeleted), errors.Is(err, services.ErrInvalidChatMessage), errors.Is(err, services.ErrInvalidReaction),
+		errors.Is(err, services.ErrContentRejected):
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{Error: err.Error()})
+	case errors.Is(err, services.ErrRateLimited):
+		return c.Status(fiber.StatusTooManyRequests).JSON(ErrorResponse{Error: err.Error()})
+	}
+	log.Printf("Chat error: %v", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{Error: "Internal server error"})
+}
+
+// @Summary EditMessage
+// @Description Edit a match chat message. Only its author can edit it; previous versions are kept.
+// @Tags Chat
+// @Accept json
+// @Produce json
+// @Param matchID path string true "Match ID"
+// @Param messageID path string true "Message ID"
+// @Param message body string true "New message"
+// @Success 200 {object} models.ChatMessage
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/chat/{matchID}/messages/{messageID} [put]
+func (ctrl *ChatController) EditMessage(c *fiber.Ctx) error {
+	var req struct {
+		Message string `json:"message"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{Error: "Invalid request"})
+	}
+
+	message, err := ctrl.ChatService.EditMessage(c.Params("matchID"), c.Params("messageID"), c.Locals("user_id").(string), req.Message)
+	if err != nil {
+		return chatMessageError(c, err)
+	}
+	return c.JSON(message)
+}
+
+// @Summary DeleteMessage
+// @Description Delete a match chat message for everyone. The author and the match organizer can delete it.
+// @Tags Chat
+// @Produce json
+// @Param matchID path string true "Match ID"
+// @Param messageID path string true "Message ID"
+// @Success 200 {object} models.ChatMessage
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/chat/{matchID}/messages/{messageID} [delete]
+func (ctrl *ChatController) DeleteMessage(c *fiber.Ctx) error {
+	message, err := ctrl.ChatService.DeleteMessage(c.Params("matchID"), c.Params("messageID"), c.Locals("user_id").(string))
+	if err != nil {
+		return chatMessageError(c, err)
+	}
+	return c.JSON(message)
+}
+
+// @Summary GetMessageHistory
+// @Description Get the previous versions of an edited match chat message
+// @Tags Chat
+// @Produce json
+// @Param matchID path string true "Match ID"
+// @Param messageID path string true "Message ID"
+// @Success 200 {object} []models.MessageEdit
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/chat/{matchID}/messages/{messageID}/history [get]
+func (ctrl *ChatController) GetMessageHistory(c *fiber.Ctx) error {
+	matchID := c.Params("matchID")
+	if !ctrl.ChatService.IsChatMember(matchID, c.Locals("user_id").(string)) {
+		return chatMessageError(c, services.ErrMessageForbidden)
+	}
+	history, err := ctrl.ChatService.GetEditHistory(matchID, c.Params("messageID"))
+	if err != nil {
+		return chatMessageError(c, err)
+	}
+	return c.JSON(history)
+}
+
+// @Summary GetMessageReplies
+// @Description Get the replies to a match chat message, oldest first
+// @Tags Chat
+// @Produce json
+// @Param matchID path string true "Match ID"
+// @Param messageID path string true "Message ID"
+// @Success 200 {object} []models.ChatMessage
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/chat/{matchID}/messages/{messageID}/replies [get]
+func (ctrl *ChatController) GetMessageReplies(c *fiber.Ctx) error {
+	matchID := c.Params("matchID")
+	if !ctrl.ChatService.IsChatMember(matchID, c.Locals("user_id").(string)) {
+		return chatMessageError(c, services.ErrMessageForbidden)
+	}
+	replies, err := ctrl.ChatService.GetReplies(matchID, c.Params("messageID"))
+	if err != nil {
+		return chatMessageError(c, err)
+	}
+	return c.JSON(replies)
+}
+
+// @Summary AddReaction
+// @Description Add an emoji reaction to a match chat message
+// @Tags Chat
+// @Accept json
+// @Produce json
+// @Param matchID path string true "Match ID"
+// @Param messageID path string true "Message ID"
+// @Param emoji body string true "Emoji"
+// @Success 200 {object} models.Reactions
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /api/chat/{matchID}/messages/{messageID}/reactions [post]
+func (ctrl *ChatController) AddReaction(c *fiber.Ctx) error {
+	return ctrl.setReaction(c, true)
+}
+
+// @Summary RemoveReaction
+// @Description Remove an emoji reaction from a match chat message
+// @Tags Chat
+// @Accept json
+// @Produce json
+// @Param matchID path string true "Match ID"
+// @Param messageID path string true "Message ID"
+// @Param emoji body string true "Emoji"
+// @Success 200 {object} models.Reactions
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /api/chat/{matchID}/messages/{messageID}/reactions [delete]
+func (ctrl *ChatController) RemoveReaction(c *fiber.Ctx) error {
+	return ctrl.setReaction(c, false)
+}
+
+func (ctrl *ChatController) setReaction(c *fiber.Ctx, add bool) error {
+	var req struct {
+		Emoji string `json:"emoji"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{Error: "Invalid request"})
+	}
+
+	reactions, err := ctrl.ChatService.SetReaction(c.Params("matchID"), c.Params("messageID"), c.Locals("user_id").(string), req.Emoji, add)
+	if err != nil {
+		return chatMessageError(c, err)
+	}
+	return c.JSON(fiber.Map{"message_id": c.Params("messageID"), "reactions": reactions})
+}
+
+// @Summary MarkChatRead
+// @Description Mark the match chat as read up to a message. The other members receive a read receipt on the chat WebSocket.
+// @Tags Chat
+// @Accept json
+// @Produce json
+// @Param matchID path string true "Match ID"
+// @Param message_id body string true "Last read message ID"
+// @Success 200 {object} models.ReadCursor
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/chat/{matchID}/read [post]
+func (ctrl *ChatController) MarkRead(c *fiber.Ctx) error {
+	var req struct {
+		MessageID string `json:"message_id"`
+	}
+	if err := c.BodyParser(&req); err != nil || req.MessageID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{Error: "Invalid request"})
+	}
+
+	cursor, err := ctrl.ChatService.MarkRead(c.Params("matchID"), c.Locals("user_id").(string), req.MessageID)
+	if err != nil {
+		return chatMessageError(c, err)
+	}
+	return c.JSON(cursor)
+}
+
+// @Summary GetChatReadReceipts
+// @Description Get the last message read by each member of a match chat
+// @Tags Chat
+// @Produce json
+// @Param matchID path string true "Match ID"
+// @Success 200 {object} []models.ReadCursor
+// @Failure 403 {object} ErrorResponse
+// @Router /api/chat/{matchID}/read [get]
+func (ctrl *ChatController) GetReadReceipts(c *fiber.Ctx) error {
+	matchID := c.Params("matchID")
+	if !ctrl.ChatService.IsChatMember(matchID, c.Locals("user_id").(string)) {
+		return chatMessageError(c, services.ErrMessageForbidden)
+	}
+	cursors, err := ctrl.ChatService.GetReadCursors(matchID)
+	if err != nil {
+		return chatMessageError(c, err)
+	}
+	return c.JSON(cursors)
+}
+
+// @Summary SendTyping
+// @Description Tell the other members of a match chat that the user is typing. Nothing is stored.
+// @Tags Chat
+// @Accept json
+// @Produce json
+// @Param matchID path string true "Match ID"
+// @Param typing body bool true "Whether the user is typing"
+// @Success 204
+// @Failure 403 {object} ErrorResponse
+// @Router /api/chat/{matchID}/typing [post]
+func (ctrl *ChatController) SendTyping(c *fiber.Ctx) error {
+	matchID := c.Params("matchID")
+	userID := c.Locals("user_id").(string)
+	if !ctrl.ChatService.IsChatMember(matchID, userID) {
+		return chatMessageError(c, services.ErrMessageForbidden)
+	}
+	var req struct {
+		Typing bool `json:"typing"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{Error: "Invalid request"})
+	}
+	if err := ctrl.ChatService.PublishTyping(matchID, userID, req.Typing); err != nil {
+		return chatMessageError(c, err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// @Summary MuteChatMember
+// @Description Mute a player in a match chat: they can still read it but no longer write. Only the organizer can do it.
+// @Tags Chat
+// @Accept json
+// @Produce json
+// @Param matchID path string true "Match ID"
+// @Param user_id body string true "Player ID"
+// @Param minutes body int false "Duration in minutes; muted until lifted when omitted"
+// @Success 200 {object} models.ChatRestriction
+// @Failure 403 {object} ErrorResponse
+// @Router /api/chat/{matchID}/mute [post]
+func (ctrl *ChatController) MuteMember(c *fiber.Ctx) error {
+	return ctrl.restrictMember(c, models.ChatMute)
+}
+
+// @Summary KickChatMember
+// @Description Remove a player from a match chat: they can no longer read nor write it. Only the organizer can do it.
+// @Tags Chat
+// @Accept json
+// @Produce json
+// @Param matchID path string true "Match ID"
+// @Param user_id body string true "Player ID"
+// @Param minutes body int false "Duration in minutes; excluded until readmitted when omitted"
+// @Success 200 {object} models.ChatRestriction
+// @Failure 403 {object} ErrorResponse
+// @Router /api/chat/{matchID}/kick [post]
+func (ctrl *ChatController) KickMember(c *fiber.Ctx) error {
+	return ctrl.restrictMember(c, models.ChatKick)
+}
+
+func (ctrl *ChatController) restrictMember(c *fiber.Ctx, restrictionType models.ChatRestrictionType) error {
+	var req struct {
+		UserID  string `json:"user_id"`
+		Minutes int    `json:"minutes"`
+	}
+	if err := c.BodyParser(&req); err != nil || req.UserID == "" || req.Minutes < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(ErrorResponse{Error: "Invalid request"})
+	}
+	var until *time.Time
+	if req.Minutes > 0 {
+		end := time.Now().Add(time.Duration(req.Minutes) * time.Minute)
+		until = &end
+	}
+
+	restriction, err := ctrl.ChatService.RestrictMember(c.Params("matchID"), c.Locals("user_id").(string), req.UserID, restrictionType, until)
+	if err != nil {
+		return chatMessageError(c, err)
+	}
+	return c.JSON(restriction)
+}
+
+// @Summary UnmuteChatMember
+// @Description Lift the mute of a player in a match chat. Only the organizer can do it.
+// @Tags Chat
+// @Produce json
+// @Param matchID path string true "Match ID"
+// @Param userID path string true "Player ID"
+// @Success 204
+// @Failure 403 {object} ErrorResponse
+// @Router /api/chat/{matchID}/mute/{userID} [delete]
+func (ctrl *ChatController) UnmuteMember(c *fiber.Ctx) error {
+	if err := ctrl.ChatService.LiftRestriction(c.Params("matchID"), c.Locals("user_id").(string), c.Params("userID"), models.ChatMute); err != nil {
+		return chatMessageError(c, err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// @Summary ReadmitChatMember
+// @Description Let a kicked player back into a match chat. Only the organizer can do it.
+// @Tags Chat
+// @Produce json
+// @Param matchID path string true "Match ID"
+// @Param userID path string true "Player ID"
+// @Success 204
+// @Failure 403 {object} ErrorResponse
+// @Router /api/chat/{matchID}/kick/{userID} [delete]
+func (ctrl *ChatController) ReadmitMember(c *fiber.Ctx) error {
+	if err := ctrl.ChatService.LiftRestriction(c.Params("matchID"), c.Locals("user_id").(string), c.Params("userID"), models.ChatKick); err != nil {
+		return chatMessageError(c, err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// @Summary GetChatRestrictions
+// @Description Get the players currently muted or kicked from a match chat
+// @Tags Chat
+// @Produce json
+// @Param matchID path string true "Match ID"
+// @Success 200 {object} []models.ChatRestriction
+// @Failure 403 {object} ErrorResponse
+// @Router /api/chat/{matchID}/restrictions [get]
+func (ctrl *ChatController) GetRestrictions(c *fiber.Ctx) error {
+	matchID := c.Params("matchID")
+	if !ctrl.ChatService.IsChatMember(matchID, c.Locals("user_id").(string)) {
+		return chatMessageError(c, services.ErrMessageForbidden)
+	}
+	restrictions, err := ctrl.ChatService.GetRestrictions(matchID)
+	if err != nil {
+		return chatMessageError(c, err)
+	}
+	return c.JSON(restrictions)
+}
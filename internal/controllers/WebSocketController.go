@@ -1,9 +1,13 @@
 package controllers
 
 import (
+	"errors"
 	"log"
+	"time"
 
+	middlewares "github.com/ady243/teamup/internal/middleware"
 	"github.com/ady243/teamup/internal/services"
+	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
 )
 
@@ -15,13 +19,50 @@ func NewWebSocketController(service *services.WebSocketService) *WebSocketContro
 	return &WebSocketController{service: service}
 }
 
-func (ctrl *WebSocketController) WebSocketHandler(c *websocket.Conn) {
-	log.Println("New WebSocket connection established")
-	defer func() {
-		log.Println("WebSocket connection closed")
-	}()
+// UserWebSocketHandler ouvre la connexion personnelle de l'utilisateur authentifié :
+// demandes d'ami et messages privés qui lui sont destinés
+func (ctrl *WebSocketController) UserWebSocketHandler(c *websocket.Conn, session *middlewares.WebSocketSession) {
+	log.Println("WebSocket connection established on /ws")
+	ctrl.service.HandleUserWebSocket(c, session.UserID, session.HandleFrame)
+}
+
+// MultiplexedWebSocketHandler ouvre une connexion unique sur laquelle le client s'abonne aux salons qui
+// l'intéressent (direct, chat ou statut d'un match, groupes) et reçoit leurs événements dans des enveloppes
+func (ctrl *WebSocketController) MultiplexedWebSocketHandler(c *websocket.Conn, session *middlewares.WebSocketSession) {
+	log.Println("WebSocket connection established on /ws/v1")
+	ctrl.service.HandleMultiplexedWebSocket(c, session.UserID, session.HandleFrame)
+}
 
-	matchID := c.Params("match_id")
+// lastEventID renvoie l'événement après lequel un flux SSE reprend : en-tête Last-Event-ID envoyé par
+// le navigateur à la reconnexion, ou paramètre last_event_id pour une première connexion
+func lastEventID(c *fiber.Ctx) string {
+	if id := c.Get("Last-Event-ID"); id != "" {
+		return id
+	}
+	return c.Query("last_event_id")
+}
+
+// streamEvents ouvre un flux Server-Sent Events sur un salon du hub
+func streamEvents(c *fiber.Ctx, service *services.WebSocketService, room string, snapshot func() (interface{}, map[string]bool, error)) error {
+	expiresAt, _ := c.Locals("token_expires_at").(time.Time)
+	stream, err := service.OpenEventStream(c.Locals("user_id").(string), room, lastEventID(c), expiresAt, snapshot)
+	if err != nil {
+		if errors.Is(err, services.ErrHubClosed) {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to open event stream"})
+	}
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	// Désactive la mise en tampon des proxys (nginx)
+	c.Set("X-Accel-Buffering", "no")
+	c.Context().SetBodyStreamWriter(stream)
+	return nil
+}
 
-	ctrl.service.HandleWebSocket(c, matchID)
+// MatchStatusStreamHandler diffuse en Server-Sent Events les changements de statut des matchs,
+// comme /api/matches/status/updates
+func (ctrl *WebSocketController) MatchStatusStreamHandler(c *fiber.Ctx) error {
+	return streamEvents(c, ctrl.service, services.MatchStatusRoom, nil)
 }
@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"errors"
 	"math/rand"
 	"time"
 
@@ -9,6 +10,7 @@ import (
 	"github.com/oklog/ulid/v2"
 	"gorm.io/gorm"
 
+	middlewares "github.com/ady243/teamup/internal/middleware"
 	"github.com/ady243/teamup/internal/models"
 	"github.com/ady243/teamup/internal/services"
 )
@@ -17,33 +19,77 @@ type AnalystController struct {
 	AnalystService   *services.AnalystService
 	AuthService      *services.AuthService
 	WebSocketService *services.WebSocketService
+	MatchService     *services.MatchService
 	DB               *gorm.DB
 }
 
 // NewAnalystController retourne un nouveau contrôleur
-func NewAnalystController(analystService *services.AnalystService, authService *services.AuthService, webSocketService *services.WebSocketService, db *gorm.DB) *AnalystController {
+func NewAnalystController(analystService *services.AnalystService, authService *services.AuthService, webSocketService *services.WebSocketService, matchService *services.MatchService, db *gorm.DB) *AnalystController {
 	return &AnalystController{
 		AnalystService:   analystService,
 		AuthService:      authService,
 		WebSocketService: webSocketService,
+		MatchService:     matchService,
 		DB:               db,
 	}
 }
 
-// WebSocketHandler gère les connexions WebSocket pour un match spécifique
-func (ctrl *AnalystController) WebSocketHandler(c *websocket.Conn) {
+// WebSocketHandler gère les connexions WebSocket pour un match spécifique.
+// Avec ?catchup=true, le client reçoit d'abord la timeline du match puis les événements en direct.
+// Le direct d'un match privé n'est ouvert qu'à son organisateur, son arbitre et ses joueurs.
+func (ctrl *AnalystController) WebSocketHandler(c *websocket.Conn, session *middlewares.WebSocketSession) {
 	matchID := c.Params("match_id")
-	ctrl.WebSocketService.HandleWebSocket(c, matchID)
+	allowed, err := ctrl.MatchService.CanWatchLive(matchID, session.UserID)
+	if err != nil || !allowed {
+		middlewares.CloseWebSocket(c, middlewares.CloseForbidden, "you cannot watch this match")
+		return
+	}
+	if c.Query("catchup") != "true" {
+		ctrl.WebSocketService.HandleWebSocket(c, matchID, session.HandleFrame)
+		return
+	}
+
+	ctrl.WebSocketService.HandleWebSocketWithCatchUp(c, matchID, session.HandleFrame, func() (interface{}, map[string]bool, error) {
+		timeline, err := ctrl.AnalystService.GetMatchTimeline(matchID)
+		if err != nil {
+			return nil, nil, err
+		}
+		return timeline, timeline.DeliveredKeys(), nil
+	})
+}
+
+// EventStreamHandler diffuse en Server-Sent Events les événements en direct d'un match, pour les clients
+// qui ne peuvent pas garder une connexion WebSocket. Mêmes règles d'accès et même ?catchup=true que
+// WebSocketHandler ; après une coupure, le flux reprend après le dernier événement reçu (Last-Event-ID).
+func (ctrl *AnalystController) EventStreamHandler(c *fiber.Ctx) error {
+	matchID := c.Params("match_id")
+	allowed, err := ctrl.MatchService.CanWatchLive(matchID, c.Locals("user_id").(string))
+	if err != nil || !allowed {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "You cannot watch this match"})
+	}
+
+	var snapshot func() (interface{}, map[string]bool, error)
+	if c.Query("catchup") == "true" {
+		snapshot = func() (interface{}, map[string]bool, error) {
+			timeline, err := ctrl.AnalystService.GetMatchTimeline(matchID)
+			if err != nil {
+				return nil, nil, err
+			}
+			return timeline, timeline.DeliveredKeys(), nil
+		}
+	}
+	return streamEvents(c, ctrl.WebSocketService, services.LiveRoom(matchID), snapshot)
 }
 
 // CreateEventHandler crée un nouvel événement (ex: but, carton, etc.)
 func (ctrl *AnalystController) CreateEventHandler(c *fiber.Ctx) error {
 	var req struct {
-		MatchID   string `json:"match_id" binding:"required"`
-		AnalystID string `json:"analyst_id" binding:"required"`
-		PlayerID  string `json:"player_id" binding:"required"`
-		EventType string `json:"event_type" binding:"required"`
-		Minute    int    `json:"minute"`
+		MatchID   string              `json:"match_id" binding:"required"`
+		AnalystID string              `json:"analyst_id" binding:"required"`
+		PlayerID  string              `json:"player_id" binding:"required"`
+		EventType string              `json:"event_type" binding:"required"`
+		Minute    int                 `json:"minute"`
+		Payload   models.EventPayload `json:"payload"`
 	}
 
 	if err := c.BodyParser(&req); err != nil {
@@ -64,6 +110,11 @@ func (ctrl *AnalystController) CreateEventHandler(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid player ID format"})
 	}
 
+	eventType, ok := models.ParseEventType(req.EventType)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Unknown event type: " + req.EventType})
+	}
+
 	// Générer un nouvel ID pour l'événement
 	t := time.Now()
 	entropy := ulid.Monotonic(rand.New(rand.NewSource(t.UnixNano())), 0)
@@ -74,21 +125,43 @@ func (ctrl *AnalystController) CreateEventHandler(c *fiber.Ctx) error {
 		MatchID:   matchID.String(),
 		AnalystID: analystID.String(),
 		PlayerID:  playerID.String(),
-		EventType: req.EventType,
+		EventType: eventType,
 		Minute:    req.Minute,
+		Payload:   req.Payload,
+	}
+
+	if err := ctrl.AnalystService.ValidateEvent(&event); err != nil {
+		if errors.Is(err, services.ErrInvalidEvent) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	// Sauvegarde en base de l'événement
-	if err := ctrl.AnalystService.CreateEvent(&event); err != nil {
+	// Sauvegarde en base de l'événement (et mise à jour du score pour un but)
+	scoreChange, err := ctrl.AnalystService.CreateEvent(&event)
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create event: " + err.Error()})
 	}
 
 	// Diffuser l'événement via WebSocket pour le match spécifique
-	go ctrl.WebSocketService.BroadcastEventToMatch(matchID.String(), event)
+	go func() {
+		ctrl.WebSocketService.BroadcastEventToMatch(matchID.String(), event)
+		if scoreChange != nil {
+			ctrl.WebSocketService.BroadcastEventToMatch(matchID.String(), scoreChange)
+		}
+	}()
 
 	return c.Status(fiber.StatusCreated).JSON(event)
 }
 
+// GetEventCatalogHandler renvoie le catalogue des types d'événements acceptés
+func (ctrl *AnalystController) GetEventCatalogHandler(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"version": models.EventCatalogVersion,
+		"events":  models.EventCatalog,
+	})
+}
+
 // GetEventsByMatchHandler renvoie tous les événements d'un match
 func (ctrl *AnalystController) GetEventsByMatchHandler(c *fiber.Ctx) error {
 	matchID := c.Params("match_id")
@@ -106,6 +179,22 @@ func (ctrl *AnalystController) GetEventsByMatchHandler(c *fiber.Ctx) error {
 	return c.JSON(fiber.Map{"events": events})
 }
 
+// GetMatchTimelineHandler renvoie la timeline d'un match : événements triés par minute,
+// score courant et marqueurs de période
+func (ctrl *AnalystController) GetMatchTimelineHandler(c *fiber.Ctx) error {
+	matchID := c.Params("match_id")
+
+	timeline, err := ctrl.AnalystService.GetMatchTimeline(matchID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Match not found"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(timeline)
+}
+
 // GetEventsByPlayerHandler renvoie tous les événements pour un joueur donné
 func (ctrl *AnalystController) GetEventsByPlayerHandler(c *fiber.Ctx) error {
 	playerID := c.Params("player_id")
@@ -123,8 +212,9 @@ func (ctrl *AnalystController) UpdateEventHandler(c *fiber.Ctx) error {
 	eventID := c.Params("event_id")
 
 	var req struct {
-		EventType *string `json:"event_type"`
-		Minute    *int    `json:"minute"`
+		EventType *string              `json:"event_type"`
+		Minute    *int                 `json:"minute"`
+		Payload   *models.EventPayload `json:"payload"`
 	}
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
@@ -138,17 +228,39 @@ func (ctrl *AnalystController) UpdateEventHandler(c *fiber.Ctx) error {
 
 	// Appliquer les modifications
 	if req.EventType != nil {
-		event.EventType = *req.EventType
+		eventType, ok := models.ParseEventType(*req.EventType)
+		if !ok {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Unknown event type: " + *req.EventType})
+		}
+		event.EventType = eventType
 	}
 	if req.Minute != nil {
 		event.Minute = *req.Minute
 	}
+	if req.Payload != nil {
+		event.Payload = *req.Payload
+	}
 
-	// Sauvegarder
-	if err := ctrl.AnalystService.UpdateEvent(event); err != nil {
+	if err := ctrl.AnalystService.ValidateEvent(event); err != nil {
+		if errors.Is(err, services.ErrInvalidEvent) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
 
+	// Sauvegarder (le score est corrigé si le but change d'équipe, de type ou disparaît)
+	scoreChange, err := ctrl.AnalystService.UpdateEvent(event)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	go func() {
+		ctrl.WebSocketService.BroadcastEventChange(event.MatchID, services.EventMatchEventUpdated, *event)
+		if scoreChange != nil {
+			ctrl.WebSocketService.BroadcastEventToMatch(event.MatchID, scoreChange)
+		}
+	}()
+
 	return c.JSON(event)
 }
 
@@ -161,12 +273,17 @@ func (ctrl *AnalystController) DeleteEventHandler(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Event not found"})
 	}
 
-	now := time.Now()
-	event.DeletedAt = &now
-
-	if err := ctrl.AnalystService.UpdateEvent(event); err != nil {
+	scoreChange, err := ctrl.AnalystService.SoftDeleteEvent(event.ID)
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
 
+	go func() {
+		ctrl.WebSocketService.BroadcastEventChange(event.MatchID, services.EventMatchEventDeleted, *event)
+		if scoreChange != nil {
+			ctrl.WebSocketService.BroadcastEventToMatch(event.MatchID, scoreChange)
+		}
+	}()
+
 	return c.JSON(fiber.Map{"message": "Event marked as deleted"})
 }
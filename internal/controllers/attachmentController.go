@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/ady243/teamup/internal/services"
+	"github.com/gofiber/fiber/v2"
+)
+
+type AttachmentController struct {
+	AttachmentService *services.AttachmentService
+}
+
+func NewAttachmentController(attachmentService *services.AttachmentService) *AttachmentController {
+	return &AttachmentController{
+		AttachmentService: attachmentService,
+	}
+}
+
+// attachmentError convertit les erreurs des pièces jointes en réponse HTTP
+func attachmentError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, services.ErrAttachmentNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrFileTooLarge):
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrUnsupportedFileType):
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrInfectedFile):
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": err.Error()})
+	}
+	return chatMessageError(c, err)
+}
+
+// UploadAttachmentHandler enregistre une photo ou un message vocal pour une conversation.
+// Formulaire multipart : file, conversation_kind (match, friend ou group) et conversation_id
+// (identifiant du match ou du groupe, ou de l'ami destinataire). L'identifiant renvoyé est ensuite envoyé
+// dans le champ attachment_id du message.
+func (ctrl *AttachmentController) UploadAttachmentHandler(c *fiber.Ctx) error {
+	file, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "file is required"})
+	}
+	kind := c.FormValue("conversation_kind")
+	conversationID := c.FormValue("conversation_id")
+	if kind == "" || conversationID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "conversation_kind and conversation_id are required"})
+	}
+
+	attachment, err := ctrl.AttachmentService.Upload(c.Locals("user_id").(string), kind, conversationID, file)
+	if err != nil {
+		return attachmentError(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(attachment)
+}
+
+// GetAttachmentHandler renvoie les informations d'une pièce jointe
+func (ctrl *AttachmentController) GetAttachmentHandler(c *fiber.Ctx) error {
+	attachment, err := ctrl.AttachmentService.Get(c.Params("id"), c.Locals("user_id").(string))
+	if err != nil {
+		return attachmentError(c, err)
+	}
+	return c.JSON(attachment)
+}
+
+// DownloadAttachmentHandler renvoie le fichier d'une pièce jointe aux membres de sa conversation
+func (ctrl *AttachmentController) DownloadAttachmentHandler(c *fiber.Ctx) error {
+	attachment, err := ctrl.AttachmentService.Get(c.Params("id"), c.Locals("user_id").(string))
+	if err != nil {
+		return attachmentError(c, err)
+	}
+
+	c.Set(fiber.HeaderContentDisposition, "inline; filename="+strconv.Quote(attachment.FileName))
+	c.Set(fiber.HeaderCacheControl, "private, max-age=86400")
+	if err := c.SendFile(ctrl.AttachmentService.ImageService.Path(attachment.StoragePath)); err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, attachment.ContentType)
+	return nil
+}
+
+// GetThumbnailHandler renvoie la miniature JPEG d'une photo
+func (ctrl *AttachmentController) GetThumbnailHandler(c *fiber.Ctx) error {
+	attachment, err := ctrl.AttachmentService.Get(c.Params("id"), c.Locals("user_id").(string))
+	if err != nil {
+		return attachmentError(c, err)
+	}
+	if !attachment.HasThumbnail {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "No thumbnail for this attachment"})
+	}
+
+	c.Set(fiber.HeaderCacheControl, "private, max-age=86400")
+	return c.SendFile(ctrl.AttachmentService.ImageService.Path(attachment.ThumbnailPath))
+}
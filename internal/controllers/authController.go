@@ -65,17 +65,11 @@ func (ctrl *AuthController) RegisterHandler(c *fiber.Ctx) error {
 		ConfirmationToken: ulid.MustNew(ulid.Timestamp(time.Now()), ulid.Monotonic(rand.New(rand.NewSource(time.Now().UnixNano())), 0)).String(),
 	}
 
-	user, err := ctrl.AuthService.RegisterUser(userInfo)
-	if err != nil {
+	// L'email de confirmation est envoyé en arrière-plan par l'outbox
+	if _, err := ctrl.AuthService.RegisterUser(userInfo); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	// Envoyer un email de confirmation
-	err = ctrl.AuthService.EmailService.SendConfirmationEmail(user.Email, user.ConfirmationToken)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to send confirmation email"})
-	}
-
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Inscription réussie, veuillez vérifier votre email pour confirmer votre compte"})
 }
 
@@ -138,11 +132,6 @@ func (ctrl *AuthController) UserHandler(c *fiber.Ctx) error {
 // @Param birthDate body string false "Date de naissance (YYYY-MM-DD)"
 // @Param role body string false "Rôle"
 // @Param skillLevel body string false "Niveau de compétence"
-// @Param pac body int false "Pac"
-// @Param sho body int false "Sho"
-// @Param pas body int false "Pas"
-// @Param dri body int false "Dri"
-// @Param def body int false "Def"
 // @Success 200 {object} models.Users
 // @Failure 401 {object} map[string]interface{}
 // @Failure 500 {object} map[string]interface{}
@@ -166,19 +155,10 @@ func (ctrl *AuthController) UserUpdate(c *fiber.Ctx) error {
 		FavoriteSport string `json:"favoriteSport"`
 		Bio           string `json:"bio"`
 		Location      string `json:"location"`
+		Club          string `json:"club"`
 		BirthDate     string `json:"birthDate"`
 		Role          string `json:"role"`
 		SkillLevel    string `json:"skillLevel"`
-		Pac           int    `json:"pac"`
-		Sho           int    `json:"sho"`
-		Pas           int    `json:"pas"`
-		Dri           int    `json:"dri"`
-		Def           int    `json:"def"`
-		Phy           int    `json:"phy"`
-		MatchesPlayed int    `json:"matchesPlayed"`
-		MatchesWon    int    `json:"matchesWon"`
-		GoalsScored   int    `json:"goalsScored"`
-		BehaviorScore int    `json:"behaviorScore"`
 	}
 
 	if err := c.BodyParser(&req); err != nil {
@@ -198,8 +178,7 @@ func (ctrl *AuthController) UserUpdate(c *fiber.Ctx) error {
 
 	user, err := ctrl.AuthService.UpdateUser(
 		userIDStr, req.Username, req.Email, req.Password, req.ProfilePhoto, req.FavoriteSport,
-		req.Location, req.Bio, birthDate, role, req.SkillLevel, req.Pac, req.Sho, req.Pas,
-		req.Dri, req.Def, req.Phy, req.MatchesPlayed, req.MatchesWon, req.GoalsScored, req.BehaviorScore,
+		req.Location, req.Club, req.Bio, birthDate, role, req.SkillLevel,
 	)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
@@ -437,36 +416,6 @@ func (ctrl *AuthController) AssignRefereeRole(c *fiber.Ctx) error {
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Role assigned successfully"})
 }
 
-// @Summary Mettre à jour les statistiques d'un joueur
-// @Description Mettre à jour les statistiques d'un joueur
-// @Tags Auth
-// @Security BearerAuth
-// @Produce json
-// @Param id path string true "User ID"
-// @Success 200 {object} map[string]interface{}
-// @Failure 400 {object} map[string]interface{}
-// @Failure 401 {object} map[string]interface{}
-func (ctrl *AuthController) UpdateUserStatistics(c *fiber.Ctx) error {
-	userID := c.Params("id")
-	var stats struct {
-		MatchesPlayed int `json:"matchesPlayed"`
-		MatchesWon    int `json:"matchesWon"`
-		GoalsScored   int `json:"goalsScored"`
-		BehaviorScore int `json:"behaviorScore"`
-	}
-
-	if err := c.BodyParser(&stats); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
-	}
-
-	err := ctrl.AuthService.UpdateUserStatistics(userID, stats.MatchesPlayed, stats.MatchesWon, stats.GoalsScored, stats.BehaviorScore)
-	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
-	}
-
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "User statistics updated successfully"})
-}
-
 // DeleteUserHandler gère la demande de suppression d'un utilisateur
 // @Summary Supprimer un utilisateur
 // @Description Supprimer un utilisateur
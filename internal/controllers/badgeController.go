@@ -0,0 +1,42 @@
+package controllers
+
+import (
+	"github.com/ady243/teamup/internal/models"
+	"github.com/ady243/teamup/internal/services"
+	"github.com/gofiber/fiber/v2"
+)
+
+type BadgeController struct {
+	BadgeService *services.BadgeService
+}
+
+func NewBadgeController(badgeService *services.BadgeService) *BadgeController {
+	return &BadgeController{
+		BadgeService: badgeService,
+	}
+}
+
+// GetBadgeCatalogHandler renvoie la liste des badges pouvant être obtenus
+func (ctrl *BadgeController) GetBadgeCatalogHandler(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"badges": models.BadgeCatalog})
+}
+
+// GetMyBadgesHandler renvoie les badges de l'utilisateur connecté
+func (ctrl *BadgeController) GetMyBadgesHandler(c *fiber.Ctx) error {
+	badges, err := ctrl.BadgeService.GetUserBadges(c.Locals("user_id").(string))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"badges": badges})
+}
+
+// GetUserBadgesHandler renvoie les badges d'un utilisateur
+func (ctrl *BadgeController) GetUserBadgesHandler(c *fiber.Ctx) error {
+	badges, err := ctrl.BadgeService.GetUserBadges(c.Params("user_id"))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"badges": badges})
+}
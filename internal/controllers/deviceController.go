@@ -0,0 +1,60 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+
+	"github.com/ady243/teamup/internal/services"
+	"github.com/gofiber/fiber/v2"
+)
+
+type DeviceController struct {
+	DeviceService *services.DeviceService
+}
+
+func NewDeviceController(deviceService *services.DeviceService) *DeviceController {
+	return &DeviceController{DeviceService: deviceService}
+}
+
+// deviceError traduit les erreurs du registre des appareils en réponse HTTP
+func deviceError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, services.ErrDeviceNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidDevice):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	log.Printf("Device registry error: %v", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Internal server error"})
+}
+
+// RegisterDeviceHandler enregistre l'appareil de l'utilisateur connecté pour les notifications push.
+// À appeler à chaque lancement de l'application et à chaque renouvellement du token FCM.
+func (ctrl *DeviceController) RegisterDeviceHandler(c *fiber.Ctx) error {
+	var input services.DeviceInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	device, err := ctrl.DeviceService.Register(c.Locals("user_id").(string), input)
+	if err != nil {
+		return deviceError(c, err)
+	}
+	return c.JSON(device)
+}
+
+// GetDevicesHandler liste les appareils de l'utilisateur connecté
+func (ctrl *DeviceController) GetDevicesHandler(c *fiber.Ctx) error {
+	devices, err := ctrl.DeviceService.GetDevices(c.Locals("user_id").(string))
+	if err != nil {
+		return deviceError(c, err)
+	}
+	return c.JSON(devices)
+}
+
+// UnregisterDeviceHandler retire un appareil de l'utilisateur connecté, qui ne reçoit alors plus de push
+func (ctrl *DeviceController) UnregisterDeviceHandler(c *fiber.Ctx) error {
+	if err := ctrl.DeviceService.Unregister(c.Locals("user_id").(string), c.Params("id")); err != nil {
+		return deviceError(c, err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
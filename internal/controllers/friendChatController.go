@@ -2,6 +2,7 @@ package controllers
 
 import (
     "log"
+    "strconv"
 
     "github.com/ady243/teamup/internal/services"
     "github.com/gofiber/fiber/v2"
@@ -26,7 +27,8 @@ func (cc *FriendChatController) SendMessage(c *fiber.Ctx) error {
         SenderID   string `json:"sender_id"`
         ReceiverID string `json:"receiver_id"`
         Content    string `json:"content"`
-        FCMToken   string `json:"fcm_token"` // Ajout du champ FCMToken
+        ReplyToID  *uint  `json:"reply_to_id"`
+        AttachmentID *string `json:"attachment_id"` // Pièce jointe envoyée au préalable
     }
     if err := c.BodyParser(&request); err != nil {
         log.Printf("Error parsing request body: %v", err)
@@ -35,6 +37,17 @@ func (cc *FriendChatController) SendMessage(c *fiber.Ctx) error {
         })
     }
 
+    // L'expéditeur est l'utilisateur connecté ; sender_id reste accepté s'il le désigne
+    userID := c.Locals("user_id").(string)
+    if request.SenderID == "" {
+        request.SenderID = userID
+    }
+    if request.SenderID != userID {
+        return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+            "error": "you can only send messages as yourself",
+        })
+    }
+
     // Vérifiez que sender_id et receiver_id ne sont pas vides
     if request.SenderID == "" || request.ReceiverID == "" {
         log.Printf("SenderID or ReceiverID is empty")
@@ -61,21 +74,9 @@ func (cc *FriendChatController) SendMessage(c *fiber.Ctx) error {
     }
 
     // Envoi du message
-    if err := cc.FriendChatService.SendMessage(request.SenderID, request.ReceiverID, request.Content); err != nil {
+    if err := cc.FriendChatService.SendMessage(request.SenderID, request.ReceiverID, request.Content, request.ReplyToID, request.AttachmentID); err != nil {
         log.Printf("Error sending message: %v", err)
-        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-            "error": err.Error(),
-        })
-    }
-
-    // Envoi de la notification push
-    if request.FCMToken != "" {
-        if err := cc.NotificationService.SendPushNotification(request.FCMToken, "Nouveau message", request.Content); err != nil {
-            log.Printf("Error sending push notification: %v", err)
-            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-                "error": "failed to send push notification",
-            })
-        }
+        return chatMessageError(c, err)
     }
 
     return c.JSON(fiber.Map{
@@ -87,6 +88,14 @@ func (cc *FriendChatController) GetMessages(c *fiber.Ctx) error {
     senderID := c.Params("senderID")
     receiverID := c.Params("receiverID")
 
+    // Seuls les deux participants peuvent lire leur conversation
+    userID := c.Locals("user_id").(string)
+    if userID != senderID && userID != receiverID {
+        return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+            "error": "you are not part of this conversation",
+        })
+    }
+
     areFriends, err := cc.FriendService.AreFriends(senderID, receiverID)
     if err != nil || !areFriends {
         log.Printf("Users are not friends or error occurred: %v", err)
@@ -102,4 +111,129 @@ func (cc *FriendChatController) GetMessages(c *fiber.Ctx) error {
         })
     }
     return c.JSON(messages)
-}
\ No newline at end of file
+}
+
+// messageIDParam lit l'identifiant du message dans l'URL
+func messageIDParam(c *fiber.Ctx) (uint, bool) {
+    id, err := strconv.ParseUint(c.Params("messageID"), 10, 64)
+    if err != nil {
+        return 0, false
+    }
+    return uint(id), true
+}
+
+func (cc *FriendChatController) EditMessage(c *fiber.Ctx) error {
+    messageID, ok := messageIDParam(c)
+    if !ok {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid message id"})
+    }
+    var request struct {
+        Content string `json:"content"`
+    }
+    if err := c.BodyParser(&request); err != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "cannot parse JSON"})
+    }
+
+    message, err := cc.FriendChatService.EditMessage(messageID, c.Locals("user_id").(string), request.Content)
+    if err != nil {
+        return chatMessageError(c, err)
+    }
+    return c.JSON(message)
+}
+
+func (cc *FriendChatController) DeleteMessage(c *fiber.Ctx) error {
+    messageID, ok := messageIDParam(c)
+    if !ok {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid message id"})
+    }
+
+    message, err := cc.FriendChatService.DeleteMessage(messageID, c.Locals("user_id").(string))
+    if err != nil {
+        return chatMessageError(c, err)
+    }
+    return c.JSON(message)
+}
+
+func (cc *FriendChatController) GetMessageHistory(c *fiber.Ctx) error {
+    messageID, ok := messageIDParam(c)
+    if !ok {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid message id"})
+    }
+
+    history, err := cc.FriendChatService.GetEditHistory(messageID, c.Locals("user_id").(string))
+    if err != nil {
+        return chatMessageError(c, err)
+    }
+    return c.JSON(history)
+}
+
+func (cc *FriendChatController) AddReaction(c *fiber.Ctx) error {
+    return cc.setReaction(c, true)
+}
+
+func (cc *FriendChatController) RemoveReaction(c *fiber.Ctx) error {
+    return cc.setReaction(c, false)
+}
+
+func (cc *FriendChatController) setReaction(c *fiber.Ctx, add bool) error {
+    messageID, ok := messageIDParam(c)
+    if !ok {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid message id"})
+    }
+    var request struct {
+        Emoji string `json:"emoji"`
+    }
+    if err := c.BodyParser(&request); err != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "cannot parse JSON"})
+    }
+
+    reactions, err := cc.FriendChatService.SetReaction(messageID, c.Locals("user_id").(string), request.Emoji, add)
+    if err != nil {
+        return chatMessageError(c, err)
+    }
+    return c.JSON(fiber.Map{"message_id": messageID, "reactions": reactions})
+}
+
+func (cc *FriendChatController) MarkRead(c *fiber.Ctx) error {
+    var request struct {
+        UserID    string `json:"user_id"` // Interlocuteur
+        MessageID uint   `json:"message_id"`
+    }
+    if err := c.BodyParser(&request); err != nil || request.UserID == "" || request.MessageID == 0 {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "user_id and message_id are required"})
+    }
+
+    cursor, err := cc.FriendChatService.MarkRead(c.Locals("user_id").(string), request.UserID, request.MessageID)
+    if err != nil {
+        return chatMessageError(c, err)
+    }
+    return c.JSON(cursor)
+}
+
+// GetReadReceipt renvoie jusqu'où l'interlocuteur a lu la conversation
+func (cc *FriendChatController) GetReadReceipt(c *fiber.Ctx) error {
+    cursor, err := cc.FriendChatService.GetReadCursor(c.Locals("user_id").(string), c.Params("userID"))
+    if err != nil {
+        return chatMessageError(c, err)
+    }
+    return c.JSON(fiber.Map{"read_cursor": cursor})
+}
+
+func (cc *FriendChatController) SendTyping(c *fiber.Ctx) error {
+    var request struct {
+        ReceiverID string `json:"receiver_id"`
+        Typing     bool   `json:"typing"`
+    }
+    if err := c.BodyParser(&request); err != nil || request.ReceiverID == "" {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "receiver_id is required"})
+    }
+
+    userID := c.Locals("user_id").(string)
+    areFriends, err := cc.FriendService.AreFriends(userID, request.ReceiverID)
+    if err != nil || !areFriends {
+        return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "users are not friends"})
+    }
+
+    cc.FriendChatService.PublishTyping(userID, request.ReceiverID, request.Typing)
+    return c.SendStatus(fiber.StatusNoContent)
+}
@@ -3,20 +3,17 @@ package controllers
 import (
 	"log"
 
-	"github.com/ady243/teamup/internal/models"
 	"github.com/ady243/teamup/internal/services"
 	"github.com/gofiber/fiber/v2"
 )
 
 type FriendController struct {
-	FriendService       *services.FriendService
-	NotificationService *services.NotificationService
+	FriendService *services.FriendService
 }
 
-func NewFriendController(friendService *services.FriendService, notificationService *services.NotificationService) *FriendController {
+func NewFriendController(friendService *services.FriendService) *FriendController {
 	return &FriendController{
-		FriendService:       friendService,
-		NotificationService: notificationService,
+		FriendService: friendService,
 	}
 }
 
@@ -32,6 +29,17 @@ func (fc *FriendController) SendFriendRequest(c *fiber.Ctx) error {
 		})
 	}
 
+	// L'expéditeur est l'utilisateur connecté ; sender_id reste accepté s'il le désigne
+	userID := c.Locals("user_id").(string)
+	if request.SenderId == "" {
+		request.SenderId = userID
+	}
+	if request.SenderId != userID {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "you can only send friend requests as yourself",
+		})
+	}
+
 	if request.SenderId == "" || request.ReceiverId == "" {
 		log.Printf("SenderId or ReceiverId is empty")
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -53,24 +61,6 @@ func (fc *FriendController) SendFriendRequest(c *fiber.Ctx) error {
 		})
 	}
 
-	var receiver models.Users
-	if err := fc.FriendService.DB.Where("id = ?", request.ReceiverId).First(&receiver).Error; err != nil {
-		log.Printf("Failed to fetch receiver: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Receiver not found",
-		})
-	}
-
-	// Envoyer une notification push au destinataire
-	err := fc.NotificationService.SendPushNotification(
-		receiver.FCMToken,
-		"TeamUp rélation",
-		"Vous avez reçu une nouvelle demande d'ami de "+receiver.Username,
-	)
-	if err != nil {
-		log.Printf("Failed to send push notification: %v", err)
-	}
-
 	return c.JSON(fiber.Map{
 		"message": "friend request sent successfully",
 	})
@@ -87,6 +77,10 @@ func (fc *FriendController) AcceptFriendRequest(c *fiber.Ctx) error {
 		})
 	}
 
+	if err := checkFriendRequestReceiver(c, &request.ReceiverId); err != nil {
+		return err
+	}
+
 	if request.SenderId == "" || request.ReceiverId == "" {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "sender_id and receiver_id cannot be empty",
@@ -99,25 +93,6 @@ func (fc *FriendController) AcceptFriendRequest(c *fiber.Ctx) error {
 		})
 	}
 
-	// Récupérer le token FCM de l'expéditeur
-	var sender models.Users
-	if err := fc.FriendService.DB.Where("id = ?", request.SenderId).First(&sender).Error; err != nil {
-		log.Printf("Failed to fetch sender: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Sender not found",
-		})
-	}
-
-	// Envoyer une notification push à l'expéditeur
-	err := fc.NotificationService.SendPushNotification(
-		sender.FCMToken,
-		"TeamUp rélation",
-		"Votre demande d'ami a été acceptée par "+sender.Username,
-	)
-	if err != nil {
-		log.Printf("Failed to send push notification: %v", err)
-	}
-
 	return c.JSON(fiber.Map{
 		"message": "friend request accepted successfully",
 	})
@@ -134,36 +109,36 @@ func (fc *FriendController) DeclineFriendRequest(c *fiber.Ctx) error {
 		})
 	}
 
-	if err := fc.FriendService.DeclineFriendRequest(request.SenderId, request.ReceiverId); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": err.Error(),
-		})
+	if err := checkFriendRequestReceiver(c, &request.ReceiverId); err != nil {
+		return err
 	}
 
-	// Récupérer le token FCM de l'expéditeur
-	var sender models.Users
-	if err := fc.FriendService.DB.Where("id = ?", request.SenderId).First(&sender).Error; err != nil {
-		log.Printf("Failed to fetch sender: %v", err)
+	if err := fc.FriendService.DeclineFriendRequest(request.SenderId, request.ReceiverId); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Sender not found",
+			"error": err.Error(),
 		})
 	}
 
-	// Envoyer une notification push à l'expéditeur
-	err := fc.NotificationService.SendPushNotification(
-		sender.FCMToken,
-		"TeamUp rélation",
-		"Votre demande d'ami a été refusée par "+sender.Username,
-	)
-	if err != nil {
-		log.Printf("Failed to send push notification: %v", err)
-	}
-
 	return c.JSON(fiber.Map{
 		"message": "friend request declined successfully",
 	})
 }
 
+// checkFriendRequestReceiver vérifie que l'utilisateur connecté est le destinataire de la demande :
+// lui seul peut l'accepter ou la refuser. receiver_id absent désigne l'utilisateur connecté.
+func checkFriendRequestReceiver(c *fiber.Ctx, receiverID *string) error {
+	userID := c.Locals("user_id").(string)
+	if *receiverID == "" {
+		*receiverID = userID
+	}
+	if *receiverID != userID {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "only the receiver can answer a friend request",
+		})
+	}
+	return nil
+}
+
 func (fc *FriendController) GetFriendRequests(c *fiber.Ctx) error {
 	userID := c.Params("userID")
 	friendRequests, err := fc.FriendService.GetFriendRequests(userID)
@@ -0,0 +1,295 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	middlewares "github.com/ady243/teamup/internal/middleware"
+	"github.com/ady243/teamup/internal/models"
+	"github.com/ady243/teamup/internal/services"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+)
+
+type GroupController struct {
+	GroupService     *services.GroupService
+	PresenceService  *services.PresenceService
+	WebSocketService *services.WebSocketService
+}
+
+func NewGroupController(groupService *services.GroupService, presenceService *services.PresenceService, webSocketService *services.WebSocketService) *GroupController {
+	return &GroupController{
+		GroupService:     groupService,
+		PresenceService:  presenceService,
+		WebSocketService: webSocketService,
+	}
+}
+
+// groupError convertit les erreurs des groupes en réponse HTTP
+func groupError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, services.ErrGroupNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrNotGroupAdmin), errors.Is(err, services.ErrNotFriendsWith):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidGroup):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrGroupFull):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	}
+	return chatMessageError(c, err)
+}
+
+// CreateGroupHandler crée un groupe avec des amis de l'utilisateur connecté.
+// Corps : {"name": "...", "user_ids": ["..."]}
+func (ctrl *GroupController) CreateGroupHandler(c *fiber.Ctx) error {
+	var req struct {
+		Name    string   `json:"name"`
+		UserIDs []string `json:"user_ids"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	group, err := ctrl.GroupService.CreateGroup(c.Locals("user_id").(string), req.Name, req.UserIDs)
+	if err != nil {
+		return groupError(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(group)
+}
+
+// GetGroupsHandler renvoie les groupes de l'utilisateur connecté
+func (ctrl *GroupController) GetGroupsHandler(c *fiber.Ctx) error {
+	groups, err := ctrl.GroupService.GetUserGroups(c.Locals("user_id").(string))
+	if err != nil {
+		return groupError(c, err)
+	}
+	return c.JSON(groups)
+}
+
+// GetGroupHandler renvoie un groupe et ses membres
+func (ctrl *GroupController) GetGroupHandler(c *fiber.Ctx) error {
+	group, err := ctrl.GroupService.GetGroup(c.Params("id"), c.Locals("user_id").(string))
+	if err != nil {
+		return groupError(c, err)
+	}
+	return c.JSON(group)
+}
+
+// RenameGroupHandler renomme un groupe ; réservé aux administrateurs. Corps : {"name": "..."}
+func (ctrl *GroupController) RenameGroupHandler(c *fiber.Ctx) error {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	group, err := ctrl.GroupService.Rename(c.Params("id"), c.Locals("user_id").(string), req.Name)
+	if err != nil {
+		return groupError(c, err)
+	}
+	return c.JSON(group)
+}
+
+// AddMembersHandler ajoute des amis de l'administrateur au groupe. Corps : {"user_ids": ["..."]}
+func (ctrl *GroupController) AddMembersHandler(c *fiber.Ctx) error {
+	var req struct {
+		UserIDs []string `json:"user_ids"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	group, err := ctrl.GroupService.AddMembers(c.Params("id"), c.Locals("user_id").(string), req.UserIDs)
+	if err != nil {
+		return groupError(c, err)
+	}
+	return c.JSON(group)
+}
+
+// RemoveMemberHandler retire un membre du groupe ; réservé aux administrateurs
+func (ctrl *GroupController) RemoveMemberHandler(c *fiber.Ctx) error {
+	if err := ctrl.GroupService.RemoveMember(c.Params("id"), c.Locals("user_id").(string), c.Params("userID")); err != nil {
+		return groupError(c, err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// SetMemberRoleHandler nomme un membre administrateur ou lui retire ce rôle. Corps : {"role": "admin" | "member"}
+func (ctrl *GroupController) SetMemberRoleHandler(c *fiber.Ctx) error {
+	var req struct {
+		Role models.GroupRole `json:"role"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	member, err := ctrl.GroupService.SetMemberRole(c.Params("id"), c.Locals("user_id").(string), c.Params("userID"), req.Role)
+	if err != nil {
+		return groupError(c, err)
+	}
+	return c.JSON(member)
+}
+
+// LeaveGroupHandler fait quitter le groupe à l'utilisateur connecté
+func (ctrl *GroupController) LeaveGroupHandler(c *fiber.Ctx) error {
+	if err := ctrl.GroupService.Leave(c.Params("id"), c.Locals("user_id").(string)); err != nil {
+		return groupError(c, err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// SendMessageHandler envoie un message dans un groupe, avec le même corps que le chat d'un match.
+// Un nouvel envoi avec le même client_msg_id renvoie le message d'origine avec le statut 200.
+func (ctrl *GroupController) SendMessageHandler(c *fiber.Ctx) error {
+	var req services.ChatMessageInput
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	message, created, err := ctrl.GroupService.SendMessage(c.Params("id"), c.Locals("user_id").(string), req)
+	if err != nil {
+		return groupError(c, err)
+	}
+	status := fiber.StatusCreated
+	if !created {
+		status = fiber.StatusOK
+	}
+	return c.Status(status).JSON(message)
+}
+
+// GetMessagesHandler renvoie les messages d'un groupe, du plus ancien au plus récent ; voir ChatController.GetMessages
+func (ctrl *GroupController) GetMessagesHandler(c *fiber.Ctx) error {
+	before := c.Query("before")
+	after := c.Query("after")
+	if before != "" && after != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Use either before or after, not both"})
+	}
+
+	messages, err := ctrl.GroupService.GetMessages(c.Params("id"), c.Locals("user_id").(string), before, after, c.QueryInt("limit", 50))
+	if err != nil {
+		return groupError(c, err)
+	}
+	return c.JSON(messages)
+}
+
+// DeleteMessageHandler supprime un message pour tous les membres ; l'auteur et les administrateurs peuvent le faire
+func (ctrl *GroupController) DeleteMessageHandler(c *fiber.Ctx) error {
+	message, err := ctrl.GroupService.DeleteMessage(c.Params("id"), c.Params("messageID"), c.Locals("user_id").(string))
+	if err != nil {
+		return groupError(c, err)
+	}
+	return c.JSON(message)
+}
+
+// MarkReadHandler avance le curseur de lecture de l'utilisateur connecté. Corps : {"message_id": "..."}
+func (ctrl *GroupController) MarkReadHandler(c *fiber.Ctx) error {
+	var req struct {
+		MessageID string `json:"message_id"`
+	}
+	if err := c.BodyParser(&req); err != nil || req.MessageID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	cursor, err := ctrl.GroupService.MarkRead(c.Params("id"), c.Locals("user_id").(string), req.MessageID)
+	if err != nil {
+		return groupError(c, err)
+	}
+	return c.JSON(cursor)
+}
+
+// GetReadReceiptsHandler renvoie le dernier message lu par chaque membre du groupe
+func (ctrl *GroupController) GetReadReceiptsHandler(c *fiber.Ctx) error {
+	cursors, err := ctrl.GroupService.GetReadCursors(c.Params("id"), c.Locals("user_id").(string))
+	if err != nil {
+		return groupError(c, err)
+	}
+	return c.JSON(cursors)
+}
+
+// SendTypingHandler signale aux autres membres que l'utilisateur écrit. Corps : {"typing": true}
+func (ctrl *GroupController) SendTypingHandler(c *fiber.Ctx) error {
+	var req struct {
+		Typing bool `json:"typing"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if err := ctrl.GroupService.PublishTyping(c.Params("id"), c.Locals("user_id").(string), req.Typing); err != nil {
+		return groupError(c, err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// GroupWebSocketHandler diffuse en temps réel les messages et événements d'un groupe à l'un de ses membres.
+// Les trames reçues suivent le protocole du chat d'un match : messages, {"type": "typing"} et {"type": "read"}.
+func (ctrl *GroupController) GroupWebSocketHandler(c *websocket.Conn, session *middlewares.WebSocketSession) {
+	groupID := c.Params("id")
+	userID := session.UserID
+	if !ctrl.GroupService.IsMember(groupID, userID) {
+		log.Println("User not in group:", userID)
+		middlewares.CloseWebSocket(c, middlewares.CloseForbidden, "you are not a member of this group")
+		return
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(services.PresenceTTL / 2)
+		defer ticker.Stop()
+		for {
+			if err := ctrl.PresenceService.Heartbeat(userID); err != nil {
+				log.Printf("Erreur de mise à jour de la présence : %v", err)
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	// Le hub diffuse les événements du groupe ; un membre retiré du groupe ou qui l'a quitté est déconnecté
+	// après avoir reçu l'annonce
+	ctrl.WebSocketService.HandleRoomWebSocket(c, userID, services.GroupRoom(groupID), session.HandleFrame, func(raw []byte, reply func([]byte)) {
+		var incoming struct {
+			services.ChatMessageInput
+			Type      string `json:"type"`
+			Typing    bool   `json:"typing"`
+			MessageID string `json:"message_id"`
+		}
+		if err := json.Unmarshal(raw, &incoming); err != nil {
+			incoming.Type = ""
+			incoming.ChatMessageInput = services.ChatMessageInput{Message: string(raw)}
+		}
+
+		switch incoming.Type {
+		case "typing":
+			if err := ctrl.GroupService.PublishTyping(groupID, userID, incoming.Typing); err != nil {
+				log.Printf("Erreur de diffusion de l'indicateur de saisie : %v", err)
+			}
+			return
+		case "read":
+			if _, err := ctrl.GroupService.MarkRead(groupID, userID, incoming.MessageID); err != nil {
+				errJSON, _ := json.Marshal(fiber.Map{"type": "error", "message_id": incoming.MessageID, "error": err.Error()})
+				reply(errJSON)
+			}
+			return
+		}
+
+		message, created, err := ctrl.GroupService.SendMessage(groupID, userID, incoming.ChatMessageInput)
+		if err != nil {
+			errJSON, _ := json.Marshal(fiber.Map{"type": "error", "client_msg_id": incoming.ClientMsgID, "error": err.Error()})
+			reply(errJSON)
+			return
+		}
+		if !created {
+			// Nouvel envoi d'un message déjà diffusé : seul l'expéditeur le reçoit de nouveau
+			msgJSON, _ := json.Marshal(message)
+			reply(msgJSON)
+		}
+	})
+}
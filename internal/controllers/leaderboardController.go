@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ady243/teamup/internal/services"
+	"github.com/gofiber/fiber/v2"
+)
+
+type LeaderboardController struct {
+	LeaderboardService *services.LeaderboardService
+}
+
+func NewLeaderboardController(leaderboardService *services.LeaderboardService) *LeaderboardController {
+	return &LeaderboardController{
+		LeaderboardService: leaderboardService,
+	}
+}
+
+// parseLeaderboardQuery lit les paramètres communs aux routes de classement
+func (ctrl *LeaderboardController) parseLeaderboardQuery(c *fiber.Ctx) (services.LeaderboardQuery, error) {
+	query := services.LeaderboardQuery{
+		Metric:    c.Params("metric"),
+		Scope:     c.Query("scope", "global"),
+		Season:    c.Query("season", "all"),
+		Latitude:  c.QueryFloat("latitude"),
+		Longitude: c.QueryFloat("longitude"),
+		RadiusKm:  c.QueryFloat("radius_km"),
+		Offset:    int64(c.QueryInt("offset", 0)),
+		Limit:     int64(c.QueryInt("limit", 20)),
+	}
+	switch query.Scope {
+	case "city":
+		query.Value = c.Query("city")
+	case "club":
+		query.Value = c.Query("club")
+	}
+
+	err := ctrl.LeaderboardService.ResolveQuery(&query, c.Locals("user_id").(string))
+	return query, err
+}
+
+// GetLeaderboardMetricsHandler liste les métriques de classement disponibles
+func (ctrl *LeaderboardController) GetLeaderboardMetricsHandler(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"metrics": services.LeaderboardMetrics,
+		"scopes":  []string{"global", "city", "club", "radius"},
+		"season":  services.SeasonOf(time.Now()),
+	})
+}
+
+// GetLeaderboardHandler renvoie une page d'un classement
+func (ctrl *LeaderboardController) GetLeaderboardHandler(c *fiber.Ctx) error {
+	query, err := ctrl.parseLeaderboardQuery(c)
+	if err != nil {
+		return leaderboardError(c, err)
+	}
+
+	entries, total, err := ctrl.LeaderboardService.GetLeaderboard(query)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"query": query, "total": total, "entries": entries})
+}
+
+// GetLeaderboardAroundMeHandler renvoie le rang de l'utilisateur connecté et ses voisins de classement
+func (ctrl *LeaderboardController) GetLeaderboardAroundMeHandler(c *fiber.Ctx) error {
+	query, err := ctrl.parseLeaderboardQuery(c)
+	if err != nil {
+		return leaderboardError(c, err)
+	}
+
+	around := int64(c.QueryInt("around", 5))
+	if around < 0 || around > 50 {
+		around = 5
+	}
+	entries, me, err := ctrl.LeaderboardService.GetAroundPlayer(query, c.Locals("user_id").(string), around)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"query": query, "me": me, "entries": entries})
+}
+
+func leaderboardError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, services.ErrUnknownMetric) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+}
@@ -1,16 +1,18 @@
 package controllers
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"math/rand"
 	"net/http"
 	"net/url"
 	"os"
 	"time"
 
+	middlewares "github.com/ady243/teamup/internal/middleware"
 	"github.com/ady243/teamup/internal/models"
 	"github.com/ady243/teamup/internal/services"
 	"github.com/go-redis/redis/v8"
@@ -29,6 +31,12 @@ type MatchController struct {
 	DB                  *gorm.DB
 	NotificationService *services.NotificationService
 	MatchPlayersService *services.MatchPlayersService
+	RatingService       *services.RatingService
+	ReportService       *services.ReportService
+	LeaderboardService  *services.LeaderboardService
+	BadgeService        *services.BadgeService
+	PresenceService     *services.PresenceService
+	WebSocketService    *services.WebSocketService
 }
 
 type GeoResponse struct {
@@ -42,7 +50,7 @@ type GeoResponse struct {
 	} `json:"results"`
 }
 
-func NewMatchController(matchService *services.MatchService, authService *services.AuthService, db *gorm.DB, chatService *services.ChatService, redisClient *redis.Client, matchPlayersService *services.MatchPlayersService) *MatchController {
+func NewMatchController(matchService *services.MatchService, authService *services.AuthService, db *gorm.DB, chatService *services.ChatService, redisClient *redis.Client, matchPlayersService *services.MatchPlayersService, ratingService *services.RatingService, reportService *services.ReportService, leaderboardService *services.LeaderboardService, badgeService *services.BadgeService, presenceService *services.PresenceService, webSocketService *services.WebSocketService) *MatchController {
 	return &MatchController{
 		MatchService:        matchService,
 		AuthService:         authService,
@@ -50,6 +58,12 @@ func NewMatchController(matchService *services.MatchService, authService *servic
 		ChatService:         chatService,
 		RedisClient:         redisClient,
 		MatchPlayersService: matchPlayersService,
+		RatingService:       ratingService,
+		ReportService:       reportService,
+		LeaderboardService:  leaderboardService,
+		BadgeService:        badgeService,
+		PresenceService:     presenceService,
+		WebSocketService:    webSocketService,
 	}
 }
 
@@ -272,6 +286,7 @@ func (ctrl *MatchController) UpdateMatchHandler(c *fiber.Ctx) error {
 		Address         string  `json:"address"`
 		NumberOfPlayers int     `json:"number_of_players"`
 		Status          *string `json:"status"`
+		PrivateLive     *bool   `json:"private_live"`
 	}
 
 	if err := c.BodyParser(&req); err != nil {
@@ -329,6 +344,9 @@ func (ctrl *MatchController) UpdateMatchHandler(c *fiber.Ctx) error {
 	if req.NumberOfPlayers != 0 {
 		match.NumberOfPlayers = req.NumberOfPlayers
 	}
+	if req.PrivateLive != nil {
+		match.PrivateLive = *req.PrivateLive
+	}
 	if req.Status != nil {
 		match.Status = models.Status(*req.Status)
 	}
@@ -387,56 +405,80 @@ func (ctrl *MatchController) DeleteMatchHandler(c *fiber.Ctx) error {
 	return c.SendStatus(fiber.StatusNoContent)
 }
 
-func (ctrl *MatchController) ChatWebSocketHandler(c *websocket.Conn) {
+func (ctrl *MatchController) ChatWebSocketHandler(c *websocket.Conn, session *middlewares.WebSocketSession) {
 	matchID := c.Params("id")
-	userID := c.Locals("user_id").(string)
+	userID := session.UserID
 
-	// Vérifier si l'utilisateur est dans le match
-	if err := ctrl.MatchService.IsUserInMatch(matchID, userID); err != nil {
-		c.Close()
-		log.Println("User not in match:", err)
+	// Seuls l'organisateur et les joueurs non exclus du chat peuvent s'y connecter
+	if !ctrl.ChatService.IsChatMember(matchID, userID) {
+		log.Println("User not allowed in match chat:", userID)
+		middlewares.CloseWebSocket(c, middlewares.CloseForbidden, "you are not a member of this chat")
 		return
 	}
 
-	room := "match:" + matchID
-	ctx := context.Background()
-	pubsub := ctrl.RedisClient.Subscribe(ctx, room)
-	defer pubsub.Close()
-
-	// Goroutine pour écouter les messages de Redis
+	// La connexion au chat vaut signal de présence, renouvelé tant qu'elle reste ouverte
+	done := make(chan struct{})
+	defer close(done)
 	go func() {
+		ticker := time.NewTicker(services.PresenceTTL / 2)
+		defer ticker.Stop()
 		for {
-			msg, err := pubsub.ReceiveMessage(ctx)
-			if err != nil {
-				log.Printf("Erreur de réception de message dans Redis : %v", err)
-				break
+			if err := ctrl.PresenceService.Heartbeat(userID); err != nil {
+				log.Printf("Erreur de mise à jour de la présence : %v", err)
 			}
-			if err := c.WriteMessage(websocket.TextMessage, []byte(msg.Payload)); err != nil {
-				log.Printf("Erreur d'envoi de message WebSocket : %v", err)
-				break
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
 			}
 		}
 	}()
 
-	for {
-		_, message, err := c.ReadMessage()
-		if err != nil {
-			log.Printf("Erreur de lecture de message WebSocket : %v", err)
-			break
+	// Le hub diffuse les événements du chat ; un joueur exclu est déconnecté après avoir reçu l'annonce de son exclusion
+	ctrl.WebSocketService.HandleRoomWebSocket(c, userID, services.ChatRoom(matchID), session.HandleFrame, func(raw []byte, reply func([]byte)) {
+		// Les clients envoient {"message": "...", "client_msg_id": "...", "reply_to_id": "..."} ; un texte brut reste accepté.
+		// Les trames {"type": "typing", "typing": true} et {"type": "read", "message_id": "..."} ne sont pas des messages.
+		var incoming struct {
+			services.ChatMessageInput
+			Type      string `json:"type"`
+			Typing    bool   `json:"typing"`
+			MessageID string `json:"message_id"`
+		}
+		if err := json.Unmarshal(raw, &incoming); err != nil {
+			incoming.Type = ""
+			incoming.ChatMessageInput = services.ChatMessageInput{Message: string(raw)}
 		}
 
-		// Construire un message avec métadonnées
-		fullMessage := fiber.Map{
-			"playerId":  userID,
-			"message":   string(message),
-			"timestamp": time.Now().Format(time.RFC3339),
+		switch incoming.Type {
+		case "typing":
+			if err := ctrl.ChatService.PublishTyping(matchID, userID, incoming.Typing); err != nil {
+				log.Printf("Erreur de diffusion de l'indicateur de saisie : %v", err)
+			}
+			return
+		case "read":
+			if _, err := ctrl.ChatService.MarkRead(matchID, userID, incoming.MessageID); err != nil {
+				errJSON, _ := json.Marshal(fiber.Map{"type": "error", "message_id": incoming.MessageID, "error": err.Error()})
+				reply(errJSON)
+			}
+			return
+		}
+		if incoming.Message == "" && incoming.AttachmentID == "" {
+			incoming.ChatMessageInput = services.ChatMessageInput{Message: string(raw)}
 		}
 
-		// Publier le message au format JSON
-		msgJSON, _ := json.Marshal(fullMessage)
-		ctrl.RedisClient.Set(ctx, "chat:"+matchID+":"+userID, msgJSON, time.Hour*24*7)
-		ctrl.RedisClient.Publish(ctx, room, string(msgJSON))
-	}
+		// Même traitement que l'envoi REST : enregistrement, diffusion sur le canal du match et notifications
+		message, created, err := ctrl.ChatService.AddMessage(matchID, userID, incoming.ChatMessageInput)
+		if err != nil {
+			errJSON, _ := json.Marshal(fiber.Map{"type": "error", "client_msg_id": incoming.ClientMsgID, "error": err.Error()})
+			reply(errJSON)
+			return
+		}
+		if !created {
+			// Nouvel envoi d'un message déjà diffusé : seul l'expéditeur reçoit de nouveau le message
+			msgJSON, _ := json.Marshal(message)
+			reply(msgJSON)
+		}
+	})
 }
 
 func (ctrl *MatchController) AddPlayerToMatchHandler(c *fiber.Ctx) error {
@@ -466,16 +508,12 @@ func (ctrl *MatchController) AddPlayerToMatchHandler(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not join chat"})
 	}
 
-	// Récupérer le token FCM de l'organisateur
-	var organizer models.Users
-	if err := ctrl.DB.Where("id = ?", match.OrganizerID).First(&organizer).Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Organizer not found"})
-	}
-
-	err = ctrl.NotificationService.SendPushNotification(
-		organizer.FCMToken,
+	err = ctrl.NotificationService.Notify(
+		match.OrganizerID,
+		models.NotificationPlayerJoined,
 		"TeamUp",
 		"Un Nouveau joueur a rejoint le match ! 🥳",
+		models.NotificationData{"screen": "match", "match_id": matchID, "user_id": userID},
 	)
 	if err != nil {
 		log.Printf("Failed to send push notification: %v", err)
@@ -501,7 +539,89 @@ func (ctrl *MatchController) GetNearbyMatchesHandler(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	return c.JSON(matches)
+	// Filtres de niveau optionnels : min_rating / max_rating, ou my_level=true pour les matchs proches de sa note
+	minRating := c.QueryFloat("min_rating", math.Inf(-1))
+	maxRating := c.QueryFloat("max_rating", math.Inf(1))
+	if c.QueryBool("my_level") {
+		rating, err := ctrl.RatingService.GetRating(userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not retrieve user rating"})
+		}
+		tolerance := c.QueryFloat("tolerance", 5)
+		level := services.ConservativeRating(rating.Mu, rating.Sigma)
+		minRating = level - tolerance
+		maxRating = level + tolerance
+	}
+	if math.IsInf(minRating, -1) && math.IsInf(maxRating, 1) {
+		return c.JSON(matches)
+	}
+
+	matchIDs := make([]string, 0, len(matches))
+	for _, match := range matches {
+		matchIDs = append(matchIDs, match.ID)
+	}
+	averages, err := ctrl.RatingService.AverageRatingsForMatches(matchIDs)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	filteredMatches := make([]models.Matches, 0, len(matches))
+	for _, match := range matches {
+		average, ok := averages[match.ID]
+		if ok && average >= minRating && average <= maxRating {
+			filteredMatches = append(filteredMatches, match)
+		}
+	}
+
+	return c.JSON(filteredMatches)
+}
+
+// ConfirmMatchResultHandler permet à l'organisateur de confirmer le score final d'un match.
+// La note des joueurs est ensuite recalculée à partir du résultat, le rapport du match est régénéré, les classements mis à jour et les badges évalués.
+func (ctrl *MatchController) ConfirmMatchResultHandler(c *fiber.Ctx) error {
+	matchID, err := ulid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid match ID"})
+	}
+
+	var req struct {
+		ScoreTeam1 *int `json:"score_team_1"`
+		ScoreTeam2 *int `json:"score_team_2"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.ScoreTeam1 == nil || req.ScoreTeam2 == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "score_team_1 and score_team_2 are required"})
+	}
+
+	userID := c.Locals("user_id").(string)
+	match, err := ctrl.MatchService.ConfirmMatchResult(matchID.String(), userID, *req.ScoreTeam1, *req.ScoreTeam2)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrMatchNotFound):
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		case errors.Is(err, services.ErrNotMatchOrganizer):
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err := ctrl.RatingService.UpdateRatingsForMatch(match.ID); err != nil {
+		// Le match reste sans notes : RateUnratedMatches le reprend à la mise à jour périodique suivante
+		log.Printf("Failed to update ratings for match %s, will retry: %v", match.ID, err)
+	}
+	if err := ctrl.ReportService.Enqueue(match.ID); err != nil {
+		log.Printf("Failed to enqueue report for match %s: %v", match.ID, err)
+	}
+	if err := ctrl.LeaderboardService.ApplyMatch(match.ID); err != nil {
+		log.Printf("Failed to update leaderboards for match %s: %v", match.ID, err)
+	}
+	if err := ctrl.BadgeService.EvaluateMatch(match.ID); err != nil {
+		log.Printf("Failed to evaluate badges for match %s: %v", match.ID, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(match)
 }
 
 // GetMatchByOrganizerIDHandler gets all matches created by the organizer with the given ID.
@@ -551,22 +671,10 @@ func (ctrl *MatchController) PutRefereeIDHandler(c *fiber.Ctx) error {
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Referee ID updated successfully"})
 }
 
-func (ctrl *MatchController) MatchStatusWebSocketHandler(c *websocket.Conn) {
-	ctx := context.Background()
-	pubsub := ctrl.RedisClient.Subscribe(ctx, "match_status_updates")
-	defer pubsub.Close()
-
-	for {
-		msg, err := pubsub.ReceiveMessage(ctx)
-		if err != nil {
-			log.Printf("Erreur de réception de message dans Redis : %v", err)
-			break
-		}
-		if err := c.WriteMessage(websocket.TextMessage, []byte(msg.Payload)); err != nil {
-			log.Printf("Erreur d'envoi de message WebSocket : %v", err)
-			break
-		}
-	}
+func (ctrl *MatchController) MatchStatusWebSocketHandler(c *websocket.Conn, session *middlewares.WebSocketSession) {
+	// Cette connexion reçoit le contenu des événements, sans leur enveloppe ; la lecture détecte
+	// la fermeture de la connexion et reçoit les renouvellements de token
+	ctrl.WebSocketService.HandleRoomWebSocket(c, session.UserID, services.MatchStatusRoom, session.HandleFrame, nil)
 }
 
 func (ctrl *MatchController) AssignRefereeHandler(c *fiber.Ctx) error {
@@ -623,10 +731,12 @@ func (ctrl *MatchController) LeaveMatchHandler(c *fiber.Ctx) error {
 	// Envoyer une notification push aux participants
 	for _, participant := range participants {
 		if participant.PlayerID != userID {
-			err := ctrl.NotificationService.SendPushNotification(
-				participant.Player.FCMToken,
+			err := ctrl.NotificationService.Notify(
+				participant.PlayerID,
+				models.NotificationPlayerLeft,
 				"Teamup match",
 				user.Username+" a quitté le match 😮",
+				models.NotificationData{"screen": "match", "match_id": matchID, "user_id": userID},
 			)
 			if err != nil {
 				log.Printf("Failed to send push notification: %v", err)
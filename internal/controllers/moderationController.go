@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"errors"
+
+	"github.com/ady243/teamup/internal/models"
+	"github.com/ady243/teamup/internal/services"
+	"github.com/gofiber/fiber/v2"
+)
+
+type ModerationController struct {
+	ModerationService *services.ModerationService
+}
+
+func NewModerationController(moderationService *services.ModerationService) *ModerationController {
+	return &ModerationController{
+		ModerationService: moderationService,
+	}
+}
+
+// moderationError convertit les erreurs de modération en réponse HTTP
+func moderationError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, services.ErrReportNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrAlreadyReported), errors.Is(err, services.ErrReportResolved):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidModeration):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	return chatMessageError(c, err)
+}
+
+// ReportMessageHandler signale un message d'un chat de match ou entre amis aux administrateurs
+func (ctrl *ModerationController) ReportMessageHandler(c *fiber.Ctx) error {
+	var req struct {
+		MessageKind string `json:"message_kind"` // match ou friend
+		MessageID   string `json:"message_id"`
+		Reason      string `json:"reason"`
+		Details     string `json:"details"`
+	}
+	if err := c.BodyParser(&req); err != nil || req.MessageID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "message_kind, message_id and reason are required"})
+	}
+
+	report, err := ctrl.ModerationService.ReportMessage(c.Locals("user_id").(string), req.MessageKind, req.MessageID, req.Reason, req.Details)
+	if err != nil {
+		return moderationError(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(report)
+}
+
+// GetReportReasonsHandler renvoie les motifs de signalement acceptés
+func (ctrl *ModerationController) GetReportReasonsHandler(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"reasons": models.ReportReasons})
+}
+
+// GetReportsHandler renvoie la file de modération ; réservé aux administrateurs.
+// Le paramètre status (pending par défaut, ou all) filtre les signalements.
+func (ctrl *ModerationController) GetReportsHandler(c *fiber.Ctx) error {
+	if !ctrl.ModerationService.IsAdmin(c.Locals("user_id").(string)) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": services.ErrNotAdmin.Error()})
+	}
+
+	status := models.MessageReportStatus(c.Query("status", string(models.ModerationPending)))
+	if status == "all" {
+		status = ""
+	}
+	reports, total, err := ctrl.ModerationService.GetReports(status, c.QueryInt("limit", 50), c.QueryInt("offset", 0))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"reports": reports, "total": total})
+}
+
+// ResolveReportHandler classe un signalement (dismiss) ou supprime le message signalé (delete_message) ;
+// réservé aux administrateurs
+func (ctrl *ModerationController) ResolveReportHandler(c *fiber.Ctx) error {
+	adminID := c.Locals("user_id").(string)
+	if !ctrl.ModerationService.IsAdmin(adminID) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": services.ErrNotAdmin.Error()})
+	}
+
+	var req struct {
+		Action string `json:"action"`
+		Note   string `json:"note"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	report, err := ctrl.ModerationService.ResolveReport(c.Params("report_id"), adminID, req.Action, req.Note)
+	if err != nil {
+		return moderationError(c, err)
+	}
+	return c.JSON(report)
+}
@@ -1,6 +1,7 @@
 package controllers
 
 import (
+    "errors"
     "log"
 
     "github.com/ady243/teamup/internal/services"
@@ -43,31 +44,54 @@ func (nc *NotificationController) SendPushNotification(c *fiber.Ctx) error {
     })
 }
 
-func (nc *NotificationController) GetUnreadNotifications(c *fiber.Ctx) error {
-    token := c.Params("token")
+// notificationError traduit les erreurs du service de notifications en réponse HTTP
+func notificationError(c *fiber.Ctx, err error) error {
+    if errors.Is(err, services.ErrNotificationNotFound) {
+        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+    }
+    log.Printf("Notification error: %v", err)
+    return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Internal server error"})
+}
 
-    notifications, err := nc.NotificationService.GetUnreadNotifications(token)
+// GetNotificationsHandler renvoie la boîte de réception de l'utilisateur connecté, de la notification la plus
+// récente à la plus ancienne. Pagination par ?before=<id de la dernière notification reçue>&limit=20 ;
+// ?unread=true ne renvoie que les notifications non lues.
+func (nc *NotificationController) GetNotificationsHandler(c *fiber.Ctx) error {
+    userID := c.Locals("user_id").(string)
+    notifications, err := nc.NotificationService.GetNotifications(userID, c.Query("before"), c.QueryBool("unread"), c.QueryInt("limit", 20))
     if err != nil {
-        log.Printf("Error fetching unread notifications: %v", err)
-        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-            "error": err.Error(),
-        })
+        return notificationError(c, err)
     }
 
-    return c.JSON(notifications)
+    response := fiber.Map{"notifications": notifications}
+    if len(notifications) > 0 {
+        response["next_before"] = notifications[len(notifications)-1].ID
+    }
+    return c.JSON(response)
 }
 
-func (nc *NotificationController) MarkNotificationsAsRead(c *fiber.Ctx) error {
-    token := c.Params("token")
+// GetUnreadCountHandler renvoie le nombre de notifications non lues de l'utilisateur connecté
+func (nc *NotificationController) GetUnreadCountHandler(c *fiber.Ctx) error {
+    count, err := nc.NotificationService.UnreadCount(c.Locals("user_id").(string))
+    if err != nil {
+        return notificationError(c, err)
+    }
+    return c.JSON(fiber.Map{"unread": count})
+}
 
-    if err := nc.NotificationService.MarkNotificationsAsRead(token); err != nil {
-        log.Printf("Error marking notifications as read: %v", err)
-        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-            "error": err.Error(),
-        })
+// MarkReadHandler marque comme lue une notification de l'utilisateur connecté
+func (nc *NotificationController) MarkReadHandler(c *fiber.Ctx) error {
+    if err := nc.NotificationService.MarkRead(c.Locals("user_id").(string), c.Params("id")); err != nil {
+        return notificationError(c, err)
     }
+    return c.SendStatus(fiber.StatusNoContent)
+}
 
-    return c.JSON(fiber.Map{
-        "message": "Notifications marked as read",
-    })
-}
\ No newline at end of file
+// MarkAllReadHandler marque comme lues toutes les notifications de l'utilisateur connecté
+func (nc *NotificationController) MarkAllReadHandler(c *fiber.Ctx) error {
+    updated, err := nc.NotificationService.MarkAllRead(c.Locals("user_id").(string))
+    if err != nil {
+        return notificationError(c, err)
+    }
+    return c.JSON(fiber.Map{"updated": updated})
+}
@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+
+	"github.com/ady243/teamup/internal/models"
+	"github.com/ady243/teamup/internal/services"
+	"github.com/gofiber/fiber/v2"
+)
+
+type NotificationPreferenceController struct {
+	PreferenceService *services.NotificationPreferenceService
+}
+
+func NewNotificationPreferenceController(preferenceService *services.NotificationPreferenceService) *NotificationPreferenceController {
+	return &NotificationPreferenceController{PreferenceService: preferenceService}
+}
+
+// preferenceError traduit les erreurs des préférences de notification en réponse HTTP
+func preferenceError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, services.ErrMuteNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidPreference):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	log.Printf("Notification preference error: %v", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Internal server error"})
+}
+
+// GetPreferencesHandler renvoie les canaux de chaque catégorie et les réglages communs de l'utilisateur connecté
+func (ctrl *NotificationPreferenceController) GetPreferencesHandler(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	preferences, err := ctrl.PreferenceService.GetPreferences(userID)
+	if err != nil {
+		return preferenceError(c, err)
+	}
+	settings, err := ctrl.PreferenceService.GetSettings(userID)
+	if err != nil {
+		return preferenceError(c, err)
+	}
+	return c.JSON(fiber.Map{"preferences": preferences, "settings": settings})
+}
+
+// UpdatePreferenceHandler modifie les canaux (push, email, in_app) d'une catégorie de notifications
+func (ctrl *NotificationPreferenceController) UpdatePreferenceHandler(c *fiber.Ctx) error {
+	var input services.PreferenceInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	category := models.NotificationCategory(c.Params("category"))
+	preference, err := ctrl.PreferenceService.UpdatePreference(c.Locals("user_id").(string), category, input)
+	if err != nil {
+		return preferenceError(c, err)
+	}
+	return c.JSON(preference)
+}
+
+// UpdateSettingsHandler modifie le fuseau horaire, les heures calmes et le masquage du contenu des messages
+func (ctrl *NotificationPreferenceController) UpdateSettingsHandler(c *fiber.Ctx) error {
+	var input services.SettingsInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	settings, err := ctrl.PreferenceService.UpdateSettings(c.Locals("user_id").(string), input)
+	if err != nil {
+		return preferenceError(c, err)
+	}
+	return c.JSON(settings)
+}
+
+// GetMutesHandler liste les conversations coupées de l'utilisateur connecté
+func (ctrl *NotificationPreferenceController) GetMutesHandler(c *fiber.Ctx) error {
+	mutes, err := ctrl.PreferenceService.GetMutes(c.Locals("user_id").(string))
+	if err != nil {
+		return preferenceError(c, err)
+	}
+	return c.JSON(mutes)
+}
+
+// MuteHandler coupe les notifications d'une conversation (ex. {"conversation": "match:<id>", "until": "..."})
+func (ctrl *NotificationPreferenceController) MuteHandler(c *fiber.Ctx) error {
+	var input services.MuteInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	mute, err := ctrl.PreferenceService.Mute(c.Locals("user_id").(string), input)
+	if err != nil {
+		return preferenceError(c, err)
+	}
+	return c.JSON(mute)
+}
+
+// UnmuteHandler rétablit les notifications d'une conversation
+func (ctrl *NotificationPreferenceController) UnmuteHandler(c *fiber.Ctx) error {
+	if err := ctrl.PreferenceService.Unmute(c.Locals("user_id").(string), c.Params("conversation")); err != nil {
+		return preferenceError(c, err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+
+	"github.com/ady243/teamup/internal/models"
+	"github.com/ady243/teamup/internal/services"
+	"github.com/gofiber/fiber/v2"
+)
+
+// OutboxController expose aux administrateurs les livraisons (push, emails) de l'outbox
+type OutboxController struct {
+	OutboxService     *services.OutboxService
+	ModerationService *services.ModerationService
+}
+
+func NewOutboxController(outboxService *services.OutboxService, moderationService *services.ModerationService) *OutboxController {
+	return &OutboxController{OutboxService: outboxService, ModerationService: moderationService}
+}
+
+// outboxError traduit les erreurs de l'outbox en réponse HTTP
+func outboxError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, services.ErrOutboxMessageNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	}
+	log.Printf("Outbox error: %v", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Internal server error"})
+}
+
+// GetOutboxMessagesHandler liste les livraisons de l'outbox ; réservé aux administrateurs.
+// Le paramètre status (dead par défaut, pending, processing ou sent) filtre les livraisons.
+func (ctrl *OutboxController) GetOutboxMessagesHandler(c *fiber.Ctx) error {
+	if !ctrl.ModerationService.IsAdmin(c.Locals("user_id").(string)) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": services.ErrNotAdmin.Error()})
+	}
+	status := models.OutboxStatus(c.Query("status"))
+	messages, total, err := ctrl.OutboxService.GetMessages(status, c.QueryInt("limit", 50), c.QueryInt("offset", 0))
+	if err != nil {
+		return outboxError(c, err)
+	}
+	return c.JSON(fiber.Map{"messages": messages, "total": total})
+}
+
+// RetryOutboxMessageHandler remet en file une livraison en échec définitif ; réservé aux administrateurs
+func (ctrl *OutboxController) RetryOutboxMessageHandler(c *fiber.Ctx) error {
+	if !ctrl.ModerationService.IsAdmin(c.Locals("user_id").(string)) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": services.ErrNotAdmin.Error()})
+	}
+	message, err := ctrl.OutboxService.Retry(c.Params("id"))
+	if err != nil {
+		return outboxError(c, err)
+	}
+	return c.JSON(message)
+}
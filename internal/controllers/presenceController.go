@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"strings"
+
+	"github.com/ady243/teamup/internal/services"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Nombre maximal d'utilisateurs dont la présence peut être demandée en une fois
+const maxPresenceLookup = 100
+
+type PresenceController struct {
+	PresenceService   *services.PresenceService
+	ChatService       *services.ChatService
+	FriendChatService *services.FriendChatService
+	GroupService      *services.GroupService
+}
+
+func NewPresenceController(presenceService *services.PresenceService, chatService *services.ChatService, friendChatService *services.FriendChatService, groupService *services.GroupService) *PresenceController {
+	return &PresenceController{
+		PresenceService:   presenceService,
+		ChatService:       chatService,
+		FriendChatService: friendChatService,
+		GroupService:      groupService,
+	}
+}
+
+// HeartbeatHandler signale que l'utilisateur connecté est en ligne ; à appeler plus souvent que PresenceTTL
+func (ctrl *PresenceController) HeartbeatHandler(c *fiber.Ctx) error {
+	if err := ctrl.PresenceService.Heartbeat(c.Locals("user_id").(string)); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"ttl_seconds": int(services.PresenceTTL.Seconds())})
+}
+
+// OfflineHandler marque l'utilisateur connecté hors ligne, par exemple quand l'application passe en arrière-plan
+func (ctrl *PresenceController) OfflineHandler(c *fiber.Ctx) error {
+	if err := ctrl.PresenceService.SetOffline(c.Locals("user_id").(string)); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// GetPresenceHandler renvoie la présence des utilisateurs passés dans user_ids, séparés par des virgules
+func (ctrl *PresenceController) GetPresenceHandler(c *fiber.Ctx) error {
+	var userIDs []string
+	for _, id := range strings.Split(c.Query("user_ids"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			userIDs = append(userIDs, id)
+		}
+	}
+	if len(userIDs) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "user_ids is required"})
+	}
+	if len(userIDs) > maxPresenceLookup {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Too many user_ids"})
+	}
+
+	presences, err := ctrl.PresenceService.GetPresence(c.Locals("user_id").(string), userIDs)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"presence": presences})
+}
+
+// SetPresenceVisibilityHandler permet à l'utilisateur de masquer sa présence aux autres utilisateurs
+func (ctrl *PresenceController) SetPresenceVisibilityHandler(c *fiber.Ctx) error {
+	var req struct {
+		HidePresence bool `json:"hide_presence"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if err := ctrl.PresenceService.SetPresenceVisibility(c.Locals("user_id").(string), req.HidePresence); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"hide_presence": req.HidePresence})
+}
+
+// GetUnreadCountsHandler renvoie le nombre de messages non lus par conversation et leur total pour le badge de l'application
+func (ctrl *PresenceController) GetUnreadCountsHandler(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	matches, err := ctrl.ChatService.UnreadCounts(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	friends, err := ctrl.FriendChatService.UnreadCounts(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	groups, err := ctrl.GroupService.UnreadCounts(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var total int64
+	for _, count := range matches {
+		total += count
+	}
+	for _, count := range friends {
+		total += count
+	}
+	for _, count := range groups {
+		total += count
+	}
+	return c.JSON(fiber.Map{
+		"matches": matches,
+		"friends": friends,
+		"groups":  groups,
+		"total":   total,
+	})
+}
@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"github.com/ady243/teamup/internal/services"
+	"github.com/gofiber/fiber/v2"
+)
+
+type RatingController struct {
+	RatingService *services.RatingService
+}
+
+func NewRatingController(ratingService *services.RatingService) *RatingController {
+	return &RatingController{
+		RatingService: ratingService,
+	}
+}
+
+// GetPlayerRatingHandler renvoie la note d'un joueur avec son intervalle de confiance
+func (ctrl *RatingController) GetPlayerRatingHandler(c *fiber.Ctx) error {
+	playerID := c.Params("player_id")
+
+	rating, err := ctrl.RatingService.GetRating(playerID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(services.Summarize(rating))
+}
+
+// GetPlayerRatingHistoryHandler renvoie l'historique des notes d'un joueur
+func (ctrl *RatingController) GetPlayerRatingHistoryHandler(c *fiber.Ctx) error {
+	playerID := c.Params("player_id")
+
+	history, err := ctrl.RatingService.GetRatingHistory(playerID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"history": history})
+}
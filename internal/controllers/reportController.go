@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"errors"
+
+	"github.com/ady243/teamup/internal/models"
+	"github.com/ady243/teamup/internal/services"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type ReportController struct {
+	ReportService *services.ReportService
+	MatchService  *services.MatchService
+}
+
+func NewReportController(reportService *services.ReportService, matchService *services.MatchService) *ReportController {
+	return &ReportController{
+		ReportService: reportService,
+		MatchService:  matchService,
+	}
+}
+
+// GetMatchReportHandler renvoie le rapport d'un match au format json (par défaut), csv ou html
+func (ctrl *ReportController) GetMatchReportHandler(c *fiber.Ctx) error {
+	matchID := c.Params("match_id")
+	if !ctrl.ReportService.CanViewReport(matchID, c.Locals("user_id").(string)) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "You are not allowed to view this report"})
+	}
+
+	report, data, err := ctrl.ReportService.GetReport(matchID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Report not found"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return sendReport(c, report, data, c.Query("format", "json"))
+}
+
+// GetSharedReportHandler renvoie un rapport partagé par son organisateur, sans authentification
+func (ctrl *ReportController) GetSharedReportHandler(c *fiber.Ctx) error {
+	report, data, err := ctrl.ReportService.GetReportByShareToken(c.Params("token"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Report not found"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return sendReport(c, report, data, c.Query("format", "html"))
+}
+
+// GetShareLinkHandler renvoie le lien de partage du rapport ; réservé à l'organisateur du match
+func (ctrl *ReportController) GetShareLinkHandler(c *fiber.Ctx) error {
+	matchID := c.Params("match_id")
+	match, err := ctrl.MatchService.GetMatchByID(matchID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Match not found"})
+	}
+	if match.OrganizerID != c.Locals("user_id").(string) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Only the organizer can share this report"})
+	}
+
+	report, _, err := ctrl.ReportService.GetReport(matchID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Report not found"})
+	}
+
+	return c.JSON(fiber.Map{
+		"token": report.ShareToken,
+		"url":   c.BaseURL() + "/reports/shared/" + report.ShareToken,
+	})
+}
+
+// RegenerateReportHandler demande une nouvelle génération du rapport ; réservé à l'organisateur du match
+func (ctrl *ReportController) RegenerateReportHandler(c *fiber.Ctx) error {
+	matchID := c.Params("match_id")
+	match, err := ctrl.MatchService.GetMatchByID(matchID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Match not found"})
+	}
+	if match.OrganizerID != c.Locals("user_id").(string) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Only the organizer can regenerate this report"})
+	}
+	if match.Status != models.Completed {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Match is not completed"})
+	}
+
+	if err := ctrl.ReportService.Enqueue(matchID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"status": models.ReportPending})
+}
+
+// sendReport écrit le rapport dans le format demandé ou son statut s'il n'est pas encore prêt
+func sendReport(c *fiber.Ctx, report *models.MatchReport, data *services.MatchReportData, format string) error {
+	if data == nil {
+		status := fiber.StatusAccepted
+		if report.Status == models.ReportFailed {
+			status = fiber.StatusInternalServerError
+		}
+		return c.Status(status).JSON(report)
+	}
+
+	switch format {
+	case "csv":
+		content, err := services.RenderCSV(data)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+		c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="match-`+data.MatchID+`.csv"`)
+		return c.Send(content)
+	case "html":
+		content, err := services.RenderHTML(data)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		}
+		c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+		return c.Send(content)
+	case "json":
+		return c.JSON(data)
+	}
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Unsupported format, expected json, csv or html"})
+}
@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"github.com/ady243/teamup/internal/models"
+	"github.com/ady243/teamup/internal/services"
+	"github.com/gofiber/fiber/v2"
+)
+
+type ReviewController struct {
+	ReviewService *services.ReviewService
+}
+
+func NewReviewController(reviewService *services.ReviewService) *ReviewController {
+	return &ReviewController{
+		ReviewService: reviewService,
+	}
+}
+
+// SubmitReviewHandler permet à un participant de noter un autre joueur du match
+func (ctrl *ReviewController) SubmitReviewHandler(c *fiber.Ctx) error {
+	var req struct {
+		PlayerID string `json:"player_id"`
+		Pac      int    `json:"pac"`
+		Sho      int    `json:"sho"`
+		Pas      int    `json:"pas"`
+		Dri      int    `json:"dri"`
+		Def      int    `json:"def"`
+		Phy      int    `json:"phy"`
+		FairPlay int    `json:"fair_play"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request format"})
+	}
+
+	review := models.PlayerReview{
+		MatchID:    c.Params("match_id"),
+		ReviewerID: c.Locals("user_id").(string),
+		PlayerID:   req.PlayerID,
+		Pac:        req.Pac,
+		Sho:        req.Sho,
+		Pas:        req.Pas,
+		Dri:        req.Dri,
+		Def:        req.Def,
+		Phy:        req.Phy,
+		FairPlay:   req.FairPlay,
+	}
+	if err := ctrl.ReviewService.SubmitReview(&review); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(review)
+}
+
+// VoteManOfTheMatchHandler enregistre le vote de l'utilisateur connecté pour l'homme du match
+func (ctrl *ReviewController) VoteManOfTheMatchHandler(c *fiber.Ctx) error {
+	var req struct {
+		PlayerID string `json:"player_id"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request format"})
+	}
+
+	matchID := c.Params("match_id")
+	if err := ctrl.ReviewService.VoteManOfTheMatch(matchID, c.Locals("user_id").(string), req.PlayerID); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "Vote recorded"})
+}
+
+// GetManOfTheMatchVotesHandler renvoie le décompte des votes pour l'homme du match
+func (ctrl *ReviewController) GetManOfTheMatchVotesHandler(c *fiber.Ctx) error {
+	votes, err := ctrl.ReviewService.GetManOfTheMatchVotes(c.Params("match_id"))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"votes": votes})
+}
+
+// GetPlayerReviewSummaryHandler renvoie les attributs d'un joueur calculés à partir des notes de ses pairs
+func (ctrl *ReviewController) GetPlayerReviewSummaryHandler(c *fiber.Ctx) error {
+	summary, err := ctrl.ReviewService.GetPlayerSummary(c.Params("player_id"))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(summary)
+}
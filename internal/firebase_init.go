@@ -2,7 +2,7 @@ package internal
 
 import (
 	"context"
-	"log"
+	"fmt"
 
 	firebase "firebase.google.com/go"
 	"google.golang.org/api/option"
@@ -31,7 +31,7 @@ func InitializeFirebase() (*firebase.App, error) {
 		ProjectID: "notification-push-40d24",
 	}, opt)
 	if err != nil {
-		log.Fatalf("erreur lors de l'initialisation de l'application: %v", err)
+		return nil, fmt.Errorf("erreur lors de l'initialisation de l'application: %w", err)
 	}
 	return app, nil
 }
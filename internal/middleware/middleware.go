@@ -114,3 +114,23 @@ func JWTMiddleware(c *fiber.Ctx) error {
 	c.Locals("permissions", permissions)
 	return c.Next()
 }
+
+// StreamAuth authentifie les flux Server-Sent Events comme JWTMiddleware. EventSource ne permet pas d'envoyer
+// d'en-tête : le token est aussi accepté dans le paramètre token. Sa date d'expiration est conservée
+// dans les Locals (token_expires_at) pour clore le flux à ce moment.
+func StreamAuth(c *fiber.Ctx) error {
+	tokenString := tokenFromRequest(c)
+	if tokenString == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Missing token"})
+	}
+	claims, err := ParseToken(tokenString)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+	}
+
+	c.Locals("user_id", claims.UserID.String())
+	c.Locals("user_role", claims.Role)
+	c.Locals("permissions", helpers.GetPermissions(claims.Role))
+	c.Locals("token_expires_at", time.Unix(claims.ExpiresAt, 0))
+	return c.Next()
+}
@@ -0,0 +1,158 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ady243/teamup/internal/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+)
+
+// Codes de fermeture WebSocket des échecs d'authentification et d'autorisation
+const (
+	CloseUnauthorized = 4401 // Token absent, invalide ou expiré
+	CloseForbidden    = 4403 // Utilisateur authentifié sans accès à ce salon
+	CloseAuthTimeout  = 4408 // Aucun message d'authentification reçu à temps
+)
+
+// Délai laissé au client pour envoyer son message d'authentification
+const webSocketAuthTimeout = 10 * time.Second
+
+// authFrame est le message par lequel un client s'authentifie, ou renouvelle son token avant expiration :
+// {"type": "auth", "token": "..."}
+type authFrame struct {
+	Type  string `json:"type"`
+	Token string `json:"token"`
+}
+
+// WebSocketSession est l'identité d'une connexion WebSocket authentifiée. La connexion est fermée
+// avec CloseUnauthorized à l'expiration du token, sauf si le client envoie un nouveau token avant.
+type WebSocketSession struct {
+	UserID string
+	Role   models.Role
+	conn   *websocket.Conn
+	timer  *time.Timer
+	mutex  sync.Mutex
+}
+
+// CloseWebSocket envoie une trame de fermeture avec un code et un motif, sans attendre la réponse du client.
+// Peut être appelée pendant qu'une autre goroutine écrit sur la connexion.
+func CloseWebSocket(c *websocket.Conn, code int, reason string) {
+	if err := c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second)); err != nil {
+		log.Println("Error sending WebSocket close frame:", err)
+	}
+	// Débloque la boucle de lecture du handler, qui libère alors la connexion
+	c.SetReadDeadline(time.Now())
+}
+
+// tokenFromRequest lit le token d'une demande de connexion : en-tête Authorization ou paramètre token
+func tokenFromRequest(c *fiber.Ctx) string {
+	if token := c.Query("token"); token != "" {
+		return token
+	}
+	if parts := strings.Split(c.Get("Authorization"), " "); len(parts) == 2 {
+		return parts[1]
+	}
+	return ""
+}
+
+// AuthenticatedWebSocket remplace websocket.New pour les routes qui exigent un utilisateur connecté.
+// Le token est lu dans l'en-tête Authorization, le paramètre token, ou à défaut dans le premier message
+// du client ; le handler ne reçoit que des connexions authentifiées.
+func AuthenticatedWebSocket(handler func(c *websocket.Conn, session *WebSocketSession)) fiber.Handler {
+	upgrade := websocket.New(func(c *websocket.Conn) {
+		session := authenticate(c)
+		if session == nil {
+			return
+		}
+		defer session.Stop()
+		handler(c, session)
+	})
+	return func(c *fiber.Ctx) error {
+		if !websocket.IsWebSocketUpgrade(c) {
+			return fiber.ErrUpgradeRequired
+		}
+		c.Locals("ws_token", tokenFromRequest(c))
+		return upgrade(c)
+	}
+}
+
+// authenticate valide le token de la connexion, en attendant le message d'authentification si nécessaire.
+// En cas d'échec, la connexion est fermée avec le code correspondant et nil est renvoyé.
+func authenticate(c *websocket.Conn) *WebSocketSession {
+	token, _ := c.Locals("ws_token").(string)
+	if token == "" {
+		c.SetReadDeadline(time.Now().Add(webSocketAuthTimeout))
+		_, raw, err := c.ReadMessage()
+		if err != nil {
+			CloseWebSocket(c, CloseAuthTimeout, "authentication timeout")
+			return nil
+		}
+		c.SetReadDeadline(time.Time{})
+		var frame authFrame
+		if err := json.Unmarshal(raw, &frame); err != nil || frame.Type != "auth" || frame.Token == "" {
+			CloseWebSocket(c, CloseUnauthorized, "first message must be an auth message")
+			return nil
+		}
+		token = frame.Token
+	}
+
+	claims, err := ParseToken(token)
+	if err != nil {
+		CloseWebSocket(c, CloseUnauthorized, "invalid token")
+		return nil
+	}
+	session := &WebSocketSession{
+		UserID: claims.UserID.String(),
+		Role:   claims.Role,
+		conn:   c,
+	}
+	session.expireAt(time.Unix(claims.ExpiresAt, 0))
+	return session
+}
+
+// expireAt programme la fermeture de la connexion à l'expiration du token
+func (s *WebSocketSession) expireAt(expiresAt time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.timer = time.AfterFunc(time.Until(expiresAt), func() {
+		CloseWebSocket(s.conn, CloseUnauthorized, "token expired")
+	})
+}
+
+// HandleFrame traite les messages d'authentification reçus pendant la connexion : un nouveau token du même
+// utilisateur prolonge la session, tout autre token la ferme. Renvoie vrai si le message a été traité,
+// faux si c'est un message ordinaire à transmettre au handler.
+func (s *WebSocketSession) HandleFrame(raw []byte) bool {
+	if !strings.Contains(string(raw), `"auth"`) {
+		return false
+	}
+	var frame authFrame
+	if err := json.Unmarshal(raw, &frame); err != nil || frame.Type != "auth" {
+		return false
+	}
+
+	claims, err := ParseToken(frame.Token)
+	if err != nil || claims.UserID.String() != s.UserID {
+		CloseWebSocket(s.conn, CloseUnauthorized, "invalid token")
+		return true
+	}
+	s.expireAt(time.Unix(claims.ExpiresAt, 0))
+	return true
+}
+
+// Stop annule la fermeture programmée à l'expiration du token
+func (s *WebSocketSession) Stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+}
@@ -1,9 +1,66 @@
 package models
 
+import "time"
+
+// ChatMessage est un message du chat d'un match, conservé en base selon la politique de rétention du match
 type ChatMessage struct {
-    PlayerID   string `json:"playerId"`
-    Message    string `json:"message"`
-    Timestamp  string `json:"timestamp"`
-    Username   string `json:"username"`
-    ProfilePic string `json:"profile_pic"`
-}
\ No newline at end of file
+	ID           string     `json:"id" gorm:"primaryKey;type:varchar(26)"`                                           // ULID, trié par date d'envoi
+	MatchID      string     `json:"matchId" gorm:"type:varchar(26);not null;index;uniqueIndex:idx_chat_client_msg"`  // Référence au match
+	PlayerID     string     `json:"playerId" gorm:"type:varchar(26);not null;uniqueIndex:idx_chat_client_msg"`       // Auteur du message
+	ClientMsgID  *string    `json:"client_msg_id,omitempty" gorm:"type:varchar(64);uniqueIndex:idx_chat_client_msg"` // Clé d'idempotence générée par le client
+	ReplyToID    *string    `json:"reply_to_id,omitempty" gorm:"type:varchar(26);index"`                             // Message auquel celui-ci répond
+	AttachmentID *string    `json:"attachment_id,omitempty" gorm:"type:varchar(26);index"`                           // Photo ou message vocal joint
+	Message      string     `json:"message" gorm:"type:text;not null"`                                               // Vide si le message a été supprimé
+	Timestamp    string     `json:"timestamp" gorm:"-"`                                                              // Date d'envoi au format RFC 3339
+	Username     string     `json:"username" gorm:"-"`                                                               // Pseudo de l'auteur
+	ProfilePic   string     `json:"profile_pic" gorm:"-"`                                                            // Photo de profil de l'auteur
+	Edited       bool       `json:"edited" gorm:"-"`
+	Deleted      bool       `json:"deleted" gorm:"-"`
+	Reactions    Reactions  `json:"reactions,omitempty" gorm:"-"`
+	EditedAt     *time.Time `json:"edited_at,omitempty"`
+	DeletedAt    *time.Time `json:"deleted_at,omitempty"` // Suppression pour tous : le message reste visible comme supprimé
+	CreatedAt    time.Time  `json:"-" gorm:"autoCreateTime;index"`
+
+	Player     Users       `json:"-" gorm:"foreignKey:PlayerID"`
+	Attachment *Attachment `json:"attachment,omitempty" gorm:"foreignKey:AttachmentID"`
+}
+
+// Types de messages auxquels peuvent s'appliquer réactions et historique de modifications
+const (
+	MessageKindMatch  = "match"
+	MessageKindFriend = "friend"
+	MessageKindGroup  = "group"
+)
+
+// Reactions associe chaque emoji aux utilisateurs qui l'ont utilisé
+type Reactions map[string][]string
+
+// MessageReaction est la réaction d'un utilisateur à un message de chat (match ou ami)
+type MessageReaction struct {
+	ID          string    `json:"id" gorm:"primaryKey;type:varchar(26)"`
+	MessageKind string    `json:"message_kind" gorm:"type:varchar(10);not null;uniqueIndex:idx_message_reaction"`
+	MessageID   string    `json:"message_id" gorm:"type:varchar(26);not null;uniqueIndex:idx_message_reaction"`
+	UserID      string    `json:"user_id" gorm:"type:varchar(26);not null;uniqueIndex:idx_message_reaction"`
+	Emoji       string    `json:"emoji" gorm:"type:varchar(32);not null;uniqueIndex:idx_message_reaction"`
+	CreatedAt   time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// MessageEdit conserve le contenu d'un message de chat avant chacune de ses modifications
+type MessageEdit struct {
+	ID              string    `json:"id" gorm:"primaryKey;type:varchar(26)"`
+	MessageKind     string    `json:"message_kind" gorm:"type:varchar(10);not null;index:idx_message_edit"`
+	MessageID       string    `json:"message_id" gorm:"type:varchar(26);not null;index:idx_message_edit"`
+	PreviousContent string    `json:"previous_content" gorm:"type:text"`
+	EditedAt        time.Time `json:"edited_at" gorm:"autoCreateTime"`
+}
+
+// ReadCursor est la position de lecture d'un utilisateur dans une conversation (chat de match ou entre amis)
+type ReadCursor struct {
+	ID                string    `json:"-" gorm:"primaryKey;type:varchar(26)"`
+	UserID            string    `json:"user_id" gorm:"type:varchar(26);not null;uniqueIndex:idx_read_cursor"`
+	ConversationKind  string    `json:"conversation_kind" gorm:"type:varchar(10);not null;uniqueIndex:idx_read_cursor"`
+	ConversationID    string    `json:"conversation_id" gorm:"type:varchar(26);not null;uniqueIndex:idx_read_cursor"` // Match ou ami concerné
+	LastReadMessageID string    `json:"last_read_message_id" gorm:"type:varchar(26);not null"`
+	LastReadMessageAt time.Time `json:"last_read_message_at"` // Date d'envoi du dernier message lu
+	UpdatedAt         time.Time `json:"read_at" gorm:"autoUpdateTime"`
+}
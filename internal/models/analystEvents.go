@@ -0,0 +1,138 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// EventCatalogVersion est la version courante du catalogue d'événements.
+// Elle doit être incrémentée à chaque ajout ou modification d'un type d'événement.
+const EventCatalogVersion = 1
+
+type EventType string
+
+const (
+	EventGoal          EventType = "goal"
+	EventOwnGoal       EventType = "own_goal"
+	EventAssist        EventType = "assist"
+	EventShotOnTarget  EventType = "shot_on_target"
+	EventShotOffTarget EventType = "shot_off_target"
+	EventYellowCard    EventType = "yellow_card"
+	EventRedCard       EventType = "red_card"
+	EventSubstitution  EventType = "substitution"
+	EventPenalty       EventType = "penalty"
+	EventSave          EventType = "save"
+	EventFoul          EventType = "foul"
+)
+
+// EventPayload contient les données propres à chaque type d'événement.
+// Les coordonnées X et Y sont exprimées en pourcentage du terrain (0 à 100).
+type EventPayload struct {
+	AssistBy *string  `json:"assist_by,omitempty"` // Passeur décisif (goal)
+	PlayerIn *string  `json:"player_in,omitempty"` // Joueur entrant (substitution), PlayerID étant le joueur sortant
+	FoulOn   *string  `json:"foul_on,omitempty"`   // Joueur victime de la faute (foul)
+	Scored   *bool    `json:"scored,omitempty"`    // Penalty transformé ou non (penalty)
+	X        *float64 `json:"x,omitempty"`         // Position sur la largeur du terrain
+	Y        *float64 `json:"y,omitempty"`         // Position sur la longueur du terrain
+}
+
+// Value sérialise le payload en JSON pour le stocker en base
+func (p EventPayload) Value() (driver.Value, error) {
+	return json.Marshal(p)
+}
+
+// Scan désérialise le payload JSON lu en base
+func (p *EventPayload) Scan(value interface{}) error {
+	if value == nil {
+		*p = EventPayload{}
+		return nil
+	}
+	var data []byte
+	switch v := value.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return errors.New("invalid event payload type")
+	}
+	if len(data) == 0 {
+		*p = EventPayload{}
+		return nil
+	}
+	return json.Unmarshal(data, p)
+}
+
+// EventDefinition décrit un type d'événement du catalogue et les champs de payload qu'il accepte
+type EventDefinition struct {
+	Type           EventType `json:"type"`
+	Label          string    `json:"label"`
+	RequiredFields []string  `json:"required_fields"`
+	OptionalFields []string  `json:"optional_fields"`
+}
+
+// EventCatalog liste les types d'événements acceptés par l'API
+var EventCatalog = []EventDefinition{
+	{Type: EventGoal, Label: "But", RequiredFields: []string{}, OptionalFields: []string{"assist_by", "x", "y"}},
+	{Type: EventOwnGoal, Label: "But contre son camp", RequiredFields: []string{}, OptionalFields: []string{"x", "y"}},
+	{Type: EventAssist, Label: "Passe décisive", RequiredFields: []string{}, OptionalFields: []string{"x", "y"}},
+	{Type: EventShotOnTarget, Label: "Tir cadré", RequiredFields: []string{}, OptionalFields: []string{"x", "y"}},
+	{Type: EventShotOffTarget, Label: "Tir non cadré", RequiredFields: []string{}, OptionalFields: []string{"x", "y"}},
+	{Type: EventYellowCard, Label: "Carton jaune", RequiredFields: []string{}, OptionalFields: []string{"x", "y"}},
+	{Type: EventRedCard, Label: "Carton rouge", RequiredFields: []string{}, OptionalFields: []string{"x", "y"}},
+	{Type: EventSubstitution, Label: "Remplacement", RequiredFields: []string{"player_in"}, OptionalFields: []string{}},
+	{Type: EventPenalty, Label: "Penalty", RequiredFields: []string{"scored"}, OptionalFields: []string{"x", "y"}},
+	{Type: EventSave, Label: "Arrêt", RequiredFields: []string{}, OptionalFields: []string{"x", "y"}},
+	{Type: EventFoul, Label: "Faute", RequiredFields: []string{}, OptionalFields: []string{"foul_on", "x", "y"}},
+}
+
+// eventTypeAliases associe les libellés envoyés par les anciens clients aux types du catalogue
+var eventTypeAliases = map[string]EventType{
+	"but":                 EventGoal,
+	"csc":                 EventOwnGoal,
+	"but_contre_son_camp": EventOwnGoal,
+	"passe_decisive":      EventAssist,
+	"passe_décisive":      EventAssist,
+	"tir_cadre":           EventShotOnTarget,
+	"tir_cadré":           EventShotOnTarget,
+	"tir_non_cadre":       EventShotOffTarget,
+	"tir_non_cadré":       EventShotOffTarget,
+	"yellow":              EventYellowCard,
+	"carton_jaune":        EventYellowCard,
+	"red":                 EventRedCard,
+	"carton_rouge":        EventRedCard,
+	"sub":                 EventSubstitution,
+	"remplacement":        EventSubstitution,
+	"penalty_kick":        EventPenalty,
+	"arret":               EventSave,
+	"arrêt":               EventSave,
+	"faute":               EventFoul,
+}
+
+// ParseEventType normalise un type d'événement reçu d'un client et vérifie qu'il appartient au catalogue
+func ParseEventType(raw string) (EventType, bool) {
+	normalized := strings.ToLower(strings.TrimSpace(raw))
+	normalized = strings.NewReplacer(" ", "_", "-", "_").Replace(normalized)
+
+	if alias, ok := eventTypeAliases[normalized]; ok {
+		return alias, true
+	}
+	for _, definition := range EventCatalog {
+		if string(definition.Type) == normalized {
+			return definition.Type, true
+		}
+	}
+	return "", false
+}
+
+// GetEventDefinition renvoie la définition d'un type d'événement du catalogue
+func GetEventDefinition(eventType EventType) (EventDefinition, bool) {
+	for _, definition := range EventCatalog {
+		if definition.Type == eventType {
+			return definition, true
+		}
+	}
+	return EventDefinition{}, false
+}
@@ -5,17 +5,30 @@ import (
 )
 
 type Analyst struct {
-	ID        string     `json:"id" gorm:"primaryKey;type:varchar(26)"`
-	MatchID   string     `json:"match_id" gorm:"not null"`   // Référence au match
-	AnalystID string     `json:"analyst_id" gorm:"not null"` // Référence à l'utilisateur qui enregistre l'événement
-	PlayerID  string     `json:"player_id" gorm:"not null"`  // Référence à l'utilisateur (joueur) concerné
-	EventType string     `json:"event_type" gorm:"null"`     // Type de l'événement (ex: goal, yellow_card, etc.)
-	Minute    int        `json:"minute"`                     // Minute de l'événement dans le match
-	CreatedAt time.Time  `json:"created_at" gorm:"autoCreateTime"`
-	UpdatedAt time.Time  `json:"updated_at" gorm:"autoUpdateTime"`
-	DeletedAt *time.Time `json:"deleted_at" gorm:"index"`
+	ID             string       `json:"id" gorm:"primaryKey;type:varchar(26)"`
+	MatchID        string       `json:"match_id" gorm:"not null"`         // Référence au match
+	AnalystID      string       `json:"analyst_id" gorm:"not null"`       // Référence à l'utilisateur qui enregistre l'événement
+	PlayerID       string       `json:"player_id" gorm:"not null"`        // Référence à l'utilisateur (joueur) concerné
+	EventType      EventType    `json:"event_type" gorm:"null"`           // Type de l'événement, issu du catalogue (ex: goal, yellow_card, etc.)
+	Minute         int          `json:"minute"`                           // Minute de l'événement dans le match
+	Payload        EventPayload `json:"payload" gorm:"type:jsonb"`        // Données propres au type d'événement
+	CatalogVersion int          `json:"catalog_version" gorm:"default:1"` // Version du catalogue utilisée pour valider l'événement
+	ScoredFor      *int         `json:"scored_for" gorm:"null"`           // Équipe créditée du but (goal, own_goal), nullable
+	CreatedAt      time.Time    `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt      time.Time    `json:"updated_at" gorm:"autoUpdateTime"`
+	DeletedAt      *time.Time   `json:"deleted_at" gorm:"index"`
 
 	AnalystUser Users   `json:"analyst_user" gorm:"foreignKey:AnalystID"`
 	Player      Users   `json:"player"       gorm:"foreignKey:PlayerID"`
 	Match       Matches `json:"match"        gorm:"foreignKey:MatchID"`
 }
+
+// ScoreChange est le message diffusé aux spectateurs quand le score d'un match change
+type ScoreChange struct {
+	Type       string `json:"type"` // Toujours "score_change"
+	MatchID    string `json:"match_id"`
+	EventID    string `json:"event_id"`
+	Reason     string `json:"reason"` // event_created, event_updated ou event_deleted
+	ScoreTeam1 int    `json:"score_team_1"`
+	ScoreTeam2 int    `json:"score_team_2"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+type AttachmentKind string
+
+const (
+	AttachmentImage AttachmentKind = "image" // Photo ou capture d'écran
+	AttachmentAudio AttachmentKind = "audio" // Message vocal
+)
+
+// Attachment est un fichier envoyé dans un chat (match, ami ou groupe), référencé par son identifiant dans un message.
+// Seuls les membres de la conversation peuvent le télécharger.
+type Attachment struct {
+	ID               string         `json:"id" gorm:"primaryKey;type:varchar(26)"`
+	OwnerID          string         `json:"owner_id" gorm:"type:varchar(26);not null;index"`
+	ConversationKind string         `json:"conversation_kind" gorm:"type:varchar(10);not null"`     // MessageKindMatch, MessageKindFriend ou MessageKindGroup
+	ConversationID   string         `json:"conversation_id" gorm:"type:varchar(26);not null;index"` // Match, groupe, ou destinataire pour un chat entre amis
+	Kind             AttachmentKind `json:"kind" gorm:"type:varchar(10);not null"`                  // image ou audio
+	ContentType      string         `json:"content_type" gorm:"type:varchar(64);not null"`          // Type détecté à partir du contenu du fichier
+	FileName         string         `json:"file_name"`                                              // Nom du fichier envoyé par le client
+	Size             int64          `json:"size"`                                                   // Taille en octets
+	Width            int            `json:"width,omitempty"`                                        // Dimensions des images
+	Height           int            `json:"height,omitempty"`                                       // Dimensions des images
+	HasThumbnail     bool           `json:"has_thumbnail"`                                          // Miniature disponible pour les images
+	StoragePath      string         `json:"-" gorm:"not null"`                                      // Chemin relatif au dossier d'upload
+	ThumbnailPath    string         `json:"-"`                                                      // Chemin relatif de la miniature
+	CreatedAt        time.Time      `json:"created_at" gorm:"autoCreateTime;index"`
+}
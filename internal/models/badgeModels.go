@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+type BadgeCode string
+
+const (
+	BadgeFirstMatch        BadgeCode = "first_match"
+	BadgeHatTrick          BadgeCode = "hat_trick"
+	BadgeTenOrganised      BadgeCode = "ten_matches_organised"
+	BadgeWinStreak         BadgeCode = "win_streak_5"
+	BadgePerfectAttendance BadgeCode = "perfect_attendance"
+)
+
+// UserBadge est un badge obtenu par un utilisateur ; chaque badge n'est attribué qu'une fois
+type UserBadge struct {
+	ID        string    `json:"id" gorm:"primaryKey;type:varchar(26)"`
+	UserID    string    `json:"user_id" gorm:"not null;uniqueIndex:idx_user_badge"`               // Référence à l'utilisateur
+	Code      BadgeCode `json:"code" gorm:"type:varchar(40);not null;uniqueIndex:idx_user_badge"` // Badge obtenu
+	MatchID   *string   `json:"match_id" gorm:"null"`                                             // Match ayant débloqué le badge, s'il y en a un
+	AwardedAt time.Time `json:"awarded_at" gorm:"autoCreateTime"`
+}
+
+// BadgeDefinition décrit un badge du catalogue
+type BadgeDefinition struct {
+	Code        BadgeCode `json:"code"`
+	Label       string    `json:"label"`
+	Description string    `json:"description"`
+}
+
+// BadgeCatalog liste les badges pouvant être obtenus
+var BadgeCatalog = []BadgeDefinition{
+	{Code: BadgeFirstMatch, Label: "Premier match", Description: "Jouer son premier match"},
+	{Code: BadgeHatTrick, Label: "Coup du chapeau", Description: "Marquer au moins trois buts dans un même match"},
+	{Code: BadgeTenOrganised, Label: "Organisateur", Description: "Organiser dix matchs menés à leur terme"},
+	{Code: BadgeWinStreak, Label: "Série de victoires", Description: "Gagner cinq matchs d'affilée"},
+	{Code: BadgePerfectAttendance, Label: "Assiduité parfaite", Description: "Jouer au moins un match par semaine pendant quatre semaines consécutives"},
+}
+
+// GetBadgeDefinition renvoie la définition d'un badge du catalogue
+func GetBadgeDefinition(code BadgeCode) (BadgeDefinition, bool) {
+	for _, definition := range BadgeCatalog {
+		if definition.Code == code {
+			return definition, true
+		}
+	}
+	return BadgeDefinition{}, false
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+type DevicePlatform string
+
+const (
+	PlatformAndroid DevicePlatform = "android"
+	PlatformIOS     DevicePlatform = "ios"
+	PlatformWeb     DevicePlatform = "web"
+)
+
+// Device est un appareil d'un utilisateur qui reçoit les notifications push. Un token FCM n'appartient
+// qu'à un appareil : il passe au nouvel utilisateur si un autre compte se connecte sur le même appareil.
+type Device struct {
+	ID         string         `json:"id" gorm:"primaryKey;type:varchar(26)"`
+	UserID     string         `json:"user_id" gorm:"type:varchar(26);not null;index"`
+	Platform   DevicePlatform `json:"platform" gorm:"type:varchar(10);not null"`
+	Token      string         `json:"token" gorm:"type:varchar(512);not null;uniqueIndex"`
+	AppVersion string         `json:"app_version" gorm:"type:varchar(30)"`
+	Locale     string         `json:"locale" gorm:"type:varchar(20)"`
+	LastSeenAt time.Time      `json:"last_seen_at" gorm:"index"`
+	CreatedAt  time.Time      `json:"created_at" gorm:"autoCreateTime"`
+}
@@ -5,9 +5,15 @@ import (
 )
 
 type Message struct {
-	ID         uint      `gorm:"primaryKey"`
-	SenderID   string    `gorm:"not null"`
-	ReceiverID string    `gorm:"not null"`
-	Content    string    `gorm:"not null"`
-	CreatedAt  time.Time `gorm:"autoCreateTime"`
+	ID           uint        `gorm:"primaryKey"`
+	SenderID     string      `gorm:"not null"`
+	ReceiverID   string      `gorm:"not null"`
+	Content      string      `gorm:"not null"`               // Vide si le message a été supprimé
+	ReplyToID    *uint       `gorm:"index"`                  // Message auquel celui-ci répond
+	AttachmentID *string     `gorm:"type:varchar(26);index"` // Photo ou message vocal joint
+	EditedAt     *time.Time  // Date de la dernière modification
+	DeletedAt    *time.Time  // Suppression pour tous : le message reste visible comme supprimé
+	CreatedAt    time.Time   `gorm:"autoCreateTime"`
+	Reactions    Reactions   `gorm:"-"`
+	Attachment   *Attachment `gorm:"foreignKey:AttachmentID"`
 }
@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+type GroupRole string
+
+const (
+	GroupRoleAdmin  GroupRole = "admin"  // Peut renommer le groupe, gérer les membres et leurs rôles
+	GroupRoleMember GroupRole = "member" // Peut écrire et quitter le groupe
+)
+
+// GroupConversation est une conversation entre plusieurs amis, indépendante de tout match
+type GroupConversation struct {
+	ID        string        `json:"id" gorm:"primaryKey;type:varchar(26)"`
+	Name      string        `json:"name" gorm:"type:varchar(100);not null"`
+	CreatedBy string        `json:"created_by" gorm:"type:varchar(26);not null"`
+	CreatedAt time.Time     `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt time.Time     `json:"updated_at" gorm:"autoUpdateTime"`
+	Members   []GroupMember `json:"members" gorm:"foreignKey:GroupID;constraint:OnDelete:CASCADE"`
+}
+
+// GroupMember est l'appartenance d'un utilisateur à un groupe
+type GroupMember struct {
+	ID       string    `json:"-" gorm:"primaryKey;type:varchar(26)"`
+	GroupID  string    `json:"group_id" gorm:"type:varchar(26);not null;uniqueIndex:idx_group_member"`
+	UserID   string    `json:"user_id" gorm:"type:varchar(26);not null;uniqueIndex:idx_group_member;index"`
+	Role     GroupRole `json:"role" gorm:"type:varchar(10);not null;default:member"`
+	JoinedAt time.Time `json:"joined_at" gorm:"autoCreateTime"`
+
+	User Users `json:"-" gorm:"foreignKey:UserID"`
+}
+
+// GroupMessage est un message d'une conversation de groupe
+type GroupMessage struct {
+	ID           string     `json:"id" gorm:"primaryKey;type:varchar(26)"` // ULID, trié par date d'envoi
+	GroupID      string     `json:"group_id" gorm:"type:varchar(26);not null;index;uniqueIndex:idx_group_client_msg"`
+	SenderID     string     `json:"sender_id" gorm:"type:varchar(26);not null;uniqueIndex:idx_group_client_msg"`
+	ClientMsgID  *string    `json:"client_msg_id,omitempty" gorm:"type:varchar(64);uniqueIndex:idx_group_client_msg"` // Clé d'idempotence générée par le client
+	ReplyToID    *string    `json:"reply_to_id,omitempty" gorm:"type:varchar(26);index"`                              // Message auquel celui-ci répond
+	AttachmentID *string    `json:"attachment_id,omitempty" gorm:"type:varchar(26);index"`                            // Photo ou message vocal joint
+	Content      string     `json:"content" gorm:"type:text;not null"`                                                // Vide si le message a été supprimé
+	DeletedAt    *time.Time `json:"deleted_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at" gorm:"autoCreateTime;index"`
+	Username     string     `json:"username" gorm:"-"`    // Pseudo de l'expéditeur
+	ProfilePic   string     `json:"profile_pic" gorm:"-"` // Photo de profil de l'expéditeur
+
+	Sender     Users       `json:"-" gorm:"foreignKey:SenderID"`
+	Attachment *Attachment `json:"attachment,omitempty" gorm:"foreignKey:AttachmentID"`
+}
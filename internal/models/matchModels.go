@@ -14,23 +14,27 @@ const (
 )
 
 type Matches struct {
-	ID              string     `json:"id" gorm:"primaryKey;type:varchar(26)"`   // ID du match
-	OrganizerID     string     `json:"organizer_id" gorm:"not null"`            // Référence vers l'ID de l'organisateur (Users.id)
-	Organizer       Users      `json:"organizer" gorm:"foreignKey:OrganizerID"` // Clé étrangère vers Users
-	RefereeID       *string    `json:"referee_id" gorm:"null"`                  // ID de l'arbitre, nullable (Users.id)
-	Referee         *Users     `json:"referee" gorm:"foreignKey:RefereeID"`     // Clé étrangère vers Users, nullable
-	Description     *string    `json:"description" gorm:"null"`                 // Description du match, nullable
-	MatchDate       time.Time  `json:"date" gorm:"not null"`                    // Date du match
-	MatchTime       time.Time  `json:"time" gorm:"not null"`                    // Heure du match
-	EndTime         time.Time  `json:"end_time" gorm:"not null"`                // Heure de fin du match
-	Address         string     `json:"address" gorm:"not null"`                 // Adresse du match
-	NumberOfPlayers int        `json:"number_of_players" gorm:"not null"`       // Nombre de joueurs
-	ScoreTeam1      int        `json:"score_team_1" gorm:"default:0"`           // Score de l'équipe 1
-	ScoreTeam2      int        `json:"score_team_2" gorm:"default:0"`           // Score de l'équipe 2
-	Status          Status     `json:"status"`                                  // Statut du match
-	Latitude        float64    `json:"latitude"`                                // Latitude du match
-	Longitude       float64    `json:"longitude"`                               // Longitude du match
-	CreatedAt       time.Time  `json:"created_at" gorm:"autoCreateTime"`        // Date de création
-	UpdatedAt       time.Time  `json:"updated_at" gorm:"autoUpdateTime"`        // Date de mise à jour
-	DeletedAt       *time.Time `json:"deleted_at" gorm:"index"`                 // Date de suppression (soft delete)
+	ID                string     `json:"id" gorm:"primaryKey;type:varchar(26)"`   // ID du match
+	OrganizerID       string     `json:"organizer_id" gorm:"not null"`            // Référence vers l'ID de l'organisateur (Users.id)
+	Organizer         Users      `json:"organizer" gorm:"foreignKey:OrganizerID"` // Clé étrangère vers Users
+	RefereeID         *string    `json:"referee_id" gorm:"null"`                  // ID de l'arbitre, nullable (Users.id)
+	Referee           *Users     `json:"referee" gorm:"foreignKey:RefereeID"`     // Clé étrangère vers Users, nullable
+	Description       *string    `json:"description" gorm:"null"`                 // Description du match, nullable
+	MatchDate         time.Time  `json:"date" gorm:"not null"`                    // Date du match
+	MatchTime         time.Time  `json:"time" gorm:"not null"`                    // Heure du match
+	EndTime           time.Time  `json:"end_time" gorm:"not null"`                // Heure de fin du match
+	Address           string     `json:"address" gorm:"not null"`                 // Adresse du match
+	NumberOfPlayers   int        `json:"number_of_players" gorm:"not null"`       // Nombre de joueurs
+	ScoreTeam1        int        `json:"score_team_1" gorm:"default:0"`           // Score de l'équipe 1
+	ScoreTeam2        int        `json:"score_team_2" gorm:"default:0"`           // Score de l'équipe 2
+	Status            Status     `json:"status"`                                  // Statut du match
+	Latitude          float64    `json:"latitude"`                                // Latitude du match
+	Longitude         float64    `json:"longitude"`                               // Longitude du match
+	ResultConfirmedAt *time.Time `json:"result_confirmed_at" gorm:"null"`         // Date de confirmation du résultat par l'organisateur
+	ReminderSentAt    *time.Time `json:"-" gorm:"null"`                           // Date d'envoi du rappel aux joueurs
+	ChatRetentionDays *int       `json:"chat_retention_days" gorm:"null"`         // Durée de conservation des messages du chat, durée par défaut si nulle
+	PrivateLive       bool       `json:"private_live" gorm:"default:false"`       // Direct réservé à l'organisateur, l'arbitre et les joueurs
+	CreatedAt         time.Time  `json:"created_at" gorm:"autoCreateTime"`        // Date de création
+	UpdatedAt         time.Time  `json:"updated_at" gorm:"autoUpdateTime"`        // Date de mise à jour
+	DeletedAt         *time.Time `json:"deleted_at" gorm:"index"`                 // Date de suppression (soft delete)
 }
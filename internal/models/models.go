@@ -18,6 +18,7 @@ type Users struct {
 	ProfilePhoto  string     `json:"profile_photo"`
 	FavoriteSport string     `json:"favorite_sport"`
 	Location      string     `json:"location"`
+	Club          string     `json:"club"`
 	Latitude      float64    `json:"latitude"`
 	Longitude     float64    `json:"longitude"`
 	SkillLevel    string     `json:"skill_level"`
@@ -41,6 +42,9 @@ type Users struct {
 
 	SentFriendRequests     []FriendRequest `json:"sent_friend_requests" gorm:"foreignKey:SenderId"`
 	ReceivedFriendRequests []FriendRequest `json:"received_friend_requests" gorm:"foreignKey:ReceiverId"`
+	Badges                 []UserBadge     `json:"badges" gorm:"foreignKey:UserID"`
 
-	FCMToken string `json:"fcm_token"`
+	FCMToken     string `json:"fcm_token"`
+	HidePresence bool   `json:"hide_presence" gorm:"default:false"` // Masque le statut en ligne et la dernière connexion aux autres utilisateurs
+	IsAdmin      bool   `json:"is_admin" gorm:"default:false"`      // Accès à la file de modération
 }
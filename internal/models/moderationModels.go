@@ -0,0 +1,51 @@
+package models
+
+import "time"
+
+type MessageReportStatus string
+
+const (
+	ModerationPending   MessageReportStatus = "pending"
+	ModerationDismissed MessageReportStatus = "dismissed" // Signalement examiné sans suite
+	ModerationActioned  MessageReportStatus = "actioned"  // Message supprimé par un administrateur
+)
+
+// MessageReport est le signalement d'un message de chat (match ou ami) par un membre de la conversation.
+// Le contenu est copié au moment du signalement pour rester consultable si le message est modifié.
+type MessageReport struct {
+	ID             string              `json:"id" gorm:"primaryKey;type:varchar(26)"`
+	MessageKind    string              `json:"message_kind" gorm:"type:varchar(10);not null;uniqueIndex:idx_message_report"`
+	MessageID      string              `json:"message_id" gorm:"type:varchar(26);not null;uniqueIndex:idx_message_report"`
+	ReporterID     string              `json:"reporter_id" gorm:"type:varchar(26);not null;uniqueIndex:idx_message_report"`
+	ReportedUserID string              `json:"reported_user_id" gorm:"type:varchar(26);not null;index"`
+	ConversationID string              `json:"conversation_id" gorm:"type:varchar(26);not null"` // Match, ou expéditeur pour un chat entre amis
+	Content        string              `json:"content" gorm:"type:text"`
+	Reason         string              `json:"reason" gorm:"type:varchar(50);not null"`
+	Details        string              `json:"details" gorm:"type:text"`
+	Status         MessageReportStatus `json:"status" gorm:"type:varchar(20);not null;default:pending;index"`
+	ResolvedBy     *string             `json:"resolved_by,omitempty" gorm:"type:varchar(26)"`
+	ResolvedAt     *time.Time          `json:"resolved_at,omitempty"`
+	Resolution     string              `json:"resolution,omitempty" gorm:"type:text"` // Note de l'administrateur
+	CreatedAt      time.Time           `json:"created_at" gorm:"autoCreateTime;index"`
+}
+
+// Motifs de signalement acceptés
+var ReportReasons = []string{"spam", "harassment", "hate_speech", "inappropriate_content", "other"}
+
+type ChatRestrictionType string
+
+const (
+	ChatMute ChatRestrictionType = "mute" // Peut lire le chat mais plus y écrire
+	ChatKick ChatRestrictionType = "kick" // N'a plus accès au chat du match
+)
+
+// ChatRestriction est une sanction appliquée par l'organisateur à un participant du chat d'un match
+type ChatRestriction struct {
+	ID        string              `json:"id" gorm:"primaryKey;type:varchar(26)"`
+	MatchID   string              `json:"match_id" gorm:"type:varchar(26);not null;uniqueIndex:idx_chat_restriction"`
+	UserID    string              `json:"user_id" gorm:"type:varchar(26);not null;uniqueIndex:idx_chat_restriction"`
+	Type      ChatRestrictionType `json:"type" gorm:"type:varchar(10);not null;uniqueIndex:idx_chat_restriction"`
+	Until     *time.Time          `json:"until,omitempty"` // Sans date de fin, la sanction dure jusqu'à sa levée
+	CreatedBy string              `json:"created_by" gorm:"type:varchar(26);not null"`
+	CreatedAt time.Time           `json:"created_at" gorm:"autoCreateTime"`
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+type NotificationType string
+
+const (
+	NotificationFriendRequest  NotificationType = "friend_request"
+	NotificationFriendAccepted NotificationType = "friend_request_accepted"
+	NotificationFriendDeclined NotificationType = "friend_request_declined"
+	NotificationFriendMessage  NotificationType = "friend_message"
+	NotificationChatMessage    NotificationType = "chat_message"
+	NotificationGroupMessage   NotificationType = "group_message"
+	NotificationPlayerJoined   NotificationType = "player_joined"
+	NotificationPlayerLeft     NotificationType = "player_left"
+	NotificationBadgeUnlocked  NotificationType = "badge_unlocked"
+	NotificationMatchReminder  NotificationType = "match_reminder"
+	NotificationMatchResult    NotificationType = "match_result"
+)
+
+// NotificationData est le lien profond d'une notification : l'écran à ouvrir et ses identifiants
+// (ex. {"screen": "match_chat", "match_id": "..."}). Il est aussi envoyé dans les données du push.
+type NotificationData map[string]string
+
+// Value sérialise les données en JSON pour les stocker en base
+func (d NotificationData) Value() (driver.Value, error) {
+	if d == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(d)
+	return string(data), err
+}
+
+// Scan désérialise les données JSON lues en base
+func (d *NotificationData) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*d = NotificationData{}
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return errors.New("invalid notification data type")
+	}
+	return json.Unmarshal(data, d)
+}
+
+// Notification est une entrée de la boîte de réception d'un utilisateur, conservée qu'elle ait été
+// envoyée en push ou non. Les messages d'une même conversation (CollapseKey) sont regroupés dans une
+// seule notification tant qu'elle n'est pas lue, Count comptant les messages regroupés.
+type Notification struct {
+	ID          string           `json:"id" gorm:"primaryKey;type:varchar(26)"`
+	UserID      string           `json:"user_id" gorm:"type:varchar(26);not null;index"`
+	Type        NotificationType `json:"type" gorm:"type:varchar(40);not null"`
+	Title       string           `json:"title" gorm:"type:varchar(255);not null"`
+	Body        string           `json:"body" gorm:"type:text"`
+	Data        NotificationData `json:"data" gorm:"type:jsonb"`
+	CollapseKey string           `json:"collapse_key,omitempty" gorm:"type:varchar(40);index"`
+	Count       int              `json:"count" gorm:"not null;default:1"`
+	ReadAt      *time.Time       `json:"read_at,omitempty"`
+	CreatedAt   time.Time        `json:"created_at" gorm:"autoCreateTime;index"`
+	UpdatedAt   time.Time        `json:"updated_at" gorm:"autoUpdateTime"`
+}
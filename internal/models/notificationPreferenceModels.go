@@ -0,0 +1,78 @@
+package models
+
+import "time"
+
+// NotificationCategory regroupe les types de notifications sur lesquels l'utilisateur règle ses préférences
+type NotificationCategory string
+
+const (
+	CategoryMatchChat      NotificationCategory = "match_chat"
+	CategoryGroupChat      NotificationCategory = "group_chat"
+	CategoryDirectMessages NotificationCategory = "direct_messages"
+	CategoryFriendRequests NotificationCategory = "friend_requests"
+	CategoryMatchReminders NotificationCategory = "match_reminders"
+	CategoryMatchResults   NotificationCategory = "match_results"
+	CategoryMatchActivity  NotificationCategory = "match_activity"
+)
+
+// NotificationCategories liste les catégories dans l'ordre où elles sont présentées à l'utilisateur
+var NotificationCategories = []NotificationCategory{
+	CategoryMatchChat,
+	CategoryGroupChat,
+	CategoryDirectMessages,
+	CategoryFriendRequests,
+	CategoryMatchReminders,
+	CategoryMatchResults,
+	CategoryMatchActivity,
+}
+
+// Category renvoie la catégorie de préférences d'un type de notification
+func (t NotificationType) Category() NotificationCategory {
+	switch t {
+	case NotificationChatMessage:
+		return CategoryMatchChat
+	case NotificationGroupMessage:
+		return CategoryGroupChat
+	case NotificationFriendMessage:
+		return CategoryDirectMessages
+	case NotificationFriendRequest, NotificationFriendAccepted, NotificationFriendDeclined:
+		return CategoryFriendRequests
+	case NotificationMatchReminder:
+		return CategoryMatchReminders
+	case NotificationMatchResult, NotificationBadgeUnlocked:
+		return CategoryMatchResults
+	}
+	return CategoryMatchActivity
+}
+
+// NotificationPreference indique sur quels canaux un utilisateur reçoit une catégorie de notifications.
+// Sans ligne enregistrée, la catégorie est envoyée en push et dans l'application, pas par email.
+type NotificationPreference struct {
+	UserID    string               `json:"-" gorm:"primaryKey;type:varchar(26)"`
+	Category  NotificationCategory `json:"category" gorm:"primaryKey;type:varchar(30)"`
+	Push      bool                 `json:"push"`
+	Email     bool                 `json:"email"`
+	InApp     bool                 `json:"in_app"`
+	UpdatedAt time.Time            `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+// NotificationSettings regroupe les réglages de notification d'un utilisateur communs à toutes les catégories.
+// Pendant les heures calmes (QuietStart à QuietEnd, au format HH:MM dans le fuseau de l'utilisateur),
+// les notifications restent dans la boîte de réception mais ne sont envoyées ni en push ni par email.
+type NotificationSettings struct {
+	UserID       string    `json:"-" gorm:"primaryKey;type:varchar(26)"`
+	Timezone     string    `json:"timezone" gorm:"type:varchar(64);not null;default:'Europe/Paris'"`
+	QuietStart   string    `json:"quiet_start" gorm:"type:varchar(5)"`
+	QuietEnd     string    `json:"quiet_end" gorm:"type:varchar(5)"`
+	HidePreviews bool      `json:"hide_previews"`
+	UpdatedAt    time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+// NotificationMute coupe les notifications push et email d'une conversation ("match:<id>", "group:<id>"
+// ou "friend:<id>") pour un utilisateur, jusqu'à Until ou indéfiniment si Until est nul
+type NotificationMute struct {
+	UserID       string     `json:"-" gorm:"primaryKey;type:varchar(26)"`
+	Conversation string     `json:"conversation" gorm:"primaryKey;type:varchar(40)"`
+	Until        *time.Time `json:"until,omitempty"`
+	CreatedAt    time.Time  `json:"created_at" gorm:"autoCreateTime"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// OutboxKind désigne le traitement qui livre un message de l'outbox
+type OutboxKind string
+
+const (
+	OutboxPush              OutboxKind = "push"
+	OutboxEmail             OutboxKind = "email"
+	OutboxConfirmationEmail OutboxKind = "confirmation_email"
+)
+
+type OutboxStatus string
+
+const (
+	OutboxPending    OutboxStatus = "pending"
+	OutboxProcessing OutboxStatus = "processing"
+	OutboxSent       OutboxStatus = "sent"
+	OutboxDead       OutboxStatus = "dead"
+)
+
+// OutboxMessage est une livraison (push, email) enregistrée dans la même transaction que le changement
+// qui la déclenche, puis envoyée par les workers de l'outbox. IdempotencyKey empêche d'enregistrer deux
+// fois la même livraison ; après MaxAttempts échecs, le message passe en dead et n'est plus retenté.
+type OutboxMessage struct {
+	ID             string          `json:"id" gorm:"primaryKey;type:varchar(26)"`
+	Kind           OutboxKind      `json:"kind" gorm:"type:varchar(30);not null"`
+	IdempotencyKey string          `json:"idempotency_key" gorm:"type:varchar(120);not null;uniqueIndex"`
+	Payload        json.RawMessage `json:"payload" gorm:"type:jsonb;not null"`
+	Status         OutboxStatus    `json:"status" gorm:"type:varchar(12);not null;default:'pending';index:idx_outbox_due,priority:1"`
+	Attempts       int             `json:"attempts" gorm:"not null;default:0"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at" gorm:"not null;index:idx_outbox_due,priority:2"`
+	LockedUntil    *time.Time      `json:"locked_until,omitempty"`
+	LastError      string          `json:"last_error,omitempty" gorm:"type:text"`
+	SentAt         *time.Time      `json:"sent_at,omitempty"`
+	CreatedAt      time.Time       `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt      time.Time       `json:"updated_at" gorm:"autoUpdateTime"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// PlayerRating stocke la note TrueSkill courante d'un joueur
+type PlayerRating struct {
+	PlayerID     string    `json:"player_id" gorm:"primaryKey;type:varchar(26)"` // Référence à l'utilisateur
+	Mu           float64   `json:"mu"`                                           // Niveau estimé
+	Sigma        float64   `json:"sigma"`                                        // Incertitude sur le niveau
+	MatchesRated int       `json:"matches_rated" gorm:"default:0"`               // Nombre de matchs pris en compte
+	UpdatedAt    time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+// RatingHistory garde une trace de chaque mise à jour de note après un match
+type RatingHistory struct {
+	ID          string    `json:"id" gorm:"primaryKey;type:varchar(26)"`
+	PlayerID    string    `json:"player_id" gorm:"not null;index;uniqueIndex:idx_rating_history_player_match"` // Référence à l'utilisateur
+	MatchID     string    `json:"match_id" gorm:"not null;index;uniqueIndex:idx_rating_history_player_match"`  // Référence au match
+	MuBefore    float64   `json:"mu_before"`
+	SigmaBefore float64   `json:"sigma_before"`
+	MuAfter     float64   `json:"mu_after"`
+	SigmaAfter  float64   `json:"sigma_after"`
+	CreatedAt   time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
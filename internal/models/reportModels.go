@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+type ReportStatus string
+
+const (
+	ReportPending ReportStatus = "pending"
+	ReportReady   ReportStatus = "ready"
+	ReportFailed  ReportStatus = "failed"
+)
+
+// MatchReport stocke le rapport d'analyse d'un match, généré en arrière-plan une fois le match terminé
+type MatchReport struct {
+	MatchID     string       `json:"match_id" gorm:"primaryKey;type:varchar(26)"` // Référence au match
+	Status      ReportStatus `json:"status" gorm:"type:varchar(10)"`              // Statut de la génération
+	Data        string       `json:"-" gorm:"type:jsonb"`                         // Contenu du rapport au format JSON
+	Error       string       `json:"error,omitempty"`                             // Dernière erreur de génération
+	Attempts    int          `json:"attempts" gorm:"not null;default:0"`          // Échecs de génération depuis la dernière demande
+	ShareToken  string       `json:"-" gorm:"type:varchar(26);uniqueIndex"`       // Jeton permettant de partager le rapport sans compte
+	GeneratedAt *time.Time   `json:"generated_at"`
+	CreatedAt   time.Time    `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt   time.Time    `json:"updated_at" gorm:"autoUpdateTime"`
+}
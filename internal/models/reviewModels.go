@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// PlayerReview est la note donnée par un participant à un autre joueur après un match
+type PlayerReview struct {
+	ID         string    `json:"id" gorm:"primaryKey;type:varchar(26)"`
+	MatchID    string    `json:"match_id" gorm:"not null;uniqueIndex:idx_review_match_reviewer_player"`        // Référence au match
+	ReviewerID string    `json:"reviewer_id" gorm:"not null;uniqueIndex:idx_review_match_reviewer_player"`     // Joueur qui note
+	PlayerID   string    `json:"player_id" gorm:"not null;index;uniqueIndex:idx_review_match_reviewer_player"` // Joueur noté
+	IsTeammate bool      `json:"is_teammate"`                                                                  // Vrai si les deux joueurs étaient dans la même équipe
+	Pac        int       `json:"pac"`
+	Sho        int       `json:"sho"`
+	Pas        int       `json:"pas"`
+	Dri        int       `json:"dri"`
+	Def        int       `json:"def"`
+	Phy        int       `json:"phy"`
+	FairPlay   int       `json:"fair_play"` // Note de fair-play de 1 à 5
+	CreatedAt  time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// ManOfTheMatchVote est le vote d'un participant pour l'homme du match
+type ManOfTheMatchVote struct {
+	ID        string    `json:"id" gorm:"primaryKey;type:varchar(26)"`
+	MatchID   string    `json:"match_id" gorm:"not null;uniqueIndex:idx_motm_match_voter"` // Référence au match
+	VoterID   string    `json:"voter_id" gorm:"not null;uniqueIndex:idx_motm_match_voter"` // Joueur qui vote
+	PlayerID  string    `json:"player_id" gorm:"not null;index"`                           // Joueur désigné
+	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
@@ -4,9 +4,29 @@ import (
 	"github.com/ady243/teamup/internal/controllers"
 	middlewares "github.com/ady243/teamup/internal/middleware"
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/websocket/v2"
 )
 
+// SetupWebSocketRoutes sets up every WebSocket endpoint. They are registered before the JWT middleware of /api
+// because WebSocket clients authenticate with the token query parameter or their first message
+// (see middlewares.AuthenticatedWebSocket), and are closed with 4401, 4403 or 4408 when that fails.
+func SetupWebSocketRoutes(app *fiber.App, webSocketController *controllers.WebSocketController, matchController *controllers.MatchController,
+	analystController *controllers.AnalystController, groupController *controllers.GroupController) {
+	app.Get("/ws", middlewares.AuthenticatedWebSocket(webSocketController.UserWebSocketHandler))
+	app.Get("/ws/v1", middlewares.AuthenticatedWebSocket(webSocketController.MultiplexedWebSocketHandler))
+	app.Get("/ws/events/live/:match_id", middlewares.AuthenticatedWebSocket(analystController.WebSocketHandler))
+	app.Get("/api/matches/:id/chat", middlewares.AuthenticatedWebSocket(matchController.ChatWebSocketHandler))
+	app.Get("/api/matches/status/updates", middlewares.AuthenticatedWebSocket(matchController.MatchStatusWebSocketHandler))
+	app.Get("/api/matches/matches/status/updates", middlewares.AuthenticatedWebSocket(matchController.MatchStatusWebSocketHandler))
+	app.Get("/api/groups/:id/ws", middlewares.AuthenticatedWebSocket(groupController.GroupWebSocketHandler))
+}
+
+// SetupStreamRoutes sets up the Server-Sent Events fallbacks of the live match feed and the match status stream.
+// EventSource cannot send headers, so the token may also be given with the token query parameter.
+func SetupStreamRoutes(app *fiber.App, webSocketController *controllers.WebSocketController, analystController *controllers.AnalystController) {
+	app.Get("/sse/events/live/:match_id", middlewares.StreamAuth, analystController.EventStreamHandler)
+	app.Get("/sse/matches/status", middlewares.StreamAuth, webSocketController.MatchStatusStreamHandler)
+}
+
 // SetupRoutesAuth sets up the routes for the authentication feature.
 func SetupRoutesAuth(app *fiber.App, controller *controllers.AuthController) {
 	api := app.Group("/api")
@@ -28,7 +48,6 @@ func SetupRoutesAuth(app *fiber.App, controller *controllers.AuthController) {
 	api.Put("/userUpdate", controller.UserUpdate)
 	api.Delete("/deleteMyAccount", controller.DeleteUserHandler)
 	api.Get("/users/:id/public", controller.GetPublicUserInfoHandler)
-	api.Post("/UpdateUserStatistics", controller.UpdateUserStatistics)
 
 }
 
@@ -46,16 +65,125 @@ func SetupRoutesMatches(app *fiber.App, controller *controllers.MatchController)
 	api.Delete("/:id", controller.DeleteMatchHandler)
 	api.Post("/:id/join", controller.AddPlayerToMatchHandler)
 	api.Post("/:id/leave", controller.LeaveMatchHandler)
+	api.Post("/:id/result", controller.ConfirmMatchResultHandler)
 	api.Get("/:id", controller.GetMatchByIDHandler)
-	api.Get("/:id/chat", websocket.New(controller.ChatWebSocketHandler))
 	api.Get("/organizer/matches", controller.GetMatchByOrganizerIDHandler)
 	api.Get("/referee/matches", controller.GetMatchByRefereeIDHandler)
 	api.Put("/assignAsAnalyst/:match_id/:referee_id", controller.PutRefereeIDHandler)
-	api.Get("/status/updates", websocket.New(controller.MatchStatusWebSocketHandler))
-	api.Get("/matches/status/updates", websocket.New(controller.MatchStatusWebSocketHandler))
 	api.Post("/assign-referee", controller.AssignRefereeHandler)
 }
 
+// SetupRatingRoutes sets up the routes for reading player skill ratings.
+func SetupRatingRoutes(app *fiber.App, controller *controllers.RatingController) {
+	api := app.Group("/api/ratings")
+	api.Use(middlewares.JWTMiddleware)
+
+	api.Get("/:player_id", controller.GetPlayerRatingHandler)
+	api.Get("/:player_id/history", controller.GetPlayerRatingHistoryHandler)
+}
+
+// SetupReviewRoutes sets up the routes for post-match peer reviews and man of the match votes.
+func SetupReviewRoutes(app *fiber.App, controller *controllers.ReviewController) {
+	api := app.Group("/api/reviews")
+	api.Use(middlewares.JWTMiddleware)
+
+	api.Post("/match/:match_id", controller.SubmitReviewHandler)
+	api.Post("/match/:match_id/motm", controller.VoteManOfTheMatchHandler)
+	api.Get("/match/:match_id/motm", controller.GetManOfTheMatchVotesHandler)
+	api.Get("/player/:player_id", controller.GetPlayerReviewSummaryHandler)
+}
+
+// SetupLeaderboardRoutes sets up the routes for player leaderboards.
+// Scope, season and pagination are passed as query parameters.
+func SetupLeaderboardRoutes(app *fiber.App, controller *controllers.LeaderboardController) {
+	api := app.Group("/api/leaderboards")
+	api.Use(middlewares.JWTMiddleware)
+
+	api.Get("/", controller.GetLeaderboardMetricsHandler)
+	api.Get("/:metric", controller.GetLeaderboardHandler)
+	api.Get("/:metric/me", controller.GetLeaderboardAroundMeHandler)
+}
+
+// SetupBadgeRoutes sets up the routes for achievements and badges.
+func SetupBadgeRoutes(app *fiber.App, controller *controllers.BadgeController) {
+	api := app.Group("/api/badges")
+	api.Use(middlewares.JWTMiddleware)
+
+	api.Get("/", controller.GetBadgeCatalogHandler)
+	api.Get("/me", controller.GetMyBadgesHandler)
+	api.Get("/user/:user_id", controller.GetUserBadgesHandler)
+}
+
+// SetupModerationRoutes sets up the routes for reporting chat messages and the admin moderation queue.
+func SetupModerationRoutes(app *fiber.App, controller *controllers.ModerationController) {
+	api := app.Group("/api/moderation")
+	api.Use(middlewares.JWTMiddleware)
+
+	api.Get("/reasons", controller.GetReportReasonsHandler)
+	api.Post("/reports", controller.ReportMessageHandler)
+	api.Get("/reports", controller.GetReportsHandler)
+	api.Post("/reports/:report_id/resolve", controller.ResolveReportHandler)
+}
+
+// SetupGroupRoutes sets up the routes for group conversations between friends.
+func SetupGroupRoutes(app *fiber.App, controller *controllers.GroupController) {
+	api := app.Group("/api/groups")
+	api.Use(middlewares.JWTMiddleware)
+
+	api.Post("/", controller.CreateGroupHandler)
+	api.Get("/", controller.GetGroupsHandler)
+	api.Get("/:id", controller.GetGroupHandler)
+	api.Put("/:id", controller.RenameGroupHandler)
+	api.Post("/:id/members", controller.AddMembersHandler)
+	api.Delete("/:id/members/:userID", controller.RemoveMemberHandler)
+	api.Put("/:id/members/:userID/role", controller.SetMemberRoleHandler)
+	api.Post("/:id/leave", controller.LeaveGroupHandler)
+	api.Get("/:id/messages", controller.GetMessagesHandler)
+	api.Post("/:id/messages", controller.SendMessageHandler)
+	api.Delete("/:id/messages/:messageID", controller.DeleteMessageHandler)
+	api.Get("/:id/read", controller.GetReadReceiptsHandler)
+	api.Post("/:id/read", controller.MarkReadHandler)
+	api.Post("/:id/typing", controller.SendTypingHandler)
+}
+
+// SetupAttachmentRoutes sets up the routes for chat attachments (photos and voice notes).
+// Files are only served to the members of the conversation they were uploaded for.
+func SetupAttachmentRoutes(app *fiber.App, controller *controllers.AttachmentController) {
+	api := app.Group("/api/attachments")
+	api.Use(middlewares.JWTMiddleware)
+
+	api.Post("/", controller.UploadAttachmentHandler)
+	api.Get("/:id", controller.GetAttachmentHandler)
+	api.Get("/:id/file", controller.DownloadAttachmentHandler)
+	api.Get("/:id/thumbnail", controller.GetThumbnailHandler)
+}
+
+// SetupPresenceRoutes sets up the routes for online presence and unread message counts.
+func SetupPresenceRoutes(app *fiber.App, controller *controllers.PresenceController) {
+	api := app.Group("/api")
+	api.Use(middlewares.JWTMiddleware)
+
+	api.Post("/presence/heartbeat", controller.HeartbeatHandler)
+	api.Post("/presence/offline", controller.OfflineHandler)
+	api.Get("/presence", controller.GetPresenceHandler)
+	api.Put("/presence/visibility", controller.SetPresenceVisibilityHandler)
+	api.Get("/unread", controller.GetUnreadCountsHandler)
+}
+
+// SetupReportRoutes sets up the routes for post-match analytics reports.
+// Reports are served as JSON, CSV or HTML with the "format" query parameter,
+// and shared reports are readable without authentication through their token.
+func SetupReportRoutes(app *fiber.App, controller *controllers.ReportController) {
+	app.Get("/reports/shared/:token", controller.GetSharedReportHandler)
+
+	api := app.Group("/api/reports")
+	api.Use(middlewares.JWTMiddleware)
+
+	api.Get("/match/:match_id", controller.GetMatchReportHandler)
+	api.Get("/match/:match_id/share", controller.GetShareLinkHandler)
+	api.Post("/match/:match_id/regenerate", controller.RegenerateReportHandler)
+}
+
 // SetupRoutesMatchePlayers sets up the routes for managing match players.
 // It will create an "api/matchesPlayers" group and add the following routes:
 //   - GET /api/matchesPlayers/:match_id: Retrieves all match players associated
@@ -86,6 +214,21 @@ func SetupChatRoutes(app *fiber.App, controller *controllers.ChatController) {
 
 	api.Post("/chat/send", controller.SendMessage)
 	api.Get("/chat/:matchID", controller.GetMessages)
+	api.Put("/chat/:matchID/retention", controller.SetRetention)
+	api.Post("/chat/:matchID/read", controller.MarkRead)
+	api.Get("/chat/:matchID/read", controller.GetReadReceipts)
+	api.Post("/chat/:matchID/typing", controller.SendTyping)
+	api.Get("/chat/:matchID/restrictions", controller.GetRestrictions)
+	api.Post("/chat/:matchID/mute", controller.MuteMember)
+	api.Delete("/chat/:matchID/mute/:userID", controller.UnmuteMember)
+	api.Post("/chat/:matchID/kick", controller.KickMember)
+	api.Delete("/chat/:matchID/kick/:userID", controller.ReadmitMember)
+	api.Put("/chat/:matchID/messages/:messageID", controller.EditMessage)
+	api.Delete("/chat/:matchID/messages/:messageID", controller.DeleteMessage)
+	api.Get("/chat/:matchID/messages/:messageID/history", controller.GetMessageHistory)
+	api.Get("/chat/:matchID/messages/:messageID/replies", controller.GetMessageReplies)
+	api.Post("/chat/:matchID/messages/:messageID/reactions", controller.AddReaction)
+	api.Delete("/chat/:matchID/messages/:messageID/reactions", controller.RemoveReaction)
 }
 
 // SetupOpenAiRoutes sets up the routes for using OpenAI services.
@@ -97,12 +240,13 @@ func SetupOpenAiRoutes(app *fiber.App, controller *controllers.OpenAiController)
 
 // SetupRoutesAnalyst sets up the routes for managing Analyst events.
 func SetupRoutesAnalyst(app *fiber.App, controller *controllers.AnalystController) {
-	app.Get("/ws/events/live/:match_id", websocket.New(controller.WebSocketHandler))
 	api := app.Group("/api/analyst")
 	api.Use(middlewares.JWTMiddleware)
 
+	api.Get("/event-types", controller.GetEventCatalogHandler)
 	api.Post("/events", controller.CreateEventHandler)
 	api.Get("/match/:match_id/events", controller.GetEventsByMatchHandler)
+	api.Get("/match/:match_id/timeline", controller.GetMatchTimelineHandler)
 	api.Get("/player/:player_id/events", controller.GetEventsByPlayerHandler)
 	api.Put("/events/:event_id", controller.UpdateEventHandler)
 	api.Delete("/events/:event_id", controller.DeleteEventHandler)
@@ -126,17 +270,45 @@ func SetupRoutesFriendMessage(app *fiber.App, friendChatController *controllers.
 	api.Use(middlewares.JWTMiddleware)
 	api.Post("/message/send", friendChatController.SendMessage)
 	api.Get("/message/messages/:senderID/:receiverID", friendChatController.GetMessages)
+	api.Post("/message/read", friendChatController.MarkRead)
+	api.Get("/message/read/:userID", friendChatController.GetReadReceipt)
+	api.Post("/message/typing", friendChatController.SendTyping)
+	api.Put("/message/:messageID", friendChatController.EditMessage)
+	api.Delete("/message/:messageID", friendChatController.DeleteMessage)
+	api.Get("/message/:messageID/history", friendChatController.GetMessageHistory)
+	api.Post("/message/:messageID/reactions", friendChatController.AddReaction)
+	api.Delete("/message/:messageID/reactions", friendChatController.RemoveReaction)
 }
 
-// SetupRoutesWebSocket sets up the routes for WebSocket connections.
-func SetupRoutesWebSocket(app *fiber.App, controller *controllers.WebSocketController) {
+func SetupNotificationRoutes(app *fiber.App, notificationController *controllers.NotificationController, preferenceController *controllers.NotificationPreferenceController) {
 	api := app.Group("/api")
-	api.Get("/matches/status/updates", websocket.New(controller.WebSocketHandler))
+	api.Use(middlewares.JWTMiddleware)
+	api.Get("/notifications", notificationController.GetNotificationsHandler)
+	api.Get("/notifications/unread-count", notificationController.GetUnreadCountHandler)
+	api.Get("/notifications/preferences", preferenceController.GetPreferencesHandler)
+	api.Put("/notifications/preferences/:category", preferenceController.UpdatePreferenceHandler)
+	api.Put("/notifications/settings", preferenceController.UpdateSettingsHandler)
+	api.Get("/notifications/mutes", preferenceController.GetMutesHandler)
+	api.Post("/notifications/mutes", preferenceController.MuteHandler)
+	api.Delete("/notifications/mutes/:conversation", preferenceController.UnmuteHandler)
+	api.Post("/notifications/read-all", notificationController.MarkAllReadHandler)
+	api.Post("/notifications/:id/read", notificationController.MarkReadHandler)
+	api.Post("/send-notification", notificationController.SendPushNotification)
 }
 
-func SetupNotificationRoutes(app *fiber.App, notificationController *controllers.NotificationController) {
-	api := app.Group("/api")
-	api.Get("/notifications/:token", notificationController.GetUnreadNotifications)
-	api.Post("/notifications/:token/read", notificationController.MarkNotificationsAsRead)
-	api.Post("/send-notification", notificationController.SendPushNotification)
+// SetupDeviceRoutes sets up the routes of the push notification device registry.
+func SetupDeviceRoutes(app *fiber.App, deviceController *controllers.DeviceController) {
+	api := app.Group("/api/devices")
+	api.Use(middlewares.JWTMiddleware)
+	api.Get("/", deviceController.GetDevicesHandler)
+	api.Post("/", deviceController.RegisterDeviceHandler)
+	api.Delete("/:id", deviceController.UnregisterDeviceHandler)
+}
+
+// SetupOutboxRoutes sets up the admin routes of the notification and email outbox.
+func SetupOutboxRoutes(app *fiber.App, outboxController *controllers.OutboxController) {
+	api := app.Group("/api/admin/outbox")
+	api.Use(middlewares.JWTMiddleware)
+	api.Get("/", outboxController.GetOutboxMessagesHandler)
+	api.Post("/:id/retry", outboxController.RetryOutboxMessageHandler)
 }
@@ -1,8 +1,11 @@
 package server
 
 import (
+	"context"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	_ "github.com/ady243/teamup/docs"
@@ -17,7 +20,6 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/helmet"
 	"github.com/gofiber/fiber/v2/middleware/limiter"
-	"github.com/gofiber/websocket/v2"
 	"github.com/joho/godotenv"
 	fiberSwagger "github.com/swaggo/fiber-swagger"
 )
@@ -35,7 +37,7 @@ func Run() {
 	}
 
 	// Table migration
-	if err := db.AutoMigrate(&models.Users{}, &models.Matches{}, &models.MatchPlayers{}, &models.FriendRequest{}, &models.Message{}, &models.Analyst{}); err != nil {
+	if err := db.AutoMigrate(&models.Users{}, &models.Matches{}, &models.MatchPlayers{}, &models.FriendRequest{}, &models.Message{}, &models.Analyst{}, &models.PlayerRating{}, &models.RatingHistory{}, &models.PlayerReview{}, &models.ManOfTheMatchVote{}, &models.MatchReport{}, &models.UserBadge{}, &models.ChatMessage{}, &models.MessageReaction{}, &models.MessageEdit{}, &models.ReadCursor{}, &models.Attachment{}, &models.MessageReport{}, &models.ChatRestriction{}, &models.GroupConversation{}, &models.GroupMember{}, &models.GroupMessage{}, &models.Notification{}, &models.Device{}, &models.NotificationPreference{}, &models.NotificationSettings{}, &models.NotificationMute{}, &models.OutboxMessage{}); err != nil {
 		log.Printf("Error migrating database: %v", err)
 	}
 
@@ -47,42 +49,82 @@ func Run() {
 	})
 
 	// Initialize Firebase
-	_, err = internal.InitializeFirebase()
-	if err != nil {
-		log.Fatalf("Failed to initialize Firebase: %v", err)
+	// Sans Firebase, l'API démarre et les notifications push sont seulement enregistrées (voir DefaultNotifiers)
+	if _, err := internal.InitializeFirebase(); err != nil {
+		log.Printf("Failed to initialize Firebase: %v", err)
 	}
 
 	// Initialize services and controllers
 	imageService := services.NewImageService("./uploads")
 	emailService := services.NewEmailService()
-	matchService := services.NewMatchService(db, services.NewChatService(db, redisClient), redisClient)
-	authService := services.NewAuthService(db, imageService, emailService)
+	deviceService := services.NewDeviceService(db)
+	notificationPreferenceService := services.NewNotificationPreferenceService(db)
+	outboxService := services.NewOutboxService(db)
+	notifiers := services.DefaultNotifiers(emailService)
+	notificationService := services.NewNotificationService(db, deviceService, notificationPreferenceService, notifiers, outboxService)
+	chatService := services.NewChatService(db, redisClient, notificationService)
+	matchService := services.NewMatchService(db, chatService, redisClient, notificationService)
+	authService := services.NewAuthService(db, imageService, emailService, notifiers.Email, outboxService)
 	analystService := services.NewAnalystService(db)
-	webSocketService := services.NewWebSocketService()
-	redisService := services.NewRedisService(os.Getenv("REDIS_ADDR"), os.Getenv("REDIS_PASSWORD"), 0)
-	notificationService, err := services.NewNotificationService(redisService)
-	if err != nil {
-		log.Fatalf("Failed to initialize notification service: %v", err)
-	}
+	webSocketService := services.NewWebSocketService(redisClient)
 
 	openAIService := services.NewOpenAIService()
-	friendChatService := services.NewFriendChatService(db, webSocketService, notificationService)
+	friendChatService := services.NewFriendChatService(db, webSocketService, notificationService, redisClient)
 
 	matchPlayersService := services.NewMatchPlayersService(db)
-	friendService := services.NewFriendService(db, authService, webSocketService)
-	friendController := controllers.NewFriendController(friendService, notificationService)
-	chatService := services.NewChatService(db, redisClient)
-	matchController := controllers.NewMatchController(matchService, authService, db, chatService, redisClient, matchPlayersService)
+	ratingService := services.NewRatingService(db)
+	reviewService := services.NewReviewService(db)
+	reportService := services.NewReportService(db, reviewService)
+	leaderboardService := services.NewLeaderboardService(db, redisClient)
+	badgeService := services.NewBadgeService(db, notificationService)
+	presenceService := services.NewPresenceService(db, redisClient)
+	friendService := services.NewFriendService(db, authService, webSocketService, notificationService)
+	groupService := services.NewGroupService(db, redisClient, notificationService, friendService)
+	attachmentService := services.NewAttachmentService(db, imageService, chatService, friendService, groupService)
+	moderationService := services.NewModerationService(db, chatService, friendChatService)
+	friendController := controllers.NewFriendController(friendService)
+	matchController := controllers.NewMatchController(matchService, authService, db, chatService, redisClient, matchPlayersService, ratingService, reportService, leaderboardService, badgeService, presenceService, webSocketService)
 	matchPlayersController := controllers.NewMatchPlayersController(matchPlayersService, authService, db)
 	chatController := controllers.NewChatController(chatService, notificationService)
 	openAiController := controllers.NewOpenAiController(openAIService, matchPlayersService)
 	authController := controllers.NewAuthController(authService, imageService, matchService)
 	friendChatController := controllers.NewFriendChatController(friendChatService, friendService, notificationService)
 	notificationController := controllers.NewNotificationController(notificationService)
-	analystController := controllers.NewAnalystController(analystService, authService, webSocketService, db)
+	notificationPreferenceController := controllers.NewNotificationPreferenceController(notificationPreferenceService)
+	analystController := controllers.NewAnalystController(analystService, authService, webSocketService, matchService, db)
+	ratingController := controllers.NewRatingController(ratingService)
+	reviewController := controllers.NewReviewController(reviewService)
+	reportController := controllers.NewReportController(reportService, matchService)
+	leaderboardController := controllers.NewLeaderboardController(leaderboardService)
+	badgeController := controllers.NewBadgeController(badgeService)
+	presenceController := controllers.NewPresenceController(presenceService, chatService, friendChatService, groupService)
+	attachmentController := controllers.NewAttachmentController(attachmentService)
+	moderationController := controllers.NewModerationController(moderationService)
+	outboxController := controllers.NewOutboxController(outboxService, moderationService)
+	groupController := controllers.NewGroupController(groupService, presenceService, webSocketService)
+	webSocketController := controllers.NewWebSocketController(webSocketService)
+	deviceController := controllers.NewDeviceController(deviceService)
+
+	// Salons temps réel auxquels une connexion multiplexée peut s'abonner, avec leur règle d'accès
+	webSocketService.RegisterRoom("live", func(userID, matchID string) bool {
+		allowed, err := matchService.CanWatchLive(matchID, userID)
+		return err == nil && allowed
+	})
+	webSocketService.RegisterRoom("match", func(userID, matchID string) bool {
+		return chatService.IsChatMember(matchID, userID)
+	})
+	webSocketService.RegisterRoom("group", func(userID, groupID string) bool {
+		return groupService.IsMember(groupID, userID)
+	})
+	webSocketService.RegisterRoom("matches", func(userID, id string) bool {
+		return services.MatchStatusRoom == "matches:"+id
+	})
 
 	// Configure Fiber app
-	app := fiber.New()
+	app := fiber.New(fiber.Config{
+		// Les pièces jointes des chats peuvent dépasser la limite par défaut de 4 Mo
+		BodyLimit: int(services.ChatAttachmentPolicy.MaxSize()) + 1<<20,
+	})
 	app.Use(helmet.New())
 	app.Use(cors.New(cors.Config{
 		AllowOrigins: "*",
@@ -109,6 +151,8 @@ func Run() {
 	app.Get("/", func(c *fiber.Ctx) error {
 		return c.SendString("Welcome to TeamUp API!")
 	})
+	routes.SetupWebSocketRoutes(app, webSocketController, matchController, analystController, groupController)
+	routes.SetupStreamRoutes(app, webSocketController, analystController)
 	routes.SetupRoutesAuth(app, authController)
 	routes.SetupRoutesMatches(app, matchController)
 	routes.SetupRoutesMatchePlayers(app, matchPlayersController)
@@ -116,26 +160,34 @@ func Run() {
 	routes.SetupOpenAiRoutes(app, openAiController)
 	routes.SetupFriendRoutes(app, friendController)
 	routes.SetupRoutesFriendMessage(app, friendChatController)
-	routes.SetupNotificationRoutes(app, notificationController)
+	routes.SetupNotificationRoutes(app, notificationController, notificationPreferenceController)
 	routes.SetupRoutesAnalyst(app, analystController)
+	routes.SetupRatingRoutes(app, ratingController)
+	routes.SetupReviewRoutes(app, reviewController)
+	routes.SetupReportRoutes(app, reportController)
+	routes.SetupLeaderboardRoutes(app, leaderboardController)
+	routes.SetupBadgeRoutes(app, badgeController)
+	routes.SetupPresenceRoutes(app, presenceController)
+	routes.SetupAttachmentRoutes(app, attachmentController)
+	routes.SetupModerationRoutes(app, moderationController)
+	routes.SetupGroupRoutes(app, groupController)
+	routes.SetupDeviceRoutes(app, deviceController)
+	routes.SetupOutboxRoutes(app, outboxController)
 
 	// Swagger route
 	app.Get("/swagger/*", fiberSwagger.WrapHandler)
 
 	// WebSocket routes
-	app.Get("/ws", websocket.New(func(c *websocket.Conn) {
-		log.Println("WebSocket connection established on /ws")
-		webSocketService.HandleWebSocket(c, "general")
-	}))
-
-	app.Get("/ws/events/live/:match_id", websocket.New(func(c *websocket.Conn) {
-		matchID := c.Params("match_id")
-		log.Printf("WebSocket connection established on /ws/events/live/%s", matchID)
-		webSocketService.HandleWebSocket(c, matchID)
-	}))
 	// Start WebSocket broadcast
 	go webSocketService.StartBroadcast()
 
+	// Import des historiques de chat stockés uniquement dans Redis par les anciennes versions de l'API
+	go func() {
+		if err := chatService.ImportLegacyChats(); err != nil {
+			log.Printf("Erreur lors de l'import des anciens messages de chat : %v", err)
+		}
+	}()
+
 	// Start server
 	port := os.Getenv("API_PORT")
 	if port == "" {
@@ -144,14 +196,136 @@ func Run() {
 	log.Printf("Server started on port %s", port)
 
 	go func() {
+		lastRun := time.Now().Add(-1 * time.Minute)
 		ticker := time.NewTicker(1 * time.Minute)
 		defer ticker.Stop()
 		for range ticker.C {
+			startedAt := time.Now()
 			if err := matchService.UpdateMatchStatuses(); err != nil {
 				log.Printf("Erreur lors de la mise à jour des statuts des matchs : %v", err)
 			}
+			if err := matchService.SendMatchReminders(); err != nil {
+				log.Printf("Erreur lors de l'envoi des rappels de match : %v", err)
+			}
+			if err := ratingService.RateUnratedMatches(); err != nil {
+				log.Printf("Erreur lors de la mise à jour des notes des joueurs : %v", err)
+			}
+			if err := reportService.EnqueueMissingReports(); err != nil {
+				log.Printf("Erreur lors de la mise en file des rapports de match : %v", err)
+			}
+			// En cas d'échec, les matchs terminés depuis lastRun sont repris au passage suivant
+			failed := false
+			if err := leaderboardService.ApplyRecentlyCompleted(lastRun); err != nil {
+				log.Printf("Erreur lors de la mise à jour des classements : %v", err)
+				failed = true
+			}
+			if err := badgeService.EvaluateRecentlyCompleted(lastRun); err != nil {
+				log.Printf("Erreur lors de l'attribution des badges : %v", err)
+				failed = true
+			}
+			if !failed {
+				lastRun = startedAt
+			}
+		}
+	}()
+
+	// Génération des rapports de match en arrière-plan
+	go reportService.StartWorker()
+
+	// Suppression quotidienne des messages de chat dont la durée de conservation est dépassée,
+	// puis des pièces jointes qui ne sont plus référencées par aucun message, des notifications expirées
+	// et des appareils inactifs. L'échec d'une étape n'empêche pas les suivantes.
+	go func() {
+		ticker := time.NewTicker(24 * time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			if purged, err := chatService.PurgeExpiredMessages(); err != nil {
+				log.Printf("Erreur lors de la purge des messages de chat : %v", err)
+			} else {
+				log.Printf("%d messages de chat supprimés", purged)
+			}
+			if purged, err := attachmentService.PurgeOrphans(24 * time.Hour); err != nil {
+				log.Printf("Erreur lors de la purge des pièces jointes : %v", err)
+			} else {
+				log.Printf("%d pièces jointes orphelines supprimées", purged)
+			}
+			if purged, err := notificationService.PurgeExpired(); err != nil {
+				log.Printf("Erreur lors de la purge des notifications : %v", err)
+			} else {
+				log.Printf("%d notifications expirées supprimées", purged)
+			}
+			if purged, err := deviceService.PurgeStale(); err != nil {
+				log.Printf("Erreur lors de la purge des appareils inactifs : %v", err)
+			} else {
+				log.Printf("%d appareils inactifs supprimés", purged)
+			}
+			if purged, err := notificationPreferenceService.PurgeExpiredMutes(); err != nil {
+				log.Printf("Erreur lors de la purge des conversations coupées : %v", err)
+			} else {
+				log.Printf("%d coupures de conversation expirées supprimées", purged)
+			}
+			if purged, err := outboxService.PurgeExpired(); err != nil {
+				log.Printf("Erreur lors de la purge de l'outbox : %v", err)
+			} else {
+				log.Printf("%d livraisons de l'outbox supprimées", purged)
+			}
 		}
 	}()
 
-	log.Fatal(app.Listen(":" + port))
+	// Reconstruction périodique des classements à partir de la base
+	go func() {
+		if err := leaderboardService.Reconcile(); err != nil {
+			log.Printf("Erreur lors de la reconstruction des classements : %v", err)
+		}
+		ticker := time.NewTicker(6 * time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := leaderboardService.Reconcile(); err != nil {
+				log.Printf("Erreur lors de la reconstruction des classements : %v", err)
+			}
+		}
+	}()
+
+	// Recalcul périodique des attributs des joueurs à partir des notes de leurs pairs
+	go func() {
+		lastRun := time.Now().Add(-1 * time.Hour)
+		ticker := time.NewTicker(1 * time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			startedAt := time.Now()
+			if err := reviewService.RecomputeReviewedSince(lastRun); err != nil {
+				log.Printf("Erreur lors du recalcul des attributs des joueurs : %v", err)
+				continue
+			}
+			lastRun = startedAt
+		}
+	}()
+
+	// Livraison en arrière-plan des push et emails de l'outbox
+	outboxCtx, stopOutbox := context.WithCancel(context.Background())
+	outboxService.Start(outboxCtx)
+
+	// Arrêt propre : les connexions WebSocket reçoivent les messages en attente et une trame de fermeture
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+		log.Println("Shutting down server")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := webSocketService.Shutdown(ctx); err != nil {
+			log.Printf("Error draining WebSocket connections: %v", err)
+		}
+		stopOutbox()
+		if err := outboxService.Wait(ctx); err != nil {
+			log.Printf("Error waiting for outbox deliveries: %v", err)
+		}
+		if err := app.ShutdownWithContext(ctx); err != nil {
+			log.Printf("Error shutting down server: %v", err)
+		}
+	}()
+
+	if err := app.Listen(":" + port); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -1,114 +1,937 @@
 package services
 
 import (
-    "context"
-    "encoding/json"
-    "errors"
-    "log"
-    "time"
-
-    "github.com/ady243/teamup/internal/models"
-    "github.com/go-redis/redis/v8"
-    "gorm.io/gorm"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ady243/teamup/internal/models"
+	"github.com/go-redis/redis/v8"
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Paramètres du cache Redis des messages récents
+const (
+	chatCacheSize = 50
+	chatCacheTTL  = 7 * 24 * time.Hour
+)
+
+// Durée de conservation par défaut des messages, modifiable par la variable CHAT_RETENTION_DAYS
+const defaultChatRetentionDays = 90
+
+// Taille maximale d'un message et d'une clé d'idempotence
+const (
+	maxChatMessageLength = 2000
+	maxClientMsgIDLength = 64
+)
+
+var (
+	ErrInvalidChatMessage = errors.New("invalid chat message")
+	ErrChatMuted          = errors.New("you have been muted in this chat")
 )
 
+// ChatMessageInput est le contenu d'un message envoyé par un client, en REST comme en WebSocket
+type ChatMessageInput struct {
+	Message      string `json:"message"`
+	ClientMsgID  string `json:"client_msg_id"` // Clé d'idempotence générée par le client
+	ReplyToID    string `json:"reply_to_id"`   // Message auquel le client répond
+	AttachmentID string `json:"attachment_id"` // Pièce jointe envoyée au préalable ; le texte devient alors facultatif
+}
+
+// validateChatContent nettoie le texte d'un message et vérifie sa longueur
+func validateChatContent(message string) (string, error) {
+	message = strings.TrimSpace(message)
+	if message == "" || len(message) > maxChatMessageLength {
+		return "", fmt.Errorf("%w: message must contain between 1 and %d characters", ErrInvalidChatMessage, maxChatMessageLength)
+	}
+	return message, nil
+}
+
 type ChatService struct {
-    DB          *gorm.DB
-    RedisClient *redis.Client
+	DB                  *gorm.DB
+	RedisClient         *redis.Client
+	NotificationService *NotificationService
+	ContentFilter       ContentFilter    // Appliqué au texte des messages envoyés et modifiés
+	RateLimiter         *ChatRateLimiter // Limite les messages de chaque joueur dans un match
+}
+
+func NewChatService(db *gorm.DB, redisClient *redis.Client, notificationService *NotificationService) *ChatService {
+	return &ChatService{
+		DB:                  db,
+		RedisClient:         redisClient,
+		NotificationService: notificationService,
+		ContentFilter:       DefaultContentFilter(),
+		RateLimiter:         NewChatRateLimiter(redisClient),
+	}
 }
 
-func NewChatService(db *gorm.DB, redisClient *redis.Client) *ChatService {
-    return &ChatService{
-        DB:          db,
-        RedisClient: redisClient,
-    }
+func chatCacheKey(matchID string) string {
+	return "chat:cache:" + matchID
 }
 
-func (s *ChatService) AddMessage(matchID, userID, message string) error {
-    var matchPlayer models.MatchPlayers
-    if err := s.DB.Preload("Player").First(&matchPlayer, "match_id = ? AND player_id = ?", matchID, userID).Error; err != nil {
-        return errors.New("player not found in match")
-    }
+// legacyChatKey est la liste Redis dans laquelle les anciennes versions de l'API stockaient les messages
+func legacyChatKey(matchID string) string {
+	return "chat:" + matchID
+}
 
-    fullMessage := models.ChatMessage{
-        PlayerID:   userID,
-        Message:    message,
-        Timestamp:  time.Now().Format(time.RFC3339),
-        Username:   matchPlayer.Player.Username,
-        ProfilePic: matchPlayer.Player.ProfilePhoto,
-    }
+// legacyImportKey reçoit la liste d'un match pendant son import, pour que les messages ajoutés entre-temps
+// par une ancienne instance de l'API restent dans legacyChatKey et soient importés au passage suivant
+func legacyImportKey(matchID string) string {
+	return "chat:import:" + matchID
+}
 
-    msgJSON, err := json.Marshal(fullMessage)
-    if err != nil {
-        return err
-    }
+// ChatRoom renvoie le salon temps réel dans lequel sont diffusés les messages d'un match
+func ChatRoom(matchID string) string {
+	return "match:" + matchID
+}
 
-    key := "chat:" + matchID
-    ctx := context.Background()
-    if err := s.RedisClient.RPush(ctx, key, msgJSON).Err(); err != nil {
-        return err
-    }
+// withAuthor complète un message avec la date d'envoi, son état et les informations de son auteur
+func withAuthor(message models.ChatMessage) models.ChatMessage {
+	message.Timestamp = message.CreatedAt.Format(time.RFC3339)
+	message.Username = message.Player.Username
+	message.ProfilePic = message.Player.ProfilePhoto
+	message.Edited = message.EditedAt != nil
+	message.Deleted = message.DeletedAt != nil
+	return message
+}
 
-    return nil
+// withReactions ajoute leurs réactions à une liste de messages
+func (s *ChatService) withReactions(messages []models.ChatMessage) ([]models.ChatMessage, error) {
+	ids := make([]string, len(messages))
+	for i, message := range messages {
+		ids[i] = message.ID
+	}
+	reactions, err := loadReactions(s.DB, models.MessageKindMatch, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range messages {
+		messages[i].Reactions = reactions[messages[i].ID]
+	}
+	return messages, nil
 }
 
-func (s *ChatService) GetMessages(matchID string, limit int) ([]models.ChatMessage, error) {
-    ctx := context.Background()
-    key := "chat:" + matchID
+// AddMessage est le point d'entrée unique des messages du chat d'un match, quel que soit le transport
+// (REST ou WebSocket) : le message est enregistré, complété avec les informations de son auteur,
+// ajouté au cache, diffusé sur le canal du match puis notifié aux autres participants.
+// Si clientMsgID a déjà été utilisé par ce joueur dans ce match, le message existant est renvoyé
+// sans être diffusé de nouveau, et created vaut false.
+func (s *ChatService) AddMessage(matchID, userID string, input ChatMessageInput) (chatMessage *models.ChatMessage, created bool, err error) {
+	message := strings.TrimSpace(input.Message)
+	if message != "" || input.AttachmentID == "" {
+		if message, err = validateChatContent(message); err != nil {
+			return nil, false, err
+		}
+	}
+	if message, err = filterContent(s.ContentFilter, message); err != nil {
+		return nil, false, err
+	}
+	clientMsgID := input.ClientMsgID
+	if len(clientMsgID) > maxClientMsgIDLength {
+		return nil, false, fmt.Errorf("%w: client_msg_id is too long", ErrInvalidChatMessage)
+	}
+
+	var matchPlayer models.MatchPlayers
+	if err := s.DB.Preload("Player").First(&matchPlayer, "match_id = ? AND player_id = ?", matchID, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, false, ErrMessageForbidden
+		}
+		return nil, false, err
+	}
+	if err := s.checkCanWrite(matchID, userID); err != nil {
+		return nil, false, err
+	}
+	if input.ReplyToID != "" {
+		var count int64
+		if err := s.DB.Model(&models.ChatMessage{}).Where("id = ? AND match_id = ?", input.ReplyToID, matchID).Count(&count).Error; err != nil {
+			return nil, false, err
+		}
+		if count == 0 {
+			return nil, false, fmt.Errorf("%w: the message you reply to does not exist in this match", ErrInvalidChatMessage)
+		}
+	}
+
+	// Un nouvel envoi d'un message déjà reçu est reconnu avant la vérification de sa pièce jointe,
+	// qui est alors déjà utilisée par le message d'origine
+	if clientMsgID != "" {
+		if existing, err := s.findByClientMsgID(matchID, userID, clientMsgID); err == nil {
+			return existing, false, nil
+		}
+	}
+
+	if err := s.RateLimiter.Allow(models.MessageKindMatch, matchID, userID); err != nil {
+		return nil, false, err
+	}
 
-    msgs, err := s.RedisClient.LRange(ctx, key, 0, int64(limit-1)).Result()
-    if err != nil {
-        return nil, err
-    }
+	var attachment *models.Attachment
+	if input.AttachmentID != "" {
+		if attachment, err = findAttachment(s.DB, input.AttachmentID, userID, models.MessageKindMatch, matchID); err != nil {
+			return nil, false, err
+		}
+	}
 
-    messages := make([]models.ChatMessage, len(msgs))
-    for i, msg := range msgs {
-        var chatMessage models.ChatMessage
-        if err := json.Unmarshal([]byte(msg), &chatMessage); err != nil {
-            return nil, err
-        }
-        messages[i] = chatMessage
-    }
+	t := time.Now()
+	entropy := ulid.Monotonic(rand.New(rand.NewSource(t.UnixNano())), 0)
+	newMessage := models.ChatMessage{
+		ID:        ulid.MustNew(ulid.Timestamp(t), entropy).String(),
+		MatchID:   matchID,
+		PlayerID:  userID,
+		Message:   message,
+		CreatedAt: t,
+	}
+	if clientMsgID != "" {
+		newMessage.ClientMsgID = &clientMsgID
+	}
+	if input.ReplyToID != "" {
+		newMessage.ReplyToID = &input.ReplyToID
+	}
+	if attachment != nil {
+		newMessage.AttachmentID = &attachment.ID
+	}
 
-    return messages, nil
+	newMessage.Player = matchPlayer.Player
+	newMessage.Attachment = attachment
+	newMessage = withAuthor(newMessage)
+
+	// Les notifications sont enregistrées dans la transaction du message : elles ne partent que s'il est enregistré
+	duplicate := false
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Omit("Player", "Attachment").Clauses(clause.OnConflict{DoNothing: true}).Create(&newMessage)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			duplicate = true
+			return nil
+		}
+		return s.notifyParticipants(tx, newMessage)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if duplicate {
+		// Message déjà reçu avec la même clé d'idempotence (nouvel envoi du client)
+		existing, err := s.findByClientMsgID(matchID, userID, clientMsgID)
+		if err != nil {
+			return nil, false, err
+		}
+		return existing, false, nil
+	}
+
+	if err := s.cacheMessage(newMessage); err != nil {
+		// Le message est déjà en base : le cache sera reconstruit à la prochaine lecture
+		log.Printf("Error caching chat message: %v", err)
+		s.RedisClient.Del(context.Background(), chatCacheKey(matchID))
+	}
+	if err := PublishRoomEvent(s.RedisClient, ChatRoom(matchID), EventChatMessage, newMessage); err != nil {
+		log.Printf("Error publishing chat message: %v", err)
+	}
+
+	return &newMessage, true, nil
 }
 
-func (s *ChatService) AddUserToChat(matchID, userID string) error {
-    ctx := context.Background()
-    chatKey := "chat:" + matchID + ":users"
+// findByClientMsgID charge le message envoyé par un joueur avec une clé d'idempotence
+func (s *ChatService) findByClientMsgID(matchID, userID, clientMsgID string) (*models.ChatMessage, error) {
+	var existing models.ChatMessage
+	if err := s.DB.Preload("Player").Preload("Attachment").
+		Where("match_id = ? AND player_id = ? AND client_msg_id = ?", matchID, userID, clientMsgID).
+		First(&existing).Error; err != nil {
+		return nil, err
+	}
+	existing = withAuthor(existing)
+	return &existing, nil
+}
+
+// notifyParticipants notifie les participants du match autres que l'auteur, dans la transaction tx du message
+func (s *ChatService) notifyParticipants(tx *gorm.DB, message models.ChatMessage) error {
+	if s.NotificationService == nil {
+		return nil
+	}
+	participants, err := s.GetParticipants(message.MatchID)
+	if err != nil {
+		return err
+	}
+	recipients := make([]string, 0, len(participants))
+	for _, participant := range participants {
+		if participant.ID != message.PlayerID {
+			recipients = append(recipients, participant.ID)
+		}
+	}
+	data := models.NotificationData{"screen": "match_chat", "match_id": message.MatchID, "message_id": message.ID}
+	return s.NotificationService.WithTx(tx).NotifyConversation(recipients, ChatRoom(message.MatchID), models.NotificationChatMessage, "TeamUp", message.Username+" : "+notificationText(message.Message, message.Attachment), data)
+}
 
-    if err := s.RedisClient.SAdd(ctx, chatKey, userID).Err(); err != nil {
-        return err
-    }
+// cacheMessage ajoute un message au cache, limité aux chatCacheSize derniers messages
+func (s *ChatService) cacheMessage(message models.ChatMessage) error {
+	msgJSON, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
 
-    if err := s.RedisClient.Expire(ctx, chatKey, time.Hour*24*7).Err(); err != nil {
-        log.Printf("Error setting expiration for chat key: %v", err)
-        return err
-    }
+	ctx := context.Background()
+	key := chatCacheKey(message.MatchID)
+	pipe := s.RedisClient.TxPipeline()
+	pipe.RPush(ctx, key, msgJSON)
+	pipe.LTrim(ctx, key, -chatCacheSize, -1)
+	pipe.Expire(ctx, key, chatCacheTTL)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// GetMessages renvoie les messages d'un match du plus ancien au plus récent.
+// Sans curseur, ce sont les derniers messages ; before et after sont des identifiants de message
+// permettant de remonter l'historique ou de récupérer les messages plus récents.
+func (s *ChatService) GetMessages(matchID, before, after string, limit int) ([]models.ChatMessage, error) {
+	if limit <= 0 || limit > 100 {
+		limit = chatCacheSize
+	}
+
+	if before == "" && after == "" && limit <= chatCacheSize {
+		if messages, ok := s.readCache(matchID, limit); ok {
+			return messages, nil
+		}
+	}
+
+	query := s.DB.Preload("Player").Preload("Attachment").Where("match_id = ?", matchID)
+	descending := after == ""
+	if before != "" {
+		query = query.Where("id < ?", before)
+	}
+	if after != "" {
+		query = query.Where("id > ?", after)
+	}
+	if descending {
+		query = query.Order("id desc")
+	} else {
+		query = query.Order("id asc")
+	}
+
+	var messages []models.ChatMessage
+	if err := query.Limit(limit).Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	if descending {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+	for i := range messages {
+		messages[i] = withAuthor(messages[i])
+	}
+	messages, err := s.withReactions(messages)
+	if err != nil {
+		return nil, err
+	}
+
+	if before == "" && after == "" {
+		s.refreshCache(matchID)
+	}
+	return messages, nil
+}
+
+// readCache lit les derniers messages depuis le cache. Le cache n'est utilisé que s'il contient
+// assez de messages, ou s'il contient tous les messages du match.
+func (s *ChatService) readCache(matchID string, limit int) ([]models.ChatMessage, bool) {
+	ctx := context.Background()
+	msgs, err := s.RedisClient.LRange(ctx, chatCacheKey(matchID), int64(-limit), -1).Result()
+	if err != nil || len(msgs) == 0 {
+		return nil, false
+	}
+
+	messages := make([]models.ChatMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		var chatMessage models.ChatMessage
+		if err := json.Unmarshal([]byte(msg), &chatMessage); err != nil || chatMessage.ID == "" {
+			// Entrée au format des anciennes versions : le cache sera reconstruit depuis la base
+			return nil, false
+		}
+		messages = append(messages, chatMessage)
+	}
+
+	if len(messages) < limit {
+		var count int64
+		if err := s.DB.Model(&models.ChatMessage{}).Where("match_id = ?", matchID).Count(&count).Error; err != nil || count != int64(len(messages)) {
+			return nil, false
+		}
+	}
+	return messages, true
+}
+
+// refreshCache reconstruit le cache d'un match à partir de ses derniers messages en base
+func (s *ChatService) refreshCache(matchID string) {
+	ctx := context.Background()
+	key := chatCacheKey(matchID)
+
+	// Un seul rafraîchissement à la fois par match, pour ne pas importer deux fois les anciens messages
+	lockKey := key + ":refresh"
+	locked, err := s.RedisClient.SetNX(ctx, lockKey, 1, 10*time.Second).Result()
+	if err != nil || !locked {
+		return
+	}
+	defer s.RedisClient.Del(ctx, lockKey)
+
+	if err := s.importLegacyMessages(matchID); err != nil {
+		log.Printf("Error importing legacy chat messages for match %s: %v", matchID, err)
+		return
+	}
 
-    return nil
+	var messages []models.ChatMessage
+	if err := s.DB.Preload("Player").Preload("Attachment").Where("match_id = ?", matchID).Order("id desc").Limit(chatCacheSize).Find(&messages).Error; err != nil {
+		log.Printf("Error loading chat messages for match %s: %v", matchID, err)
+		return
+	}
+
+	for i := range messages {
+		messages[i] = withAuthor(messages[i])
+	}
+	messages, err = s.withReactions(messages)
+	if err != nil {
+		log.Printf("Error loading chat reactions for match %s: %v", matchID, err)
+		return
+	}
+
+	pipe := s.RedisClient.TxPipeline()
+	pipe.Del(ctx, key)
+	for i := len(messages) - 1; i >= 0; i-- {
+		msgJSON, err := json.Marshal(messages[i])
+		if err != nil {
+			return
+		}
+		pipe.RPush(ctx, key, msgJSON)
+	}
+	pipe.Expire(ctx, key, chatCacheTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Error refreshing chat cache for match %s: %v", matchID, err)
+	}
+}
+
+// ImportLegacyChats enregistre en base les messages de tous les matchs stockés uniquement dans Redis
+// par les anciennes versions de l'API. Elle est appelée au démarrage ; refreshCache importe aussi
+// les messages d'un match écrits depuis par une ancienne instance.
+func (s *ChatService) ImportLegacyChats() error {
+	ctx := context.Background()
+	matchIDs := make(map[string]bool)
+	for _, pattern := range []string{legacyChatKey("*"), legacyImportKey("*")} {
+		iter := s.RedisClient.Scan(ctx, 0, pattern, 100).Iterator()
+		for iter.Next(ctx) {
+			matchID := strings.TrimPrefix(strings.TrimPrefix(iter.Val(), "chat:import:"), "chat:")
+			// Les autres clés du chat (cache, limites d'envoi) ont un préfixe supplémentaire
+			if !strings.Contains(matchID, ":") {
+				matchIDs[matchID] = true
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return err
+		}
+	}
+
+	for matchID := range matchIDs {
+		if err := s.importLegacyMessages(matchID); err != nil {
+			return fmt.Errorf("match %s: %w", matchID, err)
+		}
+	}
+	return nil
+}
+
+// importLegacyMessages enregistre en base les messages d'un match stockés uniquement dans Redis
+// par les anciennes versions de l'API, puis supprime leur liste. La liste est d'abord renommée : un import
+// interrompu est repris depuis legacyImportKey sans perdre ni dupliquer de messages.
+func (s *ChatService) importLegacyMessages(matchID string) error {
+	ctx := context.Background()
+	importKey := legacyImportKey(matchID)
+	pending, err := s.RedisClient.Exists(ctx, importKey).Result()
+	if err != nil {
+		return err
+	}
+	if pending == 0 {
+		if err := s.RedisClient.Rename(ctx, legacyChatKey(matchID), importKey).Err(); err != nil {
+			if strings.Contains(err.Error(), "no such key") {
+				return nil
+			}
+			return err
+		}
+	}
+
+	msgs, err := s.RedisClient.LRange(ctx, importKey, 0, -1).Result()
+	if err != nil {
+		return err
+	}
+
+	var legacy []models.ChatMessage
+	for _, msg := range msgs {
+		var chatMessage models.ChatMessage
+		if err := json.Unmarshal([]byte(msg), &chatMessage); err != nil || chatMessage.ID != "" || chatMessage.PlayerID == "" {
+			continue
+		}
+		createdAt, err := time.Parse(time.RFC3339, chatMessage.Timestamp)
+		if err != nil {
+			createdAt = time.Now()
+		}
+		// L'identifiant ne dépend que de la date et de la position du message : un import repris ne le duplique pas
+		entropy := ulid.Monotonic(rand.New(rand.NewSource(createdAt.UnixNano()+int64(len(legacy)))), 0)
+		legacy = append(legacy, models.ChatMessage{
+			ID:        ulid.MustNew(ulid.Timestamp(createdAt), entropy).String(),
+			MatchID:   matchID,
+			PlayerID:  chatMessage.PlayerID,
+			Message:   chatMessage.Message,
+			CreatedAt: createdAt,
+		})
+	}
+	if len(legacy) > 0 {
+		if err := s.DB.Omit("Player").Clauses(clause.OnConflict{DoNothing: true}).Create(&legacy).Error; err != nil {
+			return err
+		}
+	}
+	return s.RedisClient.Del(ctx, importKey).Err()
 }
 
+func (s *ChatService) AddUserToChat(matchID, userID string) error {
+	ctx := context.Background()
+	chatKey := "chat:" + matchID + ":users"
+
+	if err := s.RedisClient.SAdd(ctx, chatKey, userID).Err(); err != nil {
+		return err
+	}
+
+	if err := s.RedisClient.Expire(ctx, chatKey, time.Hour*24*7).Err(); err != nil {
+		log.Printf("Error setting expiration for chat key: %v", err)
+		return err
+	}
+
+	return nil
+}
 
-//delete chat messages
+// DeleteChatMessages vide le cache du chat d'un match. Les messages en base sont
+// supprimés par PurgeExpiredMessages, selon la politique de rétention du match.
 func (s *ChatService) DeleteChatMessages(matchID string) error {
-    ctx := context.Background()
-    key := "chat:" + matchID
+	ctx := context.Background()
+	return s.RedisClient.Del(ctx, chatCacheKey(matchID)).Err()
+}
 
-    if err := s.RedisClient.Del(ctx, key).Err(); err != nil {
-        return err
-    }
+// SetRetention définit la durée de conservation des messages d'un match ; nil rétablit la durée par défaut
+func (s *ChatService) SetRetention(matchID string, days *int) error {
+	if days != nil && (*days < 1 || *days > 3650) {
+		return errors.New("retention must be between 1 and 3650 days")
+	}
+	return s.DB.Model(&models.Matches{}).Where("id = ?", matchID).Update("chat_retention_days", days).Error
+}
 
-    return nil
+// DefaultChatRetentionDays renvoie la durée de conservation appliquée aux matchs sans durée propre
+func DefaultChatRetentionDays() int {
+	if days, err := strconv.Atoi(os.Getenv("CHAT_RETENTION_DAYS")); err == nil && days > 0 {
+		return days
+	}
+	return defaultChatRetentionDays
 }
 
+// expiredChatMessages sélectionne les messages dont la durée de conservation est dépassée
+const expiredChatMessages = `SELECT chat_messages.id FROM chat_messages
+	JOIN matches ON matches.id = chat_messages.match_id
+	WHERE chat_messages.created_at < NOW() - make_interval(days => COALESCE(matches.chat_retention_days, ?))`
+
+// PurgeExpiredMessages supprime les messages dont la durée de conservation est dépassée, avec leurs réactions,
+// leur historique de modifications et les curseurs de lecture qui les désignent ; les réponses restantes
+// ne référencent plus le message supprimé. NOW() étant fixé pour la transaction, chaque requête voit les mêmes messages.
+func (s *ChatService) PurgeExpiredMessages() (int64, error) {
+	days := DefaultChatRetentionDays()
+	var purged int64
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM message_reactions WHERE message_kind = ? AND message_id IN ("+expiredChatMessages+")",
+			models.MessageKindMatch, days).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM message_edits WHERE message_kind = ? AND message_id IN ("+expiredChatMessages+")",
+			models.MessageKindMatch, days).Error; err != nil {
+			return err
+		}
+		// Les messages antérieurs au dernier message lu sont purgés eux aussi : sans curseur, les messages
+		// restants comptent comme non lus, comme avant la purge
+		if err := tx.Exec("DELETE FROM read_cursors WHERE conversation_kind = ? AND last_read_message_id IN ("+expiredChatMessages+")",
+			models.MessageKindMatch, days).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("UPDATE chat_messages SET reply_to_id = NULL WHERE reply_to_id IN ("+expiredChatMessages+")",
+			days).Error; err != nil {
+			return err
+		}
+
+		result := tx.Exec(`DELETE FROM chat_messages USING matches
+			WHERE chat_messages.match_id = matches.id
+			AND chat_messages.created_at < NOW() - make_interval(days => COALESCE(matches.chat_retention_days, ?))`,
+			days)
+		purged = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
 
 func (s *ChatService) GetParticipants(matchID string) ([]models.Users, error) {
-    var participants []models.Users
-    if err := s.DB.Joins("JOIN match_players ON match_players.player_id = users.id").
-        Where("match_players.match_id = ?", matchID).
-        Find(&participants).Error; err != nil {
-        return nil, err
-    }
-    return participants, nil
-}
\ No newline at end of file
+	var participants []models.Users
+	if err := s.DB.Joins("JOIN match_players ON match_players.player_id = users.id").
+		Where("match_players.match_id = ?", matchID).
+		Find(&participants).Error; err != nil {
+		return nil, err
+	}
+	return participants, nil
+}
+
+// IsChatMember vérifie qu'un utilisateur est organisateur du match, ou joueur non exclu de son chat
+func (s *ChatService) IsChatMember(matchID, userID string) bool {
+	var count int64
+	s.DB.Model(&models.Matches{}).Where("id = ? AND organizer_id = ?", matchID, userID).Count(&count)
+	if count > 0 {
+		return true
+	}
+	s.DB.Model(&models.MatchPlayers{}).Where("match_id = ? AND player_id = ?", matchID, userID).Count(&count)
+	return count > 0 && !s.hasRestriction(matchID, userID, models.ChatKick)
+}
+
+// findMessage charge un message du chat d'un match avec son auteur
+func (s *ChatService) findMessage(matchID, messageID string) (*models.ChatMessage, error) {
+	var message models.ChatMessage
+	if err := s.DB.Preload("Player").Preload("Attachment").Where("id = ? AND match_id = ?", messageID, matchID).First(&message).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMessageNotFound
+		}
+		return nil, err
+	}
+	return &message, nil
+}
+
+// publishEvent diffuse un événement sur le canal du match et invalide le cache des messages récents
+func (s *ChatService) publishEvent(matchID string, event ChatEvent) {
+	ctx := context.Background()
+	if err := s.RedisClient.Del(ctx, chatCacheKey(matchID)).Err(); err != nil {
+		log.Printf("Error invalidating chat cache for match %s: %v", matchID, err)
+	}
+	if err := PublishRoomEvent(s.RedisClient, ChatRoom(matchID), event.Type, event); err != nil {
+		log.Printf("Error publishing chat event: %v", err)
+	}
+}
+
+// EditMessage modifie le texte d'un message ; seul son auteur peut le faire et l'ancienne version est conservée
+func (s *ChatService) EditMessage(matchID, messageID, userID, content string) (*models.ChatMessage, error) {
+	content, err := validateChatContent(content)
+	if err != nil {
+		return nil, err
+	}
+	if content, err = filterContent(s.ContentFilter, content); err != nil {
+		return nil, err
+	}
+	message, err := s.findMessage(matchID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message.PlayerID != userID {
+		return nil, ErrMessageForbidden
+	}
+	if err := s.checkCanWrite(matchID, userID); err != nil {
+		return nil, err
+	}
+	if message.DeletedAt != nil {
+		return nil, ErrMessageDeleted
+	}
+	if message.Message == content {
+		edited := withAuthor(*message)
+		return &edited, nil
+	}
+
+	now := time.Now()
+	if err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := recordEdit(tx, models.MessageKindMatch, message.ID, message.Message); err != nil {
+			return err
+		}
+		return tx.Model(&models.ChatMessage{}).Where("id = ?", message.ID).
+			Updates(map[string]interface{}{"message": content, "edited_at": now}).Error
+	}); err != nil {
+		return nil, err
+	}
+
+	message.Message = content
+	message.EditedAt = &now
+	edited := withAuthor(*message)
+	if reactions, err := loadReactions(s.DB, models.MessageKindMatch, []string{message.ID}); err == nil {
+		edited.Reactions = reactions[message.ID]
+	}
+	s.publishEvent(matchID, ChatEvent{Type: "message_edited", MessageID: message.ID, Message: edited})
+	return &edited, nil
+}
+
+// DeleteMessage supprime un message pour tous les participants. L'auteur et l'organisateur du match
+// peuvent le faire ; le message reste dans l'historique, sans contenu, pour ne pas casser les réponses.
+func (s *ChatService) DeleteMessage(matchID, messageID, userID string) (*models.ChatMessage, error) {
+	message, err := s.findMessage(matchID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message.PlayerID != userID {
+		var count int64
+		s.DB.Model(&models.Matches{}).Where("id = ? AND organizer_id = ?", matchID, userID).Count(&count)
+		if count == 0 {
+			return nil, ErrMessageForbidden
+		}
+	}
+	return s.removeMessage(message)
+}
+
+// removeMessage supprime un message pour tous les participants et diffuse sa suppression
+func (s *ChatService) removeMessage(message *models.ChatMessage) (*models.ChatMessage, error) {
+	if message.DeletedAt != nil {
+		deleted := withAuthor(*message)
+		return &deleted, nil
+	}
+
+	now := time.Now()
+	if err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.ChatMessage{}).Where("id = ?", message.ID).
+			Updates(map[string]interface{}{"message": "", "attachment_id": nil, "deleted_at": now}).Error; err != nil {
+			return err
+		}
+		// Les versions précédentes et les réactions disparaissent avec le message
+		if err := tx.Where("message_kind = ? AND message_id = ?", models.MessageKindMatch, message.ID).Delete(&models.MessageEdit{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("message_kind = ? AND message_id = ?", models.MessageKindMatch, message.ID).Delete(&models.MessageReaction{}).Error
+	}); err != nil {
+		return nil, err
+	}
+
+	// La pièce jointe n'est plus référencée : elle sera supprimée par la purge des pièces jointes orphelines
+	message.Message = ""
+	message.AttachmentID = nil
+	message.Attachment = nil
+	message.DeletedAt = &now
+	deleted := withAuthor(*message)
+	s.publishEvent(message.MatchID, ChatEvent{Type: "message_deleted", MessageID: message.ID, Message: deleted})
+	return &deleted, nil
+}
+
+// SetReaction ajoute (add) ou retire une réaction d'un participant à un message
+func (s *ChatService) SetReaction(matchID, messageID, userID, emoji string, add bool) (models.Reactions, error) {
+	if !s.IsChatMember(matchID, userID) {
+		return nil, ErrMessageForbidden
+	}
+	message, err := s.findMessage(matchID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message.DeletedAt != nil {
+		return nil, ErrMessageDeleted
+	}
+
+	if add {
+		err = addReaction(s.DB, models.MessageKindMatch, messageID, userID, emoji)
+	} else {
+		err = removeReaction(s.DB, models.MessageKindMatch, messageID, userID, emoji)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	reactions, err := loadReactions(s.DB, models.MessageKindMatch, []string{messageID})
+	if err != nil {
+		return nil, err
+	}
+	s.publishEvent(matchID, ChatEvent{Type: "reactions_updated", MessageID: messageID, Reactions: reactions[messageID], UserID: userID, Emoji: emoji})
+	return reactions[messageID], nil
+}
+
+// GetEditHistory renvoie les versions précédentes d'un message
+func (s *ChatService) GetEditHistory(matchID, messageID string) ([]models.MessageEdit, error) {
+	if _, err := s.findMessage(matchID, messageID); err != nil {
+		return nil, err
+	}
+	return loadEditHistory(s.DB, models.MessageKindMatch, messageID)
+}
+
+// GetReplies renvoie les réponses à un message, de la plus ancienne à la plus récente
+func (s *ChatService) GetReplies(matchID, messageID string) ([]models.ChatMessage, error) {
+	if _, err := s.findMessage(matchID, messageID); err != nil {
+		return nil, err
+	}
+	var replies []models.ChatMessage
+	if err := s.DB.Preload("Player").Preload("Attachment").Where("match_id = ? AND reply_to_id = ?", matchID, messageID).Order("id asc").Find(&replies).Error; err != nil {
+		return nil, err
+	}
+	for i := range replies {
+		replies[i] = withAuthor(replies[i])
+	}
+	return s.withReactions(replies)
+}
+
+// MarkRead avance le curseur de lecture d'un membre jusqu'à un message et prévient les autres membres
+func (s *ChatService) MarkRead(matchID, userID, messageID string) (*models.ReadCursor, error) {
+	if !s.IsChatMember(matchID, userID) {
+		return nil, ErrMessageForbidden
+	}
+	message, err := s.findMessage(matchID, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	advanced, err := advanceReadCursor(s.DB, userID, models.MessageKindMatch, matchID, message.ID, message.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if advanced {
+		event := ChatEvent{Type: "read", MessageID: message.ID, UserID: userID}
+		if err := PublishRoomEvent(s.RedisClient, ChatRoom(matchID), event.Type, event); err != nil {
+			log.Printf("Error publishing read receipt: %v", err)
+		}
+	}
+
+	var cursor models.ReadCursor
+	if err := s.DB.Where("user_id = ? AND conversation_kind = ? AND conversation_id = ?", userID, models.MessageKindMatch, matchID).
+		First(&cursor).Error; err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+// GetReadCursors renvoie la position de lecture de chaque membre du chat d'un match
+func (s *ChatService) GetReadCursors(matchID string) ([]models.ReadCursor, error) {
+	return loadReadCursors(s.DB, models.MessageKindMatch, matchID)
+}
+
+// UnreadCounts renvoie, pour chaque match dont l'utilisateur est membre, le nombre de messages
+// des autres membres envoyés après son dernier message lu
+func (s *ChatService) UnreadCounts(userID string) (map[string]int64, error) {
+	var rows []struct {
+		MatchID string
+		Unread  int64
+	}
+	if err := s.DB.Table("chat_messages").
+		Select("chat_messages.match_id, COUNT(*) AS unread").
+		Joins("LEFT JOIN read_cursors ON read_cursors.user_id = ? AND read_cursors.conversation_kind = ? AND read_cursors.conversation_id = chat_messages.match_id", userID, models.MessageKindMatch).
+		Where("chat_messages.match_id IN (?) OR chat_messages.match_id IN (?)",
+			s.DB.Model(&models.MatchPlayers{}).Select("match_id").Where("player_id = ?", userID),
+			s.DB.Model(&models.Matches{}).Select("id").Where("organizer_id = ?", userID)).
+		Where("chat_messages.player_id <> ? AND chat_messages.deleted_at IS NULL", userID).
+		Where("read_cursors.id IS NULL OR chat_messages.created_at > read_cursors.last_read_message_at").
+		Group("chat_messages.match_id").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.MatchID] = row.Unread
+	}
+	return counts, nil
+}
+
+// PublishTyping diffuse aux membres du chat qu'un joueur écrit ou a cessé d'écrire ; rien n'est conservé
+func (s *ChatService) PublishTyping(matchID, userID string, typing bool) error {
+	return PublishRoomEvent(s.RedisClient, ChatRoom(matchID), "typing", ChatEvent{Type: "typing", UserID: userID, Typing: &typing})
+}
+
+// hasRestriction vérifie si une sanction est en cours pour un joueur dans le chat d'un match
+func (s *ChatService) hasRestriction(matchID, userID string, restrictionType models.ChatRestrictionType) bool {
+	var count int64
+	s.DB.Model(&models.ChatRestriction{}).
+		Where("match_id = ? AND user_id = ? AND type = ? AND (until IS NULL OR until > ?)", matchID, userID, restrictionType, time.Now()).
+		Count(&count)
+	return count > 0
+}
+
+// checkCanWrite vérifie qu'un joueur n'a été ni exclu ni réduit au silence dans le chat d'un match
+func (s *ChatService) checkCanWrite(matchID, userID string) error {
+	if s.hasRestriction(matchID, userID, models.ChatKick) {
+		return ErrMessageForbidden
+	}
+	if s.hasRestriction(matchID, userID, models.ChatMute) {
+		return ErrChatMuted
+	}
+	return nil
+}
+
+// checkOrganizer vérifie que l'utilisateur organise le match et que la sanction ne le vise pas lui-même
+func (s *ChatService) checkOrganizer(matchID, organizerID, userID string) error {
+	var match models.Matches
+	if err := s.DB.Where("id = ? AND deleted_at IS NULL", matchID).First(&match).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrMessageNotFound
+		}
+		return err
+	}
+	if match.OrganizerID != organizerID || userID == organizerID {
+		return ErrMessageForbidden
+	}
+	return nil
+}
+
+// RestrictMember réduit au silence (mute) ou exclut (kick) un joueur du chat d'un match, jusqu'à until
+// ou jusqu'à la levée de la sanction. Seul l'organisateur peut le faire.
+func (s *ChatService) RestrictMember(matchID, organizerID, userID string, restrictionType models.ChatRestrictionType, until *time.Time) (*models.ChatRestriction, error) {
+	if restrictionType != models.ChatMute && restrictionType != models.ChatKick {
+		return nil, fmt.Errorf("%w: unknown restriction %q", ErrInvalidChatMessage, restrictionType)
+	}
+	if err := s.checkOrganizer(matchID, organizerID, userID); err != nil {
+		return nil, err
+	}
+
+	t := time.Now()
+	entropy := ulid.Monotonic(rand.New(rand.NewSource(t.UnixNano())), 0)
+	restriction := models.ChatRestriction{
+		ID:        ulid.MustNew(ulid.Timestamp(t), entropy).String(),
+		MatchID:   matchID,
+		UserID:    userID,
+		Type:      restrictionType,
+		Until:     until,
+		CreatedBy: organizerID,
+	}
+	if err := s.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "match_id"}, {Name: "user_id"}, {Name: "type"}},
+		DoUpdates: clause.AssignmentColumns([]string{"until", "created_by", "created_at"}),
+	}).Create(&restriction).Error; err != nil {
+		return nil, err
+	}
+
+	eventType := "member_muted"
+	if restrictionType == models.ChatKick {
+		eventType = "member_kicked"
+	}
+	s.publishEvent(matchID, ChatEvent{Type: eventType, UserID: userID})
+	return &restriction, nil
+}
+
+// LiftRestriction lève la sanction d'un joueur dans le chat d'un match
+func (s *ChatService) LiftRestriction(matchID, organizerID, userID string, restrictionType models.ChatRestrictionType) error {
+	if err := s.checkOrganizer(matchID, organizerID, userID); err != nil {
+		return err
+	}
+	result := s.DB.Where("match_id = ? AND user_id = ? AND type = ?", matchID, userID, restrictionType).Delete(&models.ChatRestriction{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		eventType := "member_unmuted"
+		if restrictionType == models.ChatKick {
+			eventType = "member_readmitted"
+		}
+		s.publishEvent(matchID, ChatEvent{Type: eventType, UserID: userID})
+	}
+	return nil
+}
+
+// GetRestrictions renvoie les sanctions en cours dans le chat d'un match
+func (s *ChatService) GetRestrictions(matchID string) ([]models.ChatRestriction, error) {
+	var restrictions []models.ChatRestriction
+	if err := s.DB.Where("match_id = ? AND (until IS NULL OR until > ?)", matchID, time.Now()).
+		Order("created_at desc").Find(&restrictions).Error; err != nil {
+		return nil, err
+	}
+	return restrictions, nil
+}
@@ -1,40 +1,315 @@
 package services
 
 import (
-    "io"
-    "mime/multipart"
-    "os"
-    "path/filepath"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 
-    "github.com/google/uuid"
+	"github.com/google/uuid"
 )
 
+var (
+	ErrFileTooLarge        = errors.New("file is too large")
+	ErrUnsupportedFileType = errors.New("unsupported file type")
+	ErrInfectedFile        = errors.New("file rejected by the virus scan")
+)
+
+// Paramètres des miniatures et limite des dimensions des images décodées
+const (
+	thumbnailMaxSide = 320
+	maxImagePixels   = 50_000_000
+)
+
+// UploadPolicy associe chaque type de fichier accepté à sa taille maximale en octets
+type UploadPolicy map[string]int64
+
+// MaxSize renvoie la plus grande taille acceptée par la politique
+func (p UploadPolicy) MaxSize() int64 {
+	var max int64
+	for _, size := range p {
+		if size > max {
+			max = size
+		}
+	}
+	return max
+}
+
+// ChatAttachmentPolicy regroupe les photos et messages vocaux acceptés dans les chats
+var ChatAttachmentPolicy = UploadPolicy{
+	"image/jpeg": 10 << 20,
+	"image/png":  10 << 20,
+	"image/gif":  10 << 20,
+	"image/webp": 10 << 20,
+	"audio/mpeg": 15 << 20,
+	"audio/mp4":  15 << 20,
+	"audio/ogg":  15 << 20,
+	"audio/webm": 15 << 20,
+	"audio/wave": 15 << 20,
+}
+
+// fileExtensions donne l'extension des fichiers enregistrés selon leur type
+var fileExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+	"audio/mpeg": ".mp3",
+	"audio/mp4":  ".m4a",
+	"audio/ogg":  ".ogg",
+	"audio/webm": ".webm",
+	"audio/wave": ".wav",
+}
+
+// VirusScanner analyse un fichier enregistré et renvoie une erreur s'il doit être rejeté
+type VirusScanner func(path string) error
+
+// StoredFile décrit un fichier enregistré par Store ; les chemins sont relatifs au dossier d'upload
+type StoredFile struct {
+	Path          string
+	ThumbnailPath string
+	ContentType   string
+	Size          int64
+	Width         int
+	Height        int
+}
+
 type ImageService struct {
-    UploadDir string
+	UploadDir string
+	Scanner   VirusScanner // Optionnel : sans analyseur, les fichiers ne sont pas analysés
 }
 
+// NewImageService crée le service de stockage des fichiers envoyés.
+// Si VIRUS_SCAN_COMMAND est défini (par exemple "clamdscan --no-summary"), chaque fichier est analysé
+// avec cette commande et rejeté si elle échoue.
 func NewImageService(uploadDir string) *ImageService {
-    return &ImageService{UploadDir: uploadDir}
+	service := &ImageService{UploadDir: uploadDir}
+	if command := strings.Fields(os.Getenv("VIRUS_SCAN_COMMAND")); len(command) > 0 {
+		service.Scanner = commandScanner(command)
+	}
+	return service
+}
+
+// commandScanner lance une commande d'analyse antivirus sur le fichier ; un code de sortie non nul le rejette
+func commandScanner(command []string) VirusScanner {
+	return func(path string) error {
+		args := append(append([]string{}, command[1:]...), path)
+		output, err := exec.Command(command[0], args...).CombinedOutput()
+		if err != nil {
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				log.Printf("Virus scan rejected %s: %s", path, strings.TrimSpace(string(output)))
+				return ErrInfectedFile
+			}
+			return fmt.Errorf("virus scan failed: %w", err)
+		}
+		return nil
+	}
 }
 
 func (s *ImageService) SaveImage(file *multipart.FileHeader) (string, error) {
-    src, err := file.Open()
-    if err != nil {
-        return "", err
-    }
-    defer src.Close()
-
-    filename := uuid.New().String() + filepath.Ext(file.Filename)
-    filepath := filepath.Join(s.UploadDir, filename)
-    out, err := os.Create(filepath)
-    if err != nil {
-        return "", err
-    }
-    defer out.Close()
-
-    if _, err = io.Copy(out, src); err != nil {
-        return "", err
-    }
-
-    return filename, nil
-}
\ No newline at end of file
+	src, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	filename := uuid.New().String() + filepath.Ext(file.Filename)
+	filepath := filepath.Join(s.UploadDir, filename)
+	out, err := os.Create(filepath)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	if _, err = io.Copy(out, src); err != nil {
+		return "", err
+	}
+
+	return filename, nil
+}
+
+// Store enregistre un fichier dans le sous-dossier dir s'il respecte la politique d'upload.
+// Le type est détecté à partir du contenu et non du nom du fichier ; les images reçoivent une miniature
+// et le fichier est analysé avant d'être accepté.
+func (s *ImageService) Store(file *multipart.FileHeader, dir string, policy UploadPolicy) (*StoredFile, error) {
+	if file.Size > policy.MaxSize() {
+		return nil, ErrFileTooLarge
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	header := make([]byte, 512)
+	n, err := io.ReadFull(src, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+	header = header[:n]
+	contentType := detectContentType(header, file.Header.Get("Content-Type"))
+	maxSize, ok := policy[contentType]
+	if !ok {
+		return nil, ErrUnsupportedFileType
+	}
+	if file.Size > maxSize {
+		return nil, ErrFileTooLarge
+	}
+
+	if err := os.MkdirAll(filepath.Join(s.UploadDir, dir), 0o755); err != nil {
+		return nil, err
+	}
+	stored := &StoredFile{
+		Path:        filepath.Join(dir, uuid.New().String()+fileExtensions[contentType]),
+		ContentType: contentType,
+	}
+	out, err := os.Create(s.Path(stored.Path))
+	if err != nil {
+		return nil, err
+	}
+	// La taille annoncée par le client n'est pas fiable : la copie s'arrête au-delà de la limite
+	written, err := io.Copy(out, io.LimitReader(io.MultiReader(bytes.NewReader(header), src), maxSize+1))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && written > maxSize {
+		err = ErrFileTooLarge
+	}
+	if err == nil && s.Scanner != nil {
+		err = s.Scanner(s.Path(stored.Path))
+	}
+	if err != nil {
+		s.Remove(stored.Path)
+		return nil, err
+	}
+	stored.Size = written
+
+	if strings.HasPrefix(contentType, "image/") {
+		if err := s.createThumbnail(stored); err != nil {
+			if errors.Is(err, ErrUnsupportedFileType) {
+				s.Remove(stored.Path)
+				return nil, err
+			}
+			// Les formats que la bibliothèque standard ne décode pas (webp) restent sans miniature
+			log.Printf("No thumbnail for %s: %v", stored.Path, err)
+		}
+	}
+	return stored, nil
+}
+
+// detectContentType détermine le type d'un fichier à partir de ses premiers octets.
+// Les conteneurs communs à l'audio et à la vidéo ne sont acceptés comme audio que si le client l'annonce.
+func detectContentType(header []byte, declared string) string {
+	contentType, _, _ := strings.Cut(http.DetectContentType(header), ";")
+	if strings.HasPrefix(declared, "audio/") {
+		switch contentType {
+		case "video/mp4":
+			return "audio/mp4"
+		case "video/webm":
+			return "audio/webm"
+		case "application/ogg":
+			return "audio/ogg"
+		}
+	}
+	return contentType
+}
+
+// createThumbnail enregistre une miniature JPEG de l'image et renseigne ses dimensions
+func (s *ImageService) createThumbnail(stored *StoredFile) error {
+	file, err := os.Open(s.Path(stored.Path))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return err
+	}
+	// Refuse les images dont la taille décodée serait démesurée par rapport au fichier
+	if config.Width*config.Height > maxImagePixels {
+		return ErrUnsupportedFileType
+	}
+	stored.Width, stored.Height = config.Width, config.Height
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return err
+	}
+
+	thumbnailPath := strings.TrimSuffix(stored.Path, filepath.Ext(stored.Path)) + "_thumb.jpg"
+	out, err := os.Create(s.Path(thumbnailPath))
+	if err != nil {
+		return err
+	}
+	err = jpeg.Encode(out, resize(img, thumbnailMaxSide), &jpeg.Options{Quality: 80})
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		s.Remove(thumbnailPath)
+		return err
+	}
+	stored.ThumbnailPath = thumbnailPath
+	return nil
+}
+
+// resize réduit une image pour que son plus grand côté ne dépasse pas maxSide (plus proche voisin)
+func resize(img image.Image, maxSide int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxSide && height <= maxSide {
+		return img
+	}
+	newWidth, newHeight := maxSide, height*maxSide/width
+	if height > width {
+		newWidth, newHeight = width*maxSide/height, maxSide
+	}
+	if newWidth < 1 {
+		newWidth = 1
+	}
+	if newHeight < 1 {
+		newHeight = 1
+	}
+
+	thumbnail := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+	for y := 0; y < newHeight; y++ {
+		for x := 0; x < newWidth; x++ {
+			thumbnail.Set(x, y, img.At(bounds.Min.X+x*width/newWidth, bounds.Min.Y+y*height/newHeight))
+		}
+	}
+	return thumbnail
+}
+
+// Path renvoie le chemin complet d'un fichier enregistré
+func (s *ImageService) Path(relative string) string {
+	return filepath.Join(s.UploadDir, filepath.Clean("/"+relative))
+}
+
+// Remove supprime des fichiers enregistrés ; les chemins vides sont ignorés
+func (s *ImageService) Remove(paths ...string) {
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if err := os.Remove(s.Path(path)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove %s: %v", path, err)
+		}
+	}
+}
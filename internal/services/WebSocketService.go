@@ -1,93 +1,871 @@
 package services
 
 import (
-    "encoding/json"
-    "log"
-    "sync"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
-    "github.com/gofiber/websocket/v2"
+	"github.com/ady243/teamup/internal/models"
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/websocket/v2"
 )
 
+// Paramètres des connexions WebSocket
+const (
+	wsSendQueueSize = 256              // Messages en attente par connexion avant de la considérer trop lente
+	wsWriteWait     = 10 * time.Second // Délai maximal d'écriture d'un message
+	wsPongWait      = 60 * time.Second // Délai maximal sans nouvelle du client
+	wsPingPeriod    = wsPongWait * 9 / 10
+	wsMaxRooms      = 100              // Abonnements simultanés d'une connexion multiplexée
+	sseKeepAlive    = 15 * time.Second // Commentaire envoyé sur un flux SSE inactif pour détecter sa fermeture
+)
+
+// Réponses d'erreur aux commandes d'une connexion multiplexée
+var (
+	ErrHubClosed      = errors.New("websocket hub is shutting down")
+	ErrUnknownRoom    = errors.New("unknown room")
+	ErrRoomForbidden  = errors.New("not allowed to join this room")
+	ErrTooManyRooms   = errors.New("too many subscriptions")
+	ErrUnknownCommand = errors.New("unknown command")
+)
+
+// Types d'événements propres aux connexions du hub
+const (
+	EventUnsubscribed = "unsubscribed" // L'utilisateur d'une connexion multiplexée n'a plus accès à un salon
+	EventSnapshot     = "snapshot"     // État initial d'un flux SSE, avant les événements en direct
+	EventAuthExpired  = "auth_expired" // Token d'un flux SSE expiré : le client se reconnecte avec un nouveau token
+)
+
+// clientProtocol est le format des trames envoyées à une connexion du hub
+type clientProtocol int
+
+const (
+	protocolPayload  clientProtocol = iota // Connexions WebSocket historiques : contenu de l'événement seul
+	protocolEnvelope                       // Connexions WebSocket multiplexées : enveloppe complète
+	protocolSSE                            // Flux Server-Sent Events : id, type et contenu de l'événement
+)
+
+// RoomAuthorizer indique si un utilisateur peut s'abonner au salon d'identifiant id
+type RoomAuthorizer func(userID, id string) bool
+
+// clientCommand est une commande envoyée par le client d'une connexion multiplexée :
+// {"action": "subscribe", "room": "match:<id>", "last_event_id": "...", "ref": "1"}
+type clientCommand struct {
+	Action      string `json:"action"`
+	Room        string `json:"room"`
+	LastEventID string `json:"last_event_id"`
+	Ref         string `json:"ref"`
+}
+
+// wsClient est une connexion locale, WebSocket ou flux SSE. Les écritures passent par une file d'envoi
+// bornée vidée par une goroutine dédiée : un client lent ne bloque ni le hub ni les autres connexions.
+type wsClient struct {
+	conn      *websocket.Conn // nil pour un flux SSE
+	send      chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+	slow      atomic.Bool // File d'envoi saturée : la connexion est fermée sans vider la file
+	userID    string
+	protocol  clientProtocol
+	rooms     map[string]bool         // Salons suivis par la connexion
+	pending   map[string][]hubMessage // Messages reçus pendant le rattrapage d'un salon
+}
+
+func newWSClient(conn *websocket.Conn, userID string) *wsClient {
+	return &wsClient{
+		conn:    conn,
+		send:    make(chan []byte, wsSendQueueSize),
+		done:    make(chan struct{}),
+		userID:  userID,
+		rooms:   make(map[string]bool),
+		pending: make(map[string][]hubMessage),
+	}
+}
+
+// enqueue ajoute un message à la file d'envoi sans bloquer ; renvoie faux si la file est pleine
+func (c *wsClient) enqueue(message []byte) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+	select {
+	case c.send <- message:
+		return true
+	default:
+		return false
+	}
+}
+
+// frame renvoie la trame envoyée à la connexion pour un événement, selon son protocole
+func (c *wsClient) frame(envelope Envelope) []byte {
+	switch c.protocol {
+	case protocolEnvelope:
+		data, err := json.Marshal(envelope)
+		if err != nil {
+			log.Println("Error marshalling event envelope:", err)
+			return nil
+		}
+		return data
+	case protocolSSE:
+		return sseFrame(envelope.ID, envelope.Type, envelope.Payload)
+	}
+	return envelope.Payload
+}
+
+// sseFrame formate un événement Server-Sent Events ; le navigateur renvoie le dernier id reçu
+// dans l'en-tête Last-Event-ID lorsqu'il se reconnecte
+func sseFrame(id, eventType string, payload []byte) []byte {
+	var frame bytes.Buffer
+	if id != "" {
+		frame.WriteString("id: " + id + "\n")
+	}
+	frame.WriteString("event: " + eventType + "\ndata: ")
+	// Une ligne data ne peut pas contenir de saut de ligne
+	if err := json.Compact(&frame, payload); err != nil {
+		frame.WriteString("null")
+	}
+	frame.WriteString("\n\n")
+	return frame.Bytes()
+}
+
+// stop demande l'arrêt de la goroutine d'écriture
+func (c *wsClient) stop() {
+	c.closeOnce.Do(func() { close(c.done) })
+}
+
+// writePump envoie les messages de la file et un ping périodique. À l'arrêt, les messages déjà en file
+// sont envoyés puis la connexion est fermée proprement.
+func (c *wsClient) writePump(finished chan<- struct{}) {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		// La connexion détournée par fasthttp n'est fermée qu'au retour du handler : l'échéance de lecture
+		// débloque la boucle de lecture
+		c.conn.SetReadDeadline(time.Now())
+		c.conn.Close()
+		close(finished)
+	}()
+
+	for {
+		select {
+		case message := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				log.Println("Error sending WebSocket message:", err)
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-c.done:
+			if c.slow.Load() {
+				return
+			}
+			for {
+				select {
+				case message := <-c.send:
+					c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+					if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+						return
+					}
+				default:
+					c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+					c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"))
+					return
+				}
+			}
+		}
+	}
+}
+
+// WebSocketService est le hub des connexions WebSocket. Chaque événement est publié dans un salon (direct
+// d'un match, chat, groupe, utilisateur...) sous la forme d'une Envelope ; une connexion multiplexée
+// s'abonne à plusieurs salons, une connexion historique n'en suit qu'un. Les événements sont publiés
+// sur Redis et chaque instance de l'API les distribue à ses propres connexions ; sans Redis, la distribution
+// reste locale.
 type WebSocketService struct {
-    connections map[string]map[*websocket.Conn]bool 
-    broadcast   chan []byte
-    mutex       sync.Mutex
-}
-
-func NewWebSocketService() *WebSocketService {
-    return &WebSocketService{
-        connections: make(map[string]map[*websocket.Conn]bool),
-        broadcast:   make(chan []byte),
-    }
-}
-
-
-func (s *WebSocketService) HandleWebSocket(c *websocket.Conn, matchID string) {
-    log.Println("Handling new WebSocket connection for match:", matchID)
-    s.mutex.Lock()
-    if s.connections[matchID] == nil {
-        s.connections[matchID] = make(map[*websocket.Conn]bool)
-    }
-    s.connections[matchID][c] = true
-    s.mutex.Unlock()
-
-    defer func() {
-        s.mutex.Lock()
-        delete(s.connections[matchID], c)
-        s.mutex.Unlock()
-        if err := c.Close(); err != nil {
-            log.Println("Error closing WebSocket connection:", err)
-        }
-        log.Println("WebSocket connection closed for match:", matchID)
-    }()
-
-    for {
-        _, msg, err := c.ReadMessage()
-        if err != nil {
-            log.Println("Error reading WebSocket message:", err)
-            break
-        }
-        log.Printf("Received message for match %s: %s", matchID, msg)
-        s.broadcast <- msg
-    }
-}
-
-// BroadcastEventToMatch permet de diffuser un événement structuré (JSON) à tous les clients connectés pour un match spécifique
+	RedisClient *redis.Client
+	pubsub      *redis.PubSub
+	rooms       map[string]map[*wsClient]bool // Connexions locales par salon
+	connections map[*wsClient]bool
+	authorizers map[string]RoomAuthorizer // Règle d'accès par préfixe de salon
+	closing     bool
+	clients     sync.WaitGroup
+	mutex       sync.Mutex
+	// Canaux Redis auxquels l'instance est abonnée ; subscriptionMutex sérialise les abonnements,
+	// effectués hors du verrou du hub
+	subscriptions     map[string]bool
+	subscriptionMutex sync.Mutex
+}
+
+func NewWebSocketService(redisClient *redis.Client) *WebSocketService {
+	s := &WebSocketService{
+		RedisClient:   redisClient,
+		rooms:         make(map[string]map[*wsClient]bool),
+		connections:   make(map[*wsClient]bool),
+		authorizers:   make(map[string]RoomAuthorizer),
+		subscriptions: make(map[string]bool),
+	}
+	// Les événements privés d'un utilisateur ne sont accessibles qu'à lui
+	s.RegisterRoom("user", func(userID, id string) bool { return userID == id })
+	if redisClient != nil {
+		s.pubsub = redisClient.Subscribe(context.Background())
+	}
+	return s
+}
+
+// RegisterRoom déclare une famille de salons (préfixe avant « : ») auxquels les connexions multiplexées
+// peuvent s'abonner, avec sa règle d'accès
+func (s *WebSocketService) RegisterRoom(prefix string, authorize RoomAuthorizer) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.authorizers[prefix] = authorize
+}
+
+// authorize vérifie qu'un utilisateur peut s'abonner à un salon
+func (s *WebSocketService) authorize(room, userID string) error {
+	prefix, id, ok := strings.Cut(room, ":")
+	s.mutex.Lock()
+	authorize := s.authorizers[prefix]
+	s.mutex.Unlock()
+	if !ok || id == "" || authorize == nil {
+		return ErrUnknownRoom
+	}
+	if !authorize(userID, id) {
+		return ErrRoomForbidden
+	}
+	return nil
+}
+
+// connect enregistre une connexion dans un premier salon ; elle est attendue par Shutdown jusqu'à sa fermeture
+func (s *WebSocketService) connect(room string, client *wsClient) error {
+	s.mutex.Lock()
+	if s.closing {
+		s.mutex.Unlock()
+		return ErrHubClosed
+	}
+	s.join(room, client)
+	s.connections[client] = true
+	s.clients.Add(1)
+	s.mutex.Unlock()
+
+	if err := s.syncSubscription(room); err != nil {
+		s.disconnect(client)
+		return err
+	}
+	return nil
+}
+
+// disconnect retire une connexion de tous ses salons
+func (s *WebSocketService) disconnect(client *wsClient) {
+	s.mutex.Lock()
+	if !s.connections[client] {
+		s.mutex.Unlock()
+		return
+	}
+	var emptied []string
+	for room := range client.rooms {
+		if s.leave(room, client) {
+			emptied = append(emptied, room)
+		}
+	}
+	delete(s.connections, client)
+	s.mutex.Unlock()
+
+	s.releaseRooms(emptied)
+	s.clients.Done()
+}
+
+// join ajoute une connexion à un salon. Appelée avec le verrou du hub ; l'appelant abonne ensuite
+// l'instance au canal Redis du salon avec syncSubscription, une fois le verrou relâché.
+func (s *WebSocketService) join(room string, client *wsClient) {
+	if s.rooms[room] == nil {
+		s.rooms[room] = make(map[*wsClient]bool)
+	}
+	s.rooms[room][client] = true
+	client.rooms[room] = true
+}
+
+// leave retire une connexion d'un salon et renvoie vrai si c'était la dernière connexion locale : l'appelant
+// désabonne alors l'instance du canal Redis avec releaseRooms, une fois le verrou relâché.
+// Appelée avec le verrou du hub.
+func (s *WebSocketService) leave(room string, client *wsClient) bool {
+	delete(client.rooms, room)
+	delete(client.pending, room)
+	if !s.rooms[room][client] {
+		return false
+	}
+	delete(s.rooms[room], client)
+	if len(s.rooms[room]) > 0 {
+		return false
+	}
+	delete(s.rooms, room)
+	return true
+}
+
+// leaveRoom retire une connexion d'un salon et désabonne l'instance du canal Redis s'il n'a plus de connexion locale
+func (s *WebSocketService) leaveRoom(room string, client *wsClient) {
+	s.mutex.Lock()
+	emptied := s.leave(room, client)
+	s.mutex.Unlock()
+	if emptied {
+		s.releaseRooms([]string{room})
+	}
+}
+
+// syncSubscription aligne l'abonnement de l'instance au canal Redis d'un salon sur ses connexions locales.
+// Appelée sans le verrou du hub : les appels sont sérialisés et relisent l'état des salons, si bien qu'un
+// abonnement et un désabonnement concurrents d'un même salon aboutissent à l'état le plus récent.
+func (s *WebSocketService) syncSubscription(room string) error {
+	if s.pubsub == nil {
+		return nil
+	}
+	s.subscriptionMutex.Lock()
+	defer s.subscriptionMutex.Unlock()
+
+	s.mutex.Lock()
+	closing := s.closing
+	wanted := len(s.rooms[room]) > 0
+	s.mutex.Unlock()
+	if closing || wanted == s.subscriptions[room] {
+		return nil
+	}
+
+	ctx := context.Background()
+	if wanted {
+		if err := s.pubsub.Subscribe(ctx, RoomChannel(room)); err != nil {
+			return err
+		}
+		s.subscriptions[room] = true
+		return nil
+	}
+	delete(s.subscriptions, room)
+	return s.pubsub.Unsubscribe(ctx, RoomChannel(room))
+}
+
+// releaseRooms désabonne l'instance des canaux Redis des salons qui n'ont plus de connexion locale
+func (s *WebSocketService) releaseRooms(rooms []string) {
+	for _, room := range rooms {
+		if err := s.syncSubscription(room); err != nil {
+			log.Printf("Error unsubscribing from %s: %v", room, err)
+		}
+	}
+}
+
+// ControlFrames traite les trames de contrôle d'une connexion, comme le renouvellement de son authentification,
+// et renvoie vrai pour celles qui ne doivent pas être transmises au hub
+type ControlFrames func(raw []byte) bool
+
+// serve gère une connexion enregistrée jusqu'à sa fermeture. Les trames reçues du client qui ne sont pas
+// des trames de contrôle sont transmises à onMessage ; le délai de lecture est prolongé à chaque pong.
+func (s *WebSocketService) serve(client *wsClient, control ControlFrames, onMessage func([]byte)) {
+	finished := make(chan struct{})
+	go client.writePump(finished)
+	defer func() {
+		s.disconnect(client)
+		client.stop()
+		<-finished
+		log.Println("WebSocket connection closed")
+	}()
+
+	client.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	client.conn.SetPongHandler(func(string) error {
+		return client.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	for {
+		_, msg, err := client.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Println("Error reading WebSocket message:", err)
+			}
+			return
+		}
+		if control != nil && control(msg) {
+			continue
+		}
+		if onMessage != nil {
+			onMessage(msg)
+		}
+	}
+}
+
+func (s *WebSocketService) HandleWebSocket(c *websocket.Conn, matchID string, control ControlFrames) {
+	log.Println("Handling new WebSocket connection for match:", matchID)
+	client := newWSClient(c, "")
+	room := LiveRoom(matchID)
+	if err := s.connect(room, client); err != nil {
+		log.Println("Error registering WebSocket connection:", err)
+		c.Close()
+		return
+	}
+	// Les trames des spectateurs ne sont pas rediffusées : la lecture sert à détecter la fermeture
+	s.serve(client, control, nil)
+}
+
+// HandleWebSocketWithCatchUp envoie d'abord l'état initial du match (snapshot) puis les événements en direct.
+// La connexion est enregistrée avant la lecture du snapshot : les messages diffusés entre-temps sont
+// mis de côté puis envoyés après le snapshot, sauf ceux dont la clé figure déjà dans le snapshot.
+// Le client ne manque ainsi aucun événement et n'en reçoit aucun en double.
+func (s *WebSocketService) HandleWebSocketWithCatchUp(c *websocket.Conn, matchID string, control ControlFrames, snapshot func() (interface{}, map[string]bool, error)) {
+	log.Println("Handling new catch-up WebSocket connection for match:", matchID)
+	client := newWSClient(c, "")
+	room := LiveRoom(matchID)
+	client.pending[room] = nil
+	if err := s.connect(room, client); err != nil {
+		log.Println("Error registering WebSocket connection:", err)
+		c.Close()
+		return
+	}
+
+	// La goroutine d'écriture n'est pas encore démarrée : le snapshot est écrit directement
+	state, delivered, err := snapshot()
+	if err == nil {
+		var message []byte
+		message, err = json.Marshal(state)
+		if err == nil {
+			c.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			err = c.WriteMessage(websocket.TextMessage, message)
+		}
+	}
+	if err != nil {
+		log.Println("Error sending catch-up snapshot:", err)
+		s.disconnect(client)
+		if err := c.Close(); err != nil {
+			log.Println("Error closing WebSocket connection:", err)
+		}
+		return
+	}
+
+	s.mutex.Lock()
+	for _, msg := range client.pending[room] {
+		if msg.Key != "" && delivered[msg.Key] {
+			continue
+		}
+		if !client.enqueue(client.frame(msg.Envelope)) {
+			break
+		}
+	}
+	delete(client.pending, room)
+	s.mutex.Unlock()
+
+	s.serve(client, control, nil)
+}
+
+// HandleUserWebSocket enregistre la connexion d'un utilisateur authentifié jusqu'à sa fermeture.
+// Un utilisateur peut avoir plusieurs connexions (plusieurs appareils) ; toutes reçoivent ses événements.
+func (s *WebSocketService) HandleUserWebSocket(c *websocket.Conn, userID string, control ControlFrames) {
+	log.Println("Handling new WebSocket connection for user:", userID)
+	client := newWSClient(c, userID)
+	if err := s.connect(UserRoom(userID), client); err != nil {
+		log.Println("Error registering WebSocket connection:", err)
+		c.Close()
+		return
+	}
+	// Les trames des clients ne sont pas rediffusées : la lecture sert à détecter la fermeture
+	s.serve(client, control, nil)
+}
+
+// HandleRoomWebSocket gère une connexion historique qui reçoit le contenu des événements d'un seul salon.
+// Les trames du client qui ne sont pas des trames de contrôle sont transmises à onMessage, qui répond
+// au client avec reply. Avec userID, la connexion est fermée lorsque l'utilisateur perd l'accès au salon.
+func (s *WebSocketService) HandleRoomWebSocket(c *websocket.Conn, userID, room string, control ControlFrames, onMessage func(msg []byte, reply func([]byte))) {
+	log.Printf("Handling new WebSocket connection for %s", room)
+	client := newWSClient(c, userID)
+	if err := s.connect(room, client); err != nil {
+		log.Println("Error registering WebSocket connection:", err)
+		c.Close()
+		return
+	}
+	var handle func([]byte)
+	if onMessage != nil {
+		reply := func(msg []byte) { client.enqueue(msg) }
+		handle = func(msg []byte) { onMessage(msg, reply) }
+	}
+	s.serve(client, control, handle)
+}
+
+// HandleMultiplexedWebSocket gère une connexion qui reçoit dans des enveloppes les événements de plusieurs
+// salons. La connexion suit d'emblée le salon de son utilisateur ; le client s'abonne aux autres avec
+// les commandes subscribe et unsubscribe, auxquelles le serveur répond par un événement ack.
+func (s *WebSocketService) HandleMultiplexedWebSocket(c *websocket.Conn, userID string, control ControlFrames) {
+	log.Println("Handling new multiplexed WebSocket connection for user:", userID)
+	client := newWSClient(c, userID)
+	client.protocol = protocolEnvelope
+	if err := s.connect(UserRoom(userID), client); err != nil {
+		log.Println("Error registering WebSocket connection:", err)
+		c.Close()
+		return
+	}
+	s.serve(client, control, func(msg []byte) {
+		var command clientCommand
+		if err := json.Unmarshal(msg, &command); err != nil {
+			s.acknowledge(client, command, AckEvent{Error: "invalid command"})
+			return
+		}
+		switch command.Action {
+		case "subscribe":
+			s.subscribe(client, command)
+		case "unsubscribe":
+			s.leaveRoom(command.Room, client)
+			s.acknowledge(client, command, AckEvent{OK: true})
+		default:
+			s.acknowledge(client, command, AckEvent{Error: ErrUnknownCommand.Error()})
+		}
+	})
+}
+
+// acknowledge envoie au client la réponse à l'une de ses commandes
+func (s *WebSocketService) acknowledge(client *wsClient, command clientCommand, ack AckEvent) {
+	ack.Ref = command.Ref
+	ack.Action = command.Action
+	envelope, err := newEnvelope(command.Room, EventAck, ack)
+	if err != nil {
+		log.Println("Error marshalling ack:", err)
+		return
+	}
+	client.enqueue(client.frame(envelope))
+}
+
+// subscribe abonne une connexion multiplexée à un salon. Avec last_event_id, les événements publiés depuis
+// sont d'abord renvoyés à partir du tampon de reprise du salon ; ceux diffusés pendant la lecture du tampon
+// sont mis de côté puis envoyés ensuite, sans doublon.
+func (s *WebSocketService) subscribe(client *wsClient, command clientCommand) {
+	if err := s.authorize(command.Room, client.userID); err != nil {
+		s.acknowledge(client, command, AckEvent{Error: err.Error()})
+		return
+	}
+
+	s.mutex.Lock()
+	if client.rooms[command.Room] {
+		s.mutex.Unlock()
+		s.acknowledge(client, command, AckEvent{OK: true})
+		return
+	}
+	if len(client.rooms) >= wsMaxRooms {
+		s.mutex.Unlock()
+		s.acknowledge(client, command, AckEvent{Error: ErrTooManyRooms.Error()})
+		return
+	}
+	// Les événements du salon sont mis de côté jusqu'à l'accusé de réception, envoyé une fois
+	// l'instance abonnée au canal Redis du salon
+	client.pending[command.Room] = nil
+	s.join(command.Room, client)
+	s.mutex.Unlock()
+
+	if err := s.syncSubscription(command.Room); err != nil {
+		s.leaveRoom(command.Room, client)
+		log.Printf("Error subscribing to %s: %v", command.Room, err)
+		s.acknowledge(client, command, AckEvent{Error: "subscription failed"})
+		return
+	}
+	if command.LastEventID == "" {
+		// L'accusé de réception précède tout événement du salon
+		s.mutex.Lock()
+		if pending, ok := client.pending[command.Room]; ok {
+			delete(client.pending, command.Room)
+			s.acknowledge(client, command, AckEvent{OK: true})
+			for _, msg := range pending {
+				client.enqueue(client.frame(msg.Envelope))
+			}
+		}
+		s.mutex.Unlock()
+		return
+	}
+
+	s.replay(client, command.Room, command.LastEventID, func(replayed int, complete bool) {
+		s.acknowledge(client, command, AckEvent{OK: true, Replayed: replayed, Complete: complete})
+	})
+}
+
+// replay envoie à une connexion les événements d'un salon publiés après lastEventID, lus dans le tampon de reprise,
+// puis ceux mis de côté depuis son arrivée dans le salon, sans doublon. before est appelée juste avant l'envoi,
+// avec le nombre d'événements renvoyés et faux si des événements plus anciens que le tampon ont été perdus.
+func (s *WebSocketService) replay(client *wsClient, room, lastEventID string, before func(replayed int, complete bool)) {
+	var events []Envelope
+	complete := false
+	if s.RedisClient != nil {
+		buffered, err := loadReplay(s.RedisClient, room)
+		if err != nil {
+			log.Printf("Error loading replay buffer of %s: %v", room, err)
+		}
+		events, complete = eventsAfter(buffered, lastEventID)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	pending, ok := client.pending[room]
+	if !ok {
+		// Désabonné pendant la lecture du tampon
+		return
+	}
+	delete(client.pending, room)
+	if before != nil {
+		before(len(events), complete)
+	}
+	sent := make(map[string]bool, len(events))
+	for _, envelope := range events {
+		sent[envelope.ID] = true
+		client.enqueue(client.frame(envelope))
+	}
+	for _, msg := range pending {
+		if !sent[msg.Envelope.ID] {
+			client.enqueue(client.frame(msg.Envelope))
+		}
+	}
+}
+
+// OpenEventStream abonne un flux Server-Sent Events à un salon et renvoie la fonction qui écrit le flux
+// jusqu'à la déconnexion du client, l'arrêt du hub ou l'expiration du token (expiresAt). Avec lastEventID,
+// le flux reprend après cet événement ; sinon, si snapshot est fourni, il commence par l'état initial,
+// comme HandleWebSocketWithCatchUp.
+func (s *WebSocketService) OpenEventStream(userID, room, lastEventID string, expiresAt time.Time, snapshot func() (interface{}, map[string]bool, error)) (func(w *bufio.Writer), error) {
+	client := newWSClient(nil, userID)
+	client.protocol = protocolSSE
+	if lastEventID != "" || snapshot != nil {
+		client.pending[room] = nil
+	}
+	if err := s.connect(room, client); err != nil {
+		return nil, err
+	}
+
+	if lastEventID == "" && snapshot != nil {
+		state, delivered, err := snapshot()
+		var data []byte
+		if err == nil {
+			data, err = json.Marshal(state)
+		}
+		if err != nil {
+			s.disconnect(client)
+			return nil, err
+		}
+		s.mutex.Lock()
+		client.enqueue(sseFrame("", EventSnapshot, data))
+		for _, msg := range client.pending[room] {
+			if msg.Key != "" && delivered[msg.Key] {
+				continue
+			}
+			client.enqueue(client.frame(msg.Envelope))
+		}
+		delete(client.pending, room)
+		s.mutex.Unlock()
+	}
+
+	return func(w *bufio.Writer) {
+		defer s.disconnect(client)
+		if lastEventID != "" {
+			s.replay(client, room, lastEventID, nil)
+		}
+
+		keepAlive := time.NewTicker(sseKeepAlive)
+		defer keepAlive.Stop()
+		expired := time.NewTimer(time.Until(expiresAt))
+		defer expired.Stop()
+		for {
+			var frame []byte
+			select {
+			case frame = <-client.send:
+			case <-keepAlive.C:
+				frame = []byte(": keep-alive\n\n")
+			case <-expired.C:
+				w.Write(sseFrame("", EventAuthExpired, []byte("{}")))
+				w.Flush()
+				return
+			case <-client.done:
+				// Arrêt du hub ou client trop lent : les événements déjà en file sont envoyés si possible
+				for !client.slow.Load() && len(client.send) > 0 {
+					w.Write(<-client.send)
+				}
+				w.Flush()
+				return
+			}
+			if _, err := w.Write(frame); err != nil {
+				return
+			}
+			// L'échec de l'envoi signale la fermeture du flux par le client
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}, nil
+}
+
+// publish diffuse un événement dans un salon : via Redis pour toutes les instances, ou localement sans Redis
+func (s *WebSocketService) publish(room, eventType, key string, payload interface{}) {
+	envelope, err := newEnvelope(room, eventType, payload)
+	if err != nil {
+		log.Println("Error marshalling event:", err)
+		return
+	}
+	if s.RedisClient == nil {
+		s.dispatch(hubMessage{Key: key, Envelope: envelope})
+		return
+	}
+	if err := publishEnvelope(s.RedisClient, key, envelope); err != nil {
+		log.Printf("Error publishing to %s: %v", room, err)
+	}
+}
+
+// dispatch distribue un événement aux connexions locales de son salon. Une connexion dont la file
+// d'envoi est pleine est fermée ; le client se reconnecte et reprend après le dernier événement reçu.
+func (s *WebSocketService) dispatch(msg hubMessage) {
+	room := msg.Envelope.Room
+	var recheck []*wsClient
+
+	s.mutex.Lock()
+	for client := range s.rooms[room] {
+		if pending, ok := client.pending[room]; ok {
+			client.pending[room] = append(pending, msg)
+			continue
+		}
+		if !client.enqueue(client.frame(msg.Envelope)) {
+			log.Println("WebSocket client too slow, closing connection on", room)
+			client.slow.Store(true)
+			client.stop()
+			continue
+		}
+		if client.protocol != protocolSSE && client.userID != "" && revokingEvents[msg.Envelope.Type] {
+			recheck = append(recheck, client)
+		}
+	}
+	s.mutex.Unlock()
+
+	// Un membre retiré d'un salon perd son abonnement après avoir reçu l'annonce
+	if len(recheck) > 0 {
+		go s.revoke(room, recheck)
+	}
+}
+
+// revoke désabonne d'un salon les connexions dont l'utilisateur n'y a plus accès. Une connexion historique,
+// qui ne suit qu'un salon, est fermée après l'envoi des messages déjà en file.
+func (s *WebSocketService) revoke(room string, clients []*wsClient) {
+	for _, client := range clients {
+		if err := s.authorize(room, client.userID); !errors.Is(err, ErrRoomForbidden) {
+			continue
+		}
+		if client.protocol == protocolPayload {
+			client.stop()
+			continue
+		}
+		s.mutex.Lock()
+		emptied := false
+		if client.rooms[room] {
+			emptied = s.leave(room, client)
+			if envelope, err := newEnvelope(room, EventUnsubscribed, struct{}{}); err == nil {
+				client.enqueue(client.frame(envelope))
+			}
+		}
+		s.mutex.Unlock()
+		if emptied {
+			s.releaseRooms([]string{room})
+		}
+	}
+}
+
+// BroadcastEventToMatch diffuse un événement de l'analyste (models.Analyst ou models.ScoreChange)
+// dans le salon de direct d'un match
 func (s *WebSocketService) BroadcastEventToMatch(matchID string, event interface{}) {
-    message, err := json.Marshal(event)
-    if err != nil {
-        log.Println("Error marshalling event:", err)
-        return
-    }
-
-    log.Printf("Broadcasting event to match %s: %s", matchID, string(message))
-    s.mutex.Lock()
-    defer s.mutex.Unlock()
-
-    for conn := range s.connections[matchID] {
-        if err := conn.WriteMessage(websocket.TextMessage, message); err != nil {
-            log.Println("Error sending WebSocket message:", err)
-            conn.Close()
-            delete(s.connections[matchID], conn)
-        }
-    }
-}
-
-// StartBroadcast diffuse les messages reçus à tous les clients connectés
+	eventType := EventMatchEvent
+	switch event.(type) {
+	case models.ScoreChange, *models.ScoreChange:
+		eventType = EventScoreChange
+	}
+	log.Printf("Broadcasting %s to match %s", eventType, matchID)
+	s.publish(LiveRoom(matchID), eventType, deliveryKey(event), event)
+}
+
+// BroadcastEventChange diffuse la modification (EventMatchEventUpdated) ou la suppression
+// (EventMatchEventDeleted) d'un événement de l'analyste dans le salon de direct d'un match
+func (s *WebSocketService) BroadcastEventChange(matchID, eventType string, event models.Analyst) {
+	log.Printf("Broadcasting %s to match %s", eventType, matchID)
+	s.publish(LiveRoom(matchID), eventType, eventType+":"+event.ID, event)
+}
+
+// DeliverToUser envoie un événement aux seules connexions d'un utilisateur
+func (s *WebSocketService) DeliverToUser(userID, eventType string, event interface{}) {
+	s.DeliverToUsers([]string{userID}, eventType, event)
+}
+
+// DeliverToUsers envoie un événement aux connexions de chacun des utilisateurs, une seule fois par utilisateur
+func (s *WebSocketService) DeliverToUsers(userIDs []string, eventType string, event interface{}) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Println("Error marshalling event:", err)
+		return
+	}
+	delivered := make(map[string]bool, len(userIDs))
+	for _, userID := range userIDs {
+		if delivered[userID] {
+			continue
+		}
+		delivered[userID] = true
+		s.publish(UserRoom(userID), eventType, "", json.RawMessage(payload))
+	}
+}
+
+// deliveryKey identifie un message temps réel pour éviter les doublons lors d'un rattrapage
+func deliveryKey(event interface{}) string {
+	switch e := event.(type) {
+	case models.Analyst:
+		return "event:" + e.ID
+	case *models.Analyst:
+		return "event:" + e.ID
+	case models.ScoreChange:
+		return "score_change:" + e.EventID + ":" + e.Reason
+	case *models.ScoreChange:
+		return "score_change:" + e.EventID + ":" + e.Reason
+	}
+	return ""
+}
+
+// StartBroadcast distribue aux connexions locales les événements publiés sur Redis par toutes les instances.
+// S'arrête à la fermeture du hub.
 func (s *WebSocketService) StartBroadcast() {
-    for {
-        msg := <-s.broadcast
-        log.Printf("Broadcasting message: %s", msg)
-        s.mutex.Lock()
-        for _, conns := range s.connections {
-            for conn := range conns {
-                if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
-                    log.Println("Error writing WebSocket message:", err)
-                    conn.Close()
-                    delete(conns, conn)
-                }
-            }
-        }
-        s.mutex.Unlock()
-    }
-}
\ No newline at end of file
+	if s.pubsub == nil {
+		return
+	}
+	for msg := range s.pubsub.Channel() {
+		var hubMsg hubMessage
+		if err := json.Unmarshal([]byte(msg.Payload), &hubMsg); err != nil {
+			log.Printf("Invalid hub message on %s: %v", msg.Channel, err)
+			continue
+		}
+		s.dispatch(hubMsg)
+	}
+}
+
+// Shutdown refuse les nouvelles connexions, envoie aux clients les messages en file suivis d'une trame
+// de fermeture, puis attend la fin des connexions ou l'expiration du contexte
+func (s *WebSocketService) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	s.closing = true
+	for client := range s.connections {
+		client.stop()
+	}
+	s.mutex.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		s.clients.Wait()
+		close(drained)
+	}()
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if s.pubsub != nil {
+		s.pubsub.Close()
+	}
+	return err
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	fasthttpws "github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+)
+
+// startHub démarre un serveur Fiber servant les connexions d'utilisateur et multiplexées d'un hub sans Redis :
+// les événements passent par la distribution locale (dispatch)
+func startHub(t *testing.T) (*WebSocketService, string) {
+	t.Helper()
+	hub := NewWebSocketService(nil)
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/user/:id", websocket.New(func(c *websocket.Conn) {
+		hub.HandleUserWebSocket(c, c.Params("id"), nil)
+	}))
+	app.Get("/multiplex/:id", websocket.New(func(c *websocket.Conn) {
+		hub.HandleMultiplexedWebSocket(c, c.Params("id"), nil)
+	}))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(listener)
+	t.Cleanup(func() { app.Shutdown() })
+	return hub, "ws://" + listener.Addr().String()
+}
+
+// dial ouvre une connexion et attend qu'elle soit enregistrée dans le salon de son utilisateur
+func dial(t *testing.T, hub *WebSocketService, url, userID string) *fasthttpws.Conn {
+	t.Helper()
+	conn, _, err := fasthttpws.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		hub.mutex.Lock()
+		connected := len(hub.rooms[UserRoom(userID)])
+		hub.mutex.Unlock()
+		if connected > 0 {
+			return conn
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("connection of %s was not registered", userID)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func read(t *testing.T, conn *fasthttpws.Conn, timeout time.Duration) ([]byte, error) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	_, message, err := conn.ReadMessage()
+	return message, err
+}
+
+func TestDeliverToUsersReachesOnlyRecipients(t *testing.T) {
+	hub, url := startHub(t)
+	alice := dial(t, hub, url+"/user/alice", "alice")
+	bob := dial(t, hub, url+"/multiplex/bob", "bob")
+	carol := dial(t, hub, url+"/multiplex/carol", "carol")
+
+	// Un utilisateur ne peut pas s'abonner au salon privé d'un autre
+	if err := carol.WriteJSON(clientCommand{Action: "subscribe", Room: UserRoom("alice"), Ref: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	message, err := read(t, carol, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var envelope Envelope
+	var ack AckEvent
+	if err := json.Unmarshal(message, &envelope); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(envelope.Payload, &ack); err != nil {
+		t.Fatal(err)
+	}
+	if envelope.Type != EventAck || ack.OK || ack.Error != ErrRoomForbidden.Error() || ack.Ref != "1" {
+		t.Fatalf("subscribe to another user's room: got %s", message)
+	}
+
+	hub.DeliverToUsers([]string{"alice", "bob", "alice"}, EventFriendRequest, FriendRequestEvent{})
+
+	// Connexion historique : contenu de l'événement seul
+	message, err = read(t, alice, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !json.Valid(message) {
+		t.Fatalf("alice: invalid payload %s", message)
+	}
+	if _, err := read(t, alice, 200*time.Millisecond); err == nil {
+		t.Fatal("alice received the event twice")
+	}
+
+	// Connexion multiplexée : enveloppe du salon de l'utilisateur
+	message, err = read(t, bob, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope = Envelope{}
+	if err := json.Unmarshal(message, &envelope); err != nil {
+		t.Fatal(err)
+	}
+	if envelope.Type != EventFriendRequest || envelope.Room != UserRoom("bob") {
+		t.Fatalf("bob: got %s", message)
+	}
+
+	if message, err := read(t, carol, 200*time.Millisecond); err == nil {
+		t.Fatalf("carol received an event addressed to others: %s", message)
+	}
+}
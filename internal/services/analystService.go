@@ -2,11 +2,16 @@ package services
 
 import (
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/ady243/teamup/internal/models"
 	"gorm.io/gorm"
 )
 
+// ErrInvalidEvent est renvoyée quand un événement ne respecte pas le catalogue
+var ErrInvalidEvent = errors.New("invalid event")
+
 type AnalystService struct {
 	DB *gorm.DB
 }
@@ -17,9 +22,35 @@ func NewAnalystService(db *gorm.DB) *AnalystService {
 	}
 }
 
-// CreateEvent crée un nouvel enregistrement dans la table Analyst
-func (s *AnalystService) CreateEvent(event *models.Analyst) error {
-	return s.DB.Create(event).Error
+// CreateEvent crée un nouvel enregistrement dans la table Analyst.
+// Un but, un but contre son camp ou un penalty transformé met à jour le score du match dans la même transaction ;
+// le nouveau score est alors renvoyé, sinon le ScoreChange est nil.
+func (s *AnalystService) CreateEvent(event *models.Analyst) (*models.ScoreChange, error) {
+	var change *models.ScoreChange
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		team, err := scoringTeam(tx, event)
+		if err != nil {
+			return err
+		}
+		event.ScoredFor = team
+
+		if err := tx.Create(event).Error; err != nil {
+			return err
+		}
+		if team == nil {
+			return nil
+		}
+
+		if err := applyScore(tx, event.MatchID, *team, 1); err != nil {
+			return err
+		}
+		change, err = readScoreChange(tx, event, "event_created")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return change, nil
 }
 
 // GetEventsByMatchID renvoie tous les events pour un match donné
@@ -63,9 +94,76 @@ func (s *AnalystService) GetEventsByPlayerID(playerID string) ([]models.Analyst,
 	return events, nil
 }
 
-// UpdateEvent met à jour un event déjà existant
-func (s *AnalystService) UpdateEvent(event *models.Analyst) error {
-	return s.DB.Save(event).Error
+// UpdateEvent met à jour un event déjà existant.
+// Le but éventuellement compté pour la version précédente est annulé avant d'appliquer la nouvelle version.
+func (s *AnalystService) UpdateEvent(event *models.Analyst) (*models.ScoreChange, error) {
+	var change *models.ScoreChange
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		var previous models.Analyst
+		if err := tx.Where("id = ? AND deleted_at IS NULL", event.ID).First(&previous).Error; err != nil {
+			return err
+		}
+
+		team, err := scoringTeam(tx, event)
+		if err != nil {
+			return err
+		}
+		event.ScoredFor = team
+
+		if err := tx.Omit("Player", "Match", "AnalystUser").Save(event).Error; err != nil {
+			return err
+		}
+
+		if sameTeam(previous.ScoredFor, team) {
+			return nil
+		}
+		if previous.ScoredFor != nil {
+			if err := applyScore(tx, event.MatchID, *previous.ScoredFor, -1); err != nil {
+				return err
+			}
+		}
+		if team != nil {
+			if err := applyScore(tx, event.MatchID, *team, 1); err != nil {
+				return err
+			}
+		}
+		change, err = readScoreChange(tx, event, "event_updated")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+// SoftDeleteEvent marque un event comme supprimé et annule le but qu'il avait éventuellement compté
+func (s *AnalystService) SoftDeleteEvent(eventID string) (*models.ScoreChange, error) {
+	var change *models.ScoreChange
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		var event models.Analyst
+		if err := tx.Where("id = ? AND deleted_at IS NULL", eventID).First(&event).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if err := tx.Model(&event).Update("deleted_at", &now).Error; err != nil {
+			return err
+		}
+		if event.ScoredFor == nil {
+			return nil
+		}
+
+		if err := applyScore(tx, event.MatchID, *event.ScoredFor, -1); err != nil {
+			return err
+		}
+		var err error
+		change, err = readScoreChange(tx, &event, "event_deleted")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return change, nil
 }
 
 // DeleteEvent supprime vraiment l’event (Hard Delete, si besoin)
@@ -75,3 +173,190 @@ func (s *AnalystService) DeleteEvent(eventID string) error {
 	}
 	return nil
 }
+
+// ValidateEvent vérifie qu'un événement respecte le catalogue : type connu, payload conforme,
+// joueurs inscrits au match dans une équipe et minute comprise dans la durée du match
+func (s *AnalystService) ValidateEvent(event *models.Analyst) error {
+	definition, ok := models.GetEventDefinition(event.EventType)
+	if !ok {
+		return fmt.Errorf("%w: unknown event type %q", ErrInvalidEvent, event.EventType)
+	}
+
+	var match models.Matches
+	if err := s.DB.Where("id = ? AND deleted_at IS NULL", event.MatchID).First(&match).Error; err != nil {
+		return fmt.Errorf("%w: match not found", ErrInvalidEvent)
+	}
+	duration := int(MatchDuration(match).Minutes())
+	if event.Minute < 0 || event.Minute > duration {
+		return fmt.Errorf("%w: minute must be between 0 and %d", ErrInvalidEvent, duration)
+	}
+
+	player, err := s.getTeamPlayer(event.MatchID, event.PlayerID)
+	if err != nil {
+		return err
+	}
+
+	// Vérifier que seuls les champs prévus par le catalogue sont renseignés
+	allowed := make(map[string]bool)
+	for _, field := range append(definition.RequiredFields, definition.OptionalFields...) {
+		allowed[field] = true
+	}
+	fields := payloadFields(event.Payload)
+	for field := range fields {
+		if !allowed[field] {
+			return fmt.Errorf("%w: field %q is not allowed for %s events", ErrInvalidEvent, field, event.EventType)
+		}
+	}
+	for _, field := range definition.RequiredFields {
+		if !fields[field] {
+			return fmt.Errorf("%w: field %q is required for %s events", ErrInvalidEvent, field, event.EventType)
+		}
+	}
+
+	payload := event.Payload
+	if (payload.X != nil && (*payload.X < 0 || *payload.X > 100)) || (payload.Y != nil && (*payload.Y < 0 || *payload.Y > 100)) {
+		return fmt.Errorf("%w: pitch coordinates must be between 0 and 100", ErrInvalidEvent)
+	}
+	if payload.AssistBy != nil {
+		if err := s.checkRelatedPlayer(event, player, *payload.AssistBy, true); err != nil {
+			return err
+		}
+	}
+	if payload.PlayerIn != nil {
+		if err := s.checkRelatedPlayer(event, player, *payload.PlayerIn, true); err != nil {
+			return err
+		}
+	}
+	if payload.FoulOn != nil {
+		if err := s.checkRelatedPlayer(event, player, *payload.FoulOn, false); err != nil {
+			return err
+		}
+	}
+
+	event.CatalogVersion = models.EventCatalogVersion
+	return nil
+}
+
+// getTeamPlayer renvoie l'inscription d'un joueur au match, à condition qu'il soit affecté à une équipe
+func (s *AnalystService) getTeamPlayer(matchID, playerID string) (*models.MatchPlayers, error) {
+	var player models.MatchPlayers
+	if err := s.DB.Where("match_id = ? AND player_id = ?", matchID, playerID).First(&player).Error; err != nil {
+		return nil, fmt.Errorf("%w: player %s is not in the match", ErrInvalidEvent, playerID)
+	}
+	if player.TeamNumber == nil {
+		return nil, fmt.Errorf("%w: player %s is not assigned to a team", ErrInvalidEvent, playerID)
+	}
+	return &player, nil
+}
+
+// checkRelatedPlayer vérifie un joueur référencé dans le payload : inscrit au match,
+// différent du joueur principal, et dans la même équipe ou l'équipe adverse selon sameTeam
+func (s *AnalystService) checkRelatedPlayer(event *models.Analyst, player *models.MatchPlayers, relatedID string, sameTeam bool) error {
+	if relatedID == event.PlayerID {
+		return fmt.Errorf("%w: related player must be different from player_id", ErrInvalidEvent)
+	}
+	related, err := s.getTeamPlayer(event.MatchID, relatedID)
+	if err != nil {
+		return err
+	}
+	if (*related.TeamNumber == *player.TeamNumber) != sameTeam {
+		if sameTeam {
+			return fmt.Errorf("%w: player %s must be in the same team", ErrInvalidEvent, relatedID)
+		}
+		return fmt.Errorf("%w: player %s must be in the opposing team", ErrInvalidEvent, relatedID)
+	}
+	return nil
+}
+
+// payloadFields renvoie les noms des champs renseignés dans un payload
+func payloadFields(payload models.EventPayload) map[string]bool {
+	fields := make(map[string]bool)
+	if payload.AssistBy != nil {
+		fields["assist_by"] = true
+	}
+	if payload.PlayerIn != nil {
+		fields["player_in"] = true
+	}
+	if payload.FoulOn != nil {
+		fields["foul_on"] = true
+	}
+	if payload.Scored != nil {
+		fields["scored"] = true
+	}
+	if payload.X != nil {
+		fields["x"] = true
+	}
+	if payload.Y != nil {
+		fields["y"] = true
+	}
+	return fields
+}
+
+// MatchDuration calcule la durée d'un match à partir de ses heures de début et de fin
+func MatchDuration(match models.Matches) time.Duration {
+	start := time.Date(0, 1, 1, match.MatchTime.Hour(), match.MatchTime.Minute(), match.MatchTime.Second(), 0, time.UTC)
+	end := time.Date(0, 1, 1, match.EndTime.Hour(), match.EndTime.Minute(), match.EndTime.Second(), 0, time.UTC)
+	if !end.After(start) {
+		// Le match se termine après minuit
+		end = end.Add(24 * time.Hour)
+	}
+	return end.Sub(start)
+}
+
+// scoringTeam renvoie l'équipe créditée d'un but pour un événement (but, but contre son camp ou
+// penalty transformé), ou nil si l'événement ne modifie pas le score
+func scoringTeam(tx *gorm.DB, event *models.Analyst) (*int, error) {
+	penaltyScored := event.EventType == models.EventPenalty && event.Payload.Scored != nil && *event.Payload.Scored
+	if event.EventType != models.EventGoal && event.EventType != models.EventOwnGoal && !penaltyScored {
+		return nil, nil
+	}
+
+	var player models.MatchPlayers
+	if err := tx.Where("match_id = ? AND player_id = ?", event.MatchID, event.PlayerID).First(&player).Error; err != nil {
+		return nil, fmt.Errorf("%w: player %s is not in the match", ErrInvalidEvent, event.PlayerID)
+	}
+	if player.TeamNumber == nil {
+		return nil, fmt.Errorf("%w: player %s is not assigned to a team", ErrInvalidEvent, event.PlayerID)
+	}
+
+	team := *player.TeamNumber
+	if event.EventType == models.EventOwnGoal {
+		// Un but contre son camp profite à l'équipe adverse
+		team = 3 - team
+	}
+	return &team, nil
+}
+
+// applyScore ajoute delta au score d'une équipe, sans descendre en dessous de zéro
+func applyScore(tx *gorm.DB, matchID string, team, delta int) error {
+	column := "score_team1"
+	if team == 2 {
+		column = "score_team2"
+	}
+	return tx.Model(&models.Matches{}).
+		Where("id = ?", matchID).
+		UpdateColumn(column, gorm.Expr("GREATEST("+column+" + ?, 0)", delta)).Error
+}
+
+// readScoreChange relit le score du match pour construire le message diffusé aux spectateurs
+func readScoreChange(tx *gorm.DB, event *models.Analyst, reason string) (*models.ScoreChange, error) {
+	var match models.Matches
+	if err := tx.Where("id = ?", event.MatchID).First(&match).Error; err != nil {
+		return nil, err
+	}
+	return &models.ScoreChange{
+		Type:       "score_change",
+		MatchID:    event.MatchID,
+		EventID:    event.ID,
+		Reason:     reason,
+		ScoreTeam1: match.ScoreTeam1,
+		ScoreTeam2: match.ScoreTeam2,
+	}, nil
+}
+
+func sameTeam(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"sort"
+	"time"
+
+	"github.com/ady243/teamup/internal/models"
+)
+
+// PlayerRef est une référence compacte à un joueur, utilisée dans la timeline
+type PlayerRef struct {
+	ID           string `json:"id"`
+	Username     string `json:"username"`
+	ProfilePhoto string `json:"profile_photo"`
+}
+
+// TimelineEntry est une ligne de la timeline : un événement d'analyste ou un marqueur de période
+type TimelineEntry struct {
+	Kind       string               `json:"kind"` // "event" ou "period"
+	ID         string               `json:"id,omitempty"`
+	Period     string               `json:"period,omitempty"` // kick_off, half_time ou full_time
+	Minute     int                  `json:"minute"`
+	EventType  models.EventType     `json:"event_type,omitempty"`
+	PlayerID   string               `json:"player_id,omitempty"`
+	Payload    *models.EventPayload `json:"payload,omitempty"`
+	ScoredFor  *int                 `json:"scored_for,omitempty"`
+	ScoreTeam1 int                  `json:"score_team_1"` // Score après cette ligne
+	ScoreTeam2 int                  `json:"score_team_2"`
+}
+
+// MatchTimeline est la chronologie complète d'un match
+type MatchTimeline struct {
+	Type       string               `json:"type"` // Toujours "timeline", pour les clients WebSocket
+	MatchID    string               `json:"match_id"`
+	Status     models.Status        `json:"status"`
+	Duration   int                  `json:"duration"` // Durée prévue du match en minutes
+	ScoreTeam1 int                  `json:"score_team_1"`
+	ScoreTeam2 int                  `json:"score_team_2"`
+	Entries    []TimelineEntry      `json:"entries"`
+	Players    map[string]PlayerRef `json:"players"`
+}
+
+// GetMatchTimeline construit la timeline d'un match : événements triés par minute,
+// score courant après chaque événement et marqueurs de période
+func (s *AnalystService) GetMatchTimeline(matchID string) (*MatchTimeline, error) {
+	var match models.Matches
+	if err := s.DB.Where("id = ? AND deleted_at IS NULL", matchID).First(&match).Error; err != nil {
+		return nil, err
+	}
+
+	var events []models.Analyst
+	if err := s.DB.Where("match_id = ? AND deleted_at IS NULL", matchID).
+		Order("minute asc, created_at asc").
+		Find(&events).Error; err != nil {
+		return nil, err
+	}
+
+	duration := int(MatchDuration(match).Minutes())
+	halfTime := duration / 2
+	timeline := &MatchTimeline{
+		Type:     "timeline",
+		MatchID:  match.ID,
+		Status:   match.Status,
+		Duration: duration,
+		Entries:  []TimelineEntry{},
+		Players:  map[string]PlayerRef{},
+	}
+
+	// Minute atteinte par le match, pour savoir quels marqueurs de période afficher
+	elapsed := 0
+	switch match.Status {
+	case models.Completed:
+		elapsed = duration
+	case models.Ongoing:
+		start := time.Date(match.MatchDate.Year(), match.MatchDate.Month(), match.MatchDate.Day(), match.MatchTime.Hour(), match.MatchTime.Minute(), match.MatchTime.Second(), 0, time.UTC)
+		elapsed = int(time.Since(start).Minutes())
+	}
+	if len(events) > 0 && events[len(events)-1].Minute > elapsed {
+		elapsed = events[len(events)-1].Minute
+	}
+	started := match.Status == models.Ongoing || match.Status == models.Completed || len(events) > 0
+
+	period := func(name string, minute int) TimelineEntry {
+		return TimelineEntry{Kind: "period", Period: name, Minute: minute, ScoreTeam1: timeline.ScoreTeam1, ScoreTeam2: timeline.ScoreTeam2}
+	}
+
+	if started {
+		timeline.Entries = append(timeline.Entries, period("kick_off", 0))
+	}
+	halfTimeAdded := false
+	playerIDs := make(map[string]bool)
+	for i := range events {
+		event := events[i]
+		if !halfTimeAdded && elapsed >= halfTime && event.Minute > halfTime {
+			timeline.Entries = append(timeline.Entries, period("half_time", halfTime))
+			halfTimeAdded = true
+		}
+
+		if event.ScoredFor != nil {
+			if *event.ScoredFor == 1 {
+				timeline.ScoreTeam1++
+			} else {
+				timeline.ScoreTeam2++
+			}
+		}
+		timeline.Entries = append(timeline.Entries, TimelineEntry{
+			Kind:       "event",
+			ID:         event.ID,
+			Minute:     event.Minute,
+			EventType:  event.EventType,
+			PlayerID:   event.PlayerID,
+			Payload:    &event.Payload,
+			ScoredFor:  event.ScoredFor,
+			ScoreTeam1: timeline.ScoreTeam1,
+			ScoreTeam2: timeline.ScoreTeam2,
+		})
+
+		playerIDs[event.PlayerID] = true
+		for _, related := range []*string{event.Payload.AssistBy, event.Payload.PlayerIn, event.Payload.FoulOn} {
+			if related != nil {
+				playerIDs[*related] = true
+			}
+		}
+	}
+	if !halfTimeAdded && started && elapsed >= halfTime {
+		timeline.Entries = append(timeline.Entries, period("half_time", halfTime))
+	}
+	if match.Status == models.Completed {
+		timeline.Entries = append(timeline.Entries, period("full_time", duration))
+	}
+
+	if len(playerIDs) > 0 {
+		ids := make([]string, 0, len(playerIDs))
+		for id := range playerIDs {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		var players []models.Users
+		if err := s.DB.Select("id", "username", "profile_photo").Where("id IN ?", ids).Find(&players).Error; err != nil {
+			return nil, err
+		}
+		for _, player := range players {
+			timeline.Players[player.ID] = PlayerRef{ID: player.ID, Username: player.Username, ProfilePhoto: player.ProfilePhoto}
+		}
+	}
+
+	return timeline, nil
+}
+
+// DeliveredKeys renvoie les clés des messages temps réel déjà contenus dans la timeline,
+// pour qu'un client en mode rattrapage ne les reçoive pas une seconde fois
+func (t *MatchTimeline) DeliveredKeys() map[string]bool {
+	keys := make(map[string]bool)
+	for _, entry := range t.Entries {
+		if entry.Kind != "event" {
+			continue
+		}
+		keys["event:"+entry.ID] = true
+		keys["score_change:"+entry.ID+":event_created"] = true
+	}
+	return keys
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	apnsProductionHost  = "https://api.push.apple.com"
+	apnsDevelopmentHost = "https://api.sandbox.push.apple.com"
+	// Apple refuse les jetons de plus d'une heure et leur renouvellement plus d'une fois toutes les 20 minutes
+	apnsTokenLifetime = 50 * time.Minute
+)
+
+// APNsNotifier envoie les notifications push directement aux appareils iOS par le service d'Apple,
+// authentifié par une clé de signature (.p8) de l'équipe de développement
+type APNsNotifier struct {
+	client *http.Client
+	host   string
+	topic  string
+	keyID  string
+	teamID string
+	key    *ecdsa.PrivateKey
+
+	mu       sync.Mutex
+	token    string
+	issuedAt time.Time
+}
+
+// NewAPNsNotifierFromFile lit la clé de signature APNs au format PEM (fichier .p8 fourni par Apple)
+func NewAPNsNotifierFromFile(keyFile, keyID, teamID, topic string, production bool) (*APNsNotifier, error) {
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return NewAPNsNotifier(keyPEM, keyID, teamID, topic, production)
+}
+
+func NewAPNsNotifier(keyPEM []byte, keyID, teamID, topic string, production bool) (*APNsNotifier, error) {
+	if keyID == "" || teamID == "" || topic == "" {
+		return nil, errors.New("APNS_KEY_ID, APNS_TEAM_ID et APNS_TOPIC sont requis")
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("clé APNs illisible")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("clé APNs illisible: %w", err)
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("la clé APNs doit être une clé ECDSA P-256")
+	}
+
+	host := apnsDevelopmentHost
+	if production {
+		host = apnsProductionHost
+	}
+	return &APNsNotifier{
+		client: &http.Client{Timeout: 10 * time.Second},
+		host:   host,
+		topic:  topic,
+		keyID:  keyID,
+		teamID: teamID,
+		key:    key,
+	}, nil
+}
+
+// bearer renvoie le jeton d'authentification du fournisseur, renouvelé avant son expiration
+func (n *APNsNotifier) bearer() (string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.token != "" && time.Since(n.issuedAt) < apnsTokenLifetime {
+		return n.token, nil
+	}
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{"iss": n.teamID, "iat": now.Unix()})
+	token.Header["kid"] = n.keyID
+	signed, err := token.SignedString(n.key)
+	if err != nil {
+		return "", err
+	}
+	n.token, n.issuedAt = signed, now
+	return signed, nil
+}
+
+// Send envoie la notification à chaque appareil ; les données du lien profond accompagnent l'alerte
+func (n *APNsNotifier) Send(ctx context.Context, notification OutgoingNotification) (*DeliveryReport, error) {
+	payload := map[string]interface{}{}
+	for key, value := range notification.Data {
+		payload[key] = value
+	}
+	aps := map[string]interface{}{
+		"alert": map[string]string{"title": notification.Title, "body": notification.Body},
+		"sound": "default",
+	}
+	if notification.CollapseKey != "" {
+		aps["thread-id"] = notification.CollapseKey
+	}
+	payload["aps"] = aps
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	bearer, err := n.bearer()
+	if err != nil {
+		return nil, fmt.Errorf("échec de la signature du jeton APNs: %w", err)
+	}
+
+	report := &DeliveryReport{}
+	var sendErr error
+	for _, deviceToken := range notification.Recipients {
+		invalid, err := n.sendOne(ctx, bearer, deviceToken, notification.CollapseKey, body)
+		switch {
+		case invalid:
+			report.Invalid = append(report.Invalid, deviceToken)
+		case err != nil:
+			sendErr = err
+			log.Printf("Échec de l'envoi de la notification à un appareil iOS: %v", err)
+		default:
+			report.Sent++
+		}
+	}
+	if report.Sent == 0 && sendErr != nil {
+		return report, sendErr
+	}
+	return report, nil
+}
+
+// sendOne envoie la notification à un appareil et indique si APNs ne reconnaît plus son token
+func (n *APNsNotifier) sendOne(ctx context.Context, bearer, deviceToken, collapseKey string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.host+"/3/device/"+deviceToken, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("authorization", "bearer "+bearer)
+	req.Header.Set("apns-topic", n.topic)
+	req.Header.Set("apns-push-type", "alert")
+	if collapseKey != "" {
+		req.Header.Set("apns-collapse-id", collapseKey)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return false, nil
+	}
+	var failure struct {
+		Reason string `json:"reason"`
+	}
+	json.NewDecoder(resp.Body).Decode(&failure)
+	if resp.StatusCode == http.StatusGone || failure.Reason == "BadDeviceToken" || failure.Reason == "Unregistered" {
+		return true, nil
+	}
+	return false, fmt.Errorf("APNs a refusé la notification (%d %s)", resp.StatusCode, failure.Reason)
+}
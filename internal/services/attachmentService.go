@@ -0,0 +1,184 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"mime/multipart"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ady243/teamup/internal/models"
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
+)
+
+var ErrAttachmentNotFound = errors.New("attachment not found")
+
+// Sous-dossier du dossier d'upload où sont rangées les pièces jointes des chats
+const attachmentDir = "attachments"
+
+// AttachmentService gère les pièces jointes des chats : envoi, contrôle d'accès et suppression
+// des fichiers qui ne sont plus référencés par aucun message
+type AttachmentService struct {
+	DB            *gorm.DB
+	ImageService  *ImageService
+	ChatService   *ChatService
+	FriendService *FriendService
+	GroupService  *GroupService
+}
+
+func NewAttachmentService(db *gorm.DB, imageService *ImageService, chatService *ChatService, friendService *FriendService, groupService *GroupService) *AttachmentService {
+	return &AttachmentService{
+		DB:            db,
+		ImageService:  imageService,
+		ChatService:   chatService,
+		FriendService: friendService,
+		GroupService:  groupService,
+	}
+}
+
+// isMember vérifie que l'utilisateur peut écrire dans la conversation
+func (s *AttachmentService) isMember(userID, kind, conversationID string) (bool, error) {
+	switch kind {
+	case models.MessageKindMatch:
+		return s.ChatService.IsChatMember(conversationID, userID), nil
+	case models.MessageKindFriend:
+		return s.FriendService.AreFriends(userID, conversationID)
+	case models.MessageKindGroup:
+		return s.GroupService.IsMember(conversationID, userID), nil
+	}
+	return false, fmt.Errorf("%w: unknown conversation kind %q", ErrInvalidChatMessage, kind)
+}
+
+// Upload enregistre une pièce jointe destinée à une conversation dont l'utilisateur est membre.
+// Pour un chat entre amis, conversationID est l'identifiant du destinataire ; pour un groupe, celui du groupe.
+func (s *AttachmentService) Upload(userID, kind, conversationID string, file *multipart.FileHeader) (*models.Attachment, error) {
+	member, err := s.isMember(userID, kind, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if !member {
+		return nil, ErrMessageForbidden
+	}
+
+	stored, err := s.ImageService.Store(file, attachmentDir, ChatAttachmentPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	t := time.Now()
+	entropy := ulid.Monotonic(rand.New(rand.NewSource(t.UnixNano())), 0)
+	attachment := models.Attachment{
+		ID:               ulid.MustNew(ulid.Timestamp(t), entropy).String(),
+		OwnerID:          userID,
+		ConversationKind: kind,
+		ConversationID:   conversationID,
+		Kind:             models.AttachmentAudio,
+		ContentType:      stored.ContentType,
+		FileName:         filepath.Base(file.Filename),
+		Size:             stored.Size,
+		Width:            stored.Width,
+		Height:           stored.Height,
+		HasThumbnail:     stored.ThumbnailPath != "",
+		StoragePath:      stored.Path,
+		ThumbnailPath:    stored.ThumbnailPath,
+	}
+	if strings.HasPrefix(stored.ContentType, "image/") {
+		attachment.Kind = models.AttachmentImage
+	}
+	if err := s.DB.Create(&attachment).Error; err != nil {
+		s.ImageService.Remove(stored.Path, stored.ThumbnailPath)
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+// Get renvoie une pièce jointe si l'utilisateur est membre de sa conversation
+func (s *AttachmentService) Get(attachmentID, userID string) (*models.Attachment, error) {
+	var attachment models.Attachment
+	if err := s.DB.Where("id = ?", attachmentID).First(&attachment).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAttachmentNotFound
+		}
+		return nil, err
+	}
+
+	allowed := attachment.OwnerID == userID
+	switch attachment.ConversationKind {
+	case models.MessageKindMatch:
+		allowed = s.ChatService.IsChatMember(attachment.ConversationID, userID)
+	case models.MessageKindFriend:
+		allowed = allowed || attachment.ConversationID == userID
+	case models.MessageKindGroup:
+		allowed = s.GroupService.IsMember(attachment.ConversationID, userID)
+	}
+	if !allowed {
+		return nil, ErrMessageForbidden
+	}
+	return &attachment, nil
+}
+
+// PurgeOrphans supprime les pièces jointes envoyées depuis plus de olderThan qui ne sont référencées par
+// aucun message : envois abandonnés, messages supprimés ou purgés par la politique de rétention
+func (s *AttachmentService) PurgeOrphans(olderThan time.Duration) (int64, error) {
+	var attachments []models.Attachment
+	if err := s.DB.Where("created_at < ?", time.Now().Add(-olderThan)).
+		Where("NOT EXISTS (SELECT 1 FROM chat_messages WHERE chat_messages.attachment_id = attachments.id)").
+		Where("NOT EXISTS (SELECT 1 FROM messages WHERE messages.attachment_id = attachments.id)").
+		Where("NOT EXISTS (SELECT 1 FROM group_messages WHERE group_messages.attachment_id = attachments.id)").
+		Find(&attachments).Error; err != nil {
+		return 0, err
+	}
+
+	var purged int64
+	for _, attachment := range attachments {
+		if err := s.DB.Delete(&attachment).Error; err != nil {
+			return purged, err
+		}
+		s.ImageService.Remove(attachment.StoragePath, attachment.ThumbnailPath)
+		purged++
+	}
+	return purged, nil
+}
+
+// findAttachment vérifie qu'une pièce jointe a été envoyée par l'utilisateur pour cette conversation
+// et n'est pas déjà utilisée par un autre message
+func findAttachment(db *gorm.DB, attachmentID, userID, kind, conversationID string) (*models.Attachment, error) {
+	var attachment models.Attachment
+	if err := db.Where("id = ? AND owner_id = ? AND conversation_kind = ? AND conversation_id = ?", attachmentID, userID, kind, conversationID).
+		First(&attachment).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: attachment not found in this conversation", ErrInvalidChatMessage)
+		}
+		return nil, err
+	}
+
+	var used int64
+	table := "chat_messages"
+	switch kind {
+	case models.MessageKindFriend:
+		table = "messages"
+	case models.MessageKindGroup:
+		table = "group_messages"
+	}
+	if err := db.Table(table).Where("attachment_id = ?", attachmentID).Count(&used).Error; err != nil {
+		return nil, err
+	}
+	if used > 0 {
+		return nil, fmt.Errorf("%w: attachment already sent", ErrInvalidChatMessage)
+	}
+	return &attachment, nil
+}
+
+// notificationText renvoie le texte d'une notification de message, qui peut ne contenir qu'une pièce jointe
+func notificationText(content string, attachment *models.Attachment) string {
+	if content != "" || attachment == nil {
+		return content
+	}
+	if attachment.Kind == models.AttachmentImage {
+		return "Photo"
+	}
+	return "Message vocal"
+}
@@ -1,7 +1,10 @@
 package services
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"math/rand"
 	"os"
 	"time"
@@ -21,10 +24,18 @@ type AuthService struct {
 	GoogleOauthConfig *oauth2.Config
 	ImageService      *ImageService
 	EmailService      *EmailService
+	EmailNotifier     Notifier // Fournisseur par lequel partent les emails de confirmation
+	Outbox            *OutboxService
+}
+
+// confirmationEmail est le contenu d'un email de confirmation de l'outbox
+type confirmationEmail struct {
+	Email string `json:"email"`
+	Token string `json:"token"`
 }
 
 // NewAuthService crée une nouvelle instance de AuthService
-func NewAuthService(db *gorm.DB, imageService *ImageService, emailService *EmailService) *AuthService {
+func NewAuthService(db *gorm.DB, imageService *ImageService, emailService *EmailService, emailNotifier Notifier, outbox *OutboxService) *AuthService {
 	googleOauthConfig := &oauth2.Config{
 		ClientID:    os.Getenv("GOOGLE_CLIENT_ID"),
 		RedirectURL: os.Getenv("GOOGLE_REDIRECT_URI"),
@@ -32,12 +43,16 @@ func NewAuthService(db *gorm.DB, imageService *ImageService, emailService *Email
 		Endpoint:    google.Endpoint,
 	}
 
-	return &AuthService{
+	service := &AuthService{
 		DB:                db,
 		GoogleOauthConfig: googleOauthConfig,
 		ImageService:      imageService,
 		EmailService:      emailService,
+		EmailNotifier:     emailNotifier,
+		Outbox:            outbox,
 	}
+	outbox.RegisterHandler(models.OutboxConfirmationEmail, service.deliverConfirmationEmail)
+	return service
 }
 
 // GetUserByEmail recherche un utilisateur par email
@@ -72,19 +87,41 @@ func (s *AuthService) RegisterUser(userInfo models.Users) (models.Users, error)
 		ConfirmationToken: confirmationToken,
 	}
 
-	// Sauvegarder l'utilisateur dans la base de données
-	if err := s.DB.Create(&user).Error; err != nil {
-		return models.Users{}, err
-	}
-
-	// Envoyer un email de confirmation avec le jeton
-	if err := s.EmailService.SendConfirmationEmail(user.Email, user.ConfirmationToken); err != nil {
+	// Sauvegarder l'utilisateur et programmer l'email de confirmation dans la même transaction :
+	// l'email est envoyé en arrière-plan, et retenté si le serveur SMTP est indisponible
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		return s.Outbox.Enqueue(tx, models.OutboxConfirmationEmail, "confirmation_email:"+user.ID,
+			confirmationEmail{Email: user.Email, Token: user.ConfirmationToken})
+	})
+	if err != nil {
 		return models.Users{}, err
 	}
 
 	return user, nil
 }
 
+// deliverConfirmationEmail envoie un email de confirmation programmé dans l'outbox
+func (s *AuthService) deliverConfirmationEmail(ctx context.Context, payload json.RawMessage) error {
+	var email confirmationEmail
+	if err := json.Unmarshal(payload, &email); err != nil {
+		return fmt.Errorf("%w: %v", ErrPermanentDelivery, err)
+	}
+	subject, body, err := s.EmailService.ConfirmationEmail(email.Email, email.Token)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPermanentDelivery, err)
+	}
+	_, err = s.EmailNotifier.Send(ctx, OutgoingNotification{
+		Recipients: []string{email.Email},
+		Title:      subject,
+		Body:       "Confirmez votre compte : https://api-teamup.onrender.com/api/confirm_email?token=" + email.Token,
+		HTML:       body,
+	})
+	return err
+}
+
 // Login authentifie un utilisateur et retourne un token JWT et un refreshToken
 func (s *AuthService) Login(email, password string) (string, string, error) {
 	var user models.Users
@@ -126,8 +163,10 @@ func (s *AuthService) GetUserByID(id string) (models.Users, error) {
 	return user, nil
 }
 
-// UpdateUser met à jour les informations d'un utilisateur
-func (s *AuthService) UpdateUser(id, username, email, password, profilePhoto, favoriteSport, location, bio string, birthDate *time.Time, role models.Role, skillLevel string, pac, sho, pas, dri, def, phy, matchesPlayed, matchesWon, goalsScored, behaviorScore int) (models.Users, error) {
+// UpdateUser met à jour les informations d'un utilisateur. Les attributs (pac, sho...), le score de
+// comportement et les statistiques ne sont pas modifiables : ils sont calculés à partir des notes des
+// pairs et des matchs joués.
+func (s *AuthService) UpdateUser(id, username, email, password, profilePhoto, favoriteSport, location, club, bio string, birthDate *time.Time, role models.Role, skillLevel string) (models.Users, error) {
 	var user models.Users
 	if err := s.DB.Where("id = ?", id).First(&user).Error; err != nil {
 		return models.Users{}, err
@@ -155,6 +194,9 @@ func (s *AuthService) UpdateUser(id, username, email, password, profilePhoto, fa
 	if location != "" {
 		user.Location = location
 	}
+	if club != "" {
+		user.Club = club
+	}
 	if bio != "" {
 		user.Bio = bio
 	}
@@ -164,36 +206,6 @@ func (s *AuthService) UpdateUser(id, username, email, password, profilePhoto, fa
 	if skillLevel != "" {
 		user.SkillLevel = skillLevel
 	}
-	if pac != 0 {
-		user.Pac = pac
-	}
-	if sho != 0 {
-		user.Sho = sho
-	}
-	if pas != 0 {
-		user.Pas = pas
-	}
-	if dri != 0 {
-		user.Dri = dri
-	}
-	if def != 0 {
-		user.Def = def
-	}
-	if phy != 0 {
-		user.Phy = phy
-	}
-	if matchesPlayed != 0 {
-		user.MatchesPlayed = matchesPlayed
-	}
-	if matchesWon != 0 {
-		user.MatchesWon = matchesWon
-	}
-	if goalsScored != 0 {
-		user.GoalsScored = goalsScored
-	}
-	if behaviorScore != 0 {
-		user.BehaviorScore = behaviorScore
-	}
 
 	if err := s.DB.Save(&user).Error; err != nil {
 		return models.Users{}, err
@@ -204,7 +216,7 @@ func (s *AuthService) UpdateUser(id, username, email, password, profilePhoto, fa
 
 func (s *AuthService) GetPublicUserInfo(id string) (map[string]interface{}, error) {
 	var user models.Users
-	if err := s.DB.Where("id = ?", id).First(&user).Error; err != nil {
+	if err := s.DB.Preload("Badges").Where("id = ?", id).First(&user).Error; err != nil {
 		return nil, err
 	}
 
@@ -217,6 +229,7 @@ func (s *AuthService) GetPublicUserInfo(id string) (map[string]interface{}, erro
 		"profilePhoto":  user.ProfilePhoto,
 		"favoriteSport": user.FavoriteSport,
 		"location":      user.Location,
+		"club":          user.Club,
 		"bio":           user.Bio,
 		"skillLevel":    user.SkillLevel,
 		"sho":           user.Sho,
@@ -224,6 +237,7 @@ func (s *AuthService) GetPublicUserInfo(id string) (map[string]interface{}, erro
 		"dri":           user.Dri,
 		"def":           user.Def,
 		"phy":           user.Phy,
+		"badges":        user.Badges,
 	}
 
 	return publicInfo, nil
@@ -305,7 +319,9 @@ func (s *AuthService) GetAllUsers() ([]models.Users, error) {
 // public user info by id
 func (s *AuthService) GetPublicUserInfoByID(id string) (models.Users, error) {
 	var user models.Users
-	if err := s.DB.Where("id = ?", id).First(&user).Error; err != nil {
+	if err := s.DB.Preload("Badges", func(db *gorm.DB) *gorm.DB {
+		return db.Order("awarded_at desc")
+	}).Where("id = ?", id).First(&user).Error; err != nil {
 		return models.Users{}, err
 	}
 	return user, nil
@@ -329,24 +345,6 @@ func (s *AuthService) AssignRefereeRole(organizerID, playerID string) error {
 	return nil
 }
 
-func (s *AuthService) UpdateUserStatistics(userID string, matchesPlayed, matchesWon, goalsScored, behaviorScore int) error {
-	var user models.Users
-	if err := s.DB.Where("id = ?", userID).First(&user).Error; err != nil {
-		return err
-	}
-
-	user.MatchesPlayed = matchesPlayed
-	user.MatchesWon = matchesWon
-	user.GoalsScored = goalsScored
-	user.BehaviorScore = behaviorScore
-
-	if err := s.DB.Save(&user).Error; err != nil {
-		return err
-	}
-
-	return nil
-}
-
 func (s *AuthService) DeleteUserAndRelatedData(userID string) error {
 	if err := s.DB.Where("sender_id = ?", userID).Delete(&models.FriendRequest{}).Error; err != nil {
 		return err
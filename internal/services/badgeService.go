@@ -0,0 +1,270 @@
+package services
+
+import (
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/ady243/teamup/internal/models"
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Seuils des badges
+const (
+	hatTrickGoals            = 3
+	organisedMatchesForBadge = 10
+	winStreakLength          = 5
+	attendanceWeeks          = 4
+)
+
+// badgeHistory regroupe les données d'un joueur nécessaires à l'évaluation des règles
+type badgeHistory struct {
+	UserID    string
+	Matches   []playedMatch // Matchs terminés joués, du plus ancien au plus récent
+	Goals     map[string]int
+	Organised int64
+}
+
+// playedMatch est la participation d'un joueur à un match terminé
+type playedMatch struct {
+	MatchID    string
+	MatchDate  time.Time
+	TeamNumber *int
+	ScoreTeam1 int
+	ScoreTeam2 int
+}
+
+func (m playedMatch) won() bool {
+	if m.TeamNumber == nil {
+		return false
+	}
+	if *m.TeamNumber == 1 {
+		return m.ScoreTeam1 > m.ScoreTeam2
+	}
+	return m.ScoreTeam2 > m.ScoreTeam1
+}
+
+// badgeRule renvoie vrai si le badge est mérité, avec le match qui l'a débloqué s'il y en a un
+type badgeRule func(history *badgeHistory) (bool, *string)
+
+// badgeRules associe chaque badge du catalogue à sa règle
+var badgeRules = map[models.BadgeCode]badgeRule{
+	models.BadgeFirstMatch: func(history *badgeHistory) (bool, *string) {
+		if len(history.Matches) == 0 {
+			return false, nil
+		}
+		return true, &history.Matches[0].MatchID
+	},
+	models.BadgeHatTrick: func(history *badgeHistory) (bool, *string) {
+		for _, match := range history.Matches {
+			if history.Goals[match.MatchID] >= hatTrickGoals {
+				return true, &match.MatchID
+			}
+		}
+		return false, nil
+	},
+	models.BadgeTenOrganised: func(history *badgeHistory) (bool, *string) {
+		return history.Organised >= organisedMatchesForBadge, nil
+	},
+	models.BadgeWinStreak: func(history *badgeHistory) (bool, *string) {
+		streak := 0
+		for _, match := range history.Matches {
+			if !match.won() {
+				streak = 0
+				continue
+			}
+			streak++
+			if streak >= winStreakLength {
+				return true, &match.MatchID
+			}
+		}
+		return false, nil
+	},
+	models.BadgePerfectAttendance: func(history *badgeHistory) (bool, *string) {
+		streak := 0
+		var previous time.Time
+		for _, match := range history.Matches {
+			week := startOfWeek(match.MatchDate)
+			switch {
+			case streak > 0 && week.Equal(previous):
+				continue
+			case streak > 0 && week.Equal(previous.AddDate(0, 0, 7)):
+				streak++
+			default:
+				streak = 1
+			}
+			previous = week
+			if streak >= attendanceWeeks {
+				return true, &match.MatchID
+			}
+		}
+		return false, nil
+	},
+}
+
+// startOfWeek renvoie le lundi de la semaine d'une date
+func startOfWeek(date time.Time) time.Time {
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	offset := (int(day.Weekday()) + 6) % 7
+	return day.AddDate(0, 0, -offset)
+}
+
+// BadgeService évalue les règles des badges et attribue ceux qui ont été débloqués
+type BadgeService struct {
+	DB                  *gorm.DB
+	NotificationService *NotificationService
+}
+
+func NewBadgeService(db *gorm.DB, notificationService *NotificationService) *BadgeService {
+	return &BadgeService{
+		DB:                  db,
+		NotificationService: notificationService,
+	}
+}
+
+// GetUserBadges renvoie les badges obtenus par un utilisateur, du plus récent au plus ancien
+func (s *BadgeService) GetUserBadges(userID string) ([]models.UserBadge, error) {
+	var badges []models.UserBadge
+	if err := s.DB.Where("user_id = ?", userID).Order("awarded_at desc").Find(&badges).Error; err != nil {
+		return nil, err
+	}
+	return badges, nil
+}
+
+// EvaluateMatch évalue les badges des joueurs et de l'organisateur d'un match
+func (s *BadgeService) EvaluateMatch(matchID string) error {
+	var match models.Matches
+	if err := s.DB.Where("id = ?", matchID).First(&match).Error; err != nil {
+		return err
+	}
+
+	var userIDs []string
+	if err := s.DB.Model(&models.MatchPlayers{}).Where("match_id = ?", matchID).Pluck("player_id", &userIDs).Error; err != nil {
+		return err
+	}
+	userIDs = append(userIDs, match.OrganizerID)
+
+	for _, userID := range userIDs {
+		if _, err := s.EvaluateUser(userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EvaluateRecentlyCompleted évalue les badges pour les matchs terminés ou modifiés depuis une date
+func (s *BadgeService) EvaluateRecentlyCompleted(since time.Time) error {
+	var matchIDs []string
+	if err := s.DB.Model(&models.Matches{}).
+		Where("status = ? AND updated_at >= ? AND deleted_at IS NULL", models.Completed, since).
+		Pluck("id", &matchIDs).Error; err != nil {
+		return err
+	}
+	for _, matchID := range matchIDs {
+		if err := s.EvaluateMatch(matchID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EvaluateUser évalue toutes les règles pour un utilisateur et renvoie les badges nouvellement obtenus.
+// Un badge déjà obtenu n'est jamais attribué une seconde fois.
+func (s *BadgeService) EvaluateUser(userID string) ([]models.UserBadge, error) {
+	owned, err := s.GetUserBadges(userID)
+	if err != nil {
+		return nil, err
+	}
+	ownedCodes := make(map[models.BadgeCode]bool)
+	for _, badge := range owned {
+		ownedCodes[badge.Code] = true
+	}
+	if len(ownedCodes) == len(models.BadgeCatalog) {
+		return nil, nil
+	}
+
+	history, err := s.loadHistory(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var awarded []models.UserBadge
+	for _, definition := range models.BadgeCatalog {
+		rule, ok := badgeRules[definition.Code]
+		if !ok || ownedCodes[definition.Code] {
+			continue
+		}
+		earned, matchID := rule(history)
+		if !earned {
+			continue
+		}
+
+		badge := models.UserBadge{ID: newBadgeID(), UserID: userID, Code: definition.Code, MatchID: matchID}
+		result := s.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&badge)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		// Aucune ligne insérée : le badge a été attribué entre-temps par un autre traitement
+		if result.RowsAffected == 0 {
+			continue
+		}
+		awarded = append(awarded, badge)
+		s.notifyUnlock(userID, definition)
+	}
+	return awarded, nil
+}
+
+// loadHistory charge les matchs terminés, les buts et le nombre de matchs organisés d'un joueur
+func (s *BadgeService) loadHistory(userID string) (*badgeHistory, error) {
+	history := &badgeHistory{UserID: userID, Goals: make(map[string]int)}
+
+	if err := s.DB.Table("match_players").
+		Select("matches.id AS match_id, matches.match_date, match_players.team_number, matches.score_team1, matches.score_team2").
+		Joins("JOIN matches ON matches.id = match_players.match_id").
+		Where("match_players.player_id = ? AND matches.status = ? AND matches.deleted_at IS NULL", userID, models.Completed).
+		Order("matches.match_date asc, matches.match_time asc").
+		Scan(&history.Matches).Error; err != nil {
+		return nil, err
+	}
+
+	var goals []struct {
+		MatchID string
+		Goals   int
+	}
+	if err := s.DB.Model(&models.Analyst{}).
+		Select("match_id, COUNT(*) AS goals").
+		Where("player_id = ? AND event_type = ? AND deleted_at IS NULL", userID, models.EventGoal).
+		Group("match_id").
+		Scan(&goals).Error; err != nil {
+		return nil, err
+	}
+	for _, goal := range goals {
+		history.Goals[goal.MatchID] = goal.Goals
+	}
+
+	if err := s.DB.Model(&models.Matches{}).
+		Where("organizer_id = ? AND status = ? AND deleted_at IS NULL", userID, models.Completed).
+		Count(&history.Organised).Error; err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// notifyUnlock prévient l'utilisateur qui vient d'obtenir un badge
+func (s *BadgeService) notifyUnlock(userID string, definition models.BadgeDefinition) {
+	if s.NotificationService == nil {
+		return
+	}
+	data := models.NotificationData{"screen": "badges", "badge": string(definition.Code)}
+	if err := s.NotificationService.Notify(userID, models.NotificationBadgeUnlocked, "Nouveau badge : "+definition.Label, definition.Description, data); err != nil {
+		log.Printf("Failed to send badge notification to user %s: %v", userID, err)
+	}
+}
+
+func newBadgeID() string {
+	t := time.Now()
+	entropy := ulid.Monotonic(rand.New(rand.NewSource(t.UnixNano())), 0)
+	return ulid.MustNew(ulid.Timestamp(t), entropy).String()
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ady243/teamup/internal/models"
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Erreurs communes aux chats de match et entre amis
+var (
+	ErrMessageNotFound  = errors.New("message not found")
+	ErrMessageForbidden = errors.New("you are not allowed to modify this message")
+	ErrMessageDeleted   = errors.New("message has been deleted")
+	ErrInvalidReaction  = errors.New("invalid reaction")
+)
+
+// ChatEvent est un événement temps réel envoyé aux clients lorsqu'un message est modifié,
+// supprimé, reçoit une réaction ou est lu, et lorsqu'un membre est en train d'écrire
+type ChatEvent struct {
+	Type      string           `json:"type"` // message_edited, message_deleted, reactions_updated, read ou typing
+	MessageID string           `json:"message_id,omitempty"`
+	Message   interface{}      `json:"message,omitempty"`
+	Reactions models.Reactions `json:"reactions,omitempty"`
+	UserID    string           `json:"user_id,omitempty"`
+	Emoji     string           `json:"emoji,omitempty"`
+	Typing    *bool            `json:"typing,omitempty"`
+}
+
+// validateEmoji vérifie qu'une réaction est un emoji court, sans espace
+func validateEmoji(emoji string) error {
+	count := utf8.RuneCountInString(emoji)
+	if count == 0 || count > 8 || len(emoji) > 32 || strings.ContainsAny(emoji, " \t\n") {
+		return ErrInvalidReaction
+	}
+	for _, r := range emoji {
+		if r < 0x80 {
+			return ErrInvalidReaction
+		}
+	}
+	return nil
+}
+
+// addReaction ajoute la réaction d'un utilisateur ; ajouter deux fois la même réaction n'a pas d'effet
+func addReaction(db *gorm.DB, kind, messageID, userID, emoji string) error {
+	if err := validateEmoji(emoji); err != nil {
+		return err
+	}
+	t := time.Now()
+	entropy := ulid.Monotonic(rand.New(rand.NewSource(t.UnixNano())), 0)
+	reaction := models.MessageReaction{
+		ID:          ulid.MustNew(ulid.Timestamp(t), entropy).String(),
+		MessageKind: kind,
+		MessageID:   messageID,
+		UserID:      userID,
+		Emoji:       emoji,
+	}
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&reaction).Error
+}
+
+// removeReaction retire la réaction d'un utilisateur
+func removeReaction(db *gorm.DB, kind, messageID, userID, emoji string) error {
+	return db.Where("message_kind = ? AND message_id = ? AND user_id = ? AND emoji = ?", kind, messageID, userID, emoji).
+		Delete(&models.MessageReaction{}).Error
+}
+
+// loadReactions renvoie les réactions de plusieurs messages, indexées par identifiant de message
+func loadReactions(db *gorm.DB, kind string, messageIDs []string) (map[string]models.Reactions, error) {
+	result := make(map[string]models.Reactions)
+	if len(messageIDs) == 0 {
+		return result, nil
+	}
+	var reactions []models.MessageReaction
+	if err := db.Where("message_kind = ? AND message_id IN ?", kind, messageIDs).Order("created_at asc").Find(&reactions).Error; err != nil {
+		return nil, err
+	}
+	for _, reaction := range reactions {
+		if result[reaction.MessageID] == nil {
+			result[reaction.MessageID] = models.Reactions{}
+		}
+		result[reaction.MessageID][reaction.Emoji] = append(result[reaction.MessageID][reaction.Emoji], reaction.UserID)
+	}
+	return result, nil
+}
+
+// recordEdit conserve le contenu d'un message avant sa modification
+func recordEdit(tx *gorm.DB, kind, messageID, previous string) error {
+	t := time.Now()
+	entropy := ulid.Monotonic(rand.New(rand.NewSource(t.UnixNano())), 0)
+	return tx.Create(&models.MessageEdit{
+		ID:              ulid.MustNew(ulid.Timestamp(t), entropy).String(),
+		MessageKind:     kind,
+		MessageID:       messageID,
+		PreviousContent: previous,
+	}).Error
+}
+
+// loadEditHistory renvoie les versions précédentes d'un message, de la plus ancienne à la plus récente
+func loadEditHistory(db *gorm.DB, kind, messageID string) ([]models.MessageEdit, error) {
+	var edits []models.MessageEdit
+	if err := db.Where("message_kind = ? AND message_id = ?", kind, messageID).Order("edited_at asc").Find(&edits).Error; err != nil {
+		return nil, err
+	}
+	return edits, nil
+}
+
+// advanceReadCursor enregistre le dernier message lu d'une conversation.
+// Le curseur n'avance que vers un message plus récent : renvoie faux si le message était déjà lu.
+func advanceReadCursor(db *gorm.DB, userID, kind, conversationID, messageID string, messageAt time.Time) (bool, error) {
+	t := time.Now()
+	entropy := ulid.Monotonic(rand.New(rand.NewSource(t.UnixNano())), 0)
+	cursor := models.ReadCursor{
+		ID:                ulid.MustNew(ulid.Timestamp(t), entropy).String(),
+		UserID:            userID,
+		ConversationKind:  kind,
+		ConversationID:    conversationID,
+		LastReadMessageID: messageID,
+		LastReadMessageAt: messageAt,
+	}
+	result := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "conversation_kind"}, {Name: "conversation_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"last_read_message_id", "last_read_message_at", "updated_at"}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Expr{SQL: "read_cursors.last_read_message_at < excluded.last_read_message_at"},
+		}},
+	}).Create(&cursor)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// loadReadCursors renvoie les curseurs de lecture d'une conversation
+func loadReadCursors(db *gorm.DB, kind, conversationID string) ([]models.ReadCursor, error) {
+	var cursors []models.ReadCursor
+	if err := db.Where("conversation_kind = ? AND conversation_id = ?", kind, conversationID).Find(&cursors).Error; err != nil {
+		return nil, err
+	}
+	return cursors, nil
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var ErrRateLimited = errors.New("too many messages, please slow down")
+
+// Limite par défaut des messages d'un utilisateur dans une conversation, modifiable par CHAT_RATE_LIMIT
+const (
+	defaultChatRateLimit = 10
+	chatRateWindow       = 10 * time.Second
+)
+
+// ChatRateLimiter limite le nombre de messages qu'un utilisateur envoie dans une conversation
+// par fenêtre de temps fixe, avec un compteur Redis par fenêtre
+type ChatRateLimiter struct {
+	RedisClient *redis.Client
+	Limit       int64
+	Window      time.Duration
+}
+
+func NewChatRateLimiter(redisClient *redis.Client) *ChatRateLimiter {
+	limit := int64(defaultChatRateLimit)
+	if value, err := strconv.ParseInt(os.Getenv("CHAT_RATE_LIMIT"), 10, 64); err == nil && value > 0 {
+		limit = value
+	}
+	return &ChatRateLimiter{
+		RedisClient: redisClient,
+		Limit:       limit,
+		Window:      chatRateWindow,
+	}
+}
+
+// Allow comptabilise un message et renvoie ErrRateLimited si la limite de la fenêtre est dépassée.
+// Si Redis est indisponible, le message est accepté.
+func (l *ChatRateLimiter) Allow(kind, conversationID, userID string) error {
+	if l == nil || l.RedisClient == nil {
+		return nil
+	}
+	ctx := context.Background()
+	window := time.Now().UnixNano() / int64(l.Window)
+	key := "chat:rate:" + kind + ":" + conversationID + ":" + userID + ":" + strconv.FormatInt(window, 10)
+
+	pipe := l.RedisClient.TxPipeline()
+	count := pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, 2*l.Window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Chat rate limiter unavailable: %v", err)
+		return nil
+	}
+	if count.Val() > l.Limit {
+		return ErrRateLimited
+	}
+	return nil
+}
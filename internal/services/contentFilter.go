@@ -0,0 +1,86 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"unicode"
+)
+
+var ErrContentRejected = errors.New("message contains forbidden words")
+
+// ContentFilter contrôle le texte des messages de chat avant leur enregistrement.
+// Filter renvoie le texte à enregistrer, éventuellement masqué, ou ErrContentRejected.
+type ContentFilter interface {
+	Filter(text string) (string, error)
+}
+
+// defaultBannedWords est la liste utilisée lorsque CHAT_BANNED_WORDS n'est pas défini
+var defaultBannedWords = []string{
+	"connard", "connasse", "salope", "encule", "enculé", "pute", "batard", "bâtard", "fdp", "ntm",
+	"fuck", "fucking", "motherfucker", "bitch", "asshole", "cunt", "nigger", "faggot",
+}
+
+// WordListFilter masque (ou rejette si Reject est vrai) les messages contenant un mot de la liste.
+// La comparaison ignore la casse et se fait mot par mot.
+type WordListFilter struct {
+	words  map[string]bool
+	Reject bool
+}
+
+func NewWordListFilter(words []string, reject bool) *WordListFilter {
+	filter := &WordListFilter{words: make(map[string]bool, len(words)), Reject: reject}
+	for _, word := range words {
+		if word = strings.ToLower(strings.TrimSpace(word)); word != "" {
+			filter.words[word] = true
+		}
+	}
+	return filter
+}
+
+// DefaultContentFilter construit le filtre des chats à partir de l'environnement :
+// CHAT_BANNED_WORDS (mots séparés par des virgules) remplace la liste par défaut et
+// CHAT_FILTER_MODE=reject refuse les messages au lieu de masquer les mots interdits.
+func DefaultContentFilter() ContentFilter {
+	words := defaultBannedWords
+	if value := os.Getenv("CHAT_BANNED_WORDS"); value != "" {
+		words = strings.Split(value, ",")
+	}
+	return NewWordListFilter(words, os.Getenv("CHAT_FILTER_MODE") == "reject")
+}
+
+func (f *WordListFilter) Filter(text string) (string, error) {
+	runes := []rune(text)
+	masked := false
+	start := -1
+	for i := 0; i <= len(runes); i++ {
+		if i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 && f.words[strings.ToLower(string(runes[start:i]))] {
+			if f.Reject {
+				return "", ErrContentRejected
+			}
+			for j := start; j < i; j++ {
+				runes[j] = '*'
+			}
+			masked = true
+		}
+		start = -1
+	}
+	if !masked {
+		return text, nil
+	}
+	return string(runes), nil
+}
+
+// filterContent applique un filtre s'il est configuré
+func filterContent(filter ContentFilter, text string) (string, error) {
+	if filter == nil || text == "" {
+		return text, nil
+	}
+	return filter.Filter(text)
+}
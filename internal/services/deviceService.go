@@ -0,0 +1,142 @@
+package services
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/ady243/teamup/internal/models"
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// FCM invalide les tokens des appareils inactifs depuis 270 jours : ils sont retirés du registre
+const deviceStaleAfter = 270 * 24 * time.Hour
+
+var (
+	ErrDeviceNotFound = errors.New("device not found")
+	ErrInvalidDevice  = errors.New("invalid device")
+)
+
+// DeviceInput décrit l'appareil déclaré par l'application à son lancement
+type DeviceInput struct {
+	Platform   models.DevicePlatform `json:"platform"`
+	Token      string                `json:"token"`
+	AppVersion string                `json:"app_version"`
+	Locale     string                `json:"locale"`
+}
+
+// DeviceService gère le registre des appareils qui reçoivent les notifications push des utilisateurs
+type DeviceService struct {
+	DB *gorm.DB
+}
+
+func NewDeviceService(db *gorm.DB) *DeviceService {
+	return &DeviceService{DB: db}
+}
+
+// Register enregistre l'appareil de l'utilisateur, ou met à jour sa description et sa dernière activité
+// s'il est déjà connu. Un token déjà enregistré pour un autre utilisateur lui est réattribué.
+func (s *DeviceService) Register(userID string, input DeviceInput) (*models.Device, error) {
+	input.Token = strings.TrimSpace(input.Token)
+	switch input.Platform {
+	case models.PlatformAndroid, models.PlatformIOS, models.PlatformWeb:
+	default:
+		return nil, ErrInvalidDevice
+	}
+	if input.Token == "" || len(input.Token) > 512 || len(input.AppVersion) > 30 || len(input.Locale) > 20 {
+		return nil, ErrInvalidDevice
+	}
+
+	device := models.Device{
+		ID:         newDeviceID(),
+		UserID:     userID,
+		Platform:   input.Platform,
+		Token:      input.Token,
+		AppVersion: input.AppVersion,
+		Locale:     input.Locale,
+		LastSeenAt: time.Now(),
+	}
+	if err := s.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "token"}},
+		DoUpdates: clause.AssignmentColumns([]string{"user_id", "platform", "app_version", "locale", "last_seen_at"}),
+	}).Create(&device).Error; err != nil {
+		return nil, err
+	}
+	// En cas de conflit, l'appareil existant conserve son identifiant
+	if err := s.DB.Where("token = ?", input.Token).First(&device).Error; err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+// GetDevices renvoie les appareils de l'utilisateur, du plus récemment actif au moins récent
+func (s *DeviceService) GetDevices(userID string) ([]models.Device, error) {
+	devices := []models.Device{}
+	err := s.DB.Where("user_id = ?", userID).Order("last_seen_at desc").Find(&devices).Error
+	return devices, err
+}
+
+// Unregister retire un appareil de l'utilisateur, par exemple à sa déconnexion
+func (s *DeviceService) Unregister(userID, deviceID string) error {
+	result := s.DB.Where("id = ? AND user_id = ?", deviceID, userID).Delete(&models.Device{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrDeviceNotFound
+	}
+	return nil
+}
+
+// Tokens renvoie les tokens de tous les appareils des utilisateurs, par plateforme. Le token unique des
+// versions de l'application antérieures au registre (users.fcm_token) est un token FCM, classé avec Android.
+func (s *DeviceService) Tokens(userIDs []string) (map[models.DevicePlatform][]string, error) {
+	var devices []models.Device
+	if err := s.DB.Select("platform", "token").Where("user_id IN ?", userIDs).Find(&devices).Error; err != nil {
+		return nil, err
+	}
+	var legacy []string
+	if err := s.DB.Model(&models.Users{}).Where("id IN ? AND fcm_token <> ''", userIDs).Pluck("fcm_token", &legacy).Error; err != nil {
+		return nil, err
+	}
+
+	tokens := make(map[models.DevicePlatform][]string)
+	seen := make(map[string]bool, len(devices))
+	for _, device := range devices {
+		seen[device.Token] = true
+		tokens[device.Platform] = append(tokens[device.Platform], device.Token)
+	}
+	for _, token := range legacy {
+		if !seen[token] {
+			seen[token] = true
+			tokens[models.PlatformAndroid] = append(tokens[models.PlatformAndroid], token)
+		}
+	}
+	return tokens, nil
+}
+
+// RemoveTokens supprime les tokens que FCM ne reconnaît plus (application désinstallée, token renouvelé)
+func (s *DeviceService) RemoveTokens(tokens []string) error {
+	if len(tokens) == 0 {
+		return nil
+	}
+	if err := s.DB.Where("token IN ?", tokens).Delete(&models.Device{}).Error; err != nil {
+		return err
+	}
+	return s.DB.Model(&models.Users{}).Where("fcm_token IN ?", tokens).Update("fcm_token", "").Error
+}
+
+// PurgeStale supprime les appareils inactifs depuis plus longtemps que la durée de validité d'un token FCM
+func (s *DeviceService) PurgeStale() (int64, error) {
+	result := s.DB.Where("last_seen_at < ?", time.Now().Add(-deviceStaleAfter)).Delete(&models.Device{})
+	return result.RowsAffected, result.Error
+}
+
+func newDeviceID() string {
+	t := time.Now()
+	entropy := ulid.Monotonic(rand.New(rand.NewSource(t.UnixNano())), 0)
+	return ulid.MustNew(ulid.Timestamp(t), entropy).String()
+}
@@ -0,0 +1,38 @@
+package services
+
+import (
+	"context"
+	"log"
+)
+
+// EmailNotifier envoie les notifications par email, par le serveur SMTP de l'EmailService
+type EmailNotifier struct {
+	EmailService *EmailService
+}
+
+func NewEmailNotifier(emailService *EmailService) *EmailNotifier {
+	return &EmailNotifier{EmailService: emailService}
+}
+
+func (n *EmailNotifier) Send(ctx context.Context, notification OutgoingNotification) (*DeliveryReport, error) {
+	report := &DeliveryReport{}
+	var sendErr error
+	for _, address := range notification.Recipients {
+		var err error
+		if notification.HTML != "" {
+			err = n.EmailService.SendHTMLEmail(address, notification.Title, notification.HTML)
+		} else {
+			err = n.EmailService.SendNotificationEmail(address, notification.Title, notification.Body)
+		}
+		if err != nil {
+			sendErr = err
+			log.Printf("Échec de l'envoi de la notification par email: %v", err)
+			continue
+		}
+		report.Sent++
+	}
+	if report.Sent == 0 && sendErr != nil {
+		return report, sendErr
+	}
+	return report, nil
+}
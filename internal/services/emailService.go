@@ -118,12 +118,15 @@ type EmailData struct {
 // Retourne:
 // - error: une erreur si l'email n'a pas pu être envoyé, nil sinon
 func (e *EmailService) SendConfirmationEmail(toEmail, token string) error {
-	from := os.Getenv("EMAIL_USER")
-	password := os.Getenv("EMAIL_PASSWORD")
-	host := "smtp.gmail.com"
-	port := "587"
+	subject, body, err := e.ConfirmationEmail(toEmail, token)
+	if err != nil {
+		return err
+	}
+	return e.SendHTMLEmail(toEmail, subject, body)
+}
 
-	auth := smtp.PlainAuth("", from, password, host)
+// ConfirmationEmail renvoie le sujet et le contenu HTML de l'email de confirmation d'un compte
+func (e *EmailService) ConfirmationEmail(toEmail, token string) (string, string, error) {
 	subject := "Confirmez votre compte"
 
 	data := EmailData{
@@ -134,24 +137,46 @@ func (e *EmailService) SendConfirmationEmail(toEmail, token string) error {
 
 	tmpl, err := template.New("email").Parse(emailTemplate)
 	if err != nil {
-		return err
+		return "", "", err
 	}
 
 	var body bytes.Buffer
 	if err := tmpl.Execute(&body, data); err != nil {
-		return err
+		return "", "", err
 	}
+	return subject, body.String(), nil
+}
+
+// SendHTMLEmail envoie un email au format HTML
+func (e *EmailService) SendHTMLEmail(toEmail, subject, body string) error {
+	from := os.Getenv("EMAIL_USER")
+	password := os.Getenv("EMAIL_PASSWORD")
+	host := "smtp.gmail.com"
+	port := "587"
 
+	auth := smtp.PlainAuth("", from, password, host)
 	msg := []byte("To: " + toEmail + "\r\n" +
 		"Subject: " + subject + "\r\n" +
 		"MIME-version: 1.0;\r\n" +
 		"Content-Type: text/html; charset=\"UTF-8\";\r\n\r\n" +
-		body.String())
+		body)
 
-	err = smtp.SendMail(host+":"+port, auth, from, []string{toEmail}, msg)
-	if err != nil {
-		return err
-	}
+	return smtp.SendMail(host+":"+port, auth, from, []string{toEmail}, msg)
+}
+
+// SendNotificationEmail envoie une notification par email, en texte brut, à un utilisateur qui a choisi ce canal
+func (e *EmailService) SendNotificationEmail(toEmail, subject, body string) error {
+	from := os.Getenv("EMAIL_USER")
+	password := os.Getenv("EMAIL_PASSWORD")
+	host := "smtp.gmail.com"
+	port := "587"
+
+	auth := smtp.PlainAuth("", from, password, host)
+	msg := []byte("To: " + toEmail + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"MIME-version: 1.0;\r\n" +
+		"Content-Type: text/plain; charset=\"UTF-8\";\r\n\r\n" +
+		body + "\r\n")
 
-	return nil
+	return smtp.SendMail(host+":"+port, auth, from, []string{toEmail}, msg)
 }
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	firebase "firebase.google.com/go"
+	"firebase.google.com/go/messaging"
+)
+
+// Nombre maximal de tokens par envoi multicast FCM
+const fcmMulticastLimit = 500
+
+// FCMNotifier envoie les notifications push par Firebase Cloud Messaging, qui dessert Android, iOS et le web
+type FCMNotifier struct {
+	client *messaging.Client
+}
+
+func NewFCMNotifier(app *firebase.App) (*FCMNotifier, error) {
+	client, err := app.Messaging(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("échec de la récupération du client de notifications: %w", err)
+	}
+	return &FCMNotifier{client: client}, nil
+}
+
+// Send envoie la notification par lots de fcmMulticastLimit tokens. Les notifications d'une même
+// CollapseKey se remplacent sur l'appareil au lieu de s'empiler.
+func (n *FCMNotifier) Send(ctx context.Context, notification OutgoingNotification) (*DeliveryReport, error) {
+	var android *messaging.AndroidConfig
+	var apns *messaging.APNSConfig
+	if key := notification.CollapseKey; key != "" {
+		android = &messaging.AndroidConfig{
+			CollapseKey:  key,
+			Notification: &messaging.AndroidNotification{Tag: key},
+		}
+		apns = &messaging.APNSConfig{Headers: map[string]string{"apns-collapse-id": key}}
+	}
+
+	report := &DeliveryReport{}
+	var sendErr error
+	tokens := notification.Recipients
+	for start := 0; start < len(tokens); start += fcmMulticastLimit {
+		batch := tokens[start:min(start+fcmMulticastLimit, len(tokens))]
+		response, err := n.client.SendMulticast(ctx, &messaging.MulticastMessage{
+			Tokens: batch,
+			Notification: &messaging.Notification{
+				Title: notification.Title,
+				Body:  notification.Body,
+			},
+			Data:    notification.Data,
+			Android: android,
+			APNS:    apns,
+		})
+		if err != nil {
+			sendErr = fmt.Errorf("échec de l'envoi de la notification: %w", err)
+			log.Print(sendErr)
+			continue
+		}
+		for i, result := range response.Responses {
+			if result.Success {
+				continue
+			}
+			if messaging.IsRegistrationTokenNotRegistered(result.Error) {
+				report.Invalid = append(report.Invalid, batch[i])
+				continue
+			}
+			log.Printf("Échec de l'envoi de la notification à un appareil: %v", result.Error)
+		}
+		report.Sent += response.SuccessCount
+	}
+	if report.Sent == 0 && sendErr != nil {
+		return report, sendErr
+	}
+	return report, nil
+}
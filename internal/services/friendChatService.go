@@ -1,12 +1,15 @@
 package services
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ady243/teamup/internal/models"
+	"github.com/go-redis/redis/v8"
 	"gorm.io/gorm"
 )
 
@@ -14,66 +17,94 @@ type FriendChatService struct {
 	DB                  *gorm.DB
 	WebSocketService    *WebSocketService
 	NotificationService *NotificationService
+	ContentFilter       ContentFilter    // Appliqué au texte des messages envoyés et modifiés
+	RateLimiter         *ChatRateLimiter // Limite les messages de chaque utilisateur dans une conversation
 }
 
-func NewFriendChatService(db *gorm.DB, webSocketService *WebSocketService, notificationService *NotificationService) *FriendChatService {
+func NewFriendChatService(db *gorm.DB, webSocketService *WebSocketService, notificationService *NotificationService, redisClient *redis.Client) *FriendChatService {
 	return &FriendChatService{
 		DB:                  db,
 		WebSocketService:    webSocketService,
 		NotificationService: notificationService,
+		ContentFilter:       DefaultContentFilter(),
+		RateLimiter:         NewChatRateLimiter(redisClient),
 	}
 }
 
-func (s *FriendChatService) SendMessage(senderID, receiverID, content string) error {
+func (s *FriendChatService) SendMessage(senderID, receiverID, content string, replyToID *uint, attachmentID *string) error {
 	log.Printf("Sending message from %s to %s: %s", senderID, receiverID, content)
 
+	// Un message ne peut être vide que s'il transporte une pièce jointe envoyée pour cette conversation
+	var attachment *models.Attachment
+	if attachmentID != nil && *attachmentID != "" {
+		var err error
+		if attachment, err = findAttachment(s.DB, *attachmentID, senderID, models.MessageKindFriend, receiverID); err != nil {
+			return err
+		}
+	} else if strings.TrimSpace(content) == "" {
+		return fmt.Errorf("%w: message cannot be empty", ErrInvalidChatMessage)
+	}
+
+	content, err := filterContent(s.ContentFilter, content)
+	if err != nil {
+		return err
+	}
+	if err := s.RateLimiter.Allow(models.MessageKindFriend, receiverID, senderID); err != nil {
+		return err
+	}
+
+	// Une réponse doit citer un message de la même conversation
+	if replyToID != nil {
+		if !s.inConversation(*replyToID, senderID, receiverID) {
+			return fmt.Errorf("%w: the message you reply to is not in this conversation", ErrInvalidChatMessage)
+		}
+	}
+
 	// Create the message data
 	message := models.Message{
 		SenderID:   senderID,
 		ReceiverID: receiverID,
 		Content:    content,
+		ReplyToID:  replyToID,
 		CreatedAt:  time.Now(),
 	}
-
-	// Store the message in the database
-	if err := s.DB.Create(&message).Error; err != nil {
-		log.Printf("Failed to create message in database: %v", err)
-		return fmt.Errorf("failed to create message in database: %w", err)
-	}
-	log.Printf("Stored message in database for %s to %s", senderID, receiverID)
-
-	// Récupérer le token FCM du destinataire
-	var receiver models.Users
-	if err := s.DB.Where("id = ?", receiverID).First(&receiver).Error; err != nil {
-		log.Printf("Failed to fetch receiver: %v", err)
-		return fmt.Errorf("failed to fetch receiver: %w", err)
+	if attachment != nil {
+		message.AttachmentID = &attachment.ID
 	}
 
-	// Envoi de notification via Firebase
-	err := s.NotificationService.SendPushNotification(
-		receiver.FCMToken,
-		"Vous avez un nouveau message",
-		content,
-	)
+	// Le message et sa notification sont enregistrés ensemble ; le push est envoyé en arrière-plan par
+	// l'outbox et son échec n'annule pas l'envoi du message
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Attachment").Create(&message).Error; err != nil {
+			return fmt.Errorf("failed to create message in database: %w", err)
+		}
+		return s.NotificationService.WithTx(tx).NotifyConversation(
+			[]string{receiverID},
+			FriendConversation(senderID),
+			models.NotificationFriendMessage,
+			"Vous avez un nouveau message",
+			notificationText(content, attachment),
+			models.NotificationData{"screen": "friend_chat", "user_id": senderID, "message_id": strconv.FormatUint(uint64(message.ID), 10)},
+		)
+	})
 	if err != nil {
-		log.Printf("Failed to send push notification: %v", err)
-		return fmt.Errorf("failed to send push notification: %w", err)
-	}
-
-	notification := map[string]string{
-		"type":       "new_message",
-		"senderID":   senderID,
-		"receiverID": receiverID,
-		"content":    content,
+		log.Printf("Failed to store message: %v", err)
+		return err
 	}
+	log.Printf("Stored message in database for %s to %s", senderID, receiverID)
 
-	notificationData, err := json.Marshal(notification)
-	if err != nil {
-		log.Printf("Failed to marshal notification: %v", err)
-		return fmt.Errorf("failed to marshal notification: %w", err)
+	notification := FriendMessageEvent{
+		Type:       EventFriendMessage,
+		ID:         message.ID,
+		SenderID:   senderID,
+		ReceiverID: receiverID,
+		Content:    content,
+		ReplyToID:  replyToID,
+		Attachment: attachment,
 	}
 
-	s.WebSocketService.broadcast <- notificationData
+	// Seuls les deux participants reçoivent le message, l'expéditeur pour ses autres appareils
+	s.WebSocketService.DeliverToUsers([]string{receiverID, senderID}, notification.Type, notification)
 
 	return nil
 }
@@ -81,11 +112,252 @@ func (s *FriendChatService) SendMessage(senderID, receiverID, content string) er
 func (s *FriendChatService) GetMessages(senderID, receiverID string) ([]models.Message, error) {
 	log.Printf("Retrieving messages between %s and %s", senderID, receiverID)
 	var messages []models.Message
-	err := s.DB.Where("(sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?)", senderID, receiverID, receiverID, senderID).Order("created_at asc").Find(&messages).Error
+	err := s.DB.Preload("Attachment").Where("(sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?)", senderID, receiverID, receiverID, senderID).Order("created_at asc").Find(&messages).Error
 	if err != nil {
 		log.Printf("Failed to get messages from database: %v", err)
 		return nil, fmt.Errorf("failed to get messages from database: %w", err)
 	}
 	log.Printf("Retrieved %d messages between %s and %s", len(messages), senderID, receiverID)
+
+	ids := make([]string, len(messages))
+	for i, message := range messages {
+		ids[i] = strconv.FormatUint(uint64(message.ID), 10)
+	}
+	reactions, err := loadReactions(s.DB, models.MessageKindFriend, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reactions from database: %w", err)
+	}
+	for i := range messages {
+		messages[i].Reactions = reactions[ids[i]]
+	}
 	return messages, nil
 }
+
+// friendChatEvent est un ChatEvent adressé aux deux membres d'une conversation entre amis
+type friendChatEvent struct {
+	ChatEvent
+	SenderID   string `json:"senderID"`
+	ReceiverID string `json:"receiverID"`
+}
+
+// publishEvent diffuse un événement aux clients WebSocket du chat entre amis
+func (s *FriendChatService) publishEvent(message *models.Message, event ChatEvent) {
+	s.broadcastConversationEvent(message.SenderID, message.ReceiverID, event)
+}
+
+// findMessage charge un message d'une conversation dont l'utilisateur fait partie
+func (s *FriendChatService) findMessage(messageID uint, userID string) (*models.Message, error) {
+	var message models.Message
+	if err := s.DB.Preload("Attachment").Where("id = ? AND (sender_id = ? OR receiver_id = ?)", messageID, userID, userID).First(&message).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMessageNotFound
+		}
+		return nil, err
+	}
+	return &message, nil
+}
+
+// inConversation vérifie qu'un message appartient à la conversation entre deux utilisateurs
+func (s *FriendChatService) inConversation(messageID uint, userA, userB string) bool {
+	var count int64
+	s.DB.Model(&models.Message{}).
+		Where("id = ? AND ((sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?))", messageID, userA, userB, userB, userA).
+		Count(&count)
+	return count > 0
+}
+
+// EditMessage modifie un message ; seul son expéditeur peut le faire et l'ancienne version est conservée
+func (s *FriendChatService) EditMessage(messageID uint, userID, content string) (*models.Message, error) {
+	content, err := validateChatContent(content)
+	if err != nil {
+		return nil, err
+	}
+	if content, err = filterContent(s.ContentFilter, content); err != nil {
+		return nil, err
+	}
+	message, err := s.findMessage(messageID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if message.SenderID != userID {
+		return nil, ErrMessageForbidden
+	}
+	if message.DeletedAt != nil {
+		return nil, ErrMessageDeleted
+	}
+	if message.Content == content {
+		return message, nil
+	}
+
+	now := time.Now()
+	id := strconv.FormatUint(uint64(message.ID), 10)
+	if err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := recordEdit(tx, models.MessageKindFriend, id, message.Content); err != nil {
+			return err
+		}
+		return tx.Model(&models.Message{}).Where("id = ?", message.ID).
+			Updates(map[string]interface{}{"content": content, "edited_at": now}).Error
+	}); err != nil {
+		return nil, err
+	}
+
+	message.Content = content
+	message.EditedAt = &now
+	if reactions, err := loadReactions(s.DB, models.MessageKindFriend, []string{id}); err == nil {
+		message.Reactions = reactions[id]
+	}
+	s.publishEvent(message, ChatEvent{Type: "message_edited", MessageID: id, Message: message})
+	return message, nil
+}
+
+// DeleteMessage supprime un message pour les deux membres de la conversation ; seul son expéditeur peut le faire
+func (s *FriendChatService) DeleteMessage(messageID uint, userID string) (*models.Message, error) {
+	message, err := s.findMessage(messageID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if message.SenderID != userID {
+		return nil, ErrMessageForbidden
+	}
+	return s.removeMessage(message)
+}
+
+// removeMessage supprime un message pour les deux membres de la conversation et diffuse sa suppression
+func (s *FriendChatService) removeMessage(message *models.Message) (*models.Message, error) {
+	if message.DeletedAt != nil {
+		return message, nil
+	}
+
+	now := time.Now()
+	id := strconv.FormatUint(uint64(message.ID), 10)
+	if err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Message{}).Where("id = ?", message.ID).
+			Updates(map[string]interface{}{"content": "", "attachment_id": nil, "deleted_at": now}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("message_kind = ? AND message_id = ?", models.MessageKindFriend, id).Delete(&models.MessageEdit{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("message_kind = ? AND message_id = ?", models.MessageKindFriend, id).Delete(&models.MessageReaction{}).Error
+	}); err != nil {
+		return nil, err
+	}
+
+	message.Content = ""
+	message.AttachmentID = nil
+	message.Attachment = nil
+	message.DeletedAt = &now
+	s.publishEvent(message, ChatEvent{Type: "message_deleted", MessageID: id, Message: message})
+	return message, nil
+}
+
+// SetReaction ajoute (add) ou retire une réaction d'un membre de la conversation à un message
+func (s *FriendChatService) SetReaction(messageID uint, userID, emoji string, add bool) (models.Reactions, error) {
+	message, err := s.findMessage(messageID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if message.DeletedAt != nil {
+		return nil, ErrMessageDeleted
+	}
+
+	id := strconv.FormatUint(uint64(message.ID), 10)
+	if add {
+		err = addReaction(s.DB, models.MessageKindFriend, id, userID, emoji)
+	} else {
+		err = removeReaction(s.DB, models.MessageKindFriend, id, userID, emoji)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	reactions, err := loadReactions(s.DB, models.MessageKindFriend, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	s.publishEvent(message, ChatEvent{Type: "reactions_updated", MessageID: id, Reactions: reactions[id], UserID: userID, Emoji: emoji})
+	return reactions[id], nil
+}
+
+// GetEditHistory renvoie les versions précédentes d'un message d'une conversation de l'utilisateur
+func (s *FriendChatService) GetEditHistory(messageID uint, userID string) ([]models.MessageEdit, error) {
+	if _, err := s.findMessage(messageID, userID); err != nil {
+		return nil, err
+	}
+	return loadEditHistory(s.DB, models.MessageKindFriend, strconv.FormatUint(uint64(messageID), 10))
+}
+
+// broadcastConversationEvent envoie un événement émis par un utilisateur à son interlocuteur et à ses propres appareils
+func (s *FriendChatService) broadcastConversationEvent(userID, otherID string, event ChatEvent) {
+	s.WebSocketService.DeliverToUsers([]string{otherID, userID}, event.Type, friendChatEvent{ChatEvent: event, SenderID: userID, ReceiverID: otherID})
+}
+
+// MarkRead avance le curseur de lecture de l'utilisateur dans sa conversation avec otherID
+// et prévient l'interlocuteur que ses messages ont été lus
+func (s *FriendChatService) MarkRead(userID, otherID string, messageID uint) (*models.ReadCursor, error) {
+	message, err := s.findMessage(messageID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !s.inConversation(messageID, userID, otherID) {
+		return nil, ErrMessageNotFound
+	}
+
+	id := strconv.FormatUint(uint64(message.ID), 10)
+	advanced, err := advanceReadCursor(s.DB, userID, models.MessageKindFriend, otherID, id, message.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if advanced {
+		s.broadcastConversationEvent(userID, otherID, ChatEvent{Type: "read", MessageID: id, UserID: userID})
+	}
+
+	var cursor models.ReadCursor
+	if err := s.DB.Where("user_id = ? AND conversation_kind = ? AND conversation_id = ?", userID, models.MessageKindFriend, otherID).
+		First(&cursor).Error; err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+// GetReadCursor renvoie jusqu'où otherID a lu sa conversation avec userID, ou nil s'il n'a rien lu
+func (s *FriendChatService) GetReadCursor(userID, otherID string) (*models.ReadCursor, error) {
+	var cursor models.ReadCursor
+	err := s.DB.Where("user_id = ? AND conversation_kind = ? AND conversation_id = ?", otherID, models.MessageKindFriend, userID).
+		First(&cursor).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+// UnreadCounts renvoie, pour chaque expéditeur, le nombre de messages reçus après le dernier message lu
+func (s *FriendChatService) UnreadCounts(userID string) (map[string]int64, error) {
+	var rows []struct {
+		SenderID string
+		Unread   int64
+	}
+	if err := s.DB.Table("messages").
+		Select("messages.sender_id, COUNT(*) AS unread").
+		Joins("LEFT JOIN read_cursors ON read_cursors.user_id = messages.receiver_id AND read_cursors.conversation_kind = ? AND read_cursors.conversation_id = messages.sender_id", models.MessageKindFriend).
+		Where("messages.receiver_id = ? AND messages.deleted_at IS NULL", userID).
+		Where("read_cursors.id IS NULL OR messages.created_at > read_cursors.last_read_message_at").
+		Group("messages.sender_id").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.SenderID] = row.Unread
+	}
+	return counts, nil
+}
+
+// PublishTyping prévient l'interlocuteur que l'utilisateur écrit ou a cessé d'écrire ; rien n'est conservé
+func (s *FriendChatService) PublishTyping(userID, otherID string, typing bool) {
+	s.broadcastConversationEvent(userID, otherID, ChatEvent{Type: "typing", UserID: userID, Typing: &typing})
+}
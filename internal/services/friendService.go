@@ -1,7 +1,6 @@
 package services
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
 	"time"
@@ -11,16 +10,18 @@ import (
 )
 
 type FriendService struct {
-	DB               *gorm.DB
-	AuthService      *AuthService
-	WebSocketService *WebSocketService
+	DB                  *gorm.DB
+	AuthService         *AuthService
+	WebSocketService    *WebSocketService
+	NotificationService *NotificationService
 }
 
-func NewFriendService(db *gorm.DB, authService *AuthService, webSocketService *WebSocketService) *FriendService {
+func NewFriendService(db *gorm.DB, authService *AuthService, webSocketService *WebSocketService, notificationService *NotificationService) *FriendService {
 	return &FriendService{
-		DB:               db,
-		AuthService:      authService,
-		WebSocketService: webSocketService,
+		DB:                  db,
+		AuthService:         authService,
+		WebSocketService:    webSocketService,
+		NotificationService: notificationService,
 	}
 }
 
@@ -43,29 +44,35 @@ func (s *FriendService) SendFriendRequest(senderId, receiverId string) error {
 		CreatedAt:  time.Now(),
 	}
 
-	// Store the friend request in the database
-	if err := s.DB.Create(&friendRequest).Error; err != nil {
+	var sender models.Users
+	if err := s.DB.Where("id = ?", senderId).First(&sender).Error; err != nil {
+		return fmt.Errorf("sender not found: %w", err)
+	}
+
+	// Store the friend request and the receiver's notification in the same transaction
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&friendRequest).Error; err != nil {
+			return err
+		}
+		return s.NotificationService.WithTx(tx).Notify(
+			receiverId,
+			models.NotificationFriendRequest,
+			"TeamUp rélation",
+			"Vous avez reçu une nouvelle demande d'ami de "+sender.Username,
+			models.NotificationData{"screen": "friend_requests", "user_id": senderId},
+		)
+	})
+	if err != nil {
 		log.Printf("Failed to create friend request in database: %v", err)
 		return fmt.Errorf("failed to create friend request in database: %w", err)
 	}
 	log.Printf("Stored friend request in database for %s to %s", senderId, receiverId)
 
 	// Send notification via WebSocket
-	notification := map[string]string{
-		"type":       "friend_request",
-		"senderId":   senderId,
-		"receiverId": receiverId,
-	}
-
-	// Serialize the notification
-	notificationData, err := json.Marshal(notification)
-	if err != nil {
-		log.Printf("Failed to marshal notification: %v", err)
-		return fmt.Errorf("failed to marshal notification: %w", err)
-	}
+	notification := FriendRequestEvent{Type: EventFriendRequest, SenderID: senderId, ReceiverID: receiverId}
 
-	// Send the notification via WebSocketService
-	s.WebSocketService.broadcast <- notificationData
+	// Send the notification to the receiver only
+	s.WebSocketService.DeliverToUser(receiverId, notification.Type, notification)
 
 	return nil
 }
@@ -83,9 +90,26 @@ func (s *FriendService) AcceptFriendRequest(senderId, receiverId string) error {
 
 	log.Printf("Friend request found: %v", friendRequest)
 
-	// Mettez à jour le statut de la demande d'ami
+	var receiver models.Users
+	if err := s.DB.Where("id = ?", receiverId).First(&receiver).Error; err != nil {
+		return fmt.Errorf("receiver not found: %w", err)
+	}
+
+	// Mettez à jour le statut de la demande d'ami et notifiez l'expéditeur dans la même transaction
 	friendRequest.Status = "accepted"
-	if err := s.DB.Save(&friendRequest).Error; err != nil {
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&friendRequest).Error; err != nil {
+			return err
+		}
+		return s.NotificationService.WithTx(tx).Notify(
+			senderId,
+			models.NotificationFriendAccepted,
+			"TeamUp rélation",
+			"Votre demande d'ami a été acceptée par "+receiver.Username,
+			models.NotificationData{"screen": "profile", "user_id": receiverId},
+		)
+	})
+	if err != nil {
 		log.Printf("Failed to update friend request status: %v", err)
 		return fmt.Errorf("failed to update friend request status: %w", err)
 	}
@@ -93,21 +117,10 @@ func (s *FriendService) AcceptFriendRequest(senderId, receiverId string) error {
 	log.Printf("Accepted friend request from %s to %s", senderId, receiverId)
 
 	// Envoyer une notification via WebSocket
-	notification := map[string]string{
-		"type":       "friend_request_accepted",
-		"senderId":   senderId,
-		"receiverId": receiverId,
-	}
+	notification := FriendRequestEvent{Type: EventFriendRequestAccepted, SenderID: senderId, ReceiverID: receiverId}
 
-	// Sérialiser la notification
-	notificationData, err := json.Marshal(notification)
-	if err != nil {
-		log.Printf("Failed to marshal notification: %v", err)
-		return fmt.Errorf("failed to marshal notification: %w", err)
-	}
-
-	// Envoyer la notification via WebSocketService
-	s.WebSocketService.broadcast <- notificationData
+	// Envoyer la notification aux deux utilisateurs concernés uniquement
+	s.WebSocketService.DeliverToUsers([]string{senderId, receiverId}, notification.Type, notification)
 
 	return nil
 }
@@ -125,9 +138,26 @@ func (s *FriendService) DeclineFriendRequest(senderId, receiverId string) error
 
 	log.Printf("Friend request found: %v", friendRequest)
 
-	// Update the status of the friend request
+	var receiver models.Users
+	if err := s.DB.Where("id = ?", receiverId).First(&receiver).Error; err != nil {
+		return fmt.Errorf("receiver not found: %w", err)
+	}
+
+	// Update the status of the friend request and notify its sender in the same transaction
 	friendRequest.Status = "declined"
-	if err := s.DB.Save(&friendRequest).Error; err != nil {
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&friendRequest).Error; err != nil {
+			return err
+		}
+		return s.NotificationService.WithTx(tx).Notify(
+			senderId,
+			models.NotificationFriendDeclined,
+			"TeamUp rélation",
+			"Votre demande d'ami a été refusée par "+receiver.Username,
+			models.NotificationData{"screen": "profile", "user_id": receiverId},
+		)
+	})
+	if err != nil {
 		log.Printf("Failed to update friend request status: %v", err)
 		return fmt.Errorf("failed to update friend request status: %w", err)
 	}
@@ -135,21 +165,10 @@ func (s *FriendService) DeclineFriendRequest(senderId, receiverId string) error
 	log.Printf("Declined friend request from %s to %s", senderId, receiverId)
 
 	// Send notification via WebSocket
-	notification := map[string]string{
-		"type":       "friend_request_declined",
-		"senderId":   senderId,
-		"receiverId": receiverId,
-	}
-
-	// Serialize the notification
-	notificationData, err := json.Marshal(notification)
-	if err != nil {
-		log.Printf("Failed to marshal notification: %v", err)
-		return fmt.Errorf("failed to marshal notification: %w", err)
-	}
+	notification := FriendRequestEvent{Type: EventFriendRequestDeclined, SenderID: senderId, ReceiverID: receiverId}
 
-	// Send the notification via WebSocketService
-	s.WebSocketService.broadcast <- notificationData
+	// Send the notification to both users of the request only
+	s.WebSocketService.DeliverToUsers([]string{senderId, receiverId}, notification.Type, notification)
 
 	return nil
 }
@@ -0,0 +1,652 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/ady243/teamup/internal/models"
+	"github.com/go-redis/redis/v8"
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrGroupNotFound  = errors.New("group not found")
+	ErrNotGroupAdmin  = errors.New("only group admins can do this")
+	ErrInvalidGroup   = errors.New("invalid group request")
+	ErrGroupFull      = errors.New("group is full")
+	ErrNotFriendsWith = errors.New("you can only add your friends to a group")
+)
+
+// Limites des conversations de groupe
+const (
+	maxGroupMembers    = 50
+	maxGroupNameLength = 100
+)
+
+// GroupService gère les conversations de groupe entre amis : membres, rôles, messages et lecture
+type GroupService struct {
+	DB                  *gorm.DB
+	RedisClient         *redis.Client
+	NotificationService *NotificationService
+	FriendService       *FriendService
+	ContentFilter       ContentFilter    // Appliqué au texte des messages envoyés
+	RateLimiter         *ChatRateLimiter // Limite les messages de chaque membre dans un groupe
+}
+
+func NewGroupService(db *gorm.DB, redisClient *redis.Client, notificationService *NotificationService, friendService *FriendService) *GroupService {
+	return &GroupService{
+		DB:                  db,
+		RedisClient:         redisClient,
+		NotificationService: notificationService,
+		FriendService:       friendService,
+		ContentFilter:       DefaultContentFilter(),
+		RateLimiter:         NewChatRateLimiter(redisClient),
+	}
+}
+
+// GroupRoom renvoie le salon temps réel dans lequel sont diffusés les messages et événements d'un groupe
+func GroupRoom(groupID string) string {
+	return "group:" + groupID
+}
+
+// validateGroupName nettoie le nom d'un groupe et vérifie sa longueur
+func validateGroupName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxGroupNameLength {
+		return "", fmt.Errorf("%w: name must contain between 1 and %d characters", ErrInvalidGroup, maxGroupNameLength)
+	}
+	return name, nil
+}
+
+// withSender complète un message de groupe avec les informations de son auteur
+func withSender(message models.GroupMessage) models.GroupMessage {
+	message.Username = message.Sender.Username
+	message.ProfilePic = message.Sender.ProfilePhoto
+	return message
+}
+
+// newGroupMember prépare l'appartenance d'un utilisateur à un groupe
+func newGroupMember(groupID, userID string, role models.GroupRole) models.GroupMember {
+	t := time.Now()
+	entropy := ulid.Monotonic(rand.New(rand.NewSource(t.UnixNano())), 0)
+	return models.GroupMember{
+		ID:      ulid.MustNew(ulid.Timestamp(t), entropy).String(),
+		GroupID: groupID,
+		UserID:  userID,
+		Role:    role,
+	}
+}
+
+// checkFriends vérifie que tous les utilisateurs sont amis avec userID
+func (s *GroupService) checkFriends(userID string, userIDs []string) error {
+	for _, id := range userIDs {
+		friends, err := s.FriendService.AreFriends(userID, id)
+		if err != nil {
+			return err
+		}
+		if !friends {
+			return ErrNotFriendsWith
+		}
+	}
+	return nil
+}
+
+// uniqueIDs retire les doublons et l'utilisateur exclu d'une liste d'identifiants
+func uniqueIDs(ids []string, exclude string) []string {
+	seen := map[string]bool{exclude: true}
+	result := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id = strings.TrimSpace(id); id != "" && !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// CreateGroup crée un groupe dont le créateur est administrateur ; les membres invités doivent être ses amis
+func (s *GroupService) CreateGroup(creatorID, name string, memberIDs []string) (*models.GroupConversation, error) {
+	name, err := validateGroupName(name)
+	if err != nil {
+		return nil, err
+	}
+	memberIDs = uniqueIDs(memberIDs, creatorID)
+	if len(memberIDs) == 0 {
+		return nil, fmt.Errorf("%w: a group needs at least one other member", ErrInvalidGroup)
+	}
+	if len(memberIDs)+1 > maxGroupMembers {
+		return nil, ErrGroupFull
+	}
+	if err := s.checkFriends(creatorID, memberIDs); err != nil {
+		return nil, err
+	}
+
+	t := time.Now()
+	entropy := ulid.Monotonic(rand.New(rand.NewSource(t.UnixNano())), 0)
+	group := models.GroupConversation{
+		ID:        ulid.MustNew(ulid.Timestamp(t), entropy).String(),
+		Name:      name,
+		CreatedBy: creatorID,
+	}
+	members := []models.GroupMember{newGroupMember(group.ID, creatorID, models.GroupRoleAdmin)}
+	for _, id := range memberIDs {
+		members = append(members, newGroupMember(group.ID, id, models.GroupRoleMember))
+	}
+
+	if err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Members").Create(&group).Error; err != nil {
+			return err
+		}
+		return tx.Omit("User").Create(&members).Error
+	}); err != nil {
+		return nil, err
+	}
+	return s.loadGroup(group.ID)
+}
+
+// loadGroup charge un groupe avec ses membres, par ordre d'arrivée
+func (s *GroupService) loadGroup(groupID string) (*models.GroupConversation, error) {
+	var group models.GroupConversation
+	if err := s.DB.Preload("Members", func(db *gorm.DB) *gorm.DB {
+		return db.Order("joined_at asc")
+	}).Where("id = ?", groupID).First(&group).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrGroupNotFound
+		}
+		return nil, err
+	}
+	return &group, nil
+}
+
+// member renvoie l'appartenance d'un utilisateur à un groupe, ou ErrGroupNotFound s'il n'en est pas membre
+func (s *GroupService) member(groupID, userID string) (*models.GroupMember, error) {
+	var member models.GroupMember
+	if err := s.DB.Where("group_id = ? AND user_id = ?", groupID, userID).First(&member).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrGroupNotFound
+		}
+		return nil, err
+	}
+	return &member, nil
+}
+
+// IsMember vérifie qu'un utilisateur fait partie d'un groupe
+func (s *GroupService) IsMember(groupID, userID string) bool {
+	_, err := s.member(groupID, userID)
+	return err == nil
+}
+
+// checkAdmin vérifie qu'un utilisateur est administrateur d'un groupe
+func (s *GroupService) checkAdmin(groupID, userID string) error {
+	member, err := s.member(groupID, userID)
+	if err != nil {
+		return err
+	}
+	if member.Role != models.GroupRoleAdmin {
+		return ErrNotGroupAdmin
+	}
+	return nil
+}
+
+// GetUserGroups renvoie les groupes d'un utilisateur, du plus récemment actif au plus ancien
+func (s *GroupService) GetUserGroups(userID string) ([]models.GroupConversation, error) {
+	var groups []models.GroupConversation
+	if err := s.DB.Preload("Members", func(db *gorm.DB) *gorm.DB {
+		return db.Order("joined_at asc")
+	}).Where("id IN (?)", s.DB.Model(&models.GroupMember{}).Select("group_id").Where("user_id = ?", userID)).
+		Order("updated_at desc").Find(&groups).Error; err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// GetGroup renvoie un groupe à l'un de ses membres
+func (s *GroupService) GetGroup(groupID, userID string) (*models.GroupConversation, error) {
+	if _, err := s.member(groupID, userID); err != nil {
+		return nil, err
+	}
+	return s.loadGroup(groupID)
+}
+
+// AddMembers ajoute des amis de l'administrateur au groupe ; les membres déjà présents sont ignorés
+func (s *GroupService) AddMembers(groupID, adminID string, userIDs []string) (*models.GroupConversation, error) {
+	if err := s.checkAdmin(groupID, adminID); err != nil {
+		return nil, err
+	}
+	userIDs = uniqueIDs(userIDs, adminID)
+	if len(userIDs) == 0 {
+		return nil, fmt.Errorf("%w: user_ids is required", ErrInvalidGroup)
+	}
+	if err := s.checkFriends(adminID, userIDs); err != nil {
+		return nil, err
+	}
+
+	var count int64
+	if err := s.DB.Model(&models.GroupMember{}).Where("group_id = ?", groupID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	var added []string
+	for _, id := range userIDs {
+		if count >= maxGroupMembers {
+			return nil, ErrGroupFull
+		}
+		member := newGroupMember(groupID, id, models.GroupRoleMember)
+		result := s.DB.Omit("User").Clauses(clause.OnConflict{DoNothing: true}).Create(&member)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected > 0 {
+			added = append(added, id)
+			count++
+		}
+	}
+
+	group, err := s.loadGroup(groupID)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range added {
+		s.publishEvent(groupID, ChatEvent{Type: "member_added", UserID: id, Message: group})
+	}
+	return group, nil
+}
+
+// RemoveMember retire un membre du groupe ; un administrateur quitte le groupe avec Leave
+func (s *GroupService) RemoveMember(groupID, adminID, userID string) error {
+	if userID == adminID {
+		return fmt.Errorf("%w: use leave to quit the group", ErrInvalidGroup)
+	}
+	if err := s.checkAdmin(groupID, adminID); err != nil {
+		return err
+	}
+	result := s.DB.Where("group_id = ? AND user_id = ?", groupID, userID).Delete(&models.GroupMember{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrGroupNotFound
+	}
+	s.publishEvent(groupID, ChatEvent{Type: "member_removed", UserID: userID})
+	return nil
+}
+
+// Rename change le nom du groupe
+func (s *GroupService) Rename(groupID, adminID, name string) (*models.GroupConversation, error) {
+	name, err := validateGroupName(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkAdmin(groupID, adminID); err != nil {
+		return nil, err
+	}
+	if err := s.DB.Model(&models.GroupConversation{}).Where("id = ?", groupID).Update("name", name).Error; err != nil {
+		return nil, err
+	}
+
+	group, err := s.loadGroup(groupID)
+	if err != nil {
+		return nil, err
+	}
+	s.publishEvent(groupID, ChatEvent{Type: "group_renamed", UserID: adminID, Message: group})
+	return group, nil
+}
+
+// SetMemberRole nomme un membre administrateur ou lui retire ce rôle ; un administrateur ne change pas son propre rôle,
+// ce qui garantit qu'il en reste toujours au moins un
+func (s *GroupService) SetMemberRole(groupID, adminID, userID string, role models.GroupRole) (*models.GroupMember, error) {
+	if role != models.GroupRoleAdmin && role != models.GroupRoleMember {
+		return nil, fmt.Errorf("%w: role must be %q or %q", ErrInvalidGroup, models.GroupRoleAdmin, models.GroupRoleMember)
+	}
+	if userID == adminID {
+		return nil, fmt.Errorf("%w: you cannot change your own role", ErrInvalidGroup)
+	}
+	if err := s.checkAdmin(groupID, adminID); err != nil {
+		return nil, err
+	}
+	member, err := s.member(groupID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.DB.Model(member).Update("role", role).Error; err != nil {
+		return nil, err
+	}
+	member.Role = role
+	s.publishEvent(groupID, ChatEvent{Type: "member_role_changed", UserID: userID, Message: member})
+	return member, nil
+}
+
+// Leave retire un utilisateur du groupe. Si le dernier administrateur s'en va, le membre le plus ancien
+// le remplace ; un groupe sans membre est supprimé avec ses messages.
+func (s *GroupService) Leave(groupID, userID string) error {
+	member, err := s.member(groupID, userID)
+	if err != nil {
+		return err
+	}
+
+	deleted := false
+	if err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(member).Error; err != nil {
+			return err
+		}
+
+		var remaining []models.GroupMember
+		if err := tx.Where("group_id = ?", groupID).Order("joined_at asc").Find(&remaining).Error; err != nil {
+			return err
+		}
+		if len(remaining) == 0 {
+			deleted = true
+			if err := tx.Where("group_id = ?", groupID).Delete(&models.GroupMessage{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("conversation_kind = ? AND conversation_id = ?", models.MessageKindGroup, groupID).Delete(&models.ReadCursor{}).Error; err != nil {
+				return err
+			}
+			return tx.Where("id = ?", groupID).Delete(&models.GroupConversation{}).Error
+		}
+		for _, m := range remaining {
+			if m.Role == models.GroupRoleAdmin {
+				return nil
+			}
+		}
+		return tx.Model(&remaining[0]).Update("role", models.GroupRoleAdmin).Error
+	}); err != nil {
+		return err
+	}
+
+	if !deleted {
+		s.publishEvent(groupID, ChatEvent{Type: "member_left", UserID: userID})
+	}
+	return nil
+}
+
+// publishEvent diffuse un événement sur le canal du groupe
+func (s *GroupService) publishEvent(groupID string, event ChatEvent) {
+	if err := PublishRoomEvent(s.RedisClient, GroupRoom(groupID), event.Type, event); err != nil {
+		log.Printf("Error publishing group event: %v", err)
+	}
+}
+
+// findByClientMsgID charge le message envoyé par un membre avec une clé d'idempotence
+func (s *GroupService) findByClientMsgID(groupID, userID, clientMsgID string) (*models.GroupMessage, error) {
+	var existing models.GroupMessage
+	if err := s.DB.Preload("Sender").Preload("Attachment").
+		Where("group_id = ? AND sender_id = ? AND client_msg_id = ?", groupID, userID, clientMsgID).
+		First(&existing).Error; err != nil {
+		return nil, err
+	}
+	existing = withSender(existing)
+	return &existing, nil
+}
+
+// SendMessage enregistre le message d'un membre, le diffuse sur le canal du groupe et le notifie aux autres membres.
+// Si clientMsgID a déjà été utilisé par ce membre dans ce groupe, le message existant est renvoyé et created vaut false.
+func (s *GroupService) SendMessage(groupID, senderID string, input ChatMessageInput) (groupMessage *models.GroupMessage, created bool, err error) {
+	message := strings.TrimSpace(input.Message)
+	if message != "" || input.AttachmentID == "" {
+		if message, err = validateChatContent(message); err != nil {
+			return nil, false, err
+		}
+	}
+	if message, err = filterContent(s.ContentFilter, message); err != nil {
+		return nil, false, err
+	}
+	clientMsgID := input.ClientMsgID
+	if len(clientMsgID) > maxClientMsgIDLength {
+		return nil, false, fmt.Errorf("%w: client_msg_id is too long", ErrInvalidChatMessage)
+	}
+
+	if _, err := s.member(groupID, senderID); err != nil {
+		return nil, false, err
+	}
+	if input.ReplyToID != "" {
+		var count int64
+		if err := s.DB.Model(&models.GroupMessage{}).Where("id = ? AND group_id = ?", input.ReplyToID, groupID).Count(&count).Error; err != nil {
+			return nil, false, err
+		}
+		if count == 0 {
+			return nil, false, fmt.Errorf("%w: the message you reply to does not exist in this group", ErrInvalidChatMessage)
+		}
+	}
+	if clientMsgID != "" {
+		if existing, err := s.findByClientMsgID(groupID, senderID, clientMsgID); err == nil {
+			return existing, false, nil
+		}
+	}
+
+	if err := s.RateLimiter.Allow(models.MessageKindGroup, groupID, senderID); err != nil {
+		return nil, false, err
+	}
+
+	var attachment *models.Attachment
+	if input.AttachmentID != "" {
+		if attachment, err = findAttachment(s.DB, input.AttachmentID, senderID, models.MessageKindGroup, groupID); err != nil {
+			return nil, false, err
+		}
+	}
+
+	t := time.Now()
+	entropy := ulid.Monotonic(rand.New(rand.NewSource(t.UnixNano())), 0)
+	newMessage := models.GroupMessage{
+		ID:        ulid.MustNew(ulid.Timestamp(t), entropy).String(),
+		GroupID:   groupID,
+		SenderID:  senderID,
+		Content:   message,
+		CreatedAt: t,
+	}
+	if clientMsgID != "" {
+		newMessage.ClientMsgID = &clientMsgID
+	}
+	if input.ReplyToID != "" {
+		newMessage.ReplyToID = &input.ReplyToID
+	}
+	if attachment != nil {
+		newMessage.AttachmentID = &attachment.ID
+	}
+
+	s.DB.Where("id = ?", senderID).First(&newMessage.Sender)
+	newMessage.Attachment = attachment
+	newMessage = withSender(newMessage)
+
+	// Les notifications sont enregistrées dans la transaction du message : elles ne partent que s'il est enregistré
+	duplicate := false
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Omit("Sender", "Attachment").Clauses(clause.OnConflict{DoNothing: true}).Create(&newMessage)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			duplicate = true
+			return nil
+		}
+		// La date de mise à jour du groupe sert à trier la liste des conversations
+		if err := tx.Model(&models.GroupConversation{}).Where("id = ?", groupID).Update("updated_at", t).Error; err != nil {
+			return err
+		}
+		return s.notifyMembers(tx, newMessage)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if duplicate {
+		existing, err := s.findByClientMsgID(groupID, senderID, clientMsgID)
+		if err != nil {
+			return nil, false, err
+		}
+		return existing, false, nil
+	}
+
+	if err := PublishRoomEvent(s.RedisClient, GroupRoom(groupID), EventGroupMessage, newMessage); err != nil {
+		log.Printf("Error publishing group message: %v", err)
+	}
+
+	return &newMessage, true, nil
+}
+
+// notifyMembers notifie les membres du groupe autres que l'auteur, dans la transaction tx du message
+func (s *GroupService) notifyMembers(tx *gorm.DB, message models.GroupMessage) error {
+	if s.NotificationService == nil {
+		return nil
+	}
+	group, err := s.loadGroup(message.GroupID)
+	if err != nil {
+		return err
+	}
+	var recipients []string
+	if err := tx.Model(&models.GroupMember{}).Where("group_id = ? AND user_id <> ?", message.GroupID, message.SenderID).
+		Pluck("user_id", &recipients).Error; err != nil {
+		return err
+	}
+	data := models.NotificationData{"screen": "group", "group_id": message.GroupID, "message_id": message.ID}
+	return s.NotificationService.WithTx(tx).NotifyConversation(recipients, GroupRoom(message.GroupID), models.NotificationGroupMessage, group.Name, message.Username+" : "+notificationText(message.Content, message.Attachment), data)
+}
+
+// GetMessages renvoie les messages d'un groupe du plus ancien au plus récent. Sans curseur, ce sont
+// les derniers messages ; before et after sont des identifiants de message, comme pour le chat d'un match.
+func (s *GroupService) GetMessages(groupID, userID, before, after string, limit int) ([]models.GroupMessage, error) {
+	if _, err := s.member(groupID, userID); err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > 100 {
+		limit = chatCacheSize
+	}
+
+	query := s.DB.Preload("Sender").Preload("Attachment").Where("group_id = ?", groupID)
+	descending := after == ""
+	if before != "" {
+		query = query.Where("id < ?", before)
+	}
+	if after != "" {
+		query = query.Where("id > ?", after)
+	}
+	if descending {
+		query = query.Order("id desc")
+	} else {
+		query = query.Order("id asc")
+	}
+
+	var messages []models.GroupMessage
+	if err := query.Limit(limit).Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	if descending {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+	for i := range messages {
+		messages[i] = withSender(messages[i])
+	}
+	return messages, nil
+}
+
+// DeleteMessage supprime un message pour tous les membres ; l'auteur et les administrateurs peuvent le faire
+func (s *GroupService) DeleteMessage(groupID, messageID, userID string) (*models.GroupMessage, error) {
+	member, err := s.member(groupID, userID)
+	if err != nil {
+		return nil, err
+	}
+	var message models.GroupMessage
+	if err := s.DB.Preload("Sender").Where("id = ? AND group_id = ?", messageID, groupID).First(&message).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMessageNotFound
+		}
+		return nil, err
+	}
+	if message.SenderID != userID && member.Role != models.GroupRoleAdmin {
+		return nil, ErrMessageForbidden
+	}
+	if message.DeletedAt != nil {
+		deleted := withSender(message)
+		return &deleted, nil
+	}
+
+	// La pièce jointe n'est plus référencée : elle sera supprimée par la purge des pièces jointes orphelines
+	now := time.Now()
+	if err := s.DB.Model(&models.GroupMessage{}).Where("id = ?", message.ID).
+		Updates(map[string]interface{}{"content": "", "attachment_id": nil, "deleted_at": now}).Error; err != nil {
+		return nil, err
+	}
+	message.Content = ""
+	message.AttachmentID = nil
+	message.DeletedAt = &now
+	deleted := withSender(message)
+	s.publishEvent(groupID, ChatEvent{Type: "message_deleted", MessageID: message.ID, Message: deleted})
+	return &deleted, nil
+}
+
+// MarkRead avance le curseur de lecture d'un membre jusqu'à un message et prévient les autres membres
+func (s *GroupService) MarkRead(groupID, userID, messageID string) (*models.ReadCursor, error) {
+	if _, err := s.member(groupID, userID); err != nil {
+		return nil, err
+	}
+	var message models.GroupMessage
+	if err := s.DB.Where("id = ? AND group_id = ?", messageID, groupID).First(&message).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMessageNotFound
+		}
+		return nil, err
+	}
+
+	advanced, err := advanceReadCursor(s.DB, userID, models.MessageKindGroup, groupID, message.ID, message.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if advanced {
+		s.publishEvent(groupID, ChatEvent{Type: "read", MessageID: message.ID, UserID: userID})
+	}
+
+	var cursor models.ReadCursor
+	if err := s.DB.Where("user_id = ? AND conversation_kind = ? AND conversation_id = ?", userID, models.MessageKindGroup, groupID).
+		First(&cursor).Error; err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+// GetReadCursors renvoie la position de lecture de chaque membre d'un groupe
+func (s *GroupService) GetReadCursors(groupID, userID string) ([]models.ReadCursor, error) {
+	if _, err := s.member(groupID, userID); err != nil {
+		return nil, err
+	}
+	return loadReadCursors(s.DB, models.MessageKindGroup, groupID)
+}
+
+// UnreadCounts renvoie, pour chaque groupe de l'utilisateur, le nombre de messages des autres membres
+// envoyés après son dernier message lu
+func (s *GroupService) UnreadCounts(userID string) (map[string]int64, error) {
+	var rows []struct {
+		GroupID string
+		Unread  int64
+	}
+	if err := s.DB.Table("group_messages").
+		Select("group_messages.group_id, COUNT(*) AS unread").
+		Joins("LEFT JOIN read_cursors ON read_cursors.user_id = ? AND read_cursors.conversation_kind = ? AND read_cursors.conversation_id = group_messages.group_id", userID, models.MessageKindGroup).
+		Where("group_messages.group_id IN (?)", s.DB.Model(&models.GroupMember{}).Select("group_id").Where("user_id = ?", userID)).
+		Where("group_messages.sender_id <> ? AND group_messages.deleted_at IS NULL", userID).
+		Where("read_cursors.id IS NULL OR group_messages.created_at > read_cursors.last_read_message_at").
+		Group("group_messages.group_id").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.GroupID] = row.Unread
+	}
+	return counts, nil
+}
+
+// PublishTyping diffuse aux membres du groupe qu'un membre écrit ou a cessé d'écrire ; rien n'est conservé
+func (s *GroupService) PublishTyping(groupID, userID string, typing bool) error {
+	if _, err := s.member(groupID, userID); err != nil {
+		return err
+	}
+	s.publishEvent(groupID, ChatEvent{Type: "typing", UserID: userID, Typing: &typing})
+	return nil
+}
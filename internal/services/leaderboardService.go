@@ -0,0 +1,836 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ady243/teamup/internal/models"
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// Métriques disponibles pour les classements
+const (
+	MetricGoals         = "goals"
+	MetricAssists       = "assists"
+	MetricMatchesPlayed = "matches_played"
+	MetricWins          = "wins"
+	MetricWinRate       = "win_rate"
+	MetricRating        = "rating"
+	MetricFairPlay      = "fair_play"
+)
+
+// LeaderboardMetrics liste les métriques acceptées par l'API
+var LeaderboardMetrics = []string{MetricGoals, MetricAssists, MetricMatchesPlayed, MetricWins, MetricWinRate, MetricRating, MetricFairPlay}
+
+// counterMetrics sont les métriques incrémentées match après match
+var counterMetrics = []string{MetricGoals, MetricAssists, MetricMatchesPlayed, MetricWins}
+
+const (
+	allTimePeriod      = "all"
+	leaderboardGeoKey  = "leaderboard:geo"
+	leaderboardKeysKey = "leaderboard:keys"
+	// Nombre minimum de matchs pour apparaître dans le classement au pourcentage de victoires
+	minWinRateMatches = 5
+	defaultRadiusKm   = 25
+	// Nombre d'essais d'ApplyMatch quand une autre instance met à jour le même match en même temps
+	applyMatchAttempts = 5
+)
+
+var ErrUnknownMetric = errors.New("unknown leaderboard metric")
+
+// LeaderboardService maintient les classements dans des sorted sets Redis.
+// Les compteurs sont mis à jour match par match et reconstruits périodiquement depuis Postgres.
+type LeaderboardService struct {
+	DB          *gorm.DB
+	RedisClient *redis.Client
+	mutex       sync.Mutex
+}
+
+func NewLeaderboardService(db *gorm.DB, redisClient *redis.Client) *LeaderboardService {
+	return &LeaderboardService{
+		DB:          db,
+		RedisClient: redisClient,
+	}
+}
+
+// LeaderboardQuery décrit le classement demandé
+type LeaderboardQuery struct {
+	Metric    string  `json:"metric"`
+	Scope     string  `json:"scope"` // global, city, club ou radius
+	Value     string  `json:"value,omitempty"`
+	Season    string  `json:"season"` // "all" ou année de début de saison (ex. "2024" pour 2024-2025)
+	Latitude  float64 `json:"latitude,omitempty"`
+	Longitude float64 `json:"longitude,omitempty"`
+	RadiusKm  float64 `json:"radius_km,omitempty"`
+	Offset    int64   `json:"offset"`
+	Limit     int64   `json:"limit"`
+}
+
+// LeaderboardEntry est une ligne d'un classement
+type LeaderboardEntry struct {
+	Rank         int64   `json:"rank"`
+	PlayerID     string  `json:"player_id"`
+	Username     string  `json:"username"`
+	ProfilePhoto string  `json:"profile_photo"`
+	Score        float64 `json:"score"`
+}
+
+// playerContribution est l'apport d'un joueur aux compteurs lors d'un match
+type playerContribution struct {
+	Goals   int `json:"goals"`
+	Assists int `json:"assists"`
+	Played  int `json:"played"`
+	Wins    int `json:"wins"`
+}
+
+func (c playerContribution) value(metric string) int {
+	switch metric {
+	case MetricGoals:
+		return c.Goals
+	case MetricAssists:
+		return c.Assists
+	case MetricMatchesPlayed:
+		return c.Played
+	case MetricWins:
+		return c.Wins
+	}
+	return 0
+}
+
+// SeasonOf renvoie la saison d'une date : une saison va d'août à juillet et porte l'année de son début
+func SeasonOf(date time.Time) string {
+	year := date.Year()
+	if date.Month() < time.August {
+		year--
+	}
+	return strconv.Itoa(year)
+}
+
+func leaderboardKey(period, metric, scope string) string {
+	return "leaderboard:" + period + ":" + metric + ":" + scope
+}
+
+func matchContributionKey(matchID string) string {
+	return "leaderboard:match:" + matchID
+}
+
+func normalizeScopeValue(value string) string {
+	return strings.Join(strings.Fields(strings.ToLower(value)), "_")
+}
+
+// userScopes renvoie les portées de classement d'un joueur : global, sa ville et son club
+func userScopes(user models.Users) []string {
+	scopes := []string{"global"}
+	if city := normalizeScopeValue(user.Location); city != "" {
+		scopes = append(scopes, "city:"+city)
+	}
+	if club := normalizeScopeValue(user.Club); club != "" {
+		scopes = append(scopes, "club:"+club)
+	}
+	return scopes
+}
+
+func isLeaderboardMetric(metric string) bool {
+	for _, m := range LeaderboardMetrics {
+		if m == metric {
+			return true
+		}
+	}
+	return false
+}
+
+// matchContributions calcule l'apport de chaque joueur d'un match terminé
+func matchContributions(match models.Matches, players []models.MatchPlayers, events []models.Analyst) map[string]playerContribution {
+	contributions := make(map[string]playerContribution)
+	if match.Status != models.Completed {
+		return contributions
+	}
+
+	winner := 0
+	if match.ScoreTeam1 > match.ScoreTeam2 {
+		winner = 1
+	} else if match.ScoreTeam2 > match.ScoreTeam1 {
+		winner = 2
+	}
+	for _, player := range players {
+		contribution := playerContribution{Played: 1}
+		if winner != 0 && player.TeamNumber != nil && *player.TeamNumber == winner {
+			contribution.Wins = 1
+		}
+		contributions[player.PlayerID] = contribution
+	}
+
+	// Comme dans le rapport de match, une passe décisive saisie sur le but et aussi comme événement
+	// séparé de la même minute n'est comptée qu'une fois
+	goalAssists := make(map[string]int)
+	for _, event := range events {
+		if event.EventType == models.EventGoal && event.Payload.AssistBy != nil {
+			goalAssists[assistKey(*event.Payload.AssistBy, event.Minute)]++
+		}
+	}
+
+	for _, event := range events {
+		switch event.EventType {
+		case models.EventGoal:
+			if contribution, ok := contributions[event.PlayerID]; ok {
+				contribution.Goals++
+				contributions[event.PlayerID] = contribution
+			}
+			if event.Payload.AssistBy != nil {
+				if contribution, ok := contributions[*event.Payload.AssistBy]; ok {
+					contribution.Assists++
+					contributions[*event.Payload.AssistBy] = contribution
+				}
+			}
+		case models.EventAssist:
+			if key := assistKey(event.PlayerID, event.Minute); goalAssists[key] > 0 {
+				goalAssists[key]--
+				break
+			}
+			if contribution, ok := contributions[event.PlayerID]; ok {
+				contribution.Assists++
+				contributions[event.PlayerID] = contribution
+			}
+		}
+	}
+	return contributions
+}
+
+// ApplyMatch met à jour les classements avec le résultat d'un match.
+// L'apport déjà compté pour ce match est retiré avant d'ajouter le nouveau :
+// l'appel peut donc être répété sans compter deux fois le même match.
+func (s *LeaderboardService) ApplyMatch(matchID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ctx := context.Background()
+
+	var match models.Matches
+	if err := s.DB.Where("id = ?", matchID).First(&match).Error; err != nil {
+		return err
+	}
+	contributions := make(map[string]playerContribution)
+	if match.DeletedAt == nil {
+		var players []models.MatchPlayers
+		if err := s.DB.Where("match_id = ?", matchID).Find(&players).Error; err != nil {
+			return err
+		}
+		var events []models.Analyst
+		if err := s.DB.Where("match_id = ? AND deleted_at IS NULL AND event_type IN ?", matchID, []models.EventType{models.EventGoal, models.EventAssist}).
+			Find(&events).Error; err != nil {
+			return err
+		}
+		contributions = matchContributions(match, players, events)
+	}
+
+	// L'apport enregistré pour le match est surveillé (WATCH) : si une autre instance l'applique en même temps,
+	// la transaction échoue et l'écart est recalculé à partir de l'apport qu'elle vient d'enregistrer
+	periods := []string{allTimePeriod, SeasonOf(match.MatchDate)}
+	var users []models.Users
+	apply := func(tx *redis.Tx) error {
+		var err error
+		users, err = s.applyContributions(ctx, tx, matchID, contributions, periods)
+		return err
+	}
+	err := s.RedisClient.Watch(ctx, apply, matchContributionKey(matchID))
+	for attempt := 1; errors.Is(err, redis.TxFailedErr) && attempt < applyMatchAttempts; attempt++ {
+		err = s.RedisClient.Watch(ctx, apply, matchContributionKey(matchID))
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.refreshPlayers(ctx, users, periods)
+}
+
+// applyContributions remplace l'apport enregistré d'un match par le nouveau et reporte l'écart sur
+// les classements, dans une même transaction. Renvoie les joueurs concernés.
+func (s *LeaderboardService) applyContributions(ctx context.Context, tx *redis.Tx, matchID string, contributions map[string]playerContribution, periods []string) ([]models.Users, error) {
+	stored, err := tx.HGetAll(ctx, matchContributionKey(matchID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	previous := make(map[string]playerContribution)
+	for playerID, raw := range stored {
+		var contribution playerContribution
+		if err := json.Unmarshal([]byte(raw), &contribution); err == nil {
+			previous[playerID] = contribution
+		}
+	}
+
+	playerIDs := make([]string, 0, len(contributions)+len(previous))
+	for playerID := range contributions {
+		playerIDs = append(playerIDs, playerID)
+	}
+	for playerID := range previous {
+		if _, ok := contributions[playerID]; !ok {
+			playerIDs = append(playerIDs, playerID)
+		}
+	}
+	if len(playerIDs) == 0 {
+		return nil, nil
+	}
+
+	var users []models.Users
+	if err := s.DB.Where("id IN ? AND deleted_at IS NULL", playerIDs).Find(&users).Error; err != nil {
+		return nil, err
+	}
+
+	_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, user := range users {
+			for _, metric := range counterMetrics {
+				delta := contributions[user.ID].value(metric) - previous[user.ID].value(metric)
+				if delta == 0 {
+					continue
+				}
+				for _, period := range periods {
+					for _, scope := range userScopes(user) {
+						key := leaderboardKey(period, metric, scope)
+						pipe.ZIncrBy(ctx, key, float64(delta), user.ID)
+						pipe.SAdd(ctx, leaderboardKeysKey, key)
+					}
+				}
+			}
+		}
+		pipe.Del(ctx, matchContributionKey(matchID))
+		for playerID, contribution := range contributions {
+			raw, err := json.Marshal(contribution)
+			if err != nil {
+				return err
+			}
+			pipe.HSet(ctx, matchContributionKey(matchID), playerID, raw)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// ApplyRecentlyCompleted met à jour les classements avec les matchs terminés ou modifiés depuis une date
+func (s *LeaderboardService) ApplyRecentlyCompleted(since time.Time) error {
+	var matchIDs []string
+	if err := s.DB.Model(&models.Matches{}).
+		Where("status = ? AND updated_at >= ?", models.Completed, since).
+		Pluck("id", &matchIDs).Error; err != nil {
+		return err
+	}
+	for _, matchID := range matchIDs {
+		if err := s.ApplyMatch(matchID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refreshPlayers recalcule les métriques qui ne sont pas des compteurs (pourcentage de victoires,
+// note et fair-play) ainsi que la position géographique des joueurs donnés
+func (s *LeaderboardService) refreshPlayers(ctx context.Context, users []models.Users, periods []string) error {
+	if len(users) == 0 {
+		return nil
+	}
+	playerIDs := make([]string, len(users))
+	for i, user := range users {
+		playerIDs[i] = user.ID
+	}
+	var ratings []models.PlayerRating
+	if err := s.DB.Where("player_id IN ?", playerIDs).Find(&ratings).Error; err != nil {
+		return err
+	}
+	ratingByPlayer := make(map[string]models.PlayerRating)
+	for _, rating := range ratings {
+		ratingByPlayer[rating.PlayerID] = rating
+	}
+	fairPlayByPlayer, err := s.fairPlayScores(playerIDs)
+	if err != nil {
+		return err
+	}
+
+	// Lecture des compteurs globaux, qui servent de base au pourcentage de victoires
+	read := s.RedisClient.Pipeline()
+	played := make(map[string]*redis.FloatCmd)
+	wins := make(map[string]*redis.FloatCmd)
+	for _, user := range users {
+		for _, period := range periods {
+			played[period+":"+user.ID] = read.ZScore(ctx, leaderboardKey(period, MetricMatchesPlayed, "global"), user.ID)
+			wins[period+":"+user.ID] = read.ZScore(ctx, leaderboardKey(period, MetricWins, "global"), user.ID)
+		}
+	}
+	if _, err := read.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+
+	pipe := s.RedisClient.TxPipeline()
+	for _, user := range users {
+		if user.Latitude != 0 || user.Longitude != 0 {
+			pipe.GeoAdd(ctx, leaderboardGeoKey, &redis.GeoLocation{Name: user.ID, Latitude: user.Latitude, Longitude: user.Longitude})
+		}
+		for _, period := range periods {
+			matches, _ := played[period+":"+user.ID].Result()
+			won, _ := wins[period+":"+user.ID].Result()
+			gauges := playerGauges(ratingByPlayer[user.ID], fairPlayByPlayer[user.ID], matches, won)
+			for _, metric := range []string{MetricWinRate, MetricRating, MetricFairPlay} {
+				for _, scope := range userScopes(user) {
+					key := leaderboardKey(period, metric, scope)
+					if score, ok := gauges[metric]; ok {
+						pipe.ZAdd(ctx, key, &redis.Z{Score: score, Member: user.ID})
+						pipe.SAdd(ctx, leaderboardKeysKey, key)
+					} else {
+						pipe.ZRem(ctx, key, user.ID)
+					}
+				}
+			}
+		}
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// playerGauges calcule les métriques non cumulatives d'un joueur sur une période.
+// La note et le fair-play sont des valeurs globales, affichées pour les joueurs ayant joué sur la période.
+func playerGauges(rating models.PlayerRating, fairPlay, played, wins float64) map[string]float64 {
+	gauges := make(map[string]float64)
+	if played <= 0 {
+		return gauges
+	}
+	if played >= minWinRateMatches {
+		gauges[MetricWinRate] = math.Round(wins/played*1000) / 10
+	}
+	if rating.MatchesRated > 0 {
+		gauges[MetricRating] = ConservativeRating(rating.Mu, rating.Sigma)
+	}
+	if fairPlay > 0 {
+		gauges[MetricFairPlay] = fairPlay
+	}
+	return gauges
+}
+
+// fairPlayScores renvoie le fair-play des joueurs (sur 100) d'après la moyenne des notes de leurs pairs,
+// pour les joueurs ayant reçu assez de notes ; playerIDs vide renvoie tous les joueurs.
+// Le score de comportement du profil n'est pas utilisé : il n'est pas issu uniquement des notes.
+func (s *LeaderboardService) fairPlayScores(playerIDs []string) (map[string]float64, error) {
+	var rows []struct {
+		PlayerID string
+		FairPlay float64
+	}
+	query := s.DB.Model(&models.PlayerReview{}).
+		Select("player_id, AVG(fair_play) AS fair_play").
+		Group("player_id").
+		Having("COUNT(*) >= ?", minReviewsForAttributes)
+	if len(playerIDs) > 0 {
+		query = query.Where("player_id IN ?", playerIDs)
+	}
+	if err := query.Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	scores := make(map[string]float64, len(rows))
+	for _, row := range rows {
+		scores[row.PlayerID] = math.Round(row.FairPlay * 20)
+	}
+	return scores, nil
+}
+
+// Reconcile reconstruit tous les classements à partir de Postgres. Elle corrige aussi les écarts
+// que les mises à jour incrémentales ne rattrapent pas (changement de ville ou de club, événements modifiés après coup).
+// Chaque classement est écrit dans une clé temporaire puis renommé, pour que les lectures
+// ne voient jamais un classement à moitié construit.
+func (s *LeaderboardService) Reconcile() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ctx := context.Background()
+
+	var matches []models.Matches
+	if err := s.DB.Where("status = ? AND deleted_at IS NULL", models.Completed).Find(&matches).Error; err != nil {
+		return err
+	}
+	matchIDs := make([]string, len(matches))
+	for i, match := range matches {
+		matchIDs[i] = match.ID
+	}
+
+	playersByMatch := make(map[string][]models.MatchPlayers)
+	eventsByMatch := make(map[string][]models.Analyst)
+	if len(matchIDs) > 0 {
+		var players []models.MatchPlayers
+		if err := s.DB.Where("match_id IN ?", matchIDs).Find(&players).Error; err != nil {
+			return err
+		}
+		for _, player := range players {
+			playersByMatch[player.MatchID] = append(playersByMatch[player.MatchID], player)
+		}
+		var events []models.Analyst
+		if err := s.DB.Where("match_id IN ? AND deleted_at IS NULL AND event_type IN ?", matchIDs, []models.EventType{models.EventGoal, models.EventAssist}).
+			Find(&events).Error; err != nil {
+			return err
+		}
+		for _, event := range events {
+			eventsByMatch[event.MatchID] = append(eventsByMatch[event.MatchID], event)
+		}
+	}
+
+	// Totaux par période puis par joueur
+	totals := map[string]map[string]playerContribution{allTimePeriod: {}}
+	contributionsByMatch := make(map[string]map[string]playerContribution)
+	for _, match := range matches {
+		contributions := matchContributions(match, playersByMatch[match.ID], eventsByMatch[match.ID])
+		contributionsByMatch[match.ID] = contributions
+		season := SeasonOf(match.MatchDate)
+		if totals[season] == nil {
+			totals[season] = make(map[string]playerContribution)
+		}
+		for playerID, contribution := range contributions {
+			for _, period := range []string{allTimePeriod, season} {
+				total := totals[period][playerID]
+				total.Goals += contribution.Goals
+				total.Assists += contribution.Assists
+				total.Played += contribution.Played
+				total.Wins += contribution.Wins
+				totals[period][playerID] = total
+			}
+		}
+	}
+
+	var users []models.Users
+	if err := s.DB.Where("deleted_at IS NULL").Find(&users).Error; err != nil {
+		return err
+	}
+	var ratings []models.PlayerRating
+	if err := s.DB.Find(&ratings).Error; err != nil {
+		return err
+	}
+	ratingByPlayer := make(map[string]models.PlayerRating)
+	for _, rating := range ratings {
+		ratingByPlayer[rating.PlayerID] = rating
+	}
+	fairPlayByPlayer, err := s.fairPlayScores(nil)
+	if err != nil {
+		return err
+	}
+
+	boards := make(map[string][]*redis.Z)
+	var locations []*redis.GeoLocation
+	for _, user := range users {
+		if user.Latitude != 0 || user.Longitude != 0 {
+			locations = append(locations, &redis.GeoLocation{Name: user.ID, Latitude: user.Latitude, Longitude: user.Longitude})
+		}
+		for period, players := range totals {
+			total, ok := players[user.ID]
+			if !ok {
+				continue
+			}
+			scores := playerGauges(ratingByPlayer[user.ID], fairPlayByPlayer[user.ID], float64(total.Played), float64(total.Wins))
+			for _, metric := range counterMetrics {
+				scores[metric] = float64(total.value(metric))
+			}
+			for metric, score := range scores {
+				for _, scope := range userScopes(user) {
+					key := leaderboardKey(period, metric, scope)
+					boards[key] = append(boards[key], &redis.Z{Score: score, Member: user.ID})
+				}
+			}
+		}
+	}
+
+	previousKeys, err := s.RedisClient.SMembers(ctx, leaderboardKeysKey).Result()
+	if err != nil {
+		return err
+	}
+
+	for key, members := range boards {
+		if err := s.replaceSortedSet(ctx, key, members); err != nil {
+			return err
+		}
+	}
+	pipe := s.RedisClient.TxPipeline()
+	for _, key := range previousKeys {
+		if _, ok := boards[key]; !ok {
+			pipe.Del(ctx, key)
+		}
+	}
+	pipe.Del(ctx, leaderboardKeysKey)
+	for key := range boards {
+		pipe.SAdd(ctx, leaderboardKeysKey, key)
+	}
+	pipe.Del(ctx, leaderboardGeoKey)
+	if len(locations) > 0 {
+		pipe.GeoAdd(ctx, leaderboardGeoKey, locations...)
+	}
+	for matchID, contributions := range contributionsByMatch {
+		pipe.Del(ctx, matchContributionKey(matchID))
+		for playerID, contribution := range contributions {
+			raw, err := json.Marshal(contribution)
+			if err != nil {
+				return err
+			}
+			pipe.HSet(ctx, matchContributionKey(matchID), playerID, raw)
+		}
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// replaceSortedSet construit un sorted set dans une clé temporaire puis remplace la clé d'origine
+func (s *LeaderboardService) replaceSortedSet(ctx context.Context, key string, members []*redis.Z) error {
+	tmpKey := key + ":rebuild"
+	pipe := s.RedisClient.TxPipeline()
+	pipe.Del(ctx, tmpKey)
+	for start := 0; start < len(members); start += 500 {
+		end := start + 500
+		if end > len(members) {
+			end = len(members)
+		}
+		pipe.ZAdd(ctx, tmpKey, members[start:end]...)
+	}
+	pipe.Rename(ctx, tmpKey, key)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// ResolveQuery valide une requête de classement et complète les valeurs par défaut
+// (ville, club ou position) à partir du profil de l'utilisateur
+func (s *LeaderboardService) ResolveQuery(query *LeaderboardQuery, userID string) error {
+	if !isLeaderboardMetric(query.Metric) {
+		return ErrUnknownMetric
+	}
+	switch query.Season {
+	case "", allTimePeriod:
+		query.Season = allTimePeriod
+	case "current":
+		query.Season = SeasonOf(time.Now())
+	default:
+		if _, err := strconv.Atoi(query.Season); err != nil {
+			return errors.New("invalid season, expected all, current or a year")
+		}
+	}
+	if query.Limit <= 0 || query.Limit > 100 {
+		query.Limit = 20
+	}
+	if query.Offset < 0 {
+		query.Offset = 0
+	}
+	if query.Scope == "" {
+		query.Scope = "global"
+	}
+
+	var user models.Users
+	needsProfile := (query.Scope == "city" || query.Scope == "club") && query.Value == "" ||
+		query.Scope == "radius" && query.Latitude == 0 && query.Longitude == 0
+	if needsProfile {
+		if err := s.DB.Where("id = ?", userID).First(&user).Error; err != nil {
+			return err
+		}
+	}
+
+	switch query.Scope {
+	case "global":
+	case "city":
+		if query.Value == "" {
+			query.Value = user.Location
+		}
+	case "club":
+		if query.Value == "" {
+			query.Value = user.Club
+		}
+	case "radius":
+		if query.Latitude == 0 && query.Longitude == 0 {
+			query.Latitude, query.Longitude = user.Latitude, user.Longitude
+		}
+		if query.Latitude == 0 && query.Longitude == 0 {
+			return errors.New("a position is required for the radius scope")
+		}
+		if query.RadiusKm <= 0 {
+			query.RadiusKm = defaultRadiusKm
+		}
+	default:
+		return errors.New("invalid scope, expected global, city, club or radius")
+	}
+	if (query.Scope == "city" || query.Scope == "club") && normalizeScopeValue(query.Value) == "" {
+		return errors.New("a " + query.Scope + " is required for this scope")
+	}
+	return nil
+}
+
+func (q LeaderboardQuery) key() string {
+	scope := "global"
+	if q.Scope == "city" || q.Scope == "club" {
+		scope = q.Scope + ":" + normalizeScopeValue(q.Value)
+	}
+	return leaderboardKey(q.Season, q.Metric, scope)
+}
+
+// GetLeaderboard renvoie une page du classement demandé et le nombre total de joueurs classés
+func (s *LeaderboardService) GetLeaderboard(query LeaderboardQuery) ([]LeaderboardEntry, int64, error) {
+	ctx := context.Background()
+	if query.Scope == "radius" {
+		ranked, err := s.rankInRadius(ctx, query)
+		if err != nil {
+			return nil, 0, err
+		}
+		total := int64(len(ranked))
+		if query.Offset >= total {
+			return []LeaderboardEntry{}, total, nil
+		}
+		end := query.Offset + query.Limit
+		if end > total {
+			end = total
+		}
+		entries, err := s.withPlayers(ranked[query.Offset:end])
+		return entries, total, err
+	}
+
+	key := query.key()
+	total, err := s.RedisClient.ZCard(ctx, key).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	entries, err := s.rangeByRank(ctx, key, query.Offset, query.Offset+query.Limit-1)
+	return entries, total, err
+}
+
+// GetAroundPlayer renvoie la ligne d'un joueur et les joueurs classés juste avant et juste après lui
+func (s *LeaderboardService) GetAroundPlayer(query LeaderboardQuery, playerID string, around int64) ([]LeaderboardEntry, *LeaderboardEntry, error) {
+	ctx := context.Background()
+	if query.Scope == "radius" {
+		ranked, err := s.rankInRadius(ctx, query)
+		if err != nil {
+			return nil, nil, err
+		}
+		position := int64(-1)
+		for i, entry := range ranked {
+			if entry.PlayerID == playerID {
+				position = int64(i)
+				break
+			}
+		}
+		if position < 0 {
+			return []LeaderboardEntry{}, nil, nil
+		}
+		start, end := position-around, position+around+1
+		if start < 0 {
+			start = 0
+		}
+		if end > int64(len(ranked)) {
+			end = int64(len(ranked))
+		}
+		entries, err := s.withPlayers(ranked[start:end])
+		if err != nil {
+			return nil, nil, err
+		}
+		return entries, findEntry(entries, playerID), nil
+	}
+
+	key := query.key()
+	rank, err := s.RedisClient.ZRevRank(ctx, key, playerID).Result()
+	if errors.Is(err, redis.Nil) {
+		return []LeaderboardEntry{}, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	start := rank - around
+	if start < 0 {
+		start = 0
+	}
+	entries, err := s.rangeByRank(ctx, key, start, rank+around)
+	if err != nil {
+		return nil, nil, err
+	}
+	return entries, findEntry(entries, playerID), nil
+}
+
+func findEntry(entries []LeaderboardEntry, playerID string) *LeaderboardEntry {
+	for i := range entries {
+		if entries[i].PlayerID == playerID {
+			return &entries[i]
+		}
+	}
+	return nil
+}
+
+// rangeByRank lit un intervalle de rangs (du meilleur au moins bon) d'un classement
+func (s *LeaderboardService) rangeByRank(ctx context.Context, key string, start, stop int64) ([]LeaderboardEntry, error) {
+	members, err := s.RedisClient.ZRevRangeWithScores(ctx, key, start, stop).Result()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]LeaderboardEntry, len(members))
+	for i, member := range members {
+		entries[i] = LeaderboardEntry{Rank: start + int64(i) + 1, PlayerID: member.Member.(string), Score: member.Score}
+	}
+	return s.withPlayers(entries)
+}
+
+// rankInRadius classe les joueurs situés dans un rayon autour d'une position.
+// Les scores sont lus dans le classement global de la période.
+func (s *LeaderboardService) rankInRadius(ctx context.Context, query LeaderboardQuery) ([]LeaderboardEntry, error) {
+	playerIDs, err := s.RedisClient.GeoSearch(ctx, leaderboardGeoKey, &redis.GeoSearchQuery{
+		Longitude:  query.Longitude,
+		Latitude:   query.Latitude,
+		Radius:     query.RadiusKm,
+		RadiusUnit: "km",
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	key := leaderboardKey(query.Season, query.Metric, "global")
+	pipe := s.RedisClient.Pipeline()
+	scores := make([]*redis.FloatCmd, len(playerIDs))
+	for i, playerID := range playerIDs {
+		scores[i] = pipe.ZScore(ctx, key, playerID)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	ranked := make([]LeaderboardEntry, 0, len(playerIDs))
+	for i, playerID := range playerIDs {
+		score, err := scores[i].Result()
+		if err != nil {
+			continue // Joueur absent du classement
+		}
+		ranked = append(ranked, LeaderboardEntry{PlayerID: playerID, Score: score})
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].Score != ranked[j].Score {
+			return ranked[i].Score > ranked[j].Score
+		}
+		return ranked[i].PlayerID > ranked[j].PlayerID
+	})
+	for i := range ranked {
+		ranked[i].Rank = int64(i) + 1
+	}
+	return ranked, nil
+}
+
+// withPlayers complète les lignes d'un classement avec le pseudo et la photo des joueurs
+func (s *LeaderboardService) withPlayers(entries []LeaderboardEntry) ([]LeaderboardEntry, error) {
+	if len(entries) == 0 {
+		return entries, nil
+	}
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.PlayerID
+	}
+	var users []models.Users
+	if err := s.DB.Select("id", "username", "profile_photo").Where("id IN ?", ids).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[string]models.Users)
+	for _, user := range users {
+		byID[user.ID] = user
+	}
+	for i := range entries {
+		entries[i].Username = byID[entries[i].PlayerID].Username
+		entries[i].ProfilePhoto = byID[entries[i].PlayerID].ProfilePhoto
+	}
+	return entries, nil
+}
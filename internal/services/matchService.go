@@ -1,8 +1,6 @@
 package services
 
 import (
-	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
@@ -18,19 +16,30 @@ import (
 
 // MatchService fournit les services pour gérer les matchs
 type MatchService struct {
-	DB          *gorm.DB
-	ChatService *ChatService
-	RedisClient *redis.Client
+	DB                  *gorm.DB
+	ChatService         *ChatService
+	RedisClient         *redis.Client
+	NotificationService *NotificationService
 }
 
-func NewMatchService(db *gorm.DB, chatService *ChatService, redisClient *redis.Client) *MatchService {
+func NewMatchService(db *gorm.DB, chatService *ChatService, redisClient *redis.Client, notificationService *NotificationService) *MatchService {
 	return &MatchService{
-		DB:          db,
-		ChatService: chatService,
-		RedisClient: redisClient,
+		DB:                  db,
+		ChatService:         chatService,
+		RedisClient:         redisClient,
+		NotificationService: notificationService,
 	}
 }
 
+var (
+	ErrMatchNotFound          = errors.New("match not found")
+	ErrNotMatchOrganizer      = errors.New("you are not authorized to confirm this match result")
+	ErrResultAlreadyConfirmed = errors.New("match result already confirmed")
+)
+
+// Délai avant le début d'un match auquel ses joueurs reçoivent un rappel
+const matchReminderLead = 2 * time.Hour
+
 // CreateMatch crée un nouveau match dans la base de données et met à jour le rôle de l'utilisateur
 func (s *MatchService) CreateMatch(match *models.Matches, userID string) error {
 	// Générer un nouvel ID pour le match
@@ -102,7 +111,7 @@ func (s *MatchService) GetMatchByID(matchID string) (*models.Matches, error) {
 	var match models.Matches
 	if err := s.DB.Preload("Organizer").Where("id = ?", matchID).First(&match).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("match not found")
+			return nil, ErrMatchNotFound
 		}
 		return nil, err
 	}
@@ -144,7 +153,7 @@ func (s *MatchService) DeleteMatch(matchID, userID string) error {
 		return err
 	}
 
-	// Supprime les messages de chat associés au match
+	// Vide le cache du chat ; les messages en base suivent la politique de rétention du match
 	if err := s.ChatService.DeleteChatMessages(matchID); err != nil {
 		return err
 	}
@@ -207,6 +216,19 @@ func (s *MatchService) IsUserInMatch(matchID, userID string) error {
 	return nil
 }
 
+// CanWatchLive vérifie qu'un utilisateur peut suivre les événements en direct d'un match :
+// tout le monde pour un direct public, seulement l'organisateur, l'arbitre et les joueurs sinon
+func (s *MatchService) CanWatchLive(matchID, userID string) (bool, error) {
+	match, err := s.GetMatchByID(matchID)
+	if err != nil {
+		return false, err
+	}
+	if !match.PrivateLive || match.OrganizerID == userID || (match.RefereeID != nil && *match.RefereeID == userID) {
+		return true, nil
+	}
+	return s.IsUserInMatch(matchID, userID) == nil, nil
+}
+
 // GetMatchByOrganizerID récupère les matchs par l'ID de l'organisateur
 func (s *MatchService) GetMatchByOrganizerID(organizerID string) ([]models.Matches, error) {
 	var matches []models.Matches
@@ -240,12 +262,7 @@ func (s *MatchService) NotifyMatchStatusUpdate(matchID string, status string) er
 		"match_id": matchID,
 		"status":   status,
 	}
-	messageJSON, err := json.Marshal(message)
-	if err != nil {
-		return err
-	}
-
-	return s.RedisClient.Publish(context.Background(), "match_status_updates", messageJSON).Err()
+	return PublishRoomEvent(s.RedisClient, MatchStatusRoom, EventMatchStatus, message)
 }
 
 func (s *MatchService) UpdateMatchStatuses() error {
@@ -257,7 +274,7 @@ func (s *MatchService) UpdateMatchStatuses() error {
 	now := time.Now()
 	for _, match := range matches {
 		if match.Status != models.Completed && match.Status != models.Expired {
-			matchStart := time.Date(match.MatchDate.Year(), match.MatchDate.Month(), match.MatchDate.Day(), match.MatchTime.Hour(), match.MatchTime.Minute(), match.MatchTime.Second(), 0, time.UTC)
+			matchStart := matchStartTime(match)
 			matchEnd := time.Date(match.MatchDate.Year(), match.MatchDate.Month(), match.MatchDate.Day(), match.EndTime.Hour(), match.EndTime.Minute(), match.EndTime.Second(), 0, time.UTC)
 
 			if now.After(matchStart) && now.Before(matchEnd) {
@@ -281,6 +298,54 @@ func (s *MatchService) UpdateMatchStatuses() error {
 	return nil
 }
 
+// matchStartTime renvoie le début d'un match, à partir de sa date et de son heure
+func matchStartTime(match models.Matches) time.Time {
+	return time.Date(match.MatchDate.Year(), match.MatchDate.Month(), match.MatchDate.Day(), match.MatchTime.Hour(), match.MatchTime.Minute(), match.MatchTime.Second(), 0, time.UTC)
+}
+
+// SendMatchReminders rappelle leur match aux joueurs des matchs qui commencent dans moins de deux heures.
+// Le rappel n'est envoyé qu'une fois par match, même avec plusieurs instances de l'API.
+func (s *MatchService) SendMatchReminders() error {
+	now := time.Now()
+	var matches []models.Matches
+	if err := s.DB.Where("status NOT IN ? AND reminder_sent_at IS NULL AND deleted_at IS NULL", []models.Status{models.Completed, models.Expired}).
+		Where("match_date BETWEEN ? AND ?", now.AddDate(0, 0, -1), now.Add(matchReminderLead).AddDate(0, 0, 1)).
+		Find(&matches).Error; err != nil {
+		return err
+	}
+
+	for _, match := range matches {
+		start := matchStartTime(match)
+		if start.Before(now) || start.After(now.Add(matchReminderLead)) {
+			continue
+		}
+		err := s.DB.Transaction(func(tx *gorm.DB) error {
+			result := tx.Model(&models.Matches{}).Where("id = ? AND reminder_sent_at IS NULL", match.ID).Update("reminder_sent_at", now)
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			var playerIDs []string
+			if err := tx.Model(&models.MatchPlayers{}).Where("match_id = ?", match.ID).Pluck("player_id", &playerIDs).Error; err != nil {
+				return err
+			}
+			if len(playerIDs) == 0 {
+				return nil
+			}
+			return s.NotificationService.WithTx(tx).NotifyUsers(
+				playerIDs,
+				models.NotificationMatchReminder,
+				"Votre match commence à "+start.Format("15:04"),
+				"Rendez-vous au "+match.Address,
+				models.NotificationData{"screen": "match", "match_id": match.ID},
+			)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s *MatchService) AssignReferee(matchID, organizerID, refereeID string) error {
 	// Vérifier si l'utilisateur est l'organisateur du match
 	var match models.Matches
@@ -312,3 +377,69 @@ func (s *MatchService) LeaveMatch(matchID, userID string) error {
 	}
 	return nil
 }
+
+// ConfirmMatchResult enregistre le score final d'un match et le marque comme terminé.
+// Seul l'organisateur peut confirmer le résultat, et une seule fois.
+func (s *MatchService) ConfirmMatchResult(matchID, organizerID string, scoreTeam1, scoreTeam2 int) (*models.Matches, error) {
+	if scoreTeam1 < 0 || scoreTeam2 < 0 {
+		return nil, errors.New("scores cannot be negative")
+	}
+
+	match, err := s.GetMatchByID(matchID)
+	if err != nil {
+		return nil, err
+	}
+	if match.OrganizerID != organizerID {
+		return nil, ErrNotMatchOrganizer
+	}
+	if match.ResultConfirmedAt != nil {
+		return nil, ErrResultAlreadyConfirmed
+	}
+
+	now := time.Now()
+	// Les joueurs sont prévenus du résultat dans la même transaction que sa confirmation. La mise à jour
+	// conditionnelle garantit qu'un résultat n'est confirmé qu'une fois, même par deux requêtes simultanées.
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Matches{}).
+			Where("id = ? AND result_confirmed_at IS NULL", match.ID).
+			Updates(map[string]interface{}{
+				"score_team1":         scoreTeam1,
+				"score_team2":         scoreTeam2,
+				"status":              models.Completed,
+				"result_confirmed_at": now,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrResultAlreadyConfirmed
+		}
+		var playerIDs []string
+		if err := tx.Model(&models.MatchPlayers{}).Where("match_id = ? AND player_id <> ?", match.ID, organizerID).Pluck("player_id", &playerIDs).Error; err != nil {
+			return err
+		}
+		if len(playerIDs) == 0 {
+			return nil
+		}
+		return s.NotificationService.WithTx(tx).NotifyUsers(
+			playerIDs,
+			models.NotificationMatchResult,
+			"Résultat du match",
+			fmt.Sprintf("Score final : %d - %d", scoreTeam1, scoreTeam2),
+			models.NotificationData{"screen": "match_report", "match_id": match.ID},
+		)
+	})
+	if err != nil {
+		return nil, err
+	}
+	match.ScoreTeam1 = scoreTeam1
+	match.ScoreTeam2 = scoreTeam2
+	match.Status = models.Completed
+	match.ResultConfirmedAt = &now
+
+	if err := s.NotifyMatchStatusUpdate(match.ID, string(match.Status)); err != nil {
+		log.Println("Erreur lors de la notification de la mise à jour du statut du match:", err)
+	}
+
+	return match, nil
+}
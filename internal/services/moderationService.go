@@ -0,0 +1,198 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/ady243/teamup/internal/models"
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrReportNotFound    = errors.New("report not found")
+	ErrAlreadyReported   = errors.New("you already reported this message")
+	ErrReportResolved    = errors.New("report has already been resolved")
+	ErrNotAdmin          = errors.New("admin access required")
+	ErrInvalidModeration = errors.New("invalid moderation request")
+)
+
+// Actions possibles sur un signalement
+const (
+	ModerationDismiss       = "dismiss"
+	ModerationDeleteMessage = "delete_message"
+)
+
+// ModerationService gère les signalements de messages et la file de modération des administrateurs
+type ModerationService struct {
+	DB                *gorm.DB
+	ChatService       *ChatService
+	FriendChatService *FriendChatService
+}
+
+func NewModerationService(db *gorm.DB, chatService *ChatService, friendChatService *FriendChatService) *ModerationService {
+	return &ModerationService{
+		DB:                db,
+		ChatService:       chatService,
+		FriendChatService: friendChatService,
+	}
+}
+
+// IsAdmin vérifie que l'utilisateur a accès à la file de modération
+func (s *ModerationService) IsAdmin(userID string) bool {
+	var count int64
+	s.DB.Model(&models.Users{}).Where("id = ? AND is_admin = ?", userID, true).Count(&count)
+	return count > 0
+}
+
+func validReason(reason string) bool {
+	for _, r := range models.ReportReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// ReportMessage enregistre le signalement d'un message par un membre de sa conversation.
+// Un utilisateur ne peut signaler ni ses propres messages ni deux fois le même message.
+func (s *ModerationService) ReportMessage(reporterID, kind, messageID, reason, details string) (*models.MessageReport, error) {
+	if !validReason(reason) {
+		return nil, fmt.Errorf("%w: reason must be one of %v", ErrInvalidModeration, models.ReportReasons)
+	}
+
+	report := models.MessageReport{
+		MessageKind: kind,
+		MessageID:   messageID,
+		ReporterID:  reporterID,
+		Reason:      reason,
+		Details:     details,
+		Status:      models.ModerationPending,
+	}
+	switch kind {
+	case models.MessageKindMatch:
+		var message models.ChatMessage
+		if err := s.DB.Where("id = ?", messageID).First(&message).Error; err != nil {
+			return nil, ErrMessageNotFound
+		}
+		if !s.ChatService.IsChatMember(message.MatchID, reporterID) {
+			return nil, ErrMessageNotFound
+		}
+		report.ReportedUserID = message.PlayerID
+		report.ConversationID = message.MatchID
+		report.Content = message.Message
+	case models.MessageKindFriend:
+		id, err := strconv.ParseUint(messageID, 10, 64)
+		if err != nil {
+			return nil, ErrMessageNotFound
+		}
+		message, err := s.FriendChatService.findMessage(uint(id), reporterID)
+		if err != nil {
+			return nil, err
+		}
+		report.ReportedUserID = message.SenderID
+		report.ConversationID = message.SenderID
+		report.Content = message.Content
+	default:
+		return nil, fmt.Errorf("%w: unknown message kind %q", ErrInvalidModeration, kind)
+	}
+	if report.ReportedUserID == reporterID {
+		return nil, fmt.Errorf("%w: you cannot report your own message", ErrInvalidModeration)
+	}
+
+	t := time.Now()
+	entropy := ulid.Monotonic(rand.New(rand.NewSource(t.UnixNano())), 0)
+	report.ID = ulid.MustNew(ulid.Timestamp(t), entropy).String()
+	result := s.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&report)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrAlreadyReported
+	}
+	return &report, nil
+}
+
+// GetReports renvoie la file de modération, des signalements les plus anciens aux plus récents
+func (s *ModerationService) GetReports(status models.MessageReportStatus, limit, offset int) ([]models.MessageReport, int64, error) {
+	if limit <= 0 || limit > 100 {
+		limit = 50
+	}
+	query := s.DB.Model(&models.MessageReport{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var reports []models.MessageReport
+	if err := query.Order("created_at asc").Limit(limit).Offset(offset).Find(&reports).Error; err != nil {
+		return nil, 0, err
+	}
+	return reports, total, nil
+}
+
+// ResolveReport classe un signalement sans suite ou supprime le message signalé pour tous.
+// Les autres signalements en attente du même message sont résolus en même temps.
+func (s *ModerationService) ResolveReport(reportID, adminID, action, note string) (*models.MessageReport, error) {
+	var report models.MessageReport
+	if err := s.DB.Where("id = ?", reportID).First(&report).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrReportNotFound
+		}
+		return nil, err
+	}
+	if report.Status != models.ModerationPending {
+		return nil, ErrReportResolved
+	}
+
+	status := models.ModerationDismissed
+	switch action {
+	case ModerationDismiss:
+	case ModerationDeleteMessage:
+		status = models.ModerationActioned
+		if err := s.deleteReportedMessage(&report); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: action must be %q or %q", ErrInvalidModeration, ModerationDismiss, ModerationDeleteMessage)
+	}
+
+	now := time.Now()
+	if err := s.DB.Model(&models.MessageReport{}).
+		Where("message_kind = ? AND message_id = ? AND status = ?", report.MessageKind, report.MessageID, models.ModerationPending).
+		Updates(map[string]interface{}{"status": status, "resolved_by": adminID, "resolved_at": now, "resolution": note}).Error; err != nil {
+		return nil, err
+	}
+
+	report.Status = status
+	report.ResolvedBy = &adminID
+	report.ResolvedAt = &now
+	report.Resolution = note
+	return &report, nil
+}
+
+// deleteReportedMessage supprime pour tous le message visé par un signalement
+func (s *ModerationService) deleteReportedMessage(report *models.MessageReport) error {
+	if report.MessageKind == models.MessageKindFriend {
+		var message models.Message
+		if err := s.DB.Where("id = ?", report.MessageID).First(&message).Error; err != nil {
+			return ErrMessageNotFound
+		}
+		_, err := s.FriendChatService.removeMessage(&message)
+		return err
+	}
+
+	message, err := s.ChatService.findMessage(report.ConversationID, report.MessageID)
+	if err != nil {
+		return err
+	}
+	_, err = s.ChatService.removeMessage(message)
+	return err
+}
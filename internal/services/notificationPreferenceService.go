@@ -0,0 +1,301 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ady243/teamup/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Fuseau horaire des heures calmes tant que l'utilisateur n'en a pas choisi
+const defaultNotificationTimezone = "Europe/Paris"
+
+var (
+	ErrInvalidPreference = errors.New("invalid notification preference")
+	ErrMuteNotFound      = errors.New("mute not found")
+)
+
+// PreferenceInput modifie les canaux d'une catégorie ; les champs absents sont inchangés
+type PreferenceInput struct {
+	Push  *bool `json:"push"`
+	Email *bool `json:"email"`
+	InApp *bool `json:"in_app"`
+}
+
+// SettingsInput modifie les réglages communs ; les champs absents sont inchangés.
+// Des heures calmes vides les désactivent.
+type SettingsInput struct {
+	Timezone     *string `json:"timezone"`
+	QuietStart   *string `json:"quiet_start"`
+	QuietEnd     *string `json:"quiet_end"`
+	HidePreviews *bool   `json:"hide_previews"`
+}
+
+// MuteInput coupe une conversation jusqu'à Until, ou indéfiniment si Until est absent
+type MuteInput struct {
+	Conversation string     `json:"conversation"`
+	Until        *time.Time `json:"until"`
+}
+
+// delivery indique sur quels canaux une notification est envoyée à un destinataire
+type delivery struct {
+	inApp       bool
+	push        bool
+	email       bool
+	hidePreview bool
+}
+
+// NotificationPreferenceService gère les préférences de notification des utilisateurs : canaux par
+// catégorie, heures calmes et conversations coupées
+type NotificationPreferenceService struct {
+	DB *gorm.DB
+}
+
+func NewNotificationPreferenceService(db *gorm.DB) *NotificationPreferenceService {
+	return &NotificationPreferenceService{DB: db}
+}
+
+func defaultPreference(userID string, category models.NotificationCategory) models.NotificationPreference {
+	return models.NotificationPreference{UserID: userID, Category: category, Push: true, InApp: true}
+}
+
+// GetPreferences renvoie les préférences de l'utilisateur pour toutes les catégories, valeurs par défaut comprises
+func (s *NotificationPreferenceService) GetPreferences(userID string) ([]models.NotificationPreference, error) {
+	var stored []models.NotificationPreference
+	if err := s.DB.Where("user_id = ?", userID).Find(&stored).Error; err != nil {
+		return nil, err
+	}
+	byCategory := make(map[models.NotificationCategory]models.NotificationPreference, len(stored))
+	for _, preference := range stored {
+		byCategory[preference.Category] = preference
+	}
+
+	preferences := make([]models.NotificationPreference, len(models.NotificationCategories))
+	for i, category := range models.NotificationCategories {
+		preference, ok := byCategory[category]
+		if !ok {
+			preference = defaultPreference(userID, category)
+		}
+		preferences[i] = preference
+	}
+	return preferences, nil
+}
+
+// UpdatePreference modifie les canaux sur lesquels l'utilisateur reçoit une catégorie de notifications
+func (s *NotificationPreferenceService) UpdatePreference(userID string, category models.NotificationCategory, input PreferenceInput) (*models.NotificationPreference, error) {
+	if !validCategory(category) {
+		return nil, ErrInvalidPreference
+	}
+	var preference models.NotificationPreference
+	result := s.DB.Where("user_id = ? AND category = ?", userID, category).Limit(1).Find(&preference)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		preference = defaultPreference(userID, category)
+	}
+	if input.Push != nil {
+		preference.Push = *input.Push
+	}
+	if input.Email != nil {
+		preference.Email = *input.Email
+	}
+	if input.InApp != nil {
+		preference.InApp = *input.InApp
+	}
+	if err := s.DB.Save(&preference).Error; err != nil {
+		return nil, err
+	}
+	return &preference, nil
+}
+
+// GetSettings renvoie les réglages communs de l'utilisateur, ou les réglages par défaut
+func (s *NotificationPreferenceService) GetSettings(userID string) (*models.NotificationSettings, error) {
+	var settings models.NotificationSettings
+	result := s.DB.Where("user_id = ?", userID).Limit(1).Find(&settings)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		settings = models.NotificationSettings{UserID: userID, Timezone: defaultNotificationTimezone}
+	}
+	return &settings, nil
+}
+
+// UpdateSettings modifie le fuseau horaire, les heures calmes et le masquage du contenu des messages
+func (s *NotificationPreferenceService) UpdateSettings(userID string, input SettingsInput) (*models.NotificationSettings, error) {
+	settings, err := s.GetSettings(userID)
+	if err != nil {
+		return nil, err
+	}
+	if input.Timezone != nil {
+		if _, err := time.LoadLocation(*input.Timezone); err != nil || *input.Timezone == "" {
+			return nil, ErrInvalidPreference
+		}
+		settings.Timezone = *input.Timezone
+	}
+	if input.QuietStart != nil {
+		settings.QuietStart = strings.TrimSpace(*input.QuietStart)
+	}
+	if input.QuietEnd != nil {
+		settings.QuietEnd = strings.TrimSpace(*input.QuietEnd)
+	}
+	// Les heures calmes sont soit toutes deux vides, soit toutes deux au format HH:MM
+	if (settings.QuietStart == "") != (settings.QuietEnd == "") {
+		return nil, ErrInvalidPreference
+	}
+	if settings.QuietStart != "" {
+		if _, ok := minuteOfDay(settings.QuietStart); !ok {
+			return nil, ErrInvalidPreference
+		}
+		if _, ok := minuteOfDay(settings.QuietEnd); !ok {
+			return nil, ErrInvalidPreference
+		}
+	}
+	if input.HidePreviews != nil {
+		settings.HidePreviews = *input.HidePreviews
+	}
+	if err := s.DB.Save(settings).Error; err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// GetMutes renvoie les conversations coupées de l'utilisateur qui n'ont pas expiré
+func (s *NotificationPreferenceService) GetMutes(userID string) ([]models.NotificationMute, error) {
+	mutes := []models.NotificationMute{}
+	err := s.DB.Where("user_id = ? AND (until IS NULL OR until > ?)", userID, time.Now()).
+		Order("created_at desc").Find(&mutes).Error
+	return mutes, err
+}
+
+// Mute coupe les notifications push et email d'une conversation, ou prolonge une coupure existante
+func (s *NotificationPreferenceService) Mute(userID string, input MuteInput) (*models.NotificationMute, error) {
+	if !validConversation(input.Conversation) || (input.Until != nil && !input.Until.After(time.Now())) {
+		return nil, ErrInvalidPreference
+	}
+	mute := models.NotificationMute{UserID: userID, Conversation: input.Conversation, Until: input.Until, CreatedAt: time.Now()}
+	if err := s.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "conversation"}},
+		DoUpdates: clause.AssignmentColumns([]string{"until"}),
+	}).Create(&mute).Error; err != nil {
+		return nil, err
+	}
+	return &mute, nil
+}
+
+// Unmute rétablit les notifications d'une conversation
+func (s *NotificationPreferenceService) Unmute(userID, conversation string) error {
+	result := s.DB.Where("user_id = ? AND conversation = ?", userID, conversation).Delete(&models.NotificationMute{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrMuteNotFound
+	}
+	return nil
+}
+
+// PurgeExpiredMutes supprime les coupures de conversation arrivées à échéance
+func (s *NotificationPreferenceService) PurgeExpiredMutes() (int64, error) {
+	result := s.DB.Where("until IS NOT NULL AND until <= ?", time.Now()).Delete(&models.NotificationMute{})
+	return result.RowsAffected, result.Error
+}
+
+// deliveries calcule, pour chaque destinataire, les canaux sur lesquels envoyer une notification
+// de la catégorie, en tenant compte des heures calmes et de la coupure de la conversation
+func (s *NotificationPreferenceService) deliveries(userIDs []string, category models.NotificationCategory, conversation string, now time.Time) (map[string]delivery, error) {
+	var preferences []models.NotificationPreference
+	if err := s.DB.Where("user_id IN ? AND category = ?", userIDs, category).Find(&preferences).Error; err != nil {
+		return nil, err
+	}
+	var settings []models.NotificationSettings
+	if err := s.DB.Where("user_id IN ?", userIDs).Find(&settings).Error; err != nil {
+		return nil, err
+	}
+	var muted []string
+	if conversation != "" {
+		if err := s.DB.Model(&models.NotificationMute{}).
+			Where("user_id IN ? AND conversation = ? AND (until IS NULL OR until > ?)", userIDs, conversation, now).
+			Pluck("user_id", &muted).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	result := make(map[string]delivery, len(userIDs))
+	for _, userID := range userIDs {
+		result[userID] = delivery{inApp: true, push: true}
+	}
+	for _, preference := range preferences {
+		result[preference.UserID] = delivery{inApp: preference.InApp, push: preference.Push, email: preference.Email}
+	}
+	for _, userSettings := range settings {
+		d := result[userSettings.UserID]
+		d.hidePreview = userSettings.HidePreviews
+		if inQuietHours(userSettings, now) {
+			d.push, d.email = false, false
+		}
+		result[userSettings.UserID] = d
+	}
+	for _, userID := range muted {
+		d := result[userID]
+		d.push, d.email = false, false
+		result[userID] = d
+	}
+	return result, nil
+}
+
+// inQuietHours indique si l'heure donnée tombe dans les heures calmes de l'utilisateur, dans son fuseau.
+// Une plage dont la fin précède le début (ex. 22:00 à 07:00) passe minuit.
+func inQuietHours(settings models.NotificationSettings, now time.Time) bool {
+	start, okStart := minuteOfDay(settings.QuietStart)
+	end, okEnd := minuteOfDay(settings.QuietEnd)
+	if !okStart || !okEnd || start == end {
+		return false
+	}
+	location, err := time.LoadLocation(settings.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	local := now.In(location)
+	minute := local.Hour()*60 + local.Minute()
+	if start < end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+// minuteOfDay convertit une heure au format HH:MM en minutes depuis minuit
+func minuteOfDay(value string) (int, bool) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, false
+	}
+	return t.Hour()*60 + t.Minute(), true
+}
+
+func validCategory(category models.NotificationCategory) bool {
+	for _, known := range models.NotificationCategories {
+		if category == known {
+			return true
+		}
+	}
+	return false
+}
+
+// FriendConversation renvoie la conversation privée avec un ami, du point de vue de son interlocuteur
+func FriendConversation(friendID string) string {
+	return "friend:" + friendID
+}
+
+// validConversation vérifie qu'une conversation est de la forme match:<id>, group:<id> ou friend:<id>
+func validConversation(conversation string) bool {
+	kind, id, ok := strings.Cut(conversation, ":")
+	if !ok || id == "" || len(conversation) > 40 {
+		return false
+	}
+	return kind == "match" || kind == "group" || kind == "friend"
+}
@@ -2,94 +2,311 @@ package services
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand"
 	"time"
 
-	firebase "firebase.google.com/go"
-	"firebase.google.com/go/messaging"
-	"github.com/ady243/teamup/internal"
+	"github.com/ady243/teamup/internal/models"
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
 )
 
+// Durées de conservation des notifications : les notifications lues sont supprimées plus tôt
+const (
+	notificationReadRetention = 30 * 24 * time.Hour
+	notificationRetention     = 90 * 24 * time.Hour
+)
+
+// Les messages d'une conversation reçus dans cet intervalle sont regroupés dans une seule notification
+const notificationCollapseWindow = 30 * time.Minute
+
+var ErrNotificationNotFound = errors.New("notification not found")
+
 type NotificationService struct {
-	app          *firebase.App
-	client       *messaging.Client
-	redisService *RedisService
+	DB                *gorm.DB
+	DeviceService     *DeviceService
+	PreferenceService *NotificationPreferenceService
+	Notifiers         *Notifiers
+	Outbox            *OutboxService
 }
 
-func NewNotificationService(redisService *RedisService) (*NotificationService, error) {
-	app := internal.GetFirebaseApp()
-	if app == nil {
-		return nil, fmt.Errorf("firebase app non initialisée")
-	}
+// pushDelivery est le contenu d'un message push de l'outbox : les appareils d'une plateforme
+type pushDelivery struct {
+	Platform     models.DevicePlatform `json:"platform"`
+	Notification OutgoingNotification  `json:"notification"`
+}
 
-	client, err := app.Messaging(context.Background())
-	if err != nil {
-		log.Fatalf("Erreur lors de la récupération du client de notifications: %v", err)
-		return nil, err
+func NewNotificationService(db *gorm.DB, deviceService *DeviceService, preferenceService *NotificationPreferenceService, notifiers *Notifiers, outbox *OutboxService) *NotificationService {
+	ns := &NotificationService{
+		DB:                db,
+		DeviceService:     deviceService,
+		PreferenceService: preferenceService,
+		Notifiers:         notifiers,
+		Outbox:            outbox,
 	}
+	outbox.RegisterHandler(models.OutboxPush, ns.deliverPush)
+	outbox.RegisterHandler(models.OutboxEmail, ns.deliverEmail)
+	return ns
+}
 
-	return &NotificationService{
-		app:          app,
-		client:       client,
-		redisService: redisService,
-	}, nil
+// WithTx renvoie le service travaillant dans la transaction tx : les notifications ne sont enregistrées,
+// et leurs push et emails envoyés, que si l'opération qui les déclenche est validée
+func (ns *NotificationService) WithTx(tx *gorm.DB) *NotificationService {
+	scoped := *ns
+	scoped.DB = tx
+	return &scoped
 }
 
+// SendPushNotification envoie une notification push à un token FCM, sans l'enregistrer dans une boîte de réception
 func (ns *NotificationService) SendPushNotification(token, title, body string) error {
-	message := &messaging.Message{
-		Token: token,
-		Notification: &messaging.Notification{
-			Title: title,
-			Body:  body,
-		},
-	}
-
-	response, err := ns.client.Send(context.Background(), message)
+	_, err := ns.Notifiers.pushNotifier(models.PlatformAndroid).Send(context.Background(), OutgoingNotification{
+		Recipients: []string{token},
+		Title:      title,
+		Body:       body,
+	})
 	if err != nil {
 		return fmt.Errorf("échec de l'envoi de la notification: %w", err)
 	}
+	return nil
+}
 
-	log.Printf("Notification envoyée avec succès: %s", response)
+// Notify enregistre une notification dans la boîte de réception d'un utilisateur puis l'envoie en push
+// sur tous ses appareils, selon ses préférences
+func (ns *NotificationService) Notify(userID string, notificationType models.NotificationType, title, body string, data models.NotificationData) error {
+	return ns.NotifyUsers([]string{userID}, notificationType, title, body, data)
+}
 
-	notificationKey := fmt.Sprintf("notification:%s", token)
-	notificationValue := fmt.Sprintf("%s: %s", title, body)
-	if err := ns.redisService.SetNotification(notificationKey, notificationValue, 14*24*time.Hour); err != nil {
-		return fmt.Errorf("échec du stockage de la notification dans Redis: %w", err)
-	}
+// NotifyUsers enregistre la même notification dans la boîte de réception de chacun des utilisateurs
+// puis l'envoie en push sur tous leurs appareils, par lots multicast, selon leurs préférences
+func (ns *NotificationService) NotifyUsers(userIDs []string, notificationType models.NotificationType, title, body string, data models.NotificationData) error {
+	return ns.send(userIDs, "", notificationType, title, body, data)
+}
 
-	return nil
+// NotifyConversation notifie un nouveau message d'une conversation (salle de WebSocket du chat d'un match
+// ou d'un groupe, ou FriendConversation). Les destinataires qui l'ont coupée ne reçoivent ni push ni email,
+// et les messages rapprochés sont regroupés dans une seule notification.
+func (ns *NotificationService) NotifyConversation(userIDs []string, conversation string, notificationType models.NotificationType, title, body string, data models.NotificationData) error {
+	return ns.send(userIDs, conversation, notificationType, title, body, data)
 }
 
-func (ns *NotificationService) GetUnreadNotifications(token string) ([]string, error) {
-	keys, err := ns.redisService.GetKeys(fmt.Sprintf("notification:%s*", token))
+func (ns *NotificationService) send(userIDs []string, conversation string, notificationType models.NotificationType, title, body string, data models.NotificationData) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	now := time.Now()
+	deliveries, err := ns.PreferenceService.deliveries(userIDs, notificationType.Category(), conversation, now)
 	if err != nil {
-		return nil, fmt.Errorf("échec de la récupération des notifications non lues: %w", err)
+		return fmt.Errorf("échec de la lecture des préférences de notification: %w", err)
 	}
 
-	notifications := make([]string, 0, len(keys))
-	for _, key := range keys {
-		notification, err := ns.redisService.GetNotification(key)
+	return ns.DB.Transaction(func(tx *gorm.DB) error {
+		var inbox []string
+		for _, userID := range userIDs {
+			if deliveries[userID].inApp {
+				inbox = append(inbox, userID)
+			}
+		}
+		counts, err := ns.store(tx, inbox, conversation, notificationType, title, body, data, now)
 		if err != nil {
-			return nil, fmt.Errorf("échec de la récupération de la notification: %w", err)
+			return fmt.Errorf("échec de l'enregistrement de la notification: %w", err)
+		}
+
+		// Le texte du push dépend du destinataire : contenu masqué ou messages regroupés
+		pushes := make(map[string][]string)
+		emails := make(map[string][]string)
+		for _, userID := range userIDs {
+			d := deliveries[userID]
+			text := body
+			if d.hidePreview && conversation != "" {
+				text = "Nouveau message"
+			}
+			if counts[userID] > 1 {
+				text = fmt.Sprintf("%d nouveaux messages", counts[userID])
+			}
+			if d.push {
+				pushes[text] = append(pushes[text], userID)
+			}
+			if d.email {
+				emails[text] = append(emails[text], userID)
+			}
+		}
+
+		// Une livraison par plateforme et par adresse, pour ne retenter que celles qui ont échoué
+		batch := newNotificationID()
+		sequence := 0
+		for text, recipients := range pushes {
+			tokens, err := ns.DeviceService.Tokens(recipients)
+			if err != nil {
+				return fmt.Errorf("échec de la récupération des appareils: %w", err)
+			}
+			for platform, platformTokens := range tokens {
+				sequence++
+				delivery := pushDelivery{
+					Platform:     platform,
+					Notification: OutgoingNotification{Recipients: platformTokens, Title: title, Body: text, Data: data, CollapseKey: conversation},
+				}
+				if err := ns.Outbox.Enqueue(tx, models.OutboxPush, fmt.Sprintf("push:%s:%d", batch, sequence), delivery); err != nil {
+					return err
+				}
+			}
+		}
+		for text, recipients := range emails {
+			var addresses []string
+			if err := tx.Model(&models.Users{}).Where("id IN ? AND email <> ''", recipients).Pluck("email", &addresses).Error; err != nil {
+				return fmt.Errorf("échec de la récupération des adresses email: %w", err)
+			}
+			for _, address := range addresses {
+				sequence++
+				email := OutgoingNotification{Recipients: []string{address}, Title: title, Body: text}
+				if err := ns.Outbox.Enqueue(tx, models.OutboxEmail, fmt.Sprintf("email:%s:%d", batch, sequence), email); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// store enregistre la notification dans la boîte de réception des utilisateurs et renvoie, pour chacun,
+// le nombre de messages qu'elle regroupe. Une notification non lue de la même conversation, datant de
+// moins de notificationCollapseWindow, est remplacée par une nouvelle qui remonte en tête de la boîte.
+func (ns *NotificationService) store(tx *gorm.DB, userIDs []string, conversation string, notificationType models.NotificationType, title, body string, data models.NotificationData, now time.Time) (map[string]int, error) {
+	counts := make(map[string]int, len(userIDs))
+	if len(userIDs) == 0 {
+		return counts, nil
+	}
+	if conversation != "" {
+		var collapsed []models.Notification
+		if err := tx.Select("id", "user_id", "count").
+			Where("user_id IN ? AND collapse_key = ? AND read_at IS NULL AND updated_at > ?", userIDs, conversation, now.Add(-notificationCollapseWindow)).
+			Find(&collapsed).Error; err != nil {
+			return nil, err
+		}
+		ids := make([]string, len(collapsed))
+		for i, notification := range collapsed {
+			ids[i] = notification.ID
+			counts[notification.UserID] += notification.Count
+		}
+		if len(ids) > 0 {
+			if err := tx.Where("id IN ?", ids).Delete(&models.Notification{}).Error; err != nil {
+				return nil, err
+			}
 		}
-		notifications = append(notifications, notification)
 	}
 
-	return notifications, nil
+	notifications := make([]models.Notification, len(userIDs))
+	for i, userID := range userIDs {
+		counts[userID]++
+		notifications[i] = models.Notification{
+			ID:          newNotificationID(),
+			UserID:      userID,
+			Type:        notificationType,
+			Title:       title,
+			Body:        body,
+			Data:        data,
+			CollapseKey: conversation,
+			Count:       counts[userID],
+		}
+	}
+	return counts, tx.Create(&notifications).Error
 }
 
-func (ns *NotificationService) MarkNotificationsAsRead(token string) error {
-	keys, err := ns.redisService.GetKeys(fmt.Sprintf("notification:%s*", token))
+// deliverPush livre un message push de l'outbox. Les tokens que le fournisseur ne reconnaît plus sont
+// retirés du registre des appareils.
+func (ns *NotificationService) deliverPush(ctx context.Context, payload json.RawMessage) error {
+	var delivery pushDelivery
+	if err := json.Unmarshal(payload, &delivery); err != nil {
+		return fmt.Errorf("%w: %v", ErrPermanentDelivery, err)
+	}
+	report, err := ns.Notifiers.pushNotifier(delivery.Platform).Send(ctx, delivery.Notification)
+	if report != nil {
+		log.Printf("Notification envoyée à %d appareils %s sur %d", report.Sent, delivery.Platform, len(delivery.Notification.Recipients))
+		if err := ns.DeviceService.RemoveTokens(report.Invalid); err != nil {
+			log.Printf("Échec de la suppression des tokens invalides: %v", err)
+		}
+	}
 	if err != nil {
-		return fmt.Errorf("échec de la récupération des notifications non lues: %w", err)
+		return fmt.Errorf("échec de l'envoi de la notification: %w", err)
+	}
+	return nil
+}
+
+// deliverEmail livre une notification par email de l'outbox
+func (ns *NotificationService) deliverEmail(ctx context.Context, payload json.RawMessage) error {
+	var email OutgoingNotification
+	if err := json.Unmarshal(payload, &email); err != nil {
+		return fmt.Errorf("%w: %v", ErrPermanentDelivery, err)
 	}
+	if _, err := ns.Notifiers.Email.Send(ctx, email); err != nil {
+		return fmt.Errorf("échec de l'envoi de la notification par email: %w", err)
+	}
+	return nil
+}
 
-	for _, key := range keys {
-		if err := ns.redisService.DeleteNotification(key); err != nil {
-			return fmt.Errorf("échec de la suppression de la notification: %w", err)
-		}
+// GetNotifications renvoie les notifications d'un utilisateur de la plus récente à la plus ancienne.
+// before est l'identifiant de la dernière notification de la page précédente.
+func (ns *NotificationService) GetNotifications(userID, before string, unreadOnly bool, limit int) ([]models.Notification, error) {
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	query := ns.DB.Where("user_id = ?", userID)
+	if before != "" {
+		query = query.Where("id < ?", before)
+	}
+	if unreadOnly {
+		query = query.Where("read_at IS NULL")
 	}
 
+	notifications := []models.Notification{}
+	if err := query.Order("id desc").Limit(limit).Find(&notifications).Error; err != nil {
+		return nil, err
+	}
+	return notifications, nil
+}
+
+// UnreadCount renvoie le nombre de notifications non lues d'un utilisateur
+func (ns *NotificationService) UnreadCount(userID string) (int64, error) {
+	var count int64
+	err := ns.DB.Model(&models.Notification{}).Where("user_id = ? AND read_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+// MarkRead marque comme lue une notification de l'utilisateur
+func (ns *NotificationService) MarkRead(userID, notificationID string) error {
+	result := ns.DB.Model(&models.Notification{}).
+		Where("id = ? AND user_id = ?", notificationID, userID).
+		Update("read_at", gorm.Expr("COALESCE(read_at, ?)", time.Now()))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotificationNotFound
+	}
 	return nil
 }
+
+// MarkAllRead marque comme lues toutes les notifications de l'utilisateur et renvoie leur nombre
+func (ns *NotificationService) MarkAllRead(userID string) (int64, error) {
+	result := ns.DB.Model(&models.Notification{}).
+		Where("user_id = ? AND read_at IS NULL", userID).
+		Update("read_at", time.Now())
+	return result.RowsAffected, result.Error
+}
+
+// PurgeExpired supprime les notifications lues depuis plus de 30 jours et toutes celles de plus de 90 jours
+func (ns *NotificationService) PurgeExpired() (int64, error) {
+	now := time.Now()
+	result := ns.DB.Where("(read_at IS NOT NULL AND read_at < ?) OR created_at < ?",
+		now.Add(-notificationReadRetention), now.Add(-notificationRetention)).
+		Delete(&models.Notification{})
+	return result.RowsAffected, result.Error
+}
+
+func newNotificationID() string {
+	t := time.Now()
+	entropy := ulid.Monotonic(rand.New(rand.NewSource(t.UnixNano())), 0)
+	return ulid.MustNew(ulid.Timestamp(t), entropy).String()
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/ady243/teamup/internal"
+	"github.com/ady243/teamup/internal/models"
+)
+
+// OutgoingNotification est une notification à acheminer par un fournisseur. Les destinataires sont
+// des tokens d'appareil pour le push et des adresses pour l'email.
+type OutgoingNotification struct {
+	Recipients  []string          `json:"recipients"`
+	Title       string            `json:"title"`
+	Body        string            `json:"body"`
+	Data        map[string]string `json:"data,omitempty"`
+	CollapseKey string            `json:"collapse_key,omitempty"`
+	HTML        string            `json:"html,omitempty"` // Version HTML du texte, envoyée à sa place par email
+}
+
+// DeliveryReport résume un envoi : Invalid liste les destinataires que le fournisseur ne reconnaît plus
+// (application désinstallée, abonnement expiré) et qui doivent être retirés du registre
+type DeliveryReport struct {
+	Sent    int
+	Invalid []string
+}
+
+// Notifier achemine des notifications par un fournisseur (FCM, APNs, Web Push, email...).
+// Une erreur signifie que l'envoi a échoué pour tous les destinataires.
+type Notifier interface {
+	Send(ctx context.Context, notification OutgoingNotification) (*DeliveryReport, error)
+}
+
+// Notifiers associe à chaque plateforme d'appareil le fournisseur de ses notifications push,
+// et l'email à son propre fournisseur
+type Notifiers struct {
+	Push  map[models.DevicePlatform]Notifier
+	Email Notifier
+}
+
+// DefaultNotifiers construit les fournisseurs de notifications à partir de l'environnement :
+//   - NOTIFIER=memory remplace tous les fournisseurs par des RecordingNotifier, sans aucun service externe ;
+//   - sinon le push passe par Firebase (FCM), ou est seulement enregistré si Firebase n'est pas initialisé ;
+//   - APNS_KEY_FILE, APNS_KEY_ID, APNS_TEAM_ID et APNS_TOPIC envoient les appareils iOS directement
+//     par APNs (APNS_PRODUCTION=true pour l'environnement de production) ;
+//   - VAPID_PUBLIC_KEY, VAPID_PRIVATE_KEY et VAPID_SUBJECT envoient les appareils web par Web Push ;
+//   - l'email passe par SMTP si EMAIL_USER est défini, sinon il est seulement enregistré.
+func DefaultNotifiers(emailService *EmailService) *Notifiers {
+	if os.Getenv("NOTIFIER") == "memory" {
+		log.Println("Notifications enregistrées en mémoire, aucun envoi externe")
+		return &Notifiers{
+			Push: map[models.DevicePlatform]Notifier{
+				models.PlatformAndroid: NewRecordingNotifier("android"),
+				models.PlatformIOS:     NewRecordingNotifier("ios"),
+				models.PlatformWeb:     NewRecordingNotifier("web"),
+			},
+			Email: NewRecordingNotifier("email"),
+		}
+	}
+
+	var fcm Notifier
+	if app := internal.GetFirebaseApp(); app != nil {
+		notifier, err := NewFCMNotifier(app)
+		if err != nil {
+			log.Printf("Client FCM indisponible, notifications push seulement enregistrées : %v", err)
+			fcm = NewRecordingNotifier("push")
+		} else {
+			fcm = notifier
+		}
+	} else {
+		log.Println("Firebase non initialisé, notifications push seulement enregistrées")
+		fcm = NewRecordingNotifier("push")
+	}
+	notifiers := &Notifiers{
+		Push: map[models.DevicePlatform]Notifier{
+			models.PlatformAndroid: fcm,
+			models.PlatformIOS:     fcm,
+			models.PlatformWeb:     fcm,
+		},
+		Email: NewRecordingNotifier("email"),
+	}
+
+	if keyFile := os.Getenv("APNS_KEY_FILE"); keyFile != "" {
+		notifier, err := NewAPNsNotifierFromFile(keyFile, os.Getenv("APNS_KEY_ID"), os.Getenv("APNS_TEAM_ID"),
+			os.Getenv("APNS_TOPIC"), os.Getenv("APNS_PRODUCTION") == "true")
+		if err != nil {
+			log.Printf("Configuration APNs invalide, appareils iOS envoyés par FCM : %v", err)
+		} else {
+			notifiers.Push[models.PlatformIOS] = notifier
+		}
+	}
+	if publicKey := os.Getenv("VAPID_PUBLIC_KEY"); publicKey != "" {
+		notifier, err := NewWebPushNotifier(publicKey, os.Getenv("VAPID_PRIVATE_KEY"), os.Getenv("VAPID_SUBJECT"))
+		if err != nil {
+			log.Printf("Configuration Web Push invalide, appareils web envoyés par FCM : %v", err)
+		} else {
+			notifiers.Push[models.PlatformWeb] = notifier
+		}
+	}
+	if os.Getenv("EMAIL_USER") != "" && emailService != nil {
+		notifiers.Email = NewEmailNotifier(emailService)
+	}
+	return notifiers
+}
+
+// pushNotifier renvoie le fournisseur des appareils d'une plateforme
+func (n *Notifiers) pushNotifier(platform models.DevicePlatform) Notifier {
+	if notifier, ok := n.Push[platform]; ok {
+		return notifier
+	}
+	return n.Push[models.PlatformAndroid]
+}
+
+// RecordingNotifier garde en mémoire les notifications au lieu de les envoyer, pour le développement
+// local et les tests. Les destinataires marqués invalides simulent une application désinstallée.
+type RecordingNotifier struct {
+	name    string
+	mu      sync.Mutex
+	sent    []OutgoingNotification
+	invalid map[string]bool
+}
+
+func NewRecordingNotifier(name string) *RecordingNotifier {
+	return &RecordingNotifier{name: name, invalid: make(map[string]bool)}
+}
+
+func (r *RecordingNotifier) Send(ctx context.Context, notification OutgoingNotification) (*DeliveryReport, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report := &DeliveryReport{}
+	for _, recipient := range notification.Recipients {
+		if r.invalid[recipient] {
+			report.Invalid = append(report.Invalid, recipient)
+		} else {
+			report.Sent++
+		}
+	}
+	r.sent = append(r.sent, notification)
+	log.Printf("[%s] notification %q enregistrée pour %d destinataires", r.name, notification.Title, report.Sent)
+	return report, nil
+}
+
+// Sent renvoie les notifications enregistrées, de la plus ancienne à la plus récente
+func (r *RecordingNotifier) Sent() []OutgoingNotification {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]OutgoingNotification(nil), r.sent...)
+}
+
+// Reset oublie les notifications enregistrées
+func (r *RecordingNotifier) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent = nil
+}
+
+// MarkInvalid fait rejeter les destinataires comme le ferait un fournisseur qui ne les reconnaît plus
+func (r *RecordingNotifier) MarkInvalid(recipients ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, recipient := range recipients {
+		r.invalid[recipient] = true
+	}
+}
@@ -0,0 +1,251 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ady243/teamup/internal/models"
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	outboxWorkers      = 4
+	outboxBatchSize    = 20
+	outboxPollInterval = time.Second
+	// Un message réservé par un worker arrêté en cours d'envoi est repris après ce délai
+	outboxLease = 2 * time.Minute
+	// Délai avant la première nouvelle tentative, doublé à chaque échec jusqu'à outboxMaxBackoff
+	outboxBaseBackoff = 30 * time.Second
+	outboxMaxBackoff  = time.Hour
+	outboxMaxAttempts = 8
+	// Les messages envoyés sont conservés une semaine, les messages en échec un mois
+	outboxSentRetention = 7 * 24 * time.Hour
+	outboxDeadRetention = 30 * 24 * time.Hour
+)
+
+var (
+	ErrOutboxMessageNotFound = errors.New("outbox message not found")
+	// ErrPermanentDelivery signale un échec qu'une nouvelle tentative ne corrigera pas (contenu invalide) :
+	// le message passe directement en dead
+	ErrPermanentDelivery = errors.New("permanent delivery failure")
+)
+
+// OutboxHandler livre le contenu d'un message de l'outbox ; une erreur programme une nouvelle tentative
+type OutboxHandler func(ctx context.Context, payload json.RawMessage) error
+
+// OutboxService enregistre les livraisons dans la transaction de l'opération qui les déclenche et les
+// envoie en arrière-plan, pour que ni l'échec ni la lenteur d'un fournisseur ne bloquent les requêtes
+type OutboxService struct {
+	DB       *gorm.DB
+	mu       sync.RWMutex
+	handlers map[models.OutboxKind]OutboxHandler
+	wg       sync.WaitGroup
+}
+
+func NewOutboxService(db *gorm.DB) *OutboxService {
+	return &OutboxService{DB: db, handlers: make(map[models.OutboxKind]OutboxHandler)}
+}
+
+// RegisterHandler associe un type de message à la fonction qui le livre
+func (s *OutboxService) RegisterHandler(kind models.OutboxKind, handler OutboxHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[kind] = handler
+}
+
+// Enqueue enregistre une livraison avec tx, la transaction de l'opération qui la déclenche : elle n'est
+// envoyée que si la transaction est validée. Une livraison déjà enregistrée avec la même clé est ignorée.
+func (s *OutboxService) Enqueue(tx *gorm.DB, kind models.OutboxKind, idempotencyKey string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	message := models.OutboxMessage{
+		ID:             newOutboxID(),
+		Kind:           kind,
+		IdempotencyKey: idempotencyKey,
+		Payload:        data,
+		Status:         models.OutboxPending,
+		NextAttemptAt:  time.Now(),
+	}
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "idempotency_key"}},
+		DoNothing: true,
+	}).Create(&message).Error
+}
+
+// Start lance les workers qui livrent les messages dus, jusqu'à l'annulation de ctx
+func (s *OutboxService) Start(ctx context.Context) {
+	for i := 0; i < outboxWorkers; i++ {
+		s.wg.Add(1)
+		go s.work(ctx)
+	}
+}
+
+// Wait attend que les workers aient terminé les livraisons en cours après l'annulation de leur contexte
+func (s *OutboxService) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *OutboxService) work(ctx context.Context) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+	for {
+		messages, err := s.claim(outboxBatchSize)
+		if err != nil {
+			log.Printf("Erreur lors de la lecture de l'outbox : %v", err)
+		}
+		for _, message := range messages {
+			s.deliver(ctx, message)
+		}
+		// Un lot complet laisse penser que d'autres messages sont dus : pas d'attente
+		if len(messages) == outboxBatchSize && ctx.Err() == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// claim réserve des messages dus pour ce worker ; SKIP LOCKED évite que deux workers, ou deux instances
+// de l'API, réservent le même message. Les réservations expirées d'un worker arrêté sont reprises.
+func (s *OutboxService) claim(limit int) ([]models.OutboxMessage, error) {
+	now := time.Now()
+	var messages []models.OutboxMessage
+	err := s.DB.Raw(`UPDATE outbox_messages SET status = ?, locked_until = ?, attempts = attempts + 1, updated_at = ?
+		WHERE id IN (
+			SELECT id FROM outbox_messages
+			WHERE (status = ? AND next_attempt_at <= ?) OR (status = ? AND locked_until < ?)
+			ORDER BY next_attempt_at
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`,
+		models.OutboxProcessing, now.Add(outboxLease), now,
+		models.OutboxPending, now, models.OutboxProcessing, now,
+		limit).Scan(&messages).Error
+	return messages, err
+}
+
+// deliver livre un message réservé puis enregistre son succès, sa prochaine tentative ou son échec définitif
+func (s *OutboxService) deliver(ctx context.Context, message models.OutboxMessage) {
+	s.mu.RLock()
+	handler, ok := s.handlers[message.Kind]
+	s.mu.RUnlock()
+
+	var err error
+	if !ok {
+		err = fmt.Errorf("%w: aucun traitement pour %q", ErrPermanentDelivery, message.Kind)
+	} else {
+		// Le contexte de livraison n'est pas annulé à l'arrêt : un envoi commencé se termine
+		deliveryCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), outboxLease/2)
+		err = handler(deliveryCtx, message.Payload)
+		cancel()
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{"locked_until": nil, "updated_at": now}
+	switch {
+	case err == nil:
+		updates["status"] = models.OutboxSent
+		updates["sent_at"] = now
+		updates["last_error"] = ""
+	case errors.Is(err, ErrPermanentDelivery) || message.Attempts >= outboxMaxAttempts:
+		updates["status"] = models.OutboxDead
+		updates["last_error"] = err.Error()
+		log.Printf("Livraison %s (%s) abandonnée après %d tentatives : %v", message.ID, message.Kind, message.Attempts, err)
+	default:
+		updates["status"] = models.OutboxPending
+		updates["next_attempt_at"] = now.Add(outboxBackoff(message.Attempts))
+		updates["last_error"] = err.Error()
+	}
+	if err := s.DB.Model(&models.OutboxMessage{}).Where("id = ?", message.ID).Updates(updates).Error; err != nil {
+		log.Printf("Erreur lors de la mise à jour du message %s de l'outbox : %v", message.ID, err)
+	}
+}
+
+// outboxBackoff renvoie le délai avant la tentative suivante : exponentiel, plafonné, avec une part
+// aléatoire pour étaler les nouvelles tentatives après une panne d'un fournisseur
+func outboxBackoff(attempts int) time.Duration {
+	delay := outboxMaxBackoff
+	if attempts < 8 {
+		delay = min(outboxBaseBackoff<<(attempts-1), outboxMaxBackoff)
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// GetMessages renvoie les messages de l'outbox d'un statut (dead par défaut), du plus récent au plus ancien
+func (s *OutboxService) GetMessages(status models.OutboxStatus, limit, offset int) ([]models.OutboxMessage, int64, error) {
+	if limit <= 0 || limit > 100 {
+		limit = 50
+	}
+	if status == "" {
+		status = models.OutboxDead
+	}
+	query := s.DB.Model(&models.OutboxMessage{}).Where("status = ?", status)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	messages := []models.OutboxMessage{}
+	if err := query.Order("id desc").Limit(limit).Offset(offset).Find(&messages).Error; err != nil {
+		return nil, 0, err
+	}
+	return messages, total, nil
+}
+
+// Retry remet en file un message en échec définitif, avec un nouveau compteur de tentatives
+func (s *OutboxService) Retry(messageID string) (*models.OutboxMessage, error) {
+	result := s.DB.Model(&models.OutboxMessage{}).
+		Where("id = ? AND status = ?", messageID, models.OutboxDead).
+		Updates(map[string]interface{}{"status": models.OutboxPending, "attempts": 0, "next_attempt_at": time.Now()})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrOutboxMessageNotFound
+	}
+	var message models.OutboxMessage
+	if err := s.DB.Where("id = ?", messageID).First(&message).Error; err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// PurgeExpired supprime les messages envoyés depuis plus d'une semaine et les échecs de plus d'un mois
+func (s *OutboxService) PurgeExpired() (int64, error) {
+	now := time.Now()
+	result := s.DB.Where("(status = ? AND updated_at < ?) OR (status = ? AND updated_at < ?)",
+		models.OutboxSent, now.Add(-outboxSentRetention), models.OutboxDead, now.Add(-outboxDeadRetention)).
+		Delete(&models.OutboxMessage{})
+	return result.RowsAffected, result.Error
+}
+
+func newOutboxID() string {
+	t := time.Now()
+	entropy := ulid.Monotonic(rand.New(rand.NewSource(t.UnixNano())), 0)
+	return ulid.MustNew(ulid.Timestamp(t), entropy).String()
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/ady243/teamup/internal/models"
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// Un utilisateur est en ligne tant que son dernier signal de présence date de moins de PresenceTTL
+const PresenceTTL = 60 * time.Second
+
+const presenceLastSeenKey = "presence:last_seen"
+
+// Presence est le statut en ligne d'un utilisateur tel qu'il est visible par les autres
+type Presence struct {
+	UserID   string     `json:"user_id"`
+	Online   bool       `json:"online"`
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+	Hidden   bool       `json:"hidden"` // L'utilisateur a choisi de masquer sa présence
+}
+
+// PresenceService suit la présence des utilisateurs dans Redis : chaque signal prolonge une clé
+// qui expire sans nouveau signal, et la date du dernier signal est conservée comme dernière connexion
+type PresenceService struct {
+	DB          *gorm.DB
+	RedisClient *redis.Client
+}
+
+func NewPresenceService(db *gorm.DB, redisClient *redis.Client) *PresenceService {
+	return &PresenceService{
+		DB:          db,
+		RedisClient: redisClient,
+	}
+}
+
+func presenceKey(userID string) string {
+	return "presence:" + userID
+}
+
+// Heartbeat marque l'utilisateur en ligne pour PresenceTTL et met à jour sa dernière connexion
+func (s *PresenceService) Heartbeat(userID string) error {
+	ctx := context.Background()
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	pipe := s.RedisClient.TxPipeline()
+	pipe.Set(ctx, presenceKey(userID), now, PresenceTTL)
+	pipe.HSet(ctx, presenceLastSeenKey, userID, now)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// SetOffline marque l'utilisateur hors ligne sans attendre l'expiration de son dernier signal
+func (s *PresenceService) SetOffline(userID string) error {
+	ctx := context.Background()
+	pipe := s.RedisClient.TxPipeline()
+	pipe.Del(ctx, presenceKey(userID))
+	pipe.HSet(ctx, presenceLastSeenKey, userID, strconv.FormatInt(time.Now().Unix(), 10))
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// GetPresence renvoie la présence de plusieurs utilisateurs vue par viewerID.
+// La présence des utilisateurs qui l'ont masquée n'est visible que par eux-mêmes.
+func (s *PresenceService) GetPresence(viewerID string, userIDs []string) ([]Presence, error) {
+	if len(userIDs) == 0 {
+		return []Presence{}, nil
+	}
+
+	var hidden []string
+	if err := s.DB.Model(&models.Users{}).Where("id IN ? AND hide_presence = ?", userIDs, true).Pluck("id", &hidden).Error; err != nil {
+		return nil, err
+	}
+	hiddenIDs := make(map[string]bool, len(hidden))
+	for _, id := range hidden {
+		hiddenIDs[id] = true
+	}
+
+	ctx := context.Background()
+	pipe := s.RedisClient.Pipeline()
+	online := make([]*redis.IntCmd, len(userIDs))
+	for i, userID := range userIDs {
+		online[i] = pipe.Exists(ctx, presenceKey(userID))
+	}
+	lastSeen := pipe.HMGet(ctx, presenceLastSeenKey, userIDs...)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	seen := lastSeen.Val()
+	presences := make([]Presence, len(userIDs))
+	for i, userID := range userIDs {
+		presence := Presence{UserID: userID}
+		if hiddenIDs[userID] && userID != viewerID {
+			presence.Hidden = true
+			presences[i] = presence
+			continue
+		}
+		presence.Online = online[i].Val() > 0
+		if i < len(seen) {
+			if value, ok := seen[i].(string); ok {
+				if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+					t := time.Unix(unix, 0).UTC()
+					presence.LastSeen = &t
+				}
+			}
+		}
+		presences[i] = presence
+	}
+	return presences, nil
+}
+
+// SetPresenceVisibility enregistre le choix de l'utilisateur de masquer ou non sa présence
+func (s *PresenceService) SetPresenceVisibility(userID string, hide bool) error {
+	return s.DB.Model(&models.Users{}).Where("id = ?", userID).Update("hide_presence", hide).Error
+}
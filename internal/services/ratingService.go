@@ -0,0 +1,352 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/ady243/teamup/internal/models"
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
+)
+
+// Paramètres TrueSkill par défaut
+const (
+	defaultMu       = 25.0
+	defaultSigma    = defaultMu / 3
+	ratingBeta      = defaultSigma / 2
+	ratingTau       = defaultSigma / 100
+	drawProbability = 0.10
+)
+
+// Les matchs dont la mise à jour des notes a échoué sont retentés pendant une semaine après leur confirmation
+const ratingRetryWindow = 7 * 24 * time.Hour
+
+// RatingService calcule et stocke la note TrueSkill des joueurs
+type RatingService struct {
+	DB *gorm.DB
+}
+
+func NewRatingService(db *gorm.DB) *RatingService {
+	return &RatingService{
+		DB: db,
+	}
+}
+
+// RatingSummary est la note d'un joueur telle qu'exposée par l'API
+type RatingSummary struct {
+	PlayerID           string  `json:"player_id"`
+	Mu                 float64 `json:"mu"`
+	Sigma              float64 `json:"sigma"`
+	Rating             float64 `json:"rating"` // Note conservatrice (mu - 3 sigma)
+	ConfidenceLow      float64 `json:"confidence_low"`
+	ConfidenceHigh     float64 `json:"confidence_high"`
+	MatchesRated       int     `json:"matches_rated"`
+	IsProvisional      bool    `json:"is_provisional"`
+	ConfidenceInterval string  `json:"confidence_interval"`
+}
+
+// ConservativeRating renvoie la note utilisée pour classer un joueur
+func ConservativeRating(mu, sigma float64) float64 {
+	return mu - 3*sigma
+}
+
+// Summarize construit le résumé d'une note avec son intervalle de confiance à 95 %
+func Summarize(rating models.PlayerRating) RatingSummary {
+	low := rating.Mu - 1.96*rating.Sigma
+	high := rating.Mu + 1.96*rating.Sigma
+	return RatingSummary{
+		PlayerID:           rating.PlayerID,
+		Mu:                 rating.Mu,
+		Sigma:              rating.Sigma,
+		Rating:             ConservativeRating(rating.Mu, rating.Sigma),
+		ConfidenceLow:      low,
+		ConfidenceHigh:     high,
+		MatchesRated:       rating.MatchesRated,
+		IsProvisional:      rating.MatchesRated < 5,
+		ConfidenceInterval: "95%",
+	}
+}
+
+// GetRating renvoie la note d'un joueur, ou la note par défaut s'il n'a jamais été noté
+func (s *RatingService) GetRating(playerID string) (models.PlayerRating, error) {
+	var rating models.PlayerRating
+	err := s.DB.Where("player_id = ?", playerID).First(&rating).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.PlayerRating{PlayerID: playerID, Mu: defaultMu, Sigma: defaultSigma}, nil
+	}
+	if err != nil {
+		return models.PlayerRating{}, err
+	}
+	return rating, nil
+}
+
+// GetRatingHistory renvoie l'historique des notes d'un joueur, du plus récent au plus ancien
+func (s *RatingService) GetRatingHistory(playerID string) ([]models.RatingHistory, error) {
+	var history []models.RatingHistory
+	if err := s.DB.Where("player_id = ?", playerID).Order("created_at desc").Find(&history).Error; err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// UpdateRatingsForMatch met à jour la note de tous les joueurs d'un match dont le résultat est confirmé.
+// Un match déjà pris en compte n'est pas recalculé.
+func (s *RatingService) UpdateRatingsForMatch(matchID string) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		var match models.Matches
+		if err := tx.Where("id = ? AND deleted_at IS NULL", matchID).First(&match).Error; err != nil {
+			return err
+		}
+		if match.ResultConfirmedAt == nil {
+			return errors.New("match result is not confirmed")
+		}
+
+		var alreadyRated int64
+		if err := tx.Model(&models.RatingHistory{}).Where("match_id = ?", matchID).Count(&alreadyRated).Error; err != nil {
+			return err
+		}
+		if alreadyRated > 0 {
+			return nil
+		}
+
+		var players []models.MatchPlayers
+		if err := tx.Where("match_id = ? AND team_number IN ?", matchID, []int{1, 2}).Find(&players).Error; err != nil {
+			return err
+		}
+
+		teams := map[int][]models.PlayerRating{}
+		for _, player := range players {
+			var rating models.PlayerRating
+			err := tx.Where("player_id = ?", player.PlayerID).First(&rating).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				rating = models.PlayerRating{PlayerID: player.PlayerID, Mu: defaultMu, Sigma: defaultSigma}
+			} else if err != nil {
+				return err
+			}
+			teams[*player.TeamNumber] = append(teams[*player.TeamNumber], rating)
+		}
+		if len(teams[1]) == 0 || len(teams[2]) == 0 {
+			return errors.New("both teams need at least one player to update ratings")
+		}
+
+		// Le résultat est exprimé du point de vue de l'équipe 1
+		outcome := 0
+		if match.ScoreTeam1 > match.ScoreTeam2 {
+			outcome = 1
+		} else if match.ScoreTeam1 < match.ScoreTeam2 {
+			outcome = -1
+		}
+
+		updated1, updated2 := rateTeams(teams[1], teams[2], outcome)
+
+		now := time.Now()
+		entropy := ulid.Monotonic(rand.New(rand.NewSource(now.UnixNano())), 0)
+		before := append(teams[1], teams[2]...)
+		for i, after := range append(updated1, updated2...) {
+			history := models.RatingHistory{
+				ID:          ulid.MustNew(ulid.Timestamp(now), entropy).String(),
+				PlayerID:    after.PlayerID,
+				MatchID:     matchID,
+				MuBefore:    before[i].Mu,
+				SigmaBefore: before[i].Sigma,
+				MuAfter:     after.Mu,
+				SigmaAfter:  after.Sigma,
+			}
+			if err := tx.Create(&history).Error; err != nil {
+				return err
+			}
+
+			after.MatchesRated++
+			if err := tx.Save(&after).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RateUnratedMatches met à jour les notes des matchs confirmés récemment qui n'ont pas encore été pris
+// en compte, par exemple après une erreur de base de données lors de la confirmation du résultat
+func (s *RatingService) RateUnratedMatches() error {
+	var matchIDs []string
+	err := s.DB.Model(&models.Matches{}).
+		Where("deleted_at IS NULL AND result_confirmed_at > ?", time.Now().Add(-ratingRetryWindow)).
+		Where("NOT EXISTS (SELECT 1 FROM rating_histories WHERE rating_histories.match_id = matches.id)").
+		// Un match sans joueur dans l'une des équipes ne peut pas être noté : inutile de le retenter
+		Where("EXISTS (SELECT 1 FROM match_players WHERE match_players.match_id = matches.id AND team_number = 1)").
+		Where("EXISTS (SELECT 1 FROM match_players WHERE match_players.match_id = matches.id AND team_number = 2)").
+		Pluck("id", &matchIDs).Error
+	if err != nil {
+		return err
+	}
+	// Un match en erreur n'empêche pas de noter les suivants
+	var errs []error
+	for _, matchID := range matchIDs {
+		if err := s.UpdateRatingsForMatch(matchID); err != nil {
+			log.Printf("Erreur lors de la notation du match %s : %v", matchID, err)
+			errs = append(errs, fmt.Errorf("match %s: %w", matchID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// AverageRatingsForMatches renvoie la note conservatrice moyenne des joueurs inscrits à chaque match
+func (s *RatingService) AverageRatingsForMatches(matchIDs []string) (map[string]float64, error) {
+	averages := make(map[string]float64)
+	if len(matchIDs) == 0 {
+		return averages, nil
+	}
+
+	var players []models.MatchPlayers
+	if err := s.DB.Where("match_id IN ?", matchIDs).Find(&players).Error; err != nil {
+		return nil, err
+	}
+
+	playerIDs := make([]string, 0, len(players))
+	for _, player := range players {
+		playerIDs = append(playerIDs, player.PlayerID)
+	}
+
+	var ratings []models.PlayerRating
+	if err := s.DB.Where("player_id IN ?", playerIDs).Find(&ratings).Error; err != nil {
+		return nil, err
+	}
+	ratingByPlayer := make(map[string]float64, len(ratings))
+	for _, rating := range ratings {
+		ratingByPlayer[rating.PlayerID] = ConservativeRating(rating.Mu, rating.Sigma)
+	}
+
+	totals := make(map[string]float64)
+	counts := make(map[string]int)
+	for _, player := range players {
+		rating, ok := ratingByPlayer[player.PlayerID]
+		if !ok {
+			rating = ConservativeRating(defaultMu, defaultSigma)
+		}
+		totals[player.MatchID] += rating
+		counts[player.MatchID]++
+	}
+	for matchID, total := range totals {
+		averages[matchID] = total / float64(counts[matchID])
+	}
+	return averages, nil
+}
+
+// rateTeams applique une mise à jour TrueSkill à deux équipes de tailles quelconques.
+// outcome vaut 1 si l'équipe 1 gagne, -1 si elle perd et 0 en cas de match nul.
+func rateTeams(team1, team2 []models.PlayerRating, outcome int) ([]models.PlayerRating, []models.PlayerRating) {
+	// Ajout de l'incertitude dynamique avant le calcul
+	withDynamics := func(team []models.PlayerRating) []models.PlayerRating {
+		result := make([]models.PlayerRating, len(team))
+		for i, player := range team {
+			player.Sigma = math.Sqrt(player.Sigma*player.Sigma + ratingTau*ratingTau)
+			result[i] = player
+		}
+		return result
+	}
+	team1 = withDynamics(team1)
+	team2 = withDynamics(team2)
+
+	var mu1, mu2, variance float64
+	for _, player := range team1 {
+		mu1 += player.Mu
+		variance += player.Sigma * player.Sigma
+	}
+	for _, player := range team2 {
+		mu2 += player.Mu
+		variance += player.Sigma * player.Sigma
+	}
+	totalPlayers := float64(len(team1) + len(team2))
+	c := math.Sqrt(variance + totalPlayers*ratingBeta*ratingBeta)
+	epsilon := drawMargin(totalPlayers) / c
+
+	var v, w float64
+	switch outcome {
+	case 1:
+		v, w = vWin((mu1-mu2)/c, epsilon), wWin((mu1-mu2)/c, epsilon)
+	case -1:
+		// On se place du point de vue du vainqueur puis on inverse le signe
+		v, w = vWin((mu2-mu1)/c, epsilon), wWin((mu2-mu1)/c, epsilon)
+		v = -v
+	default:
+		v, w = vDraw((mu1-mu2)/c, epsilon), wDraw((mu1-mu2)/c, epsilon)
+	}
+
+	update := func(team []models.PlayerRating, sign float64) []models.PlayerRating {
+		result := make([]models.PlayerRating, len(team))
+		for i, player := range team {
+			variance := player.Sigma * player.Sigma
+			player.Mu += sign * variance / c * v
+			player.Sigma = math.Sqrt(variance * math.Max(1-variance/(c*c)*w, 0.0001))
+			result[i] = player
+		}
+		return result
+	}
+	return update(team1, 1), update(team2, -1)
+}
+
+func drawMargin(totalPlayers float64) float64 {
+	return inverseNormalCDF((drawProbability+1)/2) * math.Sqrt(totalPlayers) * ratingBeta
+}
+
+func normalPDF(x float64) float64 {
+	return math.Exp(-x*x/2) / math.Sqrt(2*math.Pi)
+}
+
+func normalCDF(x float64) float64 {
+	return 0.5 * math.Erfc(-x/math.Sqrt2)
+}
+
+func inverseNormalCDF(p float64) float64 {
+	return math.Sqrt2 * math.Erfinv(2*p-1)
+}
+
+func vWin(t, epsilon float64) float64 {
+	denominator := normalCDF(t - epsilon)
+	if denominator < 2.222758749e-162 {
+		return -t + epsilon
+	}
+	return normalPDF(t-epsilon) / denominator
+}
+
+func wWin(t, epsilon float64) float64 {
+	denominator := normalCDF(t - epsilon)
+	if denominator < 2.222758749e-162 {
+		if t < 0 {
+			return 1
+		}
+		return 0
+	}
+	v := vWin(t, epsilon)
+	return v * (v + t - epsilon)
+}
+
+func vDraw(t, epsilon float64) float64 {
+	absT := math.Abs(t)
+	denominator := normalCDF(epsilon-absT) - normalCDF(-epsilon-absT)
+	if denominator < 2.222758749e-162 {
+		if t < 0 {
+			return -t - epsilon
+		}
+		return -t + epsilon
+	}
+	numerator := normalPDF(-epsilon-absT) - normalPDF(epsilon-absT)
+	if t < 0 {
+		return -numerator / denominator
+	}
+	return numerator / denominator
+}
+
+func wDraw(t, epsilon float64) float64 {
+	absT := math.Abs(t)
+	denominator := normalCDF(epsilon-absT) - normalCDF(-epsilon-absT)
+	if denominator < 2.222758749e-162 {
+		return 1
+	}
+	v := vDraw(absT, epsilon)
+	return v*v + ((epsilon-absT)*normalPDF(epsilon-absT)+(epsilon+absT)*normalPDF(epsilon+absT))/denominator
+}
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ady243/teamup/internal/models"
+	"github.com/go-redis/redis/v8"
+	"github.com/oklog/ulid/v2"
+)
+
+// Version du format des événements temps réel ; elle change quand le contenu d'un événement change
+// de façon incompatible
+const EventProtocolVersion = 1
+
+// Paramètres du tampon de reprise : derniers événements conservés par salon pour les clients qui se reconnectent
+const (
+	replayBufferSize = 100
+	replayBufferTTL  = 24 * time.Hour
+)
+
+// Types des événements temps réel
+const (
+	EventAck                   = "ack"                     // Réponse du serveur à une commande du client
+	EventFriendRequest         = "friend_request"          // Demande d'ami reçue
+	EventFriendRequestAccepted = "friend_request_accepted" // Demande d'ami acceptée
+	EventFriendRequestDeclined = "friend_request_declined" // Demande d'ami refusée
+	EventFriendMessage         = "new_message"             // Message privé entre amis
+	EventChatMessage           = "chat_message"            // Message du chat d'un match
+	EventGroupMessage          = "group_message"           // Message d'un groupe
+	EventMatchEvent            = "match_event"             // Événement saisi par l'analyste (but, carton...)
+	EventMatchEventUpdated     = "event_updated"           // Événement de l'analyste modifié
+	EventMatchEventDeleted     = "event_deleted"           // Événement de l'analyste supprimé
+	EventScoreChange           = "score_change"            // Score d'un match modifié
+	EventMatchStatus           = "match_status"            // Statut d'un match modifié
+)
+
+// ephemeralEvents ne sont pas conservés pour la reprise : ils n'ont plus de sens après coup
+var ephemeralEvents = map[string]bool{"typing": true, EventAck: true}
+
+// revokingEvents retirent un membre d'un salon : les abonnements sont alors vérifiés de nouveau
+var revokingEvents = map[string]bool{"member_kicked": true, "member_removed": true, "member_left": true}
+
+// Envelope est le format commun de tous les événements temps réel. ID est unique, croît avec l'ordre
+// de publication sur une instance et sert de point de reprise après une déconnexion.
+type Envelope struct {
+	Type      string          `json:"type"`
+	Version   int             `json:"version"`
+	ID        string          `json:"id"`
+	Timestamp time.Time       `json:"timestamp"`
+	Room      string          `json:"room"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+// FriendRequestEvent est le contenu des événements de demande d'ami
+type FriendRequestEvent struct {
+	Type       string `json:"type"`
+	SenderID   string `json:"senderId"`
+	ReceiverID string `json:"receiverId"`
+}
+
+// FriendMessageEvent est le contenu d'un message privé entre amis
+type FriendMessageEvent struct {
+	Type       string             `json:"type"`
+	ID         uint               `json:"id"`
+	SenderID   string             `json:"senderID"`
+	ReceiverID string             `json:"receiverID"`
+	Content    string             `json:"content"`
+	ReplyToID  *uint              `json:"replyToID"`
+	Attachment *models.Attachment `json:"attachment"`
+}
+
+// AckEvent est la réponse du serveur à une commande subscribe ou unsubscribe
+type AckEvent struct {
+	Ref      string `json:"ref,omitempty"` // Référence fournie par le client dans sa commande
+	Action   string `json:"action"`
+	OK       bool   `json:"ok"`
+	Error    string `json:"error,omitempty"`
+	Replayed int    `json:"replayed,omitempty"` // Événements renvoyés depuis last_event_id
+	Complete bool   `json:"complete,omitempty"` // Faux si des événements plus anciens que le tampon ont été perdus
+}
+
+// Noms des salons ; ChatRoom et GroupRoom sont définis avec leur service
+func UserRoom(userID string) string {
+	return "user:" + userID
+}
+
+func LiveRoom(matchID string) string {
+	return "live:" + matchID
+}
+
+// MatchStatusRoom reçoit les changements de statut de tous les matchs
+const MatchStatusRoom = "matches:status"
+
+// RoomChannel renvoie le canal Redis d'un salon
+func RoomChannel(room string) string {
+	return "ws:" + room
+}
+
+func replayKey(room string) string {
+	return "ws:replay:" + room
+}
+
+// hubMessage est un événement transporté par Redis ; Key sert à éviter les doublons lors d'un rattrapage
+// à partir d'un état initial (voir HandleWebSocketWithCatchUp)
+type hubMessage struct {
+	Key      string   `json:"key,omitempty"`
+	Envelope Envelope `json:"envelope"`
+}
+
+// envelopeEntropy rend les identifiants des événements croissants, même au sein d'une milliseconde
+var (
+	envelopeEntropy      = ulid.Monotonic(rand.New(rand.NewSource(time.Now().UnixNano())), 0)
+	envelopeEntropyMutex sync.Mutex
+)
+
+// newEnvelope prépare l'enveloppe d'un événement
+func newEnvelope(room, eventType string, payload interface{}) (Envelope, error) {
+	data, ok := payload.(json.RawMessage)
+	if !ok {
+		var err error
+		if data, err = json.Marshal(payload); err != nil {
+			return Envelope{}, err
+		}
+	}
+	t := time.Now()
+	envelopeEntropyMutex.Lock()
+	id := ulid.MustNew(ulid.Timestamp(t), envelopeEntropy).String()
+	envelopeEntropyMutex.Unlock()
+	return Envelope{
+		Type:      eventType,
+		Version:   EventProtocolVersion,
+		ID:        id,
+		Timestamp: t,
+		Room:      room,
+		Payload:   data,
+	}, nil
+}
+
+// PublishRoomEvent publie un événement dans un salon, pour toutes les instances de l'API, et le conserve
+// dans le tampon de reprise du salon sauf s'il est éphémère
+func PublishRoomEvent(redisClient *redis.Client, room, eventType string, payload interface{}) error {
+	return publishRoomEvent(redisClient, room, eventType, "", payload)
+}
+
+func publishRoomEvent(redisClient *redis.Client, room, eventType, key string, payload interface{}) error {
+	envelope, err := newEnvelope(room, eventType, payload)
+	if err != nil {
+		return err
+	}
+	return publishEnvelope(redisClient, key, envelope)
+}
+
+// publishEnvelope ajoute l'événement au tampon et le publie dans la même transaction : l'ordre du tampon
+// est celui de la diffusion
+func publishEnvelope(redisClient *redis.Client, key string, envelope Envelope) error {
+	data, err := json.Marshal(hubMessage{Key: key, Envelope: envelope})
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	pipe := redisClient.TxPipeline()
+	if !ephemeralEvents[envelope.Type] {
+		envelopeJSON, err := json.Marshal(envelope)
+		if err != nil {
+			return err
+		}
+		pipe.RPush(ctx, replayKey(envelope.Room), envelopeJSON)
+		pipe.LTrim(ctx, replayKey(envelope.Room), -replayBufferSize, -1)
+		pipe.Expire(ctx, replayKey(envelope.Room), replayBufferTTL)
+	}
+	pipe.Publish(ctx, RoomChannel(envelope.Room), data)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// loadReplay renvoie le tampon de reprise d'un salon, du plus ancien au plus récent
+func loadReplay(redisClient *redis.Client, room string) ([]Envelope, error) {
+	values, err := redisClient.LRange(context.Background(), replayKey(room), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	envelopes := make([]Envelope, 0, len(values))
+	for _, value := range values {
+		var envelope Envelope
+		if err := json.Unmarshal([]byte(value), &envelope); err == nil {
+			envelopes = append(envelopes, envelope)
+		}
+	}
+	return envelopes, nil
+}
+
+// eventsAfter renvoie les événements publiés après lastEventID. complete est faux si lastEventID
+// n'est plus dans le tampon : des événements ont pu être perdus et le client doit recharger l'état complet.
+func eventsAfter(envelopes []Envelope, lastEventID string) (events []Envelope, complete bool) {
+	for i, envelope := range envelopes {
+		if envelope.ID == lastEventID {
+			return envelopes[i+1:], true
+		}
+	}
+	return envelopes, false
+}
@@ -0,0 +1,552 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ady243/teamup/internal/models"
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// Taille de la grille utilisée pour les cartes de chaleur (heatGridSize x heatGridSize zones)
+	heatGridSize = 10
+	// Un rapport en échec est régénéré après reportRetryDelay, au plus reportMaxAttempts fois
+	reportRetryDelay  = 10 * time.Minute
+	reportMaxAttempts = 5
+)
+
+// ReportService construit les rapports d'analyse des matchs à partir des événements d'analyste
+type ReportService struct {
+	DB            *gorm.DB
+	ReviewService *ReviewService
+	queue         chan string
+}
+
+func NewReportService(db *gorm.DB, reviewService *ReviewService) *ReportService {
+	return &ReportService{
+		DB:            db,
+		ReviewService: reviewService,
+		queue:         make(chan string, 100),
+	}
+}
+
+// TeamStats regroupe les statistiques d'une équipe
+type TeamStats struct {
+	Team            int     `json:"team"`
+	Goals           int     `json:"goals"`
+	Assists         int     `json:"assists"`
+	ShotsOnTarget   int     `json:"shots_on_target"`
+	ShotsOffTarget  int     `json:"shots_off_target"`
+	Penalties       int     `json:"penalties"`
+	PenaltiesScored int     `json:"penalties_scored"`
+	Saves           int     `json:"saves"`
+	Fouls           int     `json:"fouls"`
+	YellowCards     int     `json:"yellow_cards"`
+	RedCards        int     `json:"red_cards"`
+	Substitutions   int     `json:"substitutions"`
+	PossessionProxy float64 `json:"possession_proxy"` // Part des actions avec ballon (buts, passes décisives, tirs, penalties), en %
+}
+
+// PlayerStatLine regroupe les statistiques d'un joueur sur le match
+type PlayerStatLine struct {
+	PlayerID       string  `json:"player_id"`
+	Username       string  `json:"username"`
+	Team           int     `json:"team"`
+	Goals          int     `json:"goals"`
+	OwnGoals       int     `json:"own_goals"`
+	Assists        int     `json:"assists"`
+	ShotsOnTarget  int     `json:"shots_on_target"`
+	ShotsOffTarget int     `json:"shots_off_target"`
+	Saves          int     `json:"saves"`
+	Fouls          int     `json:"fouls"`
+	YellowCards    int     `json:"yellow_cards"`
+	RedCards       int     `json:"red_cards"`
+	ManOfTheMatch  int64   `json:"man_of_the_match_votes"`
+	Impact         float64 `json:"impact"` // Score d'impact utilisé pour suggérer l'homme du match
+}
+
+// HeatMap compte les événements par zone du terrain
+type HeatMap struct {
+	Team     int     `json:"team"`
+	PlayerID string  `json:"player_id,omitempty"`
+	Grid     [][]int `json:"grid"` // Grid[y][x], origine en haut à gauche
+}
+
+// MatchReportData est le contenu d'un rapport d'analyse de match
+type MatchReportData struct {
+	MatchID       string           `json:"match_id"`
+	Address       string           `json:"address"`
+	Date          time.Time        `json:"date"`
+	ScoreTeam1    int              `json:"score_team_1"`
+	ScoreTeam2    int              `json:"score_team_2"`
+	Teams         []TeamStats      `json:"teams"`
+	Players       []PlayerStatLine `json:"players"`
+	HeatMaps      []HeatMap        `json:"heat_maps"`
+	ManOfTheMatch *PlayerStatLine  `json:"man_of_the_match"`
+	GeneratedAt   time.Time        `json:"generated_at"`
+}
+
+// Enqueue demande la (re)génération du rapport d'un match en arrière-plan
+func (s *ReportService) Enqueue(matchID string) error {
+	return s.enqueue(matchID, true)
+}
+
+// enqueue met le rapport en attente ; reset remet à zéro le compteur d'échecs pour une nouvelle demande,
+// les reprises automatiques le conservent pour borner le nombre de tentatives
+func (s *ReportService) enqueue(matchID string, reset bool) error {
+	updates := map[string]interface{}{"status": models.ReportPending, "error": "", "updated_at": time.Now()}
+	if reset {
+		updates["attempts"] = 0
+	}
+	report := models.MatchReport{MatchID: matchID, Status: models.ReportPending, Data: "{}", ShareToken: newReportToken()}
+	if err := s.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "match_id"}},
+		DoUpdates: clause.Assignments(updates),
+	}).Create(&report).Error; err != nil {
+		return err
+	}
+
+	select {
+	case s.queue <- matchID:
+	default:
+		// File pleine : le rapport reste "pending" et sera repris par EnqueueMissingReports
+		log.Printf("Report queue is full, match %s will be retried later", matchID)
+	}
+	return nil
+}
+
+// EnqueueMissingReports met en file les matchs terminés qui n'ont pas encore de rapport, les rapports
+// restés en attente depuis plus de dix minutes et les rapports en échec qui n'ont pas épuisé leurs tentatives
+func (s *ReportService) EnqueueMissingReports() error {
+	var matchIDs []string
+	if err := s.DB.Model(&models.Matches{}).
+		Where("status = ? AND deleted_at IS NULL", models.Completed).
+		Where("id NOT IN (?)", s.DB.Model(&models.MatchReport{}).Select("match_id")).
+		Pluck("id", &matchIDs).Error; err != nil {
+		return err
+	}
+
+	var retryIDs []string
+	if err := s.DB.Model(&models.MatchReport{}).
+		Where("(status = ? OR (status = ? AND attempts < ?)) AND updated_at < ?",
+			models.ReportPending, models.ReportFailed, reportMaxAttempts, time.Now().Add(-reportRetryDelay)).
+		Pluck("match_id", &retryIDs).Error; err != nil {
+		return err
+	}
+
+	for _, matchID := range matchIDs {
+		if err := s.enqueue(matchID, true); err != nil {
+			return err
+		}
+	}
+	for _, matchID := range retryIDs {
+		if err := s.enqueue(matchID, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartWorker génère les rapports mis en file, un par un
+func (s *ReportService) StartWorker() {
+	for matchID := range s.queue {
+		if err := s.GenerateReport(matchID); err != nil {
+			log.Printf("Erreur lors de la génération du rapport du match %s : %v", matchID, err)
+		}
+	}
+}
+
+// GenerateReport construit le rapport d'un match et l'enregistre
+func (s *ReportService) GenerateReport(matchID string) error {
+	data, err := s.BuildReport(matchID)
+	if err != nil {
+		s.DB.Model(&models.MatchReport{}).Where("match_id = ?", matchID).Updates(map[string]interface{}{
+			"status":   models.ReportFailed,
+			"error":    err.Error(),
+			"attempts": gorm.Expr("attempts + 1"),
+		})
+		return err
+	}
+
+	content, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return s.DB.Model(&models.MatchReport{}).Where("match_id = ?", matchID).Updates(map[string]interface{}{
+		"status":       models.ReportReady,
+		"data":         string(content),
+		"error":        "",
+		"generated_at": data.GeneratedAt,
+	}).Error
+}
+
+// GetReport renvoie le rapport d'un match et son contenu s'il est prêt
+func (s *ReportService) GetReport(matchID string) (*models.MatchReport, *MatchReportData, error) {
+	var report models.MatchReport
+	if err := s.DB.Where("match_id = ?", matchID).First(&report).Error; err != nil {
+		return nil, nil, err
+	}
+	return decodeReport(&report)
+}
+
+// GetReportByShareToken renvoie un rapport partagé à partir de son jeton
+func (s *ReportService) GetReportByShareToken(token string) (*models.MatchReport, *MatchReportData, error) {
+	var report models.MatchReport
+	if err := s.DB.Where("share_token = ?", token).First(&report).Error; err != nil {
+		return nil, nil, err
+	}
+	return decodeReport(&report)
+}
+
+// CanViewReport vérifie qu'un utilisateur est organisateur, arbitre ou joueur du match
+func (s *ReportService) CanViewReport(matchID, userID string) bool {
+	var count int64
+	s.DB.Model(&models.Matches{}).Where("id = ? AND (organizer_id = ? OR referee_id = ?)", matchID, userID, userID).Count(&count)
+	if count > 0 {
+		return true
+	}
+	s.DB.Model(&models.MatchPlayers{}).Where("match_id = ? AND player_id = ?", matchID, userID).Count(&count)
+	return count > 0
+}
+
+func decodeReport(report *models.MatchReport) (*models.MatchReport, *MatchReportData, error) {
+	if report.Status != models.ReportReady {
+		return report, nil, nil
+	}
+	var data MatchReportData
+	if err := json.Unmarshal([]byte(report.Data), &data); err != nil {
+		return nil, nil, err
+	}
+	return report, &data, nil
+}
+
+// BuildReport calcule le rapport d'un match à partir de ses événements d'analyste
+func (s *ReportService) BuildReport(matchID string) (*MatchReportData, error) {
+	var match models.Matches
+	if err := s.DB.Where("id = ? AND deleted_at IS NULL", matchID).First(&match).Error; err != nil {
+		return nil, err
+	}
+	if match.Status != models.Completed {
+		return nil, errors.New("match is not completed")
+	}
+
+	var players []models.MatchPlayers
+	if err := s.DB.Preload("Player").Where("match_id = ?", matchID).Find(&players).Error; err != nil {
+		return nil, err
+	}
+	var events []models.Analyst
+	if err := s.DB.Where("match_id = ? AND deleted_at IS NULL", matchID).Order("minute asc, created_at asc").Find(&events).Error; err != nil {
+		return nil, err
+	}
+	votes, err := s.ReviewService.GetManOfTheMatchVotes(matchID)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &MatchReportData{
+		MatchID:     match.ID,
+		Address:     match.Address,
+		Date:        match.MatchDate,
+		ScoreTeam1:  match.ScoreTeam1,
+		ScoreTeam2:  match.ScoreTeam2,
+		Teams:       []TeamStats{{Team: 1}, {Team: 2}},
+		Players:     []PlayerStatLine{},
+		HeatMaps:    []HeatMap{{Team: 1, Grid: newHeatGrid()}, {Team: 2, Grid: newHeatGrid()}},
+		GeneratedAt: time.Now(),
+	}
+
+	lines := make(map[string]*PlayerStatLine)
+	for _, player := range players {
+		team := 0
+		if player.TeamNumber != nil {
+			team = *player.TeamNumber
+		}
+		lines[player.PlayerID] = &PlayerStatLine{PlayerID: player.PlayerID, Username: player.Player.Username, Team: team}
+	}
+	for _, vote := range votes {
+		if line, ok := lines[vote.PlayerID]; ok {
+			line.ManOfTheMatch = vote.Votes
+		}
+	}
+
+	// Une passe décisive peut être saisie sur le but (assist_by) et aussi comme événement séparé :
+	// l'événement séparé de la même minute n'est alors pas compté une seconde fois
+	goalAssists := make(map[string]int)
+	for _, event := range events {
+		if event.EventType == models.EventGoal && event.Payload.AssistBy != nil {
+			goalAssists[assistKey(*event.Payload.AssistBy, event.Minute)]++
+		}
+	}
+
+	playerHeatMaps := make(map[string]*HeatMap)
+	onBall := map[int]int{}
+	for _, event := range events {
+		line, ok := lines[event.PlayerID]
+		if !ok || line.Team < 1 || line.Team > 2 {
+			continue
+		}
+		team := &report.Teams[line.Team-1]
+
+		switch event.EventType {
+		case models.EventGoal:
+			line.Goals++
+			team.Goals++
+			onBall[line.Team]++
+			if event.Payload.AssistBy != nil {
+				if assist, ok := lines[*event.Payload.AssistBy]; ok {
+					assist.Assists++
+					team.Assists++
+				}
+			}
+		case models.EventOwnGoal:
+			line.OwnGoals++
+			report.Teams[2-line.Team].Goals++
+		case models.EventAssist:
+			onBall[line.Team]++
+			if key := assistKey(event.PlayerID, event.Minute); goalAssists[key] > 0 {
+				goalAssists[key]--
+				break
+			}
+			line.Assists++
+			team.Assists++
+		case models.EventShotOnTarget:
+			line.ShotsOnTarget++
+			team.ShotsOnTarget++
+			onBall[line.Team]++
+		case models.EventShotOffTarget:
+			line.ShotsOffTarget++
+			team.ShotsOffTarget++
+			onBall[line.Team]++
+		case models.EventPenalty:
+			team.Penalties++
+			onBall[line.Team]++
+			if event.Payload.Scored != nil && *event.Payload.Scored {
+				team.PenaltiesScored++
+			}
+		case models.EventSave:
+			line.Saves++
+			team.Saves++
+		case models.EventFoul:
+			line.Fouls++
+			team.Fouls++
+		case models.EventYellowCard:
+			line.YellowCards++
+			team.YellowCards++
+		case models.EventRedCard:
+			line.RedCards++
+			team.RedCards++
+		case models.EventSubstitution:
+			team.Substitutions++
+		}
+
+		if event.Payload.X != nil && event.Payload.Y != nil {
+			x, y := heatCell(*event.Payload.X), heatCell(*event.Payload.Y)
+			report.HeatMaps[line.Team-1].Grid[y][x]++
+			heatMap, ok := playerHeatMaps[event.PlayerID]
+			if !ok {
+				heatMap = &HeatMap{Team: line.Team, PlayerID: event.PlayerID, Grid: newHeatGrid()}
+				playerHeatMaps[event.PlayerID] = heatMap
+			}
+			heatMap.Grid[y][x]++
+		}
+	}
+
+	if total := onBall[1] + onBall[2]; total > 0 {
+		report.Teams[0].PossessionProxy = math.Round(float64(onBall[1])/float64(total)*1000) / 10
+		report.Teams[1].PossessionProxy = math.Round(float64(onBall[2])/float64(total)*1000) / 10
+	}
+
+	winner := 0
+	if match.ScoreTeam1 > match.ScoreTeam2 {
+		winner = 1
+	} else if match.ScoreTeam2 > match.ScoreTeam1 {
+		winner = 2
+	}
+	for _, line := range lines {
+		line.Impact = playerImpact(line, winner)
+		report.Players = append(report.Players, *line)
+	}
+	sort.Slice(report.Players, func(i, j int) bool {
+		if report.Players[i].Impact != report.Players[j].Impact {
+			return report.Players[i].Impact > report.Players[j].Impact
+		}
+		return report.Players[i].PlayerID < report.Players[j].PlayerID
+	})
+	if len(report.Players) > 0 && report.Players[0].Impact > 0 {
+		motm := report.Players[0]
+		report.ManOfTheMatch = &motm
+	}
+
+	playerIDs := make([]string, 0, len(playerHeatMaps))
+	for playerID := range playerHeatMaps {
+		playerIDs = append(playerIDs, playerID)
+	}
+	sort.Strings(playerIDs)
+	for _, playerID := range playerIDs {
+		report.HeatMaps = append(report.HeatMaps, *playerHeatMaps[playerID])
+	}
+
+	return report, nil
+}
+
+func assistKey(playerID string, minute int) string {
+	return playerID + ":" + strconv.Itoa(minute)
+}
+
+// playerImpact pondère les actions d'un joueur ; les votes des participants comptent aussi
+func playerImpact(line *PlayerStatLine, winner int) float64 {
+	impact := float64(line.Goals)*4 + float64(line.Assists)*3 + float64(line.ShotsOnTarget) +
+		float64(line.ShotsOffTarget)*0.3 + float64(line.Saves)*1.5 + float64(line.ManOfTheMatch)*2 -
+		float64(line.OwnGoals)*2 - float64(line.Fouls)*0.5 - float64(line.YellowCards) - float64(line.RedCards)*3
+	if winner != 0 && line.Team == winner {
+		impact++
+	}
+	return math.Round(impact*10) / 10
+}
+
+func newHeatGrid() [][]int {
+	grid := make([][]int, heatGridSize)
+	for i := range grid {
+		grid[i] = make([]int, heatGridSize)
+	}
+	return grid
+}
+
+// heatCell convertit une coordonnée en pourcentage (0 à 100) en index de zone
+func heatCell(value float64) int {
+	cell := int(value / (100.0 / heatGridSize))
+	if cell >= heatGridSize {
+		cell = heatGridSize - 1
+	}
+	if cell < 0 {
+		cell = 0
+	}
+	return cell
+}
+
+func newReportToken() string {
+	t := time.Now()
+	entropy := ulid.Monotonic(rand.New(rand.NewSource(t.UnixNano())), 0)
+	return ulid.MustNew(ulid.Timestamp(t), entropy).String()
+}
+
+// RenderCSV exporte les statistiques d'équipe et de joueurs au format CSV
+func RenderCSV(report *MatchReportData) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+
+	itoa := strconv.Itoa
+	rows := [][]string{
+		{"team", "goals", "assists", "shots_on_target", "shots_off_target", "penalties", "penalties_scored", "saves", "fouls", "yellow_cards", "red_cards", "substitutions", "possession_proxy"},
+	}
+	for _, team := range report.Teams {
+		rows = append(rows, []string{
+			itoa(team.Team), itoa(team.Goals), itoa(team.Assists), itoa(team.ShotsOnTarget), itoa(team.ShotsOffTarget),
+			itoa(team.Penalties), itoa(team.PenaltiesScored), itoa(team.Saves), itoa(team.Fouls), itoa(team.YellowCards),
+			itoa(team.RedCards), itoa(team.Substitutions), strconv.FormatFloat(team.PossessionProxy, 'f', 1, 64),
+		})
+	}
+	rows = append(rows, []string{})
+	rows = append(rows, []string{"player_id", "username", "team", "goals", "own_goals", "assists", "shots_on_target", "shots_off_target", "saves", "fouls", "yellow_cards", "red_cards", "man_of_the_match_votes", "impact"})
+	for _, player := range report.Players {
+		rows = append(rows, []string{
+			player.PlayerID, csvCell(player.Username), itoa(player.Team), itoa(player.Goals), itoa(player.OwnGoals), itoa(player.Assists),
+			itoa(player.ShotsOnTarget), itoa(player.ShotsOffTarget), itoa(player.Saves), itoa(player.Fouls), itoa(player.YellowCards),
+			itoa(player.RedCards), strconv.FormatInt(player.ManOfTheMatch, 10), strconv.FormatFloat(player.Impact, 'f', 1, 64),
+		})
+	}
+
+	if err := writer.WriteAll(rows); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// csvCell neutralise les valeurs qu'un tableur interpréterait comme une formule (nom d'utilisateur
+// commençant par =, +, - ou @) en les préfixant d'une apostrophe
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"heat": func(count int) template.CSS {
+		return template.CSS(fmt.Sprintf("rgba(1, 191, 107, %.2f)", math.Min(float64(count)/5, 1)))
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <title>Rapport du match {{.Address}}</title>
+    <style>
+        body { font-family: Arial, sans-serif; margin: 20px; color: #222; }
+        h1 { font-size: 22px; }
+        h2 { font-size: 18px; margin-top: 30px; }
+        table { border-collapse: collapse; margin-top: 10px; }
+        th, td { border: 1px solid #ddd; padding: 6px 10px; text-align: center; }
+        th { background-color: #01BF6B; color: #fff; }
+        .score { font-size: 28px; font-weight: bold; }
+        .heat td { width: 22px; height: 22px; padding: 0; border: 1px solid #eee; }
+        .heatmaps { display: flex; gap: 30px; }
+        @media print { th { color: #000; background-color: #eee; } }
+    </style>
+</head>
+<body>
+    <h1>{{.Address}} - {{.Date.Format "02/01/2006"}}</h1>
+    <p class="score">Équipe 1 {{.ScoreTeam1}} - {{.ScoreTeam2}} Équipe 2</p>
+    {{if .ManOfTheMatch}}<p>Homme du match suggéré : <strong>{{.ManOfTheMatch.Username}}</strong> (impact {{.ManOfTheMatch.Impact}})</p>{{end}}
+
+    <h2>Statistiques des équipes</h2>
+    <table>
+        <tr><th>Équipe</th><th>Buts</th><th>Passes déc.</th><th>Tirs cadrés</th><th>Tirs non cadrés</th><th>Penalties</th><th>Arrêts</th><th>Fautes</th><th>Jaunes</th><th>Rouges</th><th>Possession (approx.)</th></tr>
+        {{range .Teams}}<tr><td>{{.Team}}</td><td>{{.Goals}}</td><td>{{.Assists}}</td><td>{{.ShotsOnTarget}}</td><td>{{.ShotsOffTarget}}</td><td>{{.PenaltiesScored}}/{{.Penalties}}</td><td>{{.Saves}}</td><td>{{.Fouls}}</td><td>{{.YellowCards}}</td><td>{{.RedCards}}</td><td>{{.PossessionProxy}}%</td></tr>
+        {{end}}
+    </table>
+
+    <h2>Statistiques des joueurs</h2>
+    <table>
+        <tr><th>Joueur</th><th>Équipe</th><th>Buts</th><th>CSC</th><th>Passes déc.</th><th>Tirs cadrés</th><th>Tirs non cadrés</th><th>Arrêts</th><th>Fautes</th><th>Jaunes</th><th>Rouges</th><th>Votes HDM</th><th>Impact</th></tr>
+        {{range .Players}}<tr><td>{{.Username}}</td><td>{{.Team}}</td><td>{{.Goals}}</td><td>{{.OwnGoals}}</td><td>{{.Assists}}</td><td>{{.ShotsOnTarget}}</td><td>{{.ShotsOffTarget}}</td><td>{{.Saves}}</td><td>{{.Fouls}}</td><td>{{.YellowCards}}</td><td>{{.RedCards}}</td><td>{{.ManOfTheMatch}}</td><td>{{.Impact}}</td></tr>
+        {{end}}
+    </table>
+
+    <h2>Cartes de chaleur</h2>
+    <div class="heatmaps">
+        {{range .HeatMaps}}{{if not .PlayerID}}<div>
+            <p>Équipe {{.Team}}</p>
+            <table class="heat">
+                {{range .Grid}}<tr>{{range .}}<td style="background-color: {{heat .}}"></td>{{end}}</tr>
+                {{end}}
+            </table>
+        </div>{{end}}{{end}}
+    </div>
+
+    <p><small>Généré le {{.GeneratedAt.Format "02/01/2006 15:04"}} par TeamUp</small></p>
+</body>
+</html>
+`))
+
+// RenderHTML génère une version HTML imprimable du rapport, partageable par l'organisateur
+func RenderHTML(report *MatchReportData) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := reportTemplate.Execute(&buffer, report); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
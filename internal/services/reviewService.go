@@ -0,0 +1,266 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/ady243/teamup/internal/models"
+	"github.com/oklog/ulid/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	// Délai pendant lequel les participants peuvent noter les joueurs d'un match
+	reviewWindow = 7 * 24 * time.Hour
+	// Nombre minimum de notes avant de remplacer les attributs déclarés par le joueur
+	minReviewsForAttributes = 3
+	// Nombre maximum de notes récentes prises en compte dans le calcul
+	maxReviewsForAttributes = 50
+)
+
+// ReviewService gère les notes entre joueurs et le vote de l'homme du match
+type ReviewService struct {
+	DB *gorm.DB
+}
+
+func NewReviewService(db *gorm.DB) *ReviewService {
+	return &ReviewService{
+		DB: db,
+	}
+}
+
+// PlayerReviewSummary résume les notes reçues par un joueur
+type PlayerReviewSummary struct {
+	PlayerID       string  `json:"player_id"`
+	ReviewsCount   int     `json:"reviews_count"`
+	Pac            int     `json:"pac"`
+	Sho            int     `json:"sho"`
+	Pas            int     `json:"pas"`
+	Dri            int     `json:"dri"`
+	Def            int     `json:"def"`
+	Phy            int     `json:"phy"`
+	FairPlay       float64 `json:"fair_play"`
+	ManOfTheMatch  int64   `json:"man_of_the_match"`
+	FromPeerRating bool    `json:"from_peer_rating"`
+}
+
+// ManOfTheMatchTally est le nombre de votes reçus par un joueur pour un match
+type ManOfTheMatchTally struct {
+	PlayerID string `json:"player_id"`
+	Votes    int64  `json:"votes"`
+}
+
+// checkReviewable vérifie que le match est terminé, encore dans la fenêtre de notation,
+// et que les deux utilisateurs y ont participé. Il renvoie les participations des deux joueurs.
+func (s *ReviewService) checkReviewable(matchID, reviewerID, playerID string) (*models.MatchPlayers, *models.MatchPlayers, error) {
+	if reviewerID == playerID {
+		return nil, nil, errors.New("you cannot review yourself")
+	}
+
+	var match models.Matches
+	if err := s.DB.Where("id = ? AND deleted_at IS NULL", matchID).First(&match).Error; err != nil {
+		return nil, nil, errors.New("match not found")
+	}
+	if match.Status != models.Completed {
+		return nil, nil, errors.New("match is not completed")
+	}
+	if time.Since(match.MatchDate) > reviewWindow {
+		return nil, nil, errors.New("review period for this match is over")
+	}
+
+	var reviewer models.MatchPlayers
+	if err := s.DB.Where("match_id = ? AND player_id = ?", matchID, reviewerID).First(&reviewer).Error; err != nil {
+		return nil, nil, errors.New("only match participants can review players")
+	}
+	var player models.MatchPlayers
+	if err := s.DB.Where("match_id = ? AND player_id = ?", matchID, playerID).First(&player).Error; err != nil {
+		return nil, nil, errors.New("player did not take part in this match")
+	}
+	return &reviewer, &player, nil
+}
+
+// SubmitReview enregistre la note d'un participant pour un autre joueur du match.
+// Un participant ne peut noter un même joueur qu'une seule fois par match.
+func (s *ReviewService) SubmitReview(review *models.PlayerReview) error {
+	for _, value := range []int{review.Pac, review.Sho, review.Pas, review.Dri, review.Def, review.Phy} {
+		if value < 1 || value > 99 {
+			return errors.New("attributes must be between 1 and 99")
+		}
+	}
+	if review.FairPlay < 1 || review.FairPlay > 5 {
+		return errors.New("fair_play must be between 1 and 5")
+	}
+
+	reviewer, player, err := s.checkReviewable(review.MatchID, review.ReviewerID, review.PlayerID)
+	if err != nil {
+		return err
+	}
+
+	var count int64
+	if err := s.DB.Model(&models.PlayerReview{}).
+		Where("match_id = ? AND reviewer_id = ? AND player_id = ?", review.MatchID, review.ReviewerID, review.PlayerID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("you already reviewed this player for this match")
+	}
+
+	t := time.Now()
+	entropy := ulid.Monotonic(rand.New(rand.NewSource(t.UnixNano())), 0)
+	review.ID = ulid.MustNew(ulid.Timestamp(t), entropy).String()
+	review.IsTeammate = reviewer.TeamNumber != nil && player.TeamNumber != nil && *reviewer.TeamNumber == *player.TeamNumber
+
+	return s.DB.Create(review).Error
+}
+
+// VoteManOfTheMatch enregistre le vote d'un participant pour l'homme du match
+func (s *ReviewService) VoteManOfTheMatch(matchID, voterID, playerID string) error {
+	if _, _, err := s.checkReviewable(matchID, voterID, playerID); err != nil {
+		return err
+	}
+
+	var count int64
+	if err := s.DB.Model(&models.ManOfTheMatchVote{}).Where("match_id = ? AND voter_id = ?", matchID, voterID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("you already voted for this match")
+	}
+
+	t := time.Now()
+	entropy := ulid.Monotonic(rand.New(rand.NewSource(t.UnixNano())), 0)
+	vote := models.ManOfTheMatchVote{
+		ID:       ulid.MustNew(ulid.Timestamp(t), entropy).String(),
+		MatchID:  matchID,
+		VoterID:  voterID,
+		PlayerID: playerID,
+	}
+	return s.DB.Create(&vote).Error
+}
+
+// GetManOfTheMatchVotes renvoie le décompte des votes d'un match, du plus voté au moins voté
+func (s *ReviewService) GetManOfTheMatchVotes(matchID string) ([]ManOfTheMatchTally, error) {
+	var tallies []ManOfTheMatchTally
+	if err := s.DB.Model(&models.ManOfTheMatchVote{}).
+		Select("player_id, COUNT(*) AS votes").
+		Where("match_id = ?", matchID).
+		Group("player_id").
+		Order("votes desc").
+		Scan(&tallies).Error; err != nil {
+		return nil, err
+	}
+	return tallies, nil
+}
+
+// GetPlayerSummary calcule les attributs d'un joueur à partir des notes de ses pairs
+func (s *ReviewService) GetPlayerSummary(playerID string) (PlayerReviewSummary, error) {
+	var reviews []models.PlayerReview
+	if err := s.DB.Where("player_id = ?", playerID).
+		Order("created_at desc").
+		Limit(maxReviewsForAttributes).
+		Find(&reviews).Error; err != nil {
+		return PlayerReviewSummary{}, err
+	}
+
+	summary := PlayerReviewSummary{PlayerID: playerID, ReviewsCount: len(reviews)}
+	if err := s.DB.Model(&models.ManOfTheMatchVote{}).Where("player_id = ?", playerID).Count(&summary.ManOfTheMatch).Error; err != nil {
+		return PlayerReviewSummary{}, err
+	}
+	if len(reviews) == 0 {
+		return summary, nil
+	}
+
+	values := func(pick func(models.PlayerReview) int) []float64 {
+		result := make([]float64, len(reviews))
+		for i, review := range reviews {
+			result[i] = float64(pick(review))
+		}
+		return result
+	}
+	summary.Pac = int(math.Round(dampenedMean(values(func(r models.PlayerReview) int { return r.Pac }), 5)))
+	summary.Sho = int(math.Round(dampenedMean(values(func(r models.PlayerReview) int { return r.Sho }), 5)))
+	summary.Pas = int(math.Round(dampenedMean(values(func(r models.PlayerReview) int { return r.Pas }), 5)))
+	summary.Dri = int(math.Round(dampenedMean(values(func(r models.PlayerReview) int { return r.Dri }), 5)))
+	summary.Def = int(math.Round(dampenedMean(values(func(r models.PlayerReview) int { return r.Def }), 5)))
+	summary.Phy = int(math.Round(dampenedMean(values(func(r models.PlayerReview) int { return r.Phy }), 5)))
+	summary.FairPlay = dampenedMean(values(func(r models.PlayerReview) int { return r.FairPlay }), 0.5)
+	summary.FromPeerRating = len(reviews) >= minReviewsForAttributes
+	return summary, nil
+}
+
+// RecomputePlayerAttributes remplace les attributs affichés d'un joueur par ceux issus des notes de ses pairs
+func (s *ReviewService) RecomputePlayerAttributes(playerID string) error {
+	summary, err := s.GetPlayerSummary(playerID)
+	if err != nil {
+		return err
+	}
+	if !summary.FromPeerRating {
+		return nil
+	}
+
+	return s.DB.Model(&models.Users{}).Where("id = ?", playerID).Updates(map[string]interface{}{
+		"pac":            summary.Pac,
+		"sho":            summary.Sho,
+		"pas":            summary.Pas,
+		"dri":            summary.Dri,
+		"def":            summary.Def,
+		"phy":            summary.Phy,
+		"behavior_score": int(math.Round(summary.FairPlay * 20)),
+	}).Error
+}
+
+// RecomputeReviewedSince recalcule les attributs des joueurs ayant reçu une note depuis la date donnée
+func (s *ReviewService) RecomputeReviewedSince(since time.Time) error {
+	var playerIDs []string
+	if err := s.DB.Model(&models.PlayerReview{}).
+		Where("created_at >= ?", since).
+		Distinct().
+		Pluck("player_id", &playerIDs).Error; err != nil {
+		return err
+	}
+
+	for _, playerID := range playerIDs {
+		if err := s.RecomputePlayerAttributes(playerID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dampenedMean calcule une moyenne en ramenant les notes aberrantes vers la médiane,
+// pour limiter l'effet d'un joueur qui noterait volontairement trop haut ou trop bas.
+func dampenedMean(values []float64, minSpread float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	median := medianOf(sorted)
+
+	deviations := make([]float64, len(sorted))
+	for i, value := range sorted {
+		deviations[i] = math.Abs(value - median)
+	}
+	sort.Float64s(deviations)
+	// Écart absolu médian ramené à l'échelle d'un écart-type, avec un minimum pour les petits échantillons
+	spread := math.Max(1.4826*medianOf(deviations)*2, minSpread)
+
+	var total float64
+	for _, value := range values {
+		total += math.Min(math.Max(value, median-spread), median+spread)
+	}
+	return total / float64(len(values))
+}
+
+func medianOf(sorted []float64) float64 {
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
@@ -0,0 +1,242 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Durée de conservation d'une notification par le service push du navigateur si l'appareil est hors ligne
+const webPushTTL = "86400"
+
+// WebPushSubscription est l'abonnement push d'un navigateur (PushSubscription.toJSON()),
+// enregistré comme token des appareils web
+type WebPushSubscription struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+// WebPushNotifier envoie les notifications aux navigateurs par le protocole Web Push, sans intermédiaire,
+// avec un contenu chiffré (RFC 8291) et une authentification VAPID (RFC 8292)
+type WebPushNotifier struct {
+	client    *http.Client
+	publicKey string
+	key       *ecdsa.PrivateKey
+	subject   string
+}
+
+// NewWebPushNotifier prend les clés VAPID encodées en base64 URL, telles que générées par les
+// bibliothèques Web Push, et le contact de l'émetteur (ex. "mailto:contact@teamup.fr")
+func NewWebPushNotifier(publicKey, privateKey, subject string) (*WebPushNotifier, error) {
+	if subject == "" {
+		return nil, errors.New("VAPID_SUBJECT est requis")
+	}
+	scalar, err := base64.RawURLEncoding.DecodeString(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("clé privée VAPID illisible: %w", err)
+	}
+	private, err := ecdh.P256().NewPrivateKey(scalar)
+	if err != nil {
+		return nil, fmt.Errorf("clé privée VAPID illisible: %w", err)
+	}
+	public := private.PublicKey().Bytes()
+	if base64.RawURLEncoding.EncodeToString(public) != publicKey {
+		return nil, errors.New("les clés VAPID ne correspondent pas")
+	}
+
+	key := &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(public[1:33]),
+			Y:     new(big.Int).SetBytes(public[33:]),
+		},
+		D: new(big.Int).SetBytes(scalar),
+	}
+	return &WebPushNotifier{
+		client:    &http.Client{Timeout: 10 * time.Second},
+		publicKey: publicKey,
+		key:       key,
+		subject:   subject,
+	}, nil
+}
+
+// Send envoie la notification à chaque abonnement ; le navigateur reçoit le titre, le texte et les
+// données du lien profond en JSON
+func (n *WebPushNotifier) Send(ctx context.Context, notification OutgoingNotification) (*DeliveryReport, error) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"title": notification.Title,
+		"body":  notification.Body,
+		"data":  notification.Data,
+		"tag":   notification.CollapseKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &DeliveryReport{}
+	var sendErr error
+	for _, token := range notification.Recipients {
+		invalid, err := n.sendOne(ctx, token, notification.CollapseKey, payload)
+		switch {
+		case invalid:
+			report.Invalid = append(report.Invalid, token)
+		case err != nil:
+			sendErr = err
+			log.Printf("Échec de l'envoi de la notification à un navigateur: %v", err)
+		default:
+			report.Sent++
+		}
+	}
+	if report.Sent == 0 && sendErr != nil {
+		return report, sendErr
+	}
+	return report, nil
+}
+
+// sendOne envoie la notification à un abonnement et indique si le service push l'a révoqué
+func (n *WebPushNotifier) sendOne(ctx context.Context, token, collapseKey string, payload []byte) (bool, error) {
+	var subscription WebPushSubscription
+	if err := json.Unmarshal([]byte(token), &subscription); err != nil || subscription.Endpoint == "" {
+		return true, nil
+	}
+	endpoint, err := url.Parse(subscription.Endpoint)
+	if err != nil || endpoint.Scheme != "https" {
+		return true, nil
+	}
+	body, err := encryptWebPush(subscription, payload)
+	if err != nil {
+		return true, nil
+	}
+	authorization, err := n.vapid(endpoint.Scheme + "://" + endpoint.Host)
+	if err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", webPushTTL)
+	req.Header.Set("Urgency", "high")
+	if collapseKey != "" {
+		// Le sujet remplace les notifications en attente du même sujet ; il est limité à 32 caractères base64 URL
+		sum := sha256.Sum256([]byte(collapseKey))
+		req.Header.Set("Topic", base64.RawURLEncoding.EncodeToString(sum[:24]))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return true, nil
+	}
+	return false, fmt.Errorf("le service Web Push a refusé la notification (%d)", resp.StatusCode)
+}
+
+// vapid renvoie l'en-tête d'authentification VAPID pour le service push d'origine audience
+func (n *WebPushNotifier) vapid(audience string) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"aud": audience,
+		"exp": time.Now().Add(12 * time.Hour).Unix(),
+		"sub": n.subject,
+	})
+	signed, err := token.SignedString(n.key)
+	if err != nil {
+		return "", err
+	}
+	return "vapid t=" + signed + ", k=" + n.publicKey, nil
+}
+
+// encryptWebPush chiffre le contenu pour l'abonnement selon le codage aes128gcm (RFC 8188 et 8291),
+// en un seul enregistrement
+func encryptWebPush(subscription WebPushSubscription, payload []byte) ([]byte, error) {
+	receiverKey, err := base64.RawURLEncoding.DecodeString(subscription.Keys.P256dh)
+	if err != nil {
+		return nil, err
+	}
+	authSecret, err := base64.RawURLEncoding.DecodeString(subscription.Keys.Auth)
+	if err != nil {
+		return nil, err
+	}
+	receiver, err := ecdh.P256().NewPublicKey(receiverKey)
+	if err != nil {
+		return nil, err
+	}
+	sender, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := sender.ECDH(receiver)
+	if err != nil {
+		return nil, err
+	}
+	senderKey := sender.PublicKey().Bytes()
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	keyInfo := append(append([]byte("WebPush: info\x00"), receiverKey...), senderKey...)
+	ikm := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, authSecret, keyInfo), ikm); err != nil {
+		return nil, err
+	}
+	prk := hkdf.Extract(sha256.New, ikm, salt)
+	contentKey := make([]byte, 16)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: aes128gcm\x00")), contentKey); err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, 12)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: nonce\x00")), nonce); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// Le délimiteur 0x02 marque le dernier (et unique) enregistrement
+	ciphertext := gcm.Seal(nil, nonce, append(payload, 0x02), nil)
+
+	header := make([]byte, 0, 16+4+1+len(senderKey))
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, 4096)
+	header = append(header, byte(len(senderKey)))
+	header = append(header, senderKey...)
+	return append(header, ciphertext...), nil
+}
@@ -18,7 +18,7 @@ import (
 )
 
 func NewAuthService(db *gorm.DB) *services.AuthService {
-	return services.NewAuthService(db, services.NewImageService("./uploads"), services.NewEmailService())
+	return services.NewAuthService(db, services.NewImageService("./uploads"), services.NewEmailService(), services.NewRecordingNotifier("email"), services.NewOutboxService(db))
 }
 
 func setupTestDB() *gorm.DB {